@@ -3,6 +3,7 @@ module github.com/zicongmei/ai-story/fullText1
 go 1.24.8
 
 require (
+	golang.org/x/time v0.14.0
 	google.golang.org/genai v1.36.0
 	gopkg.in/yaml.v3 v3.0.1
 )
@@ -1,47 +1,219 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
 	"github.com/zicongmei/ai-story/fullText1/pkg/abstract"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/cli"
+	"github.com/zicongmei/ai-story/fullText1/pkg/config"
 	"github.com/zicongmei/ai-story/fullText1/pkg/story"
 )
 
+// version is set at build time, e.g.
+// go build -ldflags "-X main.version=v1.2.0" -o ai-story .
+var version string
+
+// develVersion is reported when the build sets no version.
+const develVersion = "(devel)"
+
+// Process exit codes, so scripts and CI can tell failure types apart.
+const (
+	exitFailure     = 1   // Any other failure, e.g. a file that cannot be written
+	exitUsage       = 2   // Bad flags, arguments, or subcommand
+	exitConfig      = 3   // Missing API key, invalid config file, or API key rejected by Gemini
+	exitAPI         = 4   // A Gemini call failed, timed out, or was blocked
+	exitInterrupted = 130 // Generation stopped at a chapter boundary after Ctrl+C
+)
+
 func main() {
 	// Configure logging to include file and line number
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
 	if len(os.Args) < 2 {
-		printUsage()
-		os.Exit(1)
+		printUsage(os.Stderr)
+		os.Exit(exitUsage)
 	}
 
 	switch os.Args[1] {
 	case "abstract":
-		if err := abstract.Execute(os.Args[2:]); err != nil {
-			log.Fatalf("Abstract subcommand failed: %v", err)
-		}
+		exitOnError("abstract", abstract.Execute(os.Args[2:]))
 	case "story":
 		// The story.Execute function will handle setting up its own log file
-		if err := story.Execute(os.Args[2:]); err != nil {
-			log.Fatalf("Story subcommand failed: %v", err)
-		}
+		exitOnError("story", story.Execute(os.Args[2:]))
+	case "config":
+		exitOnError("config", config.Execute(os.Args[2:]))
+	case "models", "list-models", "--list-models":
+		exitOnError("models", listModels(os.Args[2:]))
+	case "version", "--version", "-version":
+		printVersion()
 	case "help":
-		printUsage()
+		printUsage(os.Stdout)
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown subcommand: %s\n", os.Args[1])
+		printUsage(os.Stderr)
+		os.Exit(exitUsage)
+	}
+}
+
+// exitCode maps an error returned by a subcommand to the process exit code.
+func exitCode(err error) int {
+	switch {
+	case errors.Is(err, flag.ErrHelp):
+		return 0
+	case aiEndpoint.IsConfigError(err), errors.Is(err, config.ErrInvalidConfig):
+		return exitConfig
+	case errors.Is(err, cli.ErrUsage):
+		return exitUsage
+	case errors.Is(err, story.ErrInterrupted):
+		return exitInterrupted
+	case aiEndpoint.IsAPIError(err):
+		return exitAPI
+	default:
+		return exitFailure
+	}
+}
+
+// exitOnError logs a subcommand's error and exits with its exit code; it returns when err is nil.
+// Usage errors also point to the subcommand's --help. The flag package has already printed the
+// usage for --help itself, so that exits quietly with status 0.
+func exitOnError(command string, err error) {
+	if err == nil {
+		return
+	}
+	code := exitCode(err)
+	if code == 0 {
+		os.Exit(0)
+	}
+	log.Printf("%s subcommand failed: %v", command, err)
+	if code == exitUsage {
+		fmt.Fprintf(os.Stderr, "Run 'ai-story %s --help' for usage.\n", command)
+	}
+	os.Exit(code)
+}
+
+// buildVersion returns the version injected with -ldflags, then the module version recorded by
+// 'go install module@version', or develVersion.
+func buildVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return develVersion
+}
+
+// printVersion prints the build version, the default model, and the Go version, for bug reports.
+func printVersion() {
+	fmt.Printf("ai-story %s\n", buildVersion())
+	fmt.Printf("Default model: %s\n", aiEndpoint.DefaultGeminiModel)
+	fmt.Printf("Go: %s %s/%s\n", runtime.Version(), runtime.GOOS, runtime.GOARCH)
+}
+
+// listModels implements 'models' (also --list-models): it prints every model in the pricing table,
+// with the pricing file given by --pricing-file or GEMINI_PRICING_FILE applied, and the input and
+// output price per million tokens of each of its prompt-size tiers.
+func listModels(args []string) error {
+	cmd := flag.NewFlagSet("models", flag.ContinueOnError)
+	cmd.Usage = func() {
+		fmt.Fprintf(cmd.Output(), "Usage of %s models:\n", os.Args[0])
+		cmd.PrintDefaults()
+	}
+	pricingFile := cmd.String("pricing-file", "", "Path to a pricing JSON file whose prices are listed in place of the built-in ones (optional). Defaults to the GEMINI_PRICING_FILE env var.")
+	if err := cmd.Parse(args); err != nil {
+		return cli.UsageError(fmt.Errorf("failed to parse models flags: %w", err))
+	}
+	if cmd.NArg() > 0 {
+		return cli.UsageError(fmt.Errorf("unexpected arguments: %v", cmd.Args()))
+	}
+	if err := aiEndpoint.LoadPricingOverrides(*pricingFile); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "MODEL\tPROMPT TOKENS\tINPUT USD/1M\tOUTPUT USD/1M")
+	for _, m := range aiEndpoint.PricedModels() {
+		name := m.Model
+		if name == aiEndpoint.DefaultGeminiModel {
+			name += " (default)"
+		}
+		lowerBound := 0
+		for _, tier := range m.Tiers {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, tierRange(lowerBound, tier.MaxInputTokens),
+				formatPrice(tier.Prices.InputPricePerMillion), formatPrice(tier.Prices.OutputPricePerMillion))
+			name = ""
+			lowerBound = tier.MaxInputTokens
+		}
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+
+	aliases := aiEndpoint.DeprecatedModelAliases()
+	if len(aliases) > 0 {
+		names := make([]string, 0, len(aliases))
+		for alias := range aliases {
+			names = append(names, alias)
+		}
+		sort.Strings(names)
+		fmt.Println("\nDeprecated names, called and priced as their replacement:")
+		for _, alias := range names {
+			fmt.Printf("  %s -> %s\n", alias, aliases[alias])
+		}
+	}
+	return nil
+}
+
+// formatPrice shows a price with at least two decimals and as many more as it needs, so a price
+// such as 0.075 is not rounded.
+func formatPrice(price float64) string {
+	s := strconv.FormatFloat(price, 'f', -1, 64)
+	if _, decimals, ok := strings.Cut(s, "."); !ok || len(decimals) < 2 {
+		return fmt.Sprintf("%.2f", price)
+	}
+	return s
+}
+
+// tierRange describes the prompt sizes a pricing tier applies to, from the bound of the tier before
+// it (exclusive) to maxInputTokens (inclusive, 0 for no upper bound).
+func tierRange(lowerBound, maxInputTokens int) string {
+	switch {
+	case lowerBound == 0 && maxInputTokens == 0:
+		return "any"
+	case maxInputTokens == 0:
+		return fmt.Sprintf("> %d", lowerBound)
 	default:
-		fmt.Printf("Unknown subcommand: %s\n", os.Args[1])
-		printUsage()
-		os.Exit(1)
+		return fmt.Sprintf("<= %d", maxInputTokens)
 	}
 }
 
-func printUsage() {
-	fmt.Println("Usage: ai-story <command> [arguments]")
-	fmt.Println("\nAvailable commands:")
-	fmt.Println("  abstract  Generate a story abstract/plan using Gemini API.")
-	fmt.Println("  story     Generate a full story from an abstract.")
-	fmt.Println("\nRun 'ai-story abstract --help' for abstract subcommand options.")
-	fmt.Println("Run 'ai-story story --help' for story subcommand options.")
-}
\ No newline at end of file
+// printUsage prints the available commands to w.
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: ai-story <command> [arguments]")
+	fmt.Fprintln(w, "\nAvailable commands:")
+	fmt.Fprintln(w, "  abstract  Generate a story abstract/plan using Gemini API.")
+	fmt.Fprintln(w, "  story     Generate a full story from an abstract.")
+	fmt.Fprintln(w, "            'story continue' extends a finished story with more chapters.")
+	fmt.Fprintln(w, "            'story bible' extracts a character bible for consistent details.")
+	fmt.Fprintln(w, "            'story merge' recombines --split-dir chapter files into one story.")
+	fmt.Fprintln(w, "            'story status' reports progress and estimated remaining cost.")
+	fmt.Fprintln(w, "            'story outline' expands the abstract into per-chapter beats.")
+	fmt.Fprintln(w, "  config    'config validate' checks a config file and API key without generating anything.")
+	fmt.Fprintln(w, "  models    List the supported models and their prices (also --list-models).")
+	fmt.Fprintln(w, "  version   Print the build version, default model, and Go version (also --version).")
+	fmt.Fprintln(w, "\nRun 'ai-story abstract --help' for abstract subcommand options.")
+	fmt.Fprintln(w, "Run 'ai-story story --help' for story subcommand options.")
+	fmt.Fprintln(w, "Run 'ai-story story continue --help' for story continue options.")
+}
@@ -5,17 +5,20 @@ import (
 	"flag"
 	"fmt"
 	"log"
-	"math/rand"
+	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	// "gopkg.in/yaml.v3" // Moved to pkg/abstract/file
 
-	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file" // New import
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
 	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/cli"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+	"golang.org/x/time/rate"
 )
 
 // AbstractOutput structure for YAML output - MOVED to pkg/abstract/file
@@ -26,12 +29,24 @@ import (
 
 // GenerateAbstractInput holds all input parameters for the generateAbstract function.
 type GenerateAbstractInput struct {
-	APIKey        string
-	ModelName     string
-	ThinkingLevel string
-	Instruction   string
-	Language      string
-	NumChapters   int
+	Ctx            context.Context // Defaults to context.Background() when nil
+	APIKey         string
+	ModelName      string
+	ThinkingLevel  string
+	Instruction    string
+	Language       string
+	NumChapters    int
+	StylePrompt    string        // Optional narrative voice, sent as the system instruction
+	Seed           *int          // Optional sampling seed for reproducible output
+	Temperature    *float32      // Optional sampling temperature; nil uses the SDK default
+	TopP           *float32      // Optional nucleus sampling top-p; nil uses the SDK default
+	ThinkingBudget *int32        // Optional thinking token budget; nil uses a dynamic budget
+	Timeout        time.Duration // Per-call limit; 0 waits as long as the API takes
+	Limiter        *rate.Limiter // Optional; shared by every call of the command, nil means unlimited
+	// Client, HTTPClient, and CacheDir are passed on to every call; see aiEndpoint.CallGeminiAPIInput.
+	Client     aiEndpoint.GenaiClient
+	HTTPClient *http.Client
+	CacheDir   string
 }
 
 // AbstractGenerationResult holds all output parameters for the generateAbstract function.
@@ -44,10 +59,16 @@ type AbstractGenerationResult struct {
 	Err              error // To propagate errors gracefully
 }
 
-// generateAbstract interacts with the Gemini API to create a story abstract.
-func generateAbstract(input GenerateAbstractInput) AbstractGenerationResult { // Updated signature
-	var result AbstractGenerationResult
+// contextOrBackground returns ctx, or context.Background() when ctx is nil.
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
 
+// buildAbstractPrompt builds the prompt generateAbstract sends to Gemini.
+func buildAbstractPrompt(input GenerateAbstractInput) string {
 	// Prompt engineering for a concise abstract
 	// Dynamically include the number of chapters in the prompt
 	prompt := fmt.Sprintf(`Write a concise, compelling story writing plan.
@@ -62,37 +83,249 @@ It need to include the settings, the name of main characters and a detail plan f
 
 	// Add language instruction to the prompt
 	prompt += fmt.Sprintf("\nOutput the plan in %s.", input.Language)
+	return prompt
+}
+
+// generateAbstract interacts with the Gemini API to create a story abstract.
+func generateAbstract(input GenerateAbstractInput) AbstractGenerationResult { // Updated signature
+	var result AbstractGenerationResult
+
+	const maxAbstractRetries = 3 // Retries for rate-limited (429) calls
+
+	prompt := buildAbstractPrompt(input)
+	apiInput := aiEndpoint.CallGeminiAPIInput{
+		Ctx:               contextOrBackground(input.Ctx),
+		APIKey:            input.APIKey,
+		ModelName:         input.ModelName,
+		Prompt:            prompt,
+		ThinkingLevel:     input.ThinkingLevel,
+		PreviousTurn:      nil,
+		SystemInstruction: input.StylePrompt,
+		Seed:              input.Seed,
+		Temperature:       input.Temperature,
+		TopP:              input.TopP,
+		ThinkingBudget:    input.ThinkingBudget,
+		Timeout:           input.Timeout,
+		Limiter:           input.Limiter,
+		Client:            input.Client,
+		HTTPClient:        input.HTTPClient,
+		CacheDir:          input.CacheDir,
+	}
+
+	var usage aiEndpoint.CostTracker // Every attempt is billed, including rate-limited ones
+	var apiResponse aiEndpoint.GeminiAPIResponse
+	for attempt := 0; attempt <= maxAbstractRetries; attempt++ {
+		if attempt > 0 {
+			delay := aiEndpoint.RetryDelay(apiResponse.Err, attempt)
+			log.Printf("Warning: Abstract generation was rate limited. Retrying in %s (attempt %d/%d).", delay, attempt, maxAbstractRetries)
+			// Cancelling the context stops the backoff; the attempts made so far are still billed.
+			select {
+			case <-apiInput.Ctx.Done():
+				result.InputTokens, result.OutputTokens, result.Cost = usage.Summary()
+				result.Err = apiInput.Ctx.Err()
+				return result
+			case <-time.After(delay):
+			}
+		}
+		apiResponse = aiEndpoint.CallGeminiAPI(apiInput)
+		usage.Add(apiResponse)
+		if !aiEndpoint.IsRateLimited(apiResponse.Err) {
+			break
+		}
+	}
+
+	// Partial text returned alongside an error is kept so the caller can save it.
+	result.InputTokens, result.OutputTokens, result.Cost = usage.Summary()
+	result.Abstract = apiResponse.GeneratedText
+	result.ThoughtSignature = apiResponse.ThoughtSignature
+	if apiResponse.Err != nil {
+		result.Err = fmt.Errorf("error generating content from Gemini: %w", apiResponse.Err)
+	}
+	return result
+}
+
+// RefineAbstractInput holds all input parameters for the refineAbstract function.
+type RefineAbstractInput struct {
+	Ctx              context.Context // Defaults to context.Background() when nil
+	APIKey           string
+	ModelName        string
+	ThinkingLevel    string
+	Original         string // The abstract being refined
+	ThoughtSignature []byte // Signature saved with the original abstract, for continuity
+	Instruction      string // What to change, e.g. "make it darker, add a betrayal in act two"
+	Language         string
+	NumChapters      int // Chapter count to preserve; 0 keeps whatever the original plans
+	StylePrompt      string
+	Seed             *int
+	Temperature      *float32
+	TopP             *float32
+	ThinkingBudget   *int32
+	Timeout          time.Duration
+	Limiter          *rate.Limiter
+	Client           aiEndpoint.GenaiClient
+	HTTPClient       *http.Client
+	CacheDir         string
+}
+
+// buildRefinePrompt builds the revision request refineAbstract sends after the original abstract.
+func buildRefinePrompt(input RefineAbstractInput) string {
+	chapterRule := "Keep the same number of chapters as the current plan."
+	if input.NumChapters > 0 {
+		chapterRule = fmt.Sprintf("Keep exactly %d chapters, with a detailed plan for each.", input.NumChapters)
+	}
+	return fmt.Sprintf(`Revise the story writing plan you wrote above according to this request:
+%s
+
+%s Keep the settings, characters, and chapters that the request does not ask to change.
+Return the complete revised plan, not just the changes.
+Output the plan in %s.`, input.Instruction, chapterRule, input.Language)
+}
+
+// refineAbstract asks Gemini to revise an existing abstract according to an instruction. The
+// original abstract is sent as the model's previous turn, with its thought signature, so the
+// revision continues from the earlier plan instead of starting over.
+func refineAbstract(input RefineAbstractInput) AbstractGenerationResult {
+	var result AbstractGenerationResult
 
+	prompt := buildRefinePrompt(input)
 	apiInput := aiEndpoint.CallGeminiAPIInput{
-		Ctx:           context.Background(),
+		Ctx:           contextOrBackground(input.Ctx),
 		APIKey:        input.APIKey,
 		ModelName:     input.ModelName,
 		Prompt:        prompt,
 		ThinkingLevel: input.ThinkingLevel,
-		PreviousTurn:  nil,
+		PreviousTurn: &aiEndpoint.HistoryTurn{
+			UserPrompt:       "Write a concise, compelling story writing plan, including the settings, the name of main characters and a detail plan for all chapters.",
+			ModelResponse:    input.Original,
+			ThoughtSignature: input.ThoughtSignature,
+		},
+		SystemInstruction: input.StylePrompt,
+		Seed:              input.Seed,
+		Temperature:       input.Temperature,
+		TopP:              input.TopP,
+		ThinkingBudget:    input.ThinkingBudget,
+		Timeout:           input.Timeout,
+		Limiter:           input.Limiter,
+		Client:            input.Client,
+		HTTPClient:        input.HTTPClient,
+		CacheDir:          input.CacheDir,
 	}
 	apiResponse := aiEndpoint.CallGeminiAPI(apiInput)
 
+	// Partial text and usage returned alongside an error are kept so the caller can save and report them.
+	result.Abstract = apiResponse.GeneratedText
+	result.ThoughtSignature = apiResponse.ThoughtSignature
+	result.InputTokens = apiResponse.InputTokens
+	result.OutputTokens = apiResponse.OutputTokens
+	result.Cost = apiResponse.Cost
 	if apiResponse.Err != nil {
-		result.Err = fmt.Errorf("error generating content from Gemini: %w", apiResponse.Err)
+		result.Err = fmt.Errorf("error refining abstract with Gemini: %w", apiResponse.Err)
+	}
+	return result
+}
+
+// buildRegeneratePlanPrompt builds the request regeneratePlan sends after the original abstract:
+// a new chapter plan for the kept setting and characters.
+func buildRegeneratePlanPrompt(input RefineAbstractInput, sections file.AbstractSections, numChapters int) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `Keep the settings and characters of the story writing plan you wrote above exactly as they are, and write a new detailed plan for all %d chapters with a different plot.
+Use the same world and characters, and describe each chapter in about 100 words, numbered "Chapter 1" to "Chapter %d".
+Output ONLY the new chapter plan, starting with "Chapter 1". Do not repeat the settings or the characters.
+`, numChapters, numChapters)
+	if strings.TrimSpace(input.Instruction) != "" {
+		fmt.Fprintf(&b, "\nThe new plot should follow this direction: %s\n", input.Instruction)
+	}
+	fmt.Fprintf(&b, "\n--- Settings (keep) ---\n%s\n--- End Settings ---\n", sections.Setting)
+	if sections.Characters != "" {
+		fmt.Fprintf(&b, "\n--- Characters (keep) ---\n%s\n--- End Characters ---\n", sections.Characters)
+	}
+	fmt.Fprintf(&b, "\n--- Current Chapter Plan (replace) ---\n%s\n--- End Current Chapter Plan ---\n", sections.ChapterPlan)
+	fmt.Fprintf(&b, "\nOutput the plan in %s.", input.Language)
+	return b.String()
+}
+
+// regeneratePlan implements --regenerate-plan: it keeps the setting and character sections of the
+// original abstract word for word (see file.SplitAbstractSections) and asks Gemini for a new
+// chapter plan of input.NumChapters chapters, or as many as the original plans. Like
+// refineAbstract, the original is sent as the model's previous turn with its thought signature.
+func regeneratePlan(input RefineAbstractInput) AbstractGenerationResult {
+	var result AbstractGenerationResult
+
+	sections, err := file.SplitAbstractSections(input.Original)
+	if err != nil {
+		result.Err = fmt.Errorf("cannot regenerate the chapter plan: %w", err)
 		return result
 	}
+	numChapters := input.NumChapters
+	if numChapters == 0 {
+		numChapters = file.CountPlannedChapters(sections.ChapterPlan)
+	}
+	log.Printf("Keeping the settings (%d characters) and characters (%d characters) of the abstract; regenerating its %d-chapter plan.",
+		len(sections.Setting), len(sections.Characters), numChapters)
 
-	result.Abstract = apiResponse.GeneratedText
+	apiInput := aiEndpoint.CallGeminiAPIInput{
+		Ctx:           contextOrBackground(input.Ctx),
+		APIKey:        input.APIKey,
+		ModelName:     input.ModelName,
+		Prompt:        buildRegeneratePlanPrompt(input, sections, numChapters),
+		ThinkingLevel: input.ThinkingLevel,
+		PreviousTurn: &aiEndpoint.HistoryTurn{
+			UserPrompt:       "Write a concise, compelling story writing plan, including the settings, the name of main characters and a detail plan for all chapters.",
+			ModelResponse:    input.Original,
+			ThoughtSignature: input.ThoughtSignature,
+		},
+		SystemInstruction: input.StylePrompt,
+		Seed:              input.Seed,
+		Temperature:       input.Temperature,
+		TopP:              input.TopP,
+		ThinkingBudget:    input.ThinkingBudget,
+		Timeout:           input.Timeout,
+		Limiter:           input.Limiter,
+		Client:            input.Client,
+		HTTPClient:        input.HTTPClient,
+		CacheDir:          input.CacheDir,
+	}
+	apiResponse := aiEndpoint.CallGeminiAPI(apiInput)
 	result.ThoughtSignature = apiResponse.ThoughtSignature
 	result.InputTokens = apiResponse.InputTokens
 	result.OutputTokens = apiResponse.OutputTokens
 	result.Cost = apiResponse.Cost
+	if apiResponse.Err != nil {
+		result.Abstract = apiResponse.GeneratedText
+		result.Err = fmt.Errorf("error regenerating the chapter plan with Gemini: %w", apiResponse.Err)
+		return result
+	}
+
+	// Anything the model wrote before the first chapter, such as a repeated setting, is dropped.
+	newPlan, err := file.SplitAbstractSections(apiResponse.GeneratedText)
+	if err != nil {
+		result.Abstract = apiResponse.GeneratedText
+		result.Err = fmt.Errorf("the regenerated chapter plan cannot be used: %w", err)
+		return result
+	}
+	sections.ChapterPlan = newPlan.ChapterPlan
+	result.Abstract = sections.String()
+	if planned := file.CountPlannedChapters(sections.ChapterPlan); planned != numChapters {
+		log.Printf("Warning: The regenerated plan has %d chapters instead of %d.", planned, numChapters)
+	}
 	return result
 }
 
 // GetChapterCountInput holds all input parameters for the getChapterCountFromGemini function.
 // This is specific to the abstract subcommand's chapter count check.
 type GetChapterCountInput struct {
-	APIKey        string
-	ModelName     string
-	ThinkingLevel string
-	Abstract      string
+	Ctx            context.Context // Defaults to context.Background() when nil
+	APIKey         string
+	ModelName      string
+	ThinkingLevel  string
+	Abstract       string
+	Seed           *int
+	Timeout        time.Duration
+	ThinkingBudget *int32
+	Limiter        *rate.Limiter
+	Client         aiEndpoint.GenaiClient
+	HTTPClient     *http.Client
+	CacheDir       string
 }
 
 // getChapterCountFromGemini sends the abstract to Gemini to get a pure chapter count.
@@ -108,41 +341,60 @@ Do not include any other text, explanation, or formatting. Just the pure number.
 `, input.Abstract)
 
 	apiInput := aiEndpoint.CallGeminiAPIInput{
-		Ctx:           context.Background(),
-		APIKey:        input.APIKey,
-		ModelName:     input.ModelName,
-		Prompt:        prompt,
-		ThinkingLevel: input.ThinkingLevel,
-		PreviousTurn:  nil,
+		Ctx:            contextOrBackground(input.Ctx),
+		APIKey:         input.APIKey,
+		ModelName:      input.ModelName,
+		Prompt:         prompt,
+		ThinkingLevel:  input.ThinkingLevel,
+		PreviousTurn:   nil,
+		Seed:           input.Seed,
+		Timeout:        input.Timeout,
+		ThinkingBudget: input.ThinkingBudget,
+		Limiter:        input.Limiter,
+		Client:         input.Client,
+		HTTPClient:     input.HTTPClient,
+		CacheDir:       input.CacheDir,
 	}
 	apiResponse := aiEndpoint.CallGeminiAPI(apiInput)
+	// A failed call is still billed for the tokens it reports.
+	result.InputTokens = apiResponse.InputTokens
+	result.OutputTokens = apiResponse.OutputTokens
+	result.Cost = apiResponse.Cost
 
 	if apiResponse.Err != nil {
 		result.Err = fmt.Errorf("error calling Gemini to get chapter count: %w", apiResponse.Err)
 		return result
 	}
 
-	// Clean up the response to ensure it's a pure number
-	countStr := strings.TrimSpace(apiResponse.GeneratedText)
-	// Take only the first line in case Gemini adds extra text after the number
-	countStr = strings.Split(countStr, "\n")[0]
-
-	count, err := strconv.Atoi(countStr)
+	// The model may decorate the number, e.g. "30." or "**30**"; the first integer is the count.
+	count, err := aiEndpoint.ParseChapterCount(apiResponse.GeneratedText)
 	if err != nil {
-		result.InputTokens = apiResponse.InputTokens
-		result.OutputTokens = apiResponse.OutputTokens
-		result.Cost = apiResponse.Cost
-		result.Err = fmt.Errorf("could not parse chapter count '%s' from Gemini response: %w", countStr, err)
+		result.Err = fmt.Errorf("could not parse chapter count from Gemini response: %w", err)
 		return result
 	}
 
 	result.Count = count
-	result.InputTokens = apiResponse.InputTokens
-	result.OutputTokens = apiResponse.OutputTokens
-	result.Cost = apiResponse.Cost
 	return result
 }
 
+// readInstructionFile reads a story instruction for --instruction-file. The file must be UTF-8;
+// a byte order mark and trailing newlines are removed.
+func readInstructionFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read instruction file '%s': %w", path, err)
+	}
+	if !utf8.Valid(data) {
+		return "", fmt.Errorf("instruction file '%s' is not valid UTF-8", path)
+	}
+	instruction := strings.TrimRight(strings.TrimPrefix(string(data), "\uFEFF"), "\r\n")
+	if strings.TrimSpace(instruction) == "" {
+		return "", fmt.Errorf("instruction file '%s' is empty", path)
+	}
+	log.Printf("Read the story instruction from '%s' (%d characters).", path, utf8.RuneCountInString(instruction))
+	return instruction, nil
+}
+
 // Execute is the main entry point for the 'abstract' subcommand.
 func Execute(args []string) error {
 	cmd := flag.NewFlagSet("abstract", flag.ContinueOnError) // Use ContinueOnError to allow main to handle errors
@@ -152,110 +404,222 @@ func Execute(args []string) error {
 	}
 
 	// Define command-line flags
-	configPath := cmd.String("config", "", "Path to Gemini configuration JSON file (optional). If not provided, API key is taken from GEMINI_API_KEY env var and model defaults to 'gemini-pro'.")
+	configPath := cmd.String("config", "", "Path to Gemini configuration JSON file (optional). If not provided, API key is taken from GEMINI_API_KEY env var and model defaults to '"+aiEndpoint.DefaultGeminiModel+"'.")
+	apiKeyFile := cmd.String("api-key-file", "", "Path to a file containing the Gemini API key, e.g. a mounted CI secret (optional). Takes precedence over the config file and the GEMINI_API_KEY and GEMINI_API_KEY_FILE env vars; surrounding whitespace is trimmed.")
 	outputPath := cmd.String("output", "", "Path to save the generated abstract file (default: abstract-yyyy-mm-dd-hh-mm-ss.yaml)") // Changed default extension
 
 	defaultInstruction := ""
-	instruction := cmd.String("instruction", defaultInstruction, "Story instruction or idea for which to generate an abstract (optional). ")
+	instruction := cmd.String("instruction", defaultInstruction, "Story instruction or idea for which to generate an abstract (optional). When empty, Gemini first invents a random premise (see --genre) and the plan is written from it.")
+
+	instructionFile := cmd.String("instruction-file", "", "Path to a UTF-8 text file holding the story instruction, for long multi-paragraph premises (optional). Trailing newlines are trimmed. Mutually exclusive with --instruction.")
+
+	var genres []string
+	cmd.Func("genre", "Genre of the random premise invented when --instruction is empty, e.g. 'noir' (repeatable or comma-separated; one is picked at random). Defaults to a built-in list of common genres.", func(value string) error {
+		for _, genre := range strings.Split(value, ",") {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				genres = append(genres, genre)
+			}
+		}
+		return nil
+	})
 
 	language := cmd.String("language", "english", "Specify the desired output language for the abstract (default: english).")
 
+	style := cmd.String("style", "", "Style prompt applied to every generation call, e.g. 'hard-boiled noir, present tense' (optional). Overrides 'style_prompt' from the config file and is saved in the abstract so the story command reuses it.")
+
 	chapters := cmd.Int("chapters", 0, "Specify the desired number of chapters for the story plan (optional). If not provided, a random number between 20-40 will be used.")
 
-	if err := cmd.Parse(args); err != nil {
-		return fmt.Errorf("failed to parse abstract subcommand flags: %w", err)
-	}
+	refineFrom := cmd.String("refine-from", "", "Path to an existing abstract file to revise according to --instruction instead of generating a fresh plan (optional). The chapter count and style of the original are kept unless --chapters or --style is given.")
 
-	// Load Gemini config using the utility function
-	geminiConfigDetails := aiEndpoint.LoadGeminiConfigWithFallback(*configPath) // Updated call
-	if geminiConfigDetails.Err != nil {
-		return geminiConfigDetails.Err // aiEndpoint.LoadGeminiConfigWithFallback already logs detailed errors.
-	}
-	apiKey := geminiConfigDetails.APIKey
-	modelName := geminiConfigDetails.ModelName
-	thinkingLevel := geminiConfigDetails.ThinkingLevel
+	regeneratePlan := cmd.Bool("regenerate-plan", false, "With --refine-from, keep the abstract's settings and character sections word for word and write a new chapter plan of the same length (or --chapters), for when you like the world but not the plot. --instruction is optional and steers the new plot.")
 
-	// Determine number of chapters for the *initial* abstract generation
-	numChapters := *chapters
-	if numChapters == 0 {
-		// Seed the random number generator
-		rand.Seed(time.Now().UnixNano())
-		// Generate a random number between 20 and 40 (inclusive)
-		numChapters = rand.Intn(21) + 20 // rand.Intn(n) generates [0, 20]. Adding 20 shifts it to [20, 40].
-		log.Printf("Number of chapters not specified for abstract generation. Generating a random number: %d", numChapters)
-	} else {
-		log.Printf("Using specified number of chapters: %d for abstract generation", numChapters)
+	interactive := cmd.Bool("interactive", false, "After generating the abstract, print it and read revision requests from stdin (e.g. 'rename the villain'), refining the plan after each one until you type 'accept'.")
+
+	normalize := cmd.Bool("normalize-abstract", false, "Strip Markdown (headers, bold, bullet markers, rules) from the generated abstract before saving it. The unmodified model output is kept in the file as 'abstract_raw'.")
+
+	var verbosity logging.VerbosityFlags
+	verbosity.Register(cmd)
+
+	yes := cmd.Bool("yes", false, "Do not ask for confirmation when the abstract prompt falls in a higher price tier. Use this when running non-interactively.")
+
+	var seed *int
+	cmd.Func("seed", "Sampling seed for reproducible generation (optional). Also fixes the random chapter count when --chapters is not given.", func(value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("--seed must be an integer: %w", err)
+		}
+		seed = &n
+		return nil
+	})
+
+	var temperature, topP *float32
+	float32Flag := func(dst **float32, name string) func(string) error {
+		return func(value string) error {
+			f, err := strconv.ParseFloat(value, 32)
+			if err != nil {
+				return fmt.Errorf("--%s must be a number: %w", name, err)
+			}
+			v := float32(f)
+			*dst = &v
+			return nil
+		}
 	}
+	cmd.Func("temperature", "Sampling temperature between 0 and 2; higher is more creative (optional). Overrides 'temperature' in the config file; defaults to the model's own setting.", float32Flag(&temperature, "temperature"))
+	cmd.Func("top-p", "Nucleus sampling top-p between 0 and 1 (optional). Overrides 'top_p' in the config file; defaults to the model's own setting.", float32Flag(&topP, "top-p"))
+
+	createDirs := cmd.Bool("create-dirs", false, "Create the directory of --output when it does not exist. Without it, a missing directory fails before any paid API call. The default 'output' directory and --output-dir are always created.")
+
+	outputDir := cmd.String("output-dir", "", "Directory to save the abstract in (default 'output'). When given, a relative --output path is taken inside it. Created if needed.")
+
+	var thinkingBudget *int32
+	cmd.Func("thinking-budget", "Thinking tokens per Gemini call: -1 for dynamic, 0 to turn thinking off where the model allows it, or a token count such as 512 to save cost (optional). Overrides 'thinking_budget' in the config file and wins over thinking_level.", func(value string) error {
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("--thinking-budget must be an integer: %w", err)
+		}
+		v := int32(n)
+		thinkingBudget = &v
+		return nil
+	})
+
+	rpm := cmd.Int("rpm", 60, "Maximum Gemini requests per minute across all calls made by the command, such as the premise, the abstract, and the chapter count (0 disables rate limiting). The same flag and default as the story command.")
+
+	timeout := cmd.Duration("timeout", 10*time.Minute, "Maximum time to wait for each Gemini call, e.g. '5m' or '90s' (0 waits forever). A call that takes longer fails with a timeout error.")
+
+	pricingFile := cmd.String("pricing-file", "", "Path to a pricing JSON file overriding the built-in model prices (optional). Defaults to the GEMINI_PRICING_FILE env var.")
+
+	costFormat := aiEndpoint.DefaultCostFormat
+	cmd.StringVar(&costFormat.Currency, "currency", costFormat.Currency, "Currency code costs are displayed in, e.g. 'EUR'. Use with --exchange-rate.")
+	cmd.Float64Var(&costFormat.ExchangeRate, "exchange-rate", costFormat.ExchangeRate, "Units of --currency per 1 USD, used to convert displayed costs.")
+	cmd.IntVar(&costFormat.Decimals, "cost-decimals", costFormat.Decimals, "Number of decimal places shown for costs.")
 
-	var accumulatedInputTokens int
-	var accumulatedOutputTokens int
-	var accumulatedCost float64
+	ledger := cmd.String("ledger", "", "Path to a CSV ledger to append a row with this run's model, tokens, cost, and output file to, e.g. 'ledger.csv' to track spend across runs (optional). Created with a header row if missing; safe to share between commands running at the same time.")
 
-	// --- Generate Abstract ---
-	log.Printf("Initiating abstract generation using Gemini model: %s, output language: %s, chapters: %d", modelName, *language, numChapters)
-	generateAbstractInput := GenerateAbstractInput{
-		APIKey:        apiKey,
-		ModelName:     modelName,
-		ThinkingLevel: thinkingLevel,
-		Instruction:   *instruction,
-		Language:      *language,
-		NumChapters:   numChapters,
+	fileMode := cmd.String("file-mode", fmt.Sprintf("%04o", file.DefaultFileMode), "Octal permission of the files the command creates, e.g. '0600' to keep the abstract private. The umask still applies and existing files keep their permissions. Must include owner read and write (0600).")
+
+	mock := cmd.Bool("mock", false, "Answer every Gemini call locally with deterministic placeholder (lorem ipsum) text instead of calling the API: no API key is needed and the cost is 0. For demos and offline tests of the file pipeline.")
+
+	cacheDir := cmd.String("cache-dir", "", "Directory to cache Gemini responses in, keyed by a hash of the model, prompt, history, and settings (optional, for development). Re-running the same abstract is answered from it at no cost; errors are never cached. Delete the directory to clear it.")
+
+	var httpOptions aiEndpoint.HTTPClientOptions
+	cmd.StringVar(&httpOptions.Proxy, "proxy", "", "Proxy URL for Gemini requests, e.g. 'http://proxy.corp:3128' (optional). Defaults to the HTTPS_PROXY/HTTP_PROXY environment variables.")
+	cmd.StringVar(&httpOptions.CACertPath, "ca-cert", "", "Path to a PEM bundle of extra CA certificates to trust, e.g. a corporate TLS-inspecting proxy's (optional).")
+	cmd.DurationVar(&httpOptions.Timeout, "http-timeout", 0, "Overall limit for each HTTP request to Gemini, e.g. '15m' (0 means no limit). Unlike --timeout, it also applies at the transport level.")
+
+	if err := cmd.Parse(args); err != nil {
+		return cli.UsageError(fmt.Errorf("failed to parse abstract subcommand flags: %w", err))
 	}
-	abstractResult := generateAbstract(generateAbstractInput) // Updated call
-	if abstractResult.Err != nil {
-		return fmt.Errorf("error generating abstract: %w", abstractResult.Err)
+	if err := verbosity.Apply(); err != nil {
+		return cli.UsageError(err)
 	}
-	abstract := abstractResult.Abstract
-	signature := abstractResult.ThoughtSignature
-	accumulatedInputTokens += abstractResult.InputTokens
-	accumulatedOutputTokens += abstractResult.OutputTokens
-	accumulatedCost += abstractResult.Cost
-	log.Printf("Abstract generation complete. Input tokens: %d, Output tokens: %d, Cost: $%.6f", abstractResult.InputTokens, abstractResult.OutputTokens, abstractResult.Cost)
+	originalLogOutput := log.Writer()
+	log.SetOutput(logging.Console(originalLogOutput))
+	defer log.SetOutput(originalLogOutput)
 
-	// --- Determine Output Path ---
-	finalOutputPath := *outputPath
-	if finalOutputPath == "" {
-		timestamp := time.Now().Format("2006-01-02-15-04-05")
-		finalOutputPath = filepath.Join("output", fmt.Sprintf("abstract-%s.yaml", timestamp))
+	if *timeout < 0 {
+		return cli.UsageError(fmt.Errorf("--timeout must not be negative"))
 	}
-
-	// Ensure output directory exists
-	outputDir := filepath.Dir(finalOutputPath)
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return fmt.Errorf("failed to create output directory '%s': %w", outputDir, err)
+	if *rpm < 0 {
+		return cli.UsageError(fmt.Errorf("--rpm must not be negative"))
 	}
-
-	// --- Save Abstract and Thought Signature to YAML File ---
-	err := file.WriteAbstractFile(finalOutputPath, abstract, signature)
+	if err := aiEndpoint.LoadPricingOverrides(*pricingFile); err != nil {
+		return err
+	}
+	if err := aiEndpoint.SetCostFormat(costFormat); err != nil {
+		return cli.UsageError(fmt.Errorf("invalid cost display flags: %w", err))
+	}
+	httpClient, err := aiEndpoint.ConfiguredHTTPClient(httpOptions)
 	if err != nil {
-		return fmt.Errorf("error saving abstract: %w", err)
+		return cli.UsageError(fmt.Errorf("invalid HTTP client flags: %w", err))
+	}
+	if *cacheDir, err = aiEndpoint.PrepareCacheDir(*cacheDir); err != nil {
+		return err
+	}
+	mode, err := file.ParseFileMode(*fileMode)
+	if err != nil {
+		return cli.UsageError(fmt.Errorf("invalid --file-mode: %w", err))
+	}
+	file.SetFileMode(mode)
+	if *instructionFile != "" {
+		if *instruction != "" {
+			return cli.UsageError(fmt.Errorf("--instruction and --instruction-file are mutually exclusive"))
+		}
+		if *instruction, err = readInstructionFile(*instructionFile); err != nil {
+			return err
+		}
 	}
 
-	fmt.Printf("Abstract successfully generated and saved to: %s\n", finalOutputPath)
-	log.Printf("Abstract saved to: %s", finalOutputPath)
-
-	// --- New Step: Get pure chapter count from Gemini ---
-	log.Printf("Sending abstract to Gemini to get pure chapter count...")
-	getChapterCountInput := GetChapterCountInput{
-		APIKey:        apiKey,
-		ModelName:     modelName,
-		ThinkingLevel: thinkingLevel,
-		Abstract:      abstract,
+	cfg := AbstractConfig{
+		ConfigPath:     *configPath,
+		APIKeyFile:     *apiKeyFile,
+		Instruction:    *instruction,
+		Genres:         genres,
+		Language:       *language,
+		NumChapters:    *chapters,
+		StylePrompt:    *style,
+		Seed:           seed,
+		Temperature:    temperature,
+		TopP:           topP,
+		ThinkingBudget: thinkingBudget,
+		RefineFrom:     *refineFrom,
+		RegeneratePlan: *regeneratePlan,
+		Normalize:      *normalize,
+		OutputPath:     *outputPath,
+		OutputDir:      *outputDir,
+		CreateDirs:     *createDirs,
+		Mock:           *mock,
+		HTTPClient:     httpClient,
+		CacheDir:       *cacheDir,
+		Timeout:        *timeout,
+		Limiter:        aiEndpoint.NewRateLimiter(*rpm),
 	}
-	chapterCountResult := getChapterCountFromGemini(getChapterCountInput) // Updated call
-	if chapterCountResult.Err != nil {
-		log.Printf("Warning: Failed to get pure chapter count from Gemini: %v. Proceeding without this information.", chapterCountResult.Err)
-	} else {
-		accumulatedInputTokens += chapterCountResult.InputTokens
-		accumulatedOutputTokens += chapterCountResult.OutputTokens
-		accumulatedCost += chapterCountResult.Cost
-		fmt.Printf("Pure chapter count from Gemini: %d\n", chapterCountResult.Count)
-		log.Printf("Pure chapter count from Gemini: %d. Input tokens: %d, Output tokens: %d, Cost: $%.6f", chapterCountResult.Count, chapterCountResult.InputTokens, chapterCountResult.OutputTokens, chapterCountResult.Cost)
+	if *refineFrom != "" {
+		// Keep the original's language unless --language was given explicitly.
+		languageSet := false
+		cmd.Visit(func(f *flag.Flag) { languageSet = languageSet || f.Name == "language" })
+		if !languageSet {
+			cfg.Language = ""
+		}
+	}
+	if !*yes {
+		cfg.ConfirmIn = os.Stdin
+		cfg.ConfirmOut = os.Stdout
+	}
+	if *interactive {
+		cfg.InteractiveIn = os.Stdin
+		cfg.InteractiveOut = os.Stdout
 	}
 
-	fmt.Printf("Total accumulated cost for abstract generation process: $%.6f\n", accumulatedCost)
-	log.Printf("Total accumulated tokens for abstract generation process: Input %d, Output %d. Total accumulated cost: $%.6f",
-		accumulatedInputTokens, accumulatedOutputTokens, accumulatedCost)
+	result, err := GenerateAbstractStory(context.Background(), cfg)
+	// A failed run is recorded too when it has already paid for Gemini calls.
+	if *ledger != "" && (err == nil || result.InputTokens > 0) {
+		entry := aiEndpoint.LedgerEntry{
+			Time:         time.Now(),
+			Command:      "abstract",
+			Model:        result.Model,
+			InputTokens:  result.InputTokens,
+			OutputTokens: result.OutputTokens,
+			Cost:         result.Cost,
+			OutputPath:   result.OutputPath,
+		}
+		if ledgerErr := aiEndpoint.AppendLedger(*ledger, entry); ledgerErr != nil {
+			log.Printf("Warning: %v", ledgerErr)
+		}
+	}
+	if err != nil {
+		return err
+	}
 
+	if result.Premise != "" {
+		logging.Printf(logging.VerbosityNormal, "Invented premise: %s\n", result.Premise)
+	}
+	if *instructionFile != "" && result.PromptTokens > 0 {
+		logging.Printf(logging.VerbosityNormal, "Abstract prompt with the instruction from '%s': %d input tokens\n", *instructionFile, result.PromptTokens)
+	}
+	if result.ChapterCount > 0 {
+		logging.Printf(logging.VerbosityNormal, "Pure chapter count from Gemini: %d\n", result.ChapterCount)
+	}
+	logging.Printf(logging.VerbosityQuiet, "Abstract successfully generated and saved to: %s\n", result.OutputPath)
+	logging.Printf(logging.VerbosityQuiet, "Total accumulated cost for abstract generation process: %s\n", aiEndpoint.FormatCost(result.Cost))
 	return nil
 }
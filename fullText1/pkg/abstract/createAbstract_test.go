@@ -0,0 +1,47 @@
+package abstract
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"google.golang.org/genai"
+)
+
+// rateLimitedClient is an aiEndpoint.GenaiClient whose calls are all rate limited. It runs cancel
+// after the first one, as if the user gave up while the caller backs off.
+type rateLimitedClient struct {
+	cancel context.CancelFunc
+	calls  int
+}
+
+// CountTokens reports a fixed prompt size.
+func (c *rateLimitedClient) CountTokens(ctx context.Context, model string, contents []*genai.Content, config *genai.CountTokensConfig) (*genai.CountTokensResponse, error) {
+	return &genai.CountTokensResponse{TotalTokens: 1000}, nil
+}
+
+// GenerateContent fails with a 429, billed for the prompt, and cancels the context.
+func (c *rateLimitedClient) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	c.calls++
+	c.cancel()
+	return &genai.GenerateContentResponse{UsageMetadata: &genai.GenerateContentResponseUsageMetadata{PromptTokenCount: 1000}},
+		genai.APIError{Code: http.StatusTooManyRequests, Status: "RESOURCE_EXHAUSTED"}
+}
+
+func TestGenerateAbstractBackoffStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	client := &rateLimitedClient{cancel: cancel}
+
+	result := generateAbstract(GenerateAbstractInput{Ctx: ctx, APIKey: "key", ModelName: "gemini-2.5-flash", Instruction: "A storm.", NumChapters: 3, Client: client})
+	if !errors.Is(result.Err, context.Canceled) {
+		t.Errorf("generateAbstract() error = %v, want context.Canceled", result.Err)
+	}
+	if client.calls != 1 {
+		t.Errorf("generateAbstract() made %d calls, want 1 before the cancelled backoff", client.calls)
+	}
+	if result.InputTokens != 1000 || result.Cost == 0 {
+		t.Errorf("generateAbstract() usage = (%d tokens, cost %g), want the billed first attempt", result.InputTokens, result.Cost)
+	}
+}
@@ -0,0 +1,80 @@
+package abstract
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+)
+
+// promptEstimate is the pre-generation estimate of an abstract prompt's size and input cost.
+type promptEstimate struct {
+	InputTokens int
+	InputCost   float64
+	Tier        aiEndpoint.PricingTier
+}
+
+// estimatePrompt counts the tokens of the prompt of input, about to be sent, and logs the estimated
+// input cost and the pricing tier that applies. Counting is free; it does not generate anything.
+func estimatePrompt(input aiEndpoint.CallGeminiAPIInput) (promptEstimate, error) {
+	var estimate promptEstimate
+	modelName := input.ModelName
+
+	tokens, err := aiEndpoint.CountInputTokens(input)
+	if err != nil {
+		return estimate, err
+	}
+	estimate.InputTokens = tokens
+
+	tier, err := aiEndpoint.GetPricingTier(modelName, tokens)
+	if err != nil {
+		log.Printf("Estimated abstract prompt size: %d input tokens. Pricing unknown for model '%s': %v", tokens, modelName, err)
+		return estimate, nil
+	}
+	estimate.Tier = tier
+	estimate.InputCost = float64(tokens) / aiEndpoint.TokensPerMillion * tier.Prices.InputPricePerMillion
+
+	log.Printf("Estimated abstract prompt size: %d input tokens, pricing tier %d of %d (%s input / %s output per million tokens). Estimated input cost: %s",
+		tokens, tier.Index+1, tier.Count,
+		aiEndpoint.FormatCost(tier.Prices.InputPricePerMillion), aiEndpoint.FormatCost(tier.Prices.OutputPricePerMillion),
+		aiEndpoint.FormatCost(estimate.InputCost))
+	if tier.IsHigherTier() {
+		log.Printf("Warning: The abstract prompt is unusually large (%d tokens) and falls in a higher price tier for model '%s'. Consider shortening --instruction or --instruction-file.", tokens, modelName)
+	}
+	return estimate, nil
+}
+
+// confirmPrompt asks on out whether to continue and reads a yes/no answer from in. Anything
+// other than "y" or "yes", including end of input, declines. It reads one byte at a time so
+// nothing after the answer line is consumed from in.
+func confirmPrompt(in io.Reader, out io.Writer, question string) (bool, error) {
+	fmt.Fprintf(out, "%s [y/N]: ", question)
+
+	var line strings.Builder
+	buf := make([]byte, 1)
+	for {
+		n, err := in.Read(buf)
+		if n > 0 {
+			if buf[0] == '\n' {
+				break
+			}
+			line.WriteByte(buf[0])
+		}
+		if err == io.EOF {
+			fmt.Fprintln(out)
+			break
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read confirmation: %w", err)
+		}
+	}
+
+	switch strings.ToLower(strings.TrimSpace(line.String())) {
+	case "y", "yes":
+		return true, nil
+	default:
+		return false, nil
+	}
+}
@@ -1,8 +1,11 @@
 package file
 
 import (
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"strings"
@@ -10,84 +13,258 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
-// AbstractOutput structure func call
+// AbstractOutput holds an abstract and its metadata as used in code.
 type AbstractOutput struct {
 	Abstract         string `json:"abstract" yaml:"abstract"`
 	ThoughtSignature []byte `json:"thought_signature,omitempty" yaml:"thought_signature,omitempty"`
+	ChapterCount     int    `json:"chapter_count,omitempty" yaml:"chapter_count,omitempty"` // 0 when unknown
+	StylePrompt      string `json:"style_prompt,omitempty" yaml:"style_prompt,omitempty"`
+	AbstractRaw      string `json:"abstract_raw,omitempty" yaml:"abstract_raw,omitempty"` // Model output before NormalizeAbstract; empty when not normalized
+	Language         string `json:"language,omitempty" yaml:"language,omitempty"`         // Language the story should be written in
 }
 
 // AbstractOutputFile structure for YAML/JSON output
 type AbstractOutputFile struct {
 	Abstract         string `json:"abstract" yaml:"abstract"`
-	ThoughtSignature string `json:"thought_signature,omitempty" yaml:"thought_signature,omitempty"`
+	ThoughtSignature string `json:"thought_signature,omitempty" yaml:"thought_signature,omitempty"` // Base64, see EncodeThoughtSignature
+	ChapterCount     int    `json:"chapter_count,omitempty" yaml:"chapter_count,omitempty"`
+	StylePrompt      string `json:"style_prompt,omitempty" yaml:"style_prompt,omitempty"`
+	AbstractRaw      string `json:"abstract_raw,omitempty" yaml:"abstract_raw,omitempty"`
+	Language         string `json:"language,omitempty" yaml:"language,omitempty"`
 }
 
 // StoryStatus represents the state of story generation saved to a file.
 type StoryStatus struct {
-	PreviousChapters        string  `yaml:"previous_chapters"`
-	LastThoughtSignature    string  `yaml:"last_thought_signature"`
-	AccumulatedInputTokens  int     `yaml:"accumulated_input_tokens"`
-	AccumulatedOutputTokens int     `yaml:"accumulated_output_tokens"`
-	AccumulatedCost         float64 `yaml:"accumulated_cost"`
-	ChaptersWritten         int     `yaml:"chapters_written"`
+	PreviousChapters        string           `yaml:"previous_chapters"`
+	LastThoughtSignature    string           `yaml:"last_thought_signature"` // Base64, see EncodeThoughtSignature
+	AccumulatedInputTokens  int              `yaml:"accumulated_input_tokens"`
+	AccumulatedOutputTokens int              `yaml:"accumulated_output_tokens"`
+	AccumulatedCost         float64          `yaml:"accumulated_cost"`
+	ChaptersWritten         int              `yaml:"chapters_written"`
+	ChapterMetrics          []ChapterMetrics `yaml:"chapter_metrics,omitempty"`
+	StorySummary            string           `yaml:"story_summary,omitempty"`         // Rolling summary used by --context-mode summary
+	SummaryChapter          int              `yaml:"summary_chapter,omitempty"`       // Last chapter covered by StorySummary
+	ChapterTitles           map[int]string   `yaml:"chapter_titles,omitempty"`        // Title the model gave each chapter
+	ChapterRecaps           map[int]string   `yaml:"chapter_recaps,omitempty"`        // One-sentence recap of each chapter from --include-chapter-summaries
+	AppendPrompts           []string         `yaml:"append_prompts,omitempty"`        // --append-prompt instructions, kept for resumed runs
+	PlanningUsage           *UsageTotals     `yaml:"planning_usage,omitempty"`        // Part of the accumulated totals spent on chapter count calls
+	SummaryUsage            *UsageTotals     `yaml:"summary_usage,omitempty"`         // Part of the accumulated totals spent on --context-mode summary updates and chapter recaps
+	ReviewUsage             *UsageTotals     `yaml:"review_usage,omitempty"`          // Part of the accumulated totals spent on --review passes
+	ChapterHeaderFormat     string           `yaml:"chapter_header_format,omitempty"` // --chapter-header-format of the story file
+	Separator               string           `yaml:"separator,omitempty"`             // --separator of the story file
+	NoChapterTitles         bool             `yaml:"no_chapter_titles,omitempty"`     // --no-chapter-titles: chapters are written without a title line
+	AppendTo                string           `yaml:"append_to,omitempty"`             // --append-to file the story is also written into
+	AppendOffset            int64            `yaml:"append_offset,omitempty"`         // Byte offset in AppendTo where the story starts
+	AppendLength            int64            `yaml:"append_length,omitempty"`         // Bytes of AppendTo written by the last save, from AppendOffset
+}
+
+// UsageTotals records the tokens and USD cost of a group of Gemini calls.
+type UsageTotals struct {
+	InputTokens  int     `yaml:"input_tokens" json:"input_tokens"`
+	OutputTokens int     `yaml:"output_tokens" json:"output_tokens"`
+	Cost         float64 `yaml:"cost" json:"cost"`
+}
+
+// ChapterMetrics records the size, API usage, and wall-clock generation time of one chapter.
+type ChapterMetrics struct {
+	Chapter      int     `yaml:"chapter" json:"chapter"`
+	Words        int     `yaml:"words" json:"words"`
+	InputTokens  int     `yaml:"input_tokens" json:"input_tokens"`
+	OutputTokens int     `yaml:"output_tokens" json:"output_tokens"`
+	Cost         float64 `yaml:"cost" json:"cost"`
+	Seconds      float64 `yaml:"seconds" json:"seconds"`                 // Includes retries, expansion rounds, and rate-limit waits
+	Model        string  `yaml:"model,omitempty" json:"model,omitempty"` // Model that wrote the chapter, e.g. the --fallback-model
+}
+
+// Abstract formats understood by ReadAbstractReader.
+const (
+	AbstractFormatText = "text"
+	AbstractFormatYAML = "yaml"
+	AbstractFormatJSON = "json"
+)
+
+// AbstractFormatAuto makes ReadAbstractOutputFileAs pick the format from the file extension.
+const AbstractFormatAuto = "auto"
+
+// ValidateAbstractFormat checks a format for ReadAbstractOutputFileAs: AbstractFormatAuto or one
+// of the formats understood by ReadAbstractReader.
+func ValidateAbstractFormat(format string) error {
+	switch format {
+	case AbstractFormatAuto, AbstractFormatText, AbstractFormatYAML, AbstractFormatJSON:
+		return nil
+	}
+	return fmt.Errorf("unsupported abstract format '%s' (valid values: %s, %s, %s, %s)", format, AbstractFormatAuto, AbstractFormatText, AbstractFormatYAML, AbstractFormatJSON)
+}
+
+// AbstractFormatFromPath returns the abstract format implied by the file extension,
+// defaulting to plain text for unknown extensions.
+func AbstractFormatFromPath(path string) string {
+	lowerPath := strings.ToLower(path)
+	switch {
+	case strings.HasSuffix(lowerPath, ".yaml") || strings.HasSuffix(lowerPath, ".yml"):
+		return AbstractFormatYAML
+	case strings.HasSuffix(lowerPath, ".json"):
+		return AbstractFormatJSON
+	default:
+		return AbstractFormatText
+	}
 }
 
 // ReadAbstractFile reads an abstract from the specified file path.
-// It attempts to parse it as YAML or JSON first, falling back to plain text if parsing fails.
-// It returns the abstract content, a boolean indicating if it was successfully parsed (YAML/JSON), and an error.
+// It attempts to parse it as YAML or JSON first (based on the file extension), falling back to plain text if parsing fails.
+// A file that parses but has no 'abstract' field, or an empty file, is an error.
+// It returns the abstract content, the thought signature (empty if none was stored), and an error.
 func ReadAbstractFile(abstractFilePath string) (rawAbstractContent string, thoughtSignature []byte, err error) {
+	output, err := ReadAbstractOutputFile(abstractFilePath)
+	return output.Abstract, output.ThoughtSignature, err
+}
+
+// ReadAbstractReader reads an abstract from r, such as stdin, where there is no file extension to sniff.
+// format must be one of AbstractFormatText, AbstractFormatYAML, or AbstractFormatJSON; YAML and JSON
+// input that fails to parse, or has no 'abstract' field, is treated as plain text.
+func ReadAbstractReader(r io.Reader, format string) (string, []byte, error) {
+	output, err := ReadAbstractOutputReader(r, format)
+	return output.Abstract, output.ThoughtSignature, err
+}
+
+// ReadAbstractOutputFile is like ReadAbstractFile but returns the abstract together with
+// all stored metadata, such as the cached chapter count.
+func ReadAbstractOutputFile(abstractFilePath string) (AbstractOutput, error) {
+	return ReadAbstractOutputFileAs(abstractFilePath, AbstractFormatAuto)
+}
+
+// ReadAbstractOutputFileAs is like ReadAbstractOutputFile but parses the file in the given format
+// whatever its extension, e.g. a YAML abstract named .txt. AbstractFormatAuto picks the format from
+// the extension, like ReadAbstractOutputFile.
+func ReadAbstractOutputFileAs(abstractFilePath, format string) (AbstractOutput, error) {
+	if err := ValidateAbstractFormat(format); err != nil {
+		return AbstractOutput{}, err
+	}
+	if format == AbstractFormatAuto {
+		format = AbstractFormatFromPath(abstractFilePath)
+	}
 	abstractContentBytes, err := os.ReadFile(abstractFilePath)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to read abstract file '%s': %w", abstractFilePath, err)
+		return AbstractOutput{}, fmt.Errorf("failed to read abstract file '%s': %w", abstractFilePath, err)
 	}
+	return parseAbstract(abstractContentBytes, format, fmt.Sprintf("abstract file '%s'", abstractFilePath), false)
+}
 
-	rawAbstractContent = string(abstractContentBytes) // Default to raw content
-	thoughtSignature = []byte{}
+// ReadAbstractOutputReader is like ReadAbstractReader but returns the abstract together with
+// all stored metadata, such as the cached chapter count.
+func ReadAbstractOutputReader(r io.Reader, format string) (AbstractOutput, error) {
+	switch format {
+	case AbstractFormatText, AbstractFormatYAML, AbstractFormatJSON:
+	default:
+		return AbstractOutput{}, fmt.Errorf("unsupported abstract format '%s' (valid values: %s, %s, %s)", format, AbstractFormatText, AbstractFormatYAML, AbstractFormatJSON)
+	}
 
-	if strings.HasSuffix(strings.ToLower(abstractFilePath), ".yaml") || strings.HasSuffix(strings.ToLower(abstractFilePath), ".yml") {
-		var abstractData AbstractOutputFile
-		if err := yaml.Unmarshal(abstractContentBytes, &abstractData); err != nil {
-			log.Printf("Warning: Failed to parse abstract file '%s' as YAML: %v. Attempting to treat as plain text.", abstractFilePath, err)
-			// Continue, abstractContent remains raw content
-		} else {
-			rawAbstractContent = abstractData.Abstract
-			thoughtSignature = []byte(abstractData.ThoughtSignature)
-			log.Printf("Successfully parsed abstract content from YAML file.")
-		}
-	} else if strings.HasSuffix(strings.ToLower(abstractFilePath), ".json") {
-		var abstractData AbstractOutputFile
-		if err := json.Unmarshal(abstractContentBytes, &abstractData); err != nil {
-			log.Printf("Warning: Failed to parse abstract file '%s' as JSON: %v. Attempting to treat as plain text.", abstractFilePath, err)
-			// Continue, abstractContent remains raw content
-		} else {
-			rawAbstractContent = abstractData.Abstract
-			thoughtSignature = []byte(abstractData.ThoughtSignature)
-			log.Printf("Successfully parsed abstract content from JSON file.")
+	abstractContentBytes, err := io.ReadAll(r)
+	if err != nil {
+		return AbstractOutput{}, fmt.Errorf("failed to read abstract: %w", err)
+	}
+	return parseAbstract(abstractContentBytes, format, "abstract input", true)
+}
+
+// parseAbstract extracts the abstract and its metadata from data in the given format.
+// source describes where the data came from and is used in log and error messages.
+// YAML or JSON that parses but has no 'abstract' field is treated as plain text when
+// textFallback is set (input such as stdin, whose format is only a guess) and is an error
+// otherwise, so an empty plan is never sent to the model.
+func parseAbstract(data []byte, format, source string, textFallback bool) (AbstractOutput, error) {
+	output := AbstractOutput{
+		Abstract:         string(data), // Default to raw content
+		ThoughtSignature: []byte{},
+	}
+	if strings.TrimSpace(output.Abstract) == "" {
+		return output, fmt.Errorf("%s is empty", source)
+	}
+
+	var abstractData AbstractOutputFile
+	var err error
+	switch format {
+	case AbstractFormatYAML:
+		err = yaml.Unmarshal(data, &abstractData)
+	case AbstractFormatJSON:
+		err = json.Unmarshal(data, &abstractData)
+	default:
+		return output, nil
+	}
+
+	if err != nil {
+		log.Printf("Warning: Failed to parse %s as %s: %v. Attempting to treat as plain text.", source, strings.ToUpper(format), err)
+		// Continue, abstract remains raw content
+		return output, nil
+	}
+
+	if strings.TrimSpace(abstractData.Abstract) == "" {
+		if textFallback {
+			log.Printf("Warning: %s parsed as %s but has no 'abstract' field. Treating it as plain text.", source, strings.ToUpper(format))
+			return output, nil
 		}
+		return output, fmt.Errorf("%s parsed as %s but has no 'abstract' field (or it is empty); add an 'abstract' key holding the plan, or save it with a .txt extension to read it as plain text", source, strings.ToUpper(format))
 	}
 
-	return rawAbstractContent, thoughtSignature, nil
+	output.Abstract = abstractData.Abstract
+	signature, err := DecodeThoughtSignature(abstractData.ThoughtSignature)
+	if err != nil {
+		// A bad signature would make the next API call fail; the story continues fine without one.
+		log.Printf("Warning: Ignoring the thought signature in %s: %v", source, err)
+	}
+	output.ThoughtSignature = signature
+	output.ChapterCount = abstractData.ChapterCount
+	output.StylePrompt = abstractData.StylePrompt
+	output.AbstractRaw = abstractData.AbstractRaw
+	output.Language = abstractData.Language
+	log.Printf("Successfully parsed abstract content from %s.", strings.ToUpper(format))
+	return output, nil
 }
 
-// WriteAbstractFile writes the abstract content and thought signature to the specified file path in YAML format.
-// The `ThoughtSignature []byte` field will be automatically base64 encoded by the YAML marshaler.
-func WriteAbstractFile(outputPath string, abstract string, thoughtSignature []byte) error {
+// WriteAbstractFile writes the abstract content, thought signature, chapter count, and style prompt to the specified file path in YAML format.
+// A zero ChapterCount and an empty StylePrompt, AbstractRaw, or Language are omitted from the file.
+func WriteAbstractFile(outputPath string, output AbstractOutput) error {
 	outputData := AbstractOutputFile{
-		Abstract:         abstract,
-		ThoughtSignature: string(thoughtSignature),
+		Abstract:         output.Abstract,
+		ThoughtSignature: EncodeThoughtSignature(output.ThoughtSignature),
+		ChapterCount:     output.ChapterCount,
+		StylePrompt:      output.StylePrompt,
+		AbstractRaw:      output.AbstractRaw,
+		Language:         output.Language,
 	}
 	yamlBytes, err := yaml.Marshal(outputData)
 	if err != nil {
 		return fmt.Errorf("error marshaling abstract output to YAML: %w", err)
 	}
 
-	err = os.WriteFile(outputPath, yamlBytes, 0644)
+	err = os.WriteFile(outputPath, yamlBytes, FileMode())
 	if err != nil {
 		return fmt.Errorf("error saving abstract to file '%s': %w", outputPath, err)
 	}
 	return nil
 }
 
+// EncodeThoughtSignature encodes a thought signature as standard base64 so arbitrary bytes survive
+// the text fields of the abstract and status files. An empty signature encodes to "".
+func EncodeThoughtSignature(signature []byte) string {
+	if len(signature) == 0 {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(signature)
+}
+
+// DecodeThoughtSignature reverses EncodeThoughtSignature. It returns nil for an empty string and an
+// error if the value is not valid base64, e.g. a file written before signatures were encoded.
+func DecodeThoughtSignature(encoded string) ([]byte, error) {
+	if encoded == "" {
+		return nil, nil
+	}
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("malformed base64 thought signature: %w", err)
+	}
+	return signature, nil
+}
+
 // ReadStoryStatusFile reads the story generation status from a YAML file.
 func ReadStoryStatusFile(path string) (StoryStatus, error) {
 	var status StoryStatus
@@ -101,14 +278,235 @@ func ReadStoryStatusFile(path string) (StoryStatus, error) {
 	return status, nil
 }
 
-// WriteStoryStatusFile writes the story generation status to a YAML file.
-func WriteStoryStatusFile(path string, status StoryStatus) error {
+// WriteStoryStatusFile writes the story generation status to a YAML file. When sync is true the
+// file is flushed to stable storage before returning (see WriteFile).
+func WriteStoryStatusFile(path string, status StoryStatus, sync bool) error {
 	data, err := yaml.Marshal(status)
 	if err != nil {
 		return fmt.Errorf("failed to marshal status data: %w", err)
 	}
-	if err := os.WriteFile(path, data, 0644); err != nil {
+	if err := WriteFile(path, data, FileMode(), sync); err != nil {
 		return fmt.Errorf("failed to write status file '%s': %w", path, err)
 	}
 	return nil
 }
+
+// EnsureOutputDir checks that dir, the directory an output file will be written to, exists and
+// is a directory, so that a bad path fails before any paid API call rather than when the file is
+// written. A missing directory is created when create is true and is an error otherwise.
+func EnsureOutputDir(dir string, create bool) error {
+	info, err := os.Stat(dir)
+	switch {
+	case err == nil && !info.IsDir():
+		return fmt.Errorf("output directory '%s' is not a directory", dir)
+	case err == nil:
+		return nil
+	case !errors.Is(err, os.ErrNotExist):
+		return fmt.Errorf("cannot use output directory '%s': %w", dir, err)
+	case !create:
+		return fmt.Errorf("output directory '%s' does not exist; create it or pass --create-dirs", dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create output directory '%s': %w", dir, err)
+	}
+	return nil
+}
+
+// WriteFile writes data to path like os.WriteFile. When sync is true it also calls Sync before
+// closing, so the content survives a crash or power loss once WriteFile returns.
+func WriteFile(path string, data []byte, perm os.FileMode, sync bool) error {
+	if !sync {
+		return os.WriteFile(path, data, perm)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadChapterPlan reads a YAML chapter plan mapping chapter numbers to target word counts, e.g. `3: 8000`.
+// Chapter numbers and word counts must be positive. Use ValidateChapterPlan to check the plan
+// against the total number of chapters once it is known.
+func ReadChapterPlan(path string) (map[int]int, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read chapter plan file '%s': %w", path, err)
+	}
+
+	plan := make(map[int]int)
+	if err := yaml.Unmarshal(data, &plan); err != nil {
+		return nil, fmt.Errorf("failed to parse chapter plan file '%s' (expected a mapping of chapter number to word count): %w", path, err)
+	}
+
+	for chapter, words := range plan {
+		if chapter <= 0 {
+			return nil, fmt.Errorf("invalid chapter number %d in chapter plan file '%s': chapter numbers must be positive", chapter, path)
+		}
+		if words <= 0 {
+			return nil, fmt.Errorf("invalid word count %d for chapter %d in chapter plan file '%s': word counts must be positive", words, chapter, path)
+		}
+	}
+	return plan, nil
+}
+
+// ValidateChapterPlan checks that every chapter listed in the plan is within 1..totalChapters.
+func ValidateChapterPlan(plan map[int]int, totalChapters int) error {
+	for chapter := range plan {
+		if chapter > totalChapters {
+			return fmt.Errorf("chapter plan lists chapter %d, but the story only has %d chapters", chapter, totalChapters)
+		}
+	}
+	return nil
+}
+
+// BibleCharacter describes a single character in a character bible.
+type BibleCharacter struct {
+	Name          string   `yaml:"name"`
+	Traits        []string `yaml:"traits"`                  // Physical and personality details, e.g. "green eyes"
+	Relationships []string `yaml:"relationships,omitempty"` // e.g. "older sister of Mara"
+	Arc           string   `yaml:"arc"`
+}
+
+// CharacterBible is the structured list of characters used to keep details consistent across chapters.
+type CharacterBible struct {
+	Characters []BibleCharacter `yaml:"characters"`
+}
+
+// ParseCharacterBible parses a character bible from YAML, tolerating a surrounding Markdown code fence
+// as models often add one. It returns an error if no named characters are present.
+func ParseCharacterBible(data []byte) (CharacterBible, error) {
+	var bible CharacterBible
+	if err := yaml.Unmarshal([]byte(stripCodeFence(string(data))), &bible); err != nil {
+		return bible, fmt.Errorf("failed to parse character bible YAML: %w", err)
+	}
+	if len(bible.Characters) == 0 {
+		return bible, fmt.Errorf("character bible contains no characters")
+	}
+	for i, c := range bible.Characters {
+		if strings.TrimSpace(c.Name) == "" {
+			return bible, fmt.Errorf("character %d in the character bible has no name", i+1)
+		}
+	}
+	return bible, nil
+}
+
+// ReadCharacterBible reads a character bible from a YAML file.
+func ReadCharacterBible(path string) (CharacterBible, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return CharacterBible{}, fmt.Errorf("failed to read character bible file '%s': %w", path, err)
+	}
+	bible, err := ParseCharacterBible(data)
+	if err != nil {
+		return bible, fmt.Errorf("invalid character bible file '%s': %w", path, err)
+	}
+	return bible, nil
+}
+
+// WriteCharacterBible writes a character bible to a YAML file.
+func WriteCharacterBible(path string, bible CharacterBible) error {
+	data, err := yaml.Marshal(bible)
+	if err != nil {
+		return fmt.Errorf("failed to marshal character bible: %w", err)
+	}
+	if err := os.WriteFile(path, data, FileMode()); err != nil {
+		return fmt.Errorf("failed to write character bible file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// OutlineChapter holds the planned beats of a single chapter in a story outline.
+type OutlineChapter struct {
+	Chapter int    `yaml:"chapter"`
+	Title   string `yaml:"title,omitempty"`
+	Beats   string `yaml:"beats"` // One paragraph of the events, in order, that the chapter must cover
+}
+
+// StoryOutline is the per-chapter outline written by 'story outline' and read with --outline.
+type StoryOutline struct {
+	Chapters []OutlineChapter `yaml:"chapters"`
+}
+
+// Chapter returns the outline entry for chapterNum, if the outline has one.
+func (o StoryOutline) Chapter(chapterNum int) (OutlineChapter, bool) {
+	for _, c := range o.Chapters {
+		if c.Chapter == chapterNum {
+			return c, true
+		}
+	}
+	return OutlineChapter{}, false
+}
+
+// ParseStoryOutline parses a story outline from YAML, tolerating a surrounding Markdown code fence
+// as models often add one. Every chapter must have a positive, unique number and non-empty beats.
+func ParseStoryOutline(data []byte) (StoryOutline, error) {
+	var outline StoryOutline
+	if err := yaml.Unmarshal([]byte(stripCodeFence(string(data))), &outline); err != nil {
+		return outline, fmt.Errorf("failed to parse story outline YAML: %w", err)
+	}
+	if len(outline.Chapters) == 0 {
+		return outline, fmt.Errorf("story outline contains no chapters")
+	}
+	seen := make(map[int]bool, len(outline.Chapters))
+	for i, c := range outline.Chapters {
+		if c.Chapter <= 0 {
+			return outline, fmt.Errorf("entry %d in the story outline has no valid chapter number", i+1)
+		}
+		if seen[c.Chapter] {
+			return outline, fmt.Errorf("story outline lists chapter %d more than once", c.Chapter)
+		}
+		seen[c.Chapter] = true
+		if strings.TrimSpace(c.Beats) == "" {
+			return outline, fmt.Errorf("chapter %d in the story outline has no beats", c.Chapter)
+		}
+	}
+	return outline, nil
+}
+
+// ReadStoryOutline reads a story outline from a YAML file.
+func ReadStoryOutline(path string) (StoryOutline, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return StoryOutline{}, fmt.Errorf("failed to read story outline file '%s': %w", path, err)
+	}
+	outline, err := ParseStoryOutline(data)
+	if err != nil {
+		return outline, fmt.Errorf("invalid story outline file '%s': %w", path, err)
+	}
+	return outline, nil
+}
+
+// WriteStoryOutline writes a story outline to a YAML file.
+func WriteStoryOutline(path string, outline StoryOutline) error {
+	data, err := yaml.Marshal(outline)
+	if err != nil {
+		return fmt.Errorf("failed to marshal story outline: %w", err)
+	}
+	if err := os.WriteFile(path, data, FileMode()); err != nil {
+		return fmt.Errorf("failed to write story outline file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// stripCodeFence removes a Markdown code fence (``` or ```yaml) wrapping text, if present.
+func stripCodeFence(text string) string {
+	trimmed := strings.TrimSpace(text)
+	if !strings.HasPrefix(trimmed, "```") {
+		return text
+	}
+	if idx := strings.Index(trimmed, "\n"); idx >= 0 {
+		trimmed = trimmed[idx+1:]
+	} else {
+		return ""
+	}
+	return strings.TrimSuffix(strings.TrimSpace(trimmed), "```")
+}
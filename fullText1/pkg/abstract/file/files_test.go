@@ -0,0 +1,143 @@
+package file
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestThoughtSignatureRoundTrip(t *testing.T) {
+	signatures := map[string][]byte{
+		"NUL bytes":      {0x00, 'a', 0x00, 0x00, 'b'},
+		"non-UTF-8":      {0xff, 0xfe, 0xc3, 0x28, 0x80, 0x00},
+		"every byte":     allBytes(),
+		"printable text": []byte("plain signature"),
+	}
+	for name, signature := range signatures {
+		t.Run(name, func(t *testing.T) {
+			encoded := EncodeThoughtSignature(signature)
+			if !utf8.ValidString(encoded) {
+				t.Fatalf("EncodeThoughtSignature() = %q, want valid UTF-8 text", encoded)
+			}
+			decoded, err := DecodeThoughtSignature(encoded)
+			if err != nil {
+				t.Fatalf("DecodeThoughtSignature() error = %v", err)
+			}
+			if !bytes.Equal(decoded, signature) {
+				t.Errorf("DecodeThoughtSignature(EncodeThoughtSignature(%v)) = %v", signature, decoded)
+			}
+
+			// The signature also survives the YAML abstract file.
+			path := filepath.Join(t.TempDir(), "abstract.yaml")
+			if err := WriteAbstractFile(path, AbstractOutput{Abstract: "A plan.", ThoughtSignature: signature}); err != nil {
+				t.Fatalf("WriteAbstractFile() error = %v", err)
+			}
+			output, err := ReadAbstractOutputFile(path)
+			if err != nil {
+				t.Fatalf("ReadAbstractOutputFile() error = %v", err)
+			}
+			if !bytes.Equal(output.ThoughtSignature, signature) {
+				t.Errorf("signature read back = %v, want %v", output.ThoughtSignature, signature)
+			}
+		})
+	}
+}
+
+func TestEmptyThoughtSignature(t *testing.T) {
+	if got := EncodeThoughtSignature(nil); got != "" {
+		t.Errorf("EncodeThoughtSignature(nil) = %q, want \"\"", got)
+	}
+	if got, err := DecodeThoughtSignature(""); got != nil || err != nil {
+		t.Errorf("DecodeThoughtSignature(\"\") = %v, %v; want nil, nil", got, err)
+	}
+}
+
+func TestLegacyRawThoughtSignature(t *testing.T) {
+	// Files written before signatures were base64-encoded hold the raw bytes as a string.
+	const legacy = "raw signature: not base64!"
+	if _, err := DecodeThoughtSignature(legacy); err == nil {
+		t.Fatalf("DecodeThoughtSignature(%q) error = nil, want a malformed base64 error", legacy)
+	}
+
+	// Reading such a file keeps the abstract and drops the unusable signature.
+	path := filepath.Join(t.TempDir(), "abstract.yaml")
+	data := "abstract: A plan.\nthought_signature: \"" + legacy + "\"\nchapter_count: 3\n"
+	if err := os.WriteFile(path, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	output, err := ReadAbstractOutputFile(path)
+	if err != nil {
+		t.Fatalf("ReadAbstractOutputFile() error = %v", err)
+	}
+	if output.Abstract != "A plan." || output.ChapterCount != 3 || output.ThoughtSignature != nil {
+		t.Errorf("ReadAbstractOutputFile() = (%q, %d, %v), want (%q, 3, nil)", output.Abstract, output.ChapterCount, output.ThoughtSignature, "A plan.")
+	}
+}
+
+func TestParseAbstractMissingField(t *testing.T) {
+	const source = "abstract file 'plan.yaml'"
+	tests := []struct {
+		name    string
+		data    string
+		format  string
+		wantErr string
+	}{
+		{"empty file", "", AbstractFormatYAML, source + " is empty"},
+		{"blank file", " \n\t\n", AbstractFormatYAML, source + " is empty"},
+		{"YAML empty abstract", "abstract: \"\"\nchapter_count: 3\n", AbstractFormatYAML, source + " parsed as YAML but has no 'abstract' field"},
+		{"YAML blank abstract", "abstract: \"   \"\n", AbstractFormatYAML, source + " parsed as YAML but has no 'abstract' field"},
+		{"YAML null abstract", "abstract:\nlanguage: english\n", AbstractFormatYAML, source + " parsed as YAML but has no 'abstract' field"},
+		{"YAML note without abstract", "title: Shopping list\nitems: eggs\n", AbstractFormatYAML, source + " parsed as YAML but has no 'abstract' field"},
+		{"YAML abstract nested under another key", "story:\n  abstract: A plan.\n", AbstractFormatYAML, source + " parsed as YAML but has no 'abstract' field"},
+		{"JSON empty abstract", `{"abstract": "", "chapter_count": 3}`, AbstractFormatJSON, source + " parsed as JSON but has no 'abstract' field"},
+		{"JSON without abstract", `{"plan": "A plan."}`, AbstractFormatJSON, source + " parsed as JSON but has no 'abstract' field"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseAbstract([]byte(tt.data), tt.format, source, false)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Fatalf("parseAbstract(%q) error = %v, want one starting with %q", tt.data, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseAbstractTextFallback(t *testing.T) {
+	tests := []struct {
+		name         string
+		data         string
+		format       string
+		textFallback bool
+		want         string
+	}{
+		{"stdin YAML without abstract", "title: A note\n", AbstractFormatYAML, true, "title: A note\n"},
+		{"stdin JSON without abstract", `{"plan": "A plan."}`, AbstractFormatJSON, true, `{"plan": "A plan."}`},
+		{"prose in a YAML file", "Once upon a time, a plan.", AbstractFormatYAML, false, "Once upon a time, a plan."},
+		{"malformed JSON", "{not json", AbstractFormatJSON, false, "{not json"},
+		{"plain text", "abstract: kept as text", AbstractFormatText, false, "abstract: kept as text"},
+		{"YAML abstract", "abstract: A plan.\n", AbstractFormatYAML, false, "A plan."},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := parseAbstract([]byte(tt.data), tt.format, "abstract input", tt.textFallback)
+			if err != nil {
+				t.Fatalf("parseAbstract(%q) error = %v", tt.data, err)
+			}
+			if output.Abstract != tt.want {
+				t.Errorf("parseAbstract(%q) abstract = %q, want %q", tt.data, output.Abstract, tt.want)
+			}
+		})
+	}
+}
+
+// allBytes returns the 256 byte values in order.
+func allBytes() []byte {
+	b := make([]byte, 256)
+	for i := range b {
+		b[i] = byte(i)
+	}
+	return b
+}
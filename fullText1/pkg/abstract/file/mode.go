@@ -0,0 +1,49 @@
+package file
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// DefaultFileMode is the permission of files created by the commands when --file-mode is not given.
+const DefaultFileMode os.FileMode = 0644
+
+// fileMode holds the permission set by SetFileMode; zero means DefaultFileMode.
+var fileMode atomic.Uint32
+
+// ParseFileMode parses an octal permission such as "0600" or "644". It rejects values outside
+// 0000-0777 and modes that do not let the owner both read and write the file, since the commands
+// rewrite their files (e.g. the status file after every chapter) and read them back on resume.
+func ParseFileMode(value string) (os.FileMode, error) {
+	trimmed := strings.TrimSpace(value)
+	n, err := strconv.ParseUint(strings.TrimPrefix(trimmed, "0o"), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("file mode '%s' is not an octal number such as 0644 or 0600", value)
+	}
+	mode := os.FileMode(n)
+	if mode&^os.ModePerm != 0 {
+		return 0, fmt.Errorf("file mode '%s' must be between 0000 and 0777", value)
+	}
+	if mode&0600 != 0600 {
+		return 0, fmt.Errorf("file mode '%s' must let the owner read and write the file (include 0600)", value)
+	}
+	return mode, nil
+}
+
+// SetFileMode sets the permission of every file the commands create from now on: abstracts, stories
+// and their sidecars, logs, and request dumps. As with os.WriteFile, the process umask still
+// applies and existing files keep their permissions.
+func SetFileMode(mode os.FileMode) {
+	fileMode.Store(uint32(mode.Perm()))
+}
+
+// FileMode returns the permission set by SetFileMode, or DefaultFileMode.
+func FileMode() os.FileMode {
+	if mode := os.FileMode(fileMode.Load()); mode != 0 {
+		return mode
+	}
+	return DefaultFileMode
+}
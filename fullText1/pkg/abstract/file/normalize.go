@@ -0,0 +1,44 @@
+package file
+
+import (
+	"regexp"
+	"strings"
+)
+
+var (
+	markdownFencePattern      = regexp.MustCompile("(?m)^[ \t]*(```|~~~).*$\n?")
+	markdownRulePattern       = regexp.MustCompile(`(?m)^[ \t]*([-*_][ \t]*){3,}$`)
+	markdownHeaderPattern     = regexp.MustCompile(`(?m)^[ \t]*#{1,6}[ \t]+(.*?)[ \t#]*$`)
+	markdownBulletPattern     = regexp.MustCompile(`(?m)^([ \t]*)[*+][ \t]+`)
+	markdownQuotePattern      = regexp.MustCompile(`(?m)^[ \t]*>[ \t]?`)
+	markdownBoldPattern       = regexp.MustCompile(`(\*\*|__)(\S(?:.*?\S)?)(\*\*|__)`)
+	markdownItalicPattern     = regexp.MustCompile(`(^|[^\w*])\*(\S(?:[^*\n]*?\S)?)\*`)
+	markdownInlineCodePattern = regexp.MustCompile("`([^`\n]+)`")
+	markdownLinkPattern       = regexp.MustCompile(`!?\[([^\]\n]*)\]\([^)\n]*\)`)
+	extraBlankLinesPattern    = regexp.MustCompile(`\n{3,}`)
+)
+
+// NormalizeAbstract turns the Markdown the model tends to produce into plain text suitable for
+// embedding in the story header: headers, emphasis, inline code, links, block quotes, code fences,
+// and horizontal rules are stripped, "*" and "+" bullets become "-" bullets, and runs of blank
+// lines are collapsed. Numbered lists and paragraph structure are kept.
+func NormalizeAbstract(raw string) string {
+	text := strings.ReplaceAll(raw, "\r\n", "\n")
+	text = markdownFencePattern.ReplaceAllString(text, "")
+	text = markdownRulePattern.ReplaceAllString(text, "")
+	text = markdownHeaderPattern.ReplaceAllString(text, "$1")
+	text = markdownBulletPattern.ReplaceAllString(text, "$1- ")
+	text = markdownQuotePattern.ReplaceAllString(text, "")
+	text = markdownBoldPattern.ReplaceAllString(text, "$2")
+	text = markdownItalicPattern.ReplaceAllString(text, "$1$2")
+	text = markdownInlineCodePattern.ReplaceAllString(text, "$1")
+	text = markdownLinkPattern.ReplaceAllString(text, "$1")
+
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	text = strings.Join(lines, "\n")
+	text = extraBlankLinesPattern.ReplaceAllString(text, "\n\n")
+	return strings.TrimSpace(text)
+}
@@ -0,0 +1,94 @@
+package file
+
+import (
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// plannedChapterPattern matches a line that opens a chapter's plan, such as "Chapter 7: The Storm",
+// "**Chapter 7**", "### Ch. 7" or "第7章", and captures the chapter number.
+var plannedChapterPattern = regexp.MustCompile(`(?im)^[ \t>#*_\-]*(?:(?:chapter|ch\.)[ \t]*(\d+)\b|第[ \t]*(\d+)[ \t]*[章回])`)
+
+// characterHeadingPattern matches the heading line that opens the character section of an
+// abstract, such as "Characters:", "## Main Characters" or "**Key Characters**".
+var characterHeadingPattern = regexp.MustCompile(`(?im)^[ \t>#*_\-]*(?:main |key |principal )?(?:characters|cast|人物|登场人物)[ \t*_]*:?[ \t*_]*$`)
+
+// planHeadingPattern matches a heading line announcing the chapter plan, such as "## Chapter Plan"
+// or "**Chapter Outline:**", kept with the plan it introduces.
+var planHeadingPattern = regexp.MustCompile(`(?i)^[ \t>#*_\-]*(?:detailed |the )?(?:chapter|plot)[ \t]*(?:plan|outline|breakdown|by chapter)s?[ \t*_]*:?[ \t*_]*$`)
+
+// ErrNoChapterPlan is returned by SplitAbstractSections for an abstract without "Chapter N" lines.
+var ErrNoChapterPlan = errors.New("the abstract has no chapter plan (no line starting with 'Chapter N')")
+
+// AbstractSections is an abstract split into its setup and its per-chapter plan, so the plan can
+// be rewritten while the world and characters are kept. The sections joined in order give back
+// the abstract.
+type AbstractSections struct {
+	Setting     string // Title, premise, and settings: everything before the characters and the plan
+	Characters  string // The character section from its heading; "" when the abstract has no such heading
+	ChapterPlan string // From the first "Chapter N" line, or the heading announcing it, to the end
+}
+
+// String joins the sections back into an abstract.
+func (s AbstractSections) String() string {
+	var parts []string
+	for _, section := range []string{s.Setting, s.Characters, s.ChapterPlan} {
+		if section = strings.TrimSpace(section); section != "" {
+			parts = append(parts, section)
+		}
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// CountPlannedChapters counts the distinct chapter numbers that open a line of the abstract,
+// without an API call. It returns 0 when the plan does not number its chapters that way.
+func CountPlannedChapters(abstract string) int {
+	seen := make(map[int]bool)
+	for _, match := range plannedChapterPattern.FindAllStringSubmatch(abstract, -1) {
+		digits := match[1]
+		if digits == "" {
+			digits = match[2]
+		}
+		if n, err := strconv.Atoi(digits); err == nil && n > 0 {
+			seen[n] = true
+		}
+	}
+	return len(seen)
+}
+
+// SplitAbstractSections splits an abstract into its setting, character, and chapter plan sections.
+// The plan starts at the first line opening a chapter (see CountPlannedChapters), together with a
+// heading such as "Chapter Plan:" just above it; the character section starts at a "Characters"
+// heading before the plan. It returns ErrNoChapterPlan when no line opens a chapter.
+func SplitAbstractSections(abstract string) (AbstractSections, error) {
+	loc := plannedChapterPattern.FindStringIndex(abstract)
+	if loc == nil {
+		return AbstractSections{}, ErrNoChapterPlan
+	}
+	planStart := loc[0]
+	setup := abstract[:planStart]
+	if lineStart, ok := lastNonEmptyLine(setup); ok && planHeadingPattern.MatchString(strings.TrimSpace(setup[lineStart:])) {
+		planStart = lineStart
+		setup = abstract[:planStart]
+	}
+
+	sections := AbstractSections{ChapterPlan: strings.TrimSpace(abstract[planStart:])}
+	if heading := characterHeadingPattern.FindStringIndex(setup); heading != nil {
+		sections.Setting = strings.TrimSpace(setup[:heading[0]])
+		sections.Characters = strings.TrimSpace(setup[heading[0]:])
+	} else {
+		sections.Setting = strings.TrimSpace(setup)
+	}
+	return sections, nil
+}
+
+// lastNonEmptyLine returns the offset of the last line of text that is not blank.
+func lastNonEmptyLine(text string) (int, bool) {
+	trimmed := strings.TrimRight(text, " \t\r\n")
+	if trimmed == "" {
+		return 0, false
+	}
+	return strings.LastIndex(trimmed, "\n") + 1, true
+}
@@ -0,0 +1,419 @@
+package abstract
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/cli"
+	"golang.org/x/time/rate"
+)
+
+// AbstractConfig holds everything GenerateAbstractStory needs. Execute fills it from the
+// abstract command's flags; library callers can fill it directly.
+type AbstractConfig struct {
+	ConfigPath     string // Gemini config file, searched as by --config; used only when APIKey is empty
+	APIKey         string
+	APIKeyFile     string // Read for the API key when APIKey is empty, ahead of the config file and environment
+	ModelName      string // Defaults to aiEndpoint.DefaultGeminiModel when APIKey is set directly
+	ThinkingLevel  string
+	Instruction    string   // Story idea, or the requested changes when RefineFrom is set; "" invents a random premise first
+	Genres         []string // Genres the random premise is drawn from when Instruction is empty; nil uses DefaultGenres
+	Language       string   // Defaults to the refined abstract's language, then "english"
+	NumChapters    int      // 0 picks a random count between 20 and 40, or keeps the refined abstract's count; negative counts are rejected
+	StylePrompt    string   // Overrides style_prompt from the config file and the refined abstract
+	Seed           *int
+	Temperature    *float32      // Overrides 'temperature' from the config file; nil uses the SDK default
+	TopP           *float32      // Overrides 'top_p' from the config file; nil uses the SDK default
+	ThinkingBudget *int32        // Overrides 'thinking_budget' from the config file; nil uses a dynamic budget
+	RefineFrom     string        // Existing abstract file to revise instead of generating a fresh plan
+	RegeneratePlan bool          // With RefineFrom, keep the settings and characters and write a new chapter plan; Instruction is optional
+	Normalize      bool          // Strip Markdown before saving, keeping the model output as abstract_raw
+	OutputPath     string        // Defaults to <OutputDir>/abstract-<timestamp>.yaml
+	OutputDir      string        // Directory for the default output name and base of a relative OutputPath; "" uses "output" for the default name only
+	CreateDirs     bool          // Create a missing directory of an explicit OutputPath instead of failing
+	SkipSave       bool          // Return the abstract without writing it; OutputPath and OutputDir are ignored
+	Mock           bool          // Answer every call of this run locally with placeholder text (see aiEndpoint.MockClient); no API key is needed
+	HTTPClient     *http.Client  // Carries the requests to Gemini, e.g. from aiEndpoint.ConfiguredHTTPClient; nil uses the SDK's default client
+	CacheDir       string        // Existing directory caching Gemini responses, e.g. from aiEndpoint.PrepareCacheDir; "" disables the cache
+	InteractiveIn  io.Reader     // When set, revision requests are read from here before saving (see --interactive)
+	InteractiveOut io.Writer     // Where the interactive loop prints the abstract; defaults to os.Stdout
+	ConfirmIn      io.Reader     // When set, a prompt in a higher price tier must be confirmed here before it is sent (see --yes)
+	ConfirmOut     io.Writer     // Where the confirmation question is printed; defaults to os.Stdout
+	Timeout        time.Duration // Maximum time for each Gemini call; 0 waits as long as the API takes
+	Limiter        *rate.Limiter // Shared by every Gemini call, e.g. from aiEndpoint.NewRateLimiter; nil means unlimited
+}
+
+// AbstractStoryResult is the outcome of GenerateAbstractStory.
+type AbstractStoryResult struct {
+	OutputPath       string // "" when SkipSave was set
+	Abstract         string
+	Language         string // Language the plan was written in
+	StylePrompt      string // Style prompt the plan was written with, saved with the abstract
+	ThoughtSignature []byte
+	Model            string // Model the plan was written with, resolved from the flags or the config file
+	ChapterCount     int    // 0 when Gemini's chapter count could not be determined
+	Premise          string // The random premise the plan was written from when no instruction was given
+	PromptTokens     int    // Estimated input tokens of the abstract prompt; 0 when the estimate failed
+	InputTokens      int
+	OutputTokens     int
+	Cost             float64
+}
+
+// GenerateAbstractStory generates (or refines) a story abstract, determines its chapter count,
+// and writes it to cfg.OutputPath unless cfg.SkipSave is set. It does no flag parsing, so it can
+// be called from other Go programs.
+func GenerateAbstractStory(ctx context.Context, cfg AbstractConfig) (AbstractStoryResult, error) {
+	var result AbstractStoryResult
+
+	if cfg.NumChapters < 0 {
+		return result, cli.UsageError(fmt.Errorf("--chapters must be at least 1 (or 0 for a random count), got %d", cfg.NumChapters))
+	}
+	var original file.AbstractOutput
+	if cfg.RegeneratePlan && cfg.RefineFrom == "" {
+		return result, fmt.Errorf("--regenerate-plan requires --refine-from with the abstract whose plan is replaced")
+	}
+	if cfg.RefineFrom != "" {
+		if cfg.Instruction == "" && !cfg.RegeneratePlan {
+			return result, fmt.Errorf("--instruction is required with --refine-from to describe the changes")
+		}
+		var err error
+		original, err = file.ReadAbstractOutputFile(cfg.RefineFrom)
+		if err != nil {
+			return result, err
+		}
+		if strings.TrimSpace(original.Abstract) == "" {
+			return result, fmt.Errorf("abstract file '%s' has no abstract to refine", cfg.RefineFrom)
+		}
+	}
+	language := cfg.Language
+	if language == "" {
+		language = original.Language
+	}
+	if language == "" {
+		language = "english"
+	}
+
+	if err := aiEndpoint.ValidateSampling(cfg.Temperature, cfg.TopP); err != nil {
+		return result, err
+	}
+	if err := aiEndpoint.ValidateThinkingBudget(cfg.ThinkingBudget); err != nil {
+		return result, err
+	}
+
+	var client aiEndpoint.GenaiClient // Answers every call of this run instead of the API when set
+	if cfg.Mock {
+		client = aiEndpoint.NewMockClient()
+		if cfg.APIKey == "" {
+			cfg.APIKey = aiEndpoint.MockAPIKey
+		}
+	}
+	apiKey, modelName, thinkingLevel := cfg.APIKey, cfg.ModelName, cfg.ThinkingLevel
+	temperature, topP, thinkingBudget := cfg.Temperature, cfg.TopP, cfg.ThinkingBudget
+	stylePrompt := ""
+	if apiKey == "" {
+		// Load Gemini config using the utility function
+		geminiConfigDetails := aiEndpoint.LoadGeminiConfigWithKeyFile(cfg.ConfigPath, cfg.APIKeyFile)
+		if geminiConfigDetails.Err != nil {
+			return result, geminiConfigDetails.Err // aiEndpoint.LoadGeminiConfigWithFallback already logs detailed errors.
+		}
+		apiKey = geminiConfigDetails.APIKey
+		modelName = geminiConfigDetails.ModelName
+		thinkingLevel = geminiConfigDetails.ThinkingLevel
+		stylePrompt = geminiConfigDetails.StylePrompt
+		if temperature == nil {
+			temperature = geminiConfigDetails.Temperature
+		}
+		if topP == nil {
+			topP = geminiConfigDetails.TopP
+		}
+		if thinkingBudget == nil {
+			thinkingBudget = geminiConfigDetails.ThinkingBudget
+		}
+	} else if modelName == "" {
+		modelName = aiEndpoint.DefaultGeminiModel
+	} else {
+		modelName = aiEndpoint.CanonicalModelName(modelName)
+	}
+	result.Model = modelName
+	if original.StylePrompt != "" {
+		stylePrompt = original.StylePrompt
+	}
+	if cfg.StylePrompt != "" {
+		stylePrompt = cfg.StylePrompt
+	}
+	if stylePrompt != "" {
+		log.Printf("Using style prompt: %s", stylePrompt)
+	}
+
+	// Seed the random number generator from --seed when given so the chapter count and genre are reproducible.
+	randSeed := time.Now().UnixNano()
+	if cfg.Seed != nil {
+		randSeed = int64(*cfg.Seed)
+	}
+	rng := rand.New(rand.NewSource(randSeed))
+
+	// Determine number of chapters for the *initial* abstract generation
+	numChapters := cfg.NumChapters
+	if cfg.RefineFrom != "" {
+		if numChapters == 0 {
+			numChapters = original.ChapterCount
+		}
+		log.Printf("Refining abstract from '%s', keeping %d chapters (0 means as in the original plan).", cfg.RefineFrom, numChapters)
+	} else if numChapters == 0 {
+		// Generate a random number between 20 and 40 (inclusive)
+		numChapters = rng.Intn(21) + 20 // rng.Intn(n) generates [0, 20]. Adding 20 shifts it to [20, 40].
+		log.Printf("Number of chapters not specified for abstract generation. Generating a random number: %d", numChapters)
+	} else {
+		log.Printf("Using specified number of chapters: %d for abstract generation", numChapters)
+	}
+
+	refineBase := RefineAbstractInput{
+		Ctx:            ctx,
+		APIKey:         apiKey,
+		ModelName:      modelName,
+		ThinkingLevel:  thinkingLevel,
+		Language:       language,
+		NumChapters:    numChapters,
+		StylePrompt:    stylePrompt,
+		Seed:           cfg.Seed,
+		Temperature:    temperature,
+		TopP:           topP,
+		ThinkingBudget: thinkingBudget,
+		Timeout:        cfg.Timeout,
+		Limiter:        cfg.Limiter,
+		Client:         client,
+		HTTPClient:     cfg.HTTPClient,
+		CacheDir:       cfg.CacheDir,
+	}
+
+	refineInput := refineBase
+	refineInput.Original = original.Abstract
+	refineInput.ThoughtSignature = original.ThoughtSignature
+	refineInput.Instruction = cfg.Instruction
+	generateInput := GenerateAbstractInput{
+		Ctx:            ctx,
+		APIKey:         apiKey,
+		ModelName:      modelName,
+		ThinkingLevel:  thinkingLevel,
+		Instruction:    cfg.Instruction,
+		Language:       language,
+		NumChapters:    numChapters,
+		StylePrompt:    stylePrompt,
+		Seed:           cfg.Seed,
+		Temperature:    temperature,
+		TopP:           topP,
+		ThinkingBudget: thinkingBudget,
+		Timeout:        cfg.Timeout,
+		Limiter:        cfg.Limiter,
+		Client:         client,
+		HTTPClient:     cfg.HTTPClient,
+		CacheDir:       cfg.CacheDir,
+	}
+
+	// --- Determine Output Path ---
+	// Decided and checked before the first paid call, so a bad --output fails without spending
+	// anything, and so that partial text can be saved next to it.
+	if !cfg.SkipSave {
+		result.OutputPath = cfg.OutputPath
+		switch {
+		case result.OutputPath == "":
+			outputDir := cfg.OutputDir
+			if outputDir == "" {
+				outputDir = "output"
+			}
+			timestamp := time.Now().Format("2006-01-02-15-04-05")
+			result.OutputPath = filepath.Join(outputDir, fmt.Sprintf("abstract-%s.yaml", timestamp))
+		case cfg.OutputDir != "" && !filepath.IsAbs(result.OutputPath):
+			result.OutputPath = filepath.Join(cfg.OutputDir, result.OutputPath)
+		}
+		// The default output directory and --output-dir are created as needed; the directory of an
+		// explicit --output must exist unless --create-dirs is given.
+		outputDir := filepath.Dir(result.OutputPath)
+		createOutputDir := cfg.CreateDirs || cfg.OutputPath == "" || (cfg.OutputDir != "" && filepath.Clean(cfg.OutputDir) == outputDir)
+		if err := file.EnsureOutputDir(outputDir, createOutputDir); err != nil {
+			return result, err
+		}
+	}
+
+	usage := &aiEndpoint.CostTracker{} // Every call of this run, including the premise, revisions, and the chapter count
+
+	// --- Invent a Premise ---
+	// Without an instruction, a random premise gives the plan a concrete idea to build on.
+	if cfg.RefineFrom == "" && strings.TrimSpace(cfg.Instruction) == "" {
+		genre := pickGenre(cfg.Genres, rng)
+		log.Printf("No instruction given. Asking Gemini for a random %s premise...", genre)
+		premise := generatePremise(generateInput, genre)
+		usage.AddUsage(premise.InputTokens, premise.OutputTokens, premise.Cost)
+		if premise.Err != nil {
+			log.Printf("Warning: %v. Generating the plan without a premise.", premise.Err)
+		} else {
+			log.Printf("Invented %s premise: %s", genre, premise.Abstract)
+			result.Premise = premise.Abstract
+			generateInput.Instruction = premise.Abstract
+		}
+	} else if len(cfg.Genres) > 0 {
+		log.Printf("Warning: --genre only applies when --instruction is empty; ignoring it.")
+	}
+
+	// --- Estimate Prompt Size ---
+	estimateText := buildAbstractPrompt(generateInput)
+	if cfg.RefineFrom != "" {
+		estimateText = refineInput.Original + "\n\n" + buildRefinePrompt(refineInput)
+	}
+	if cfg.RegeneratePlan {
+		sections, err := file.SplitAbstractSections(original.Abstract)
+		if err != nil {
+			return result, fmt.Errorf("cannot regenerate the chapter plan of '%s': %w", cfg.RefineFrom, err)
+		}
+		estimateText = refineInput.Original + "\n\n" + buildRegeneratePlanPrompt(refineInput, sections, numChapters)
+	}
+	estimate, err := estimatePrompt(aiEndpoint.CallGeminiAPIInput{
+		Ctx:        contextOrBackground(ctx),
+		APIKey:     apiKey,
+		ModelName:  modelName,
+		Prompt:     estimateText,
+		Client:     client,
+		HTTPClient: cfg.HTTPClient,
+	})
+	result.PromptTokens = estimate.InputTokens
+	if err != nil {
+		log.Printf("Warning: Failed to estimate abstract prompt tokens: %v. Proceeding without an estimate.", err)
+	} else if estimate.Tier.IsHigherTier() && cfg.ConfirmIn != nil {
+		out := cfg.ConfirmOut
+		if out == nil {
+			out = os.Stdout
+		}
+		question := fmt.Sprintf("The abstract prompt is %d tokens, in a higher price tier (estimated input cost %s). Continue?",
+			estimate.InputTokens, aiEndpoint.FormatCost(estimate.InputCost))
+		ok, err := confirmPrompt(cfg.ConfirmIn, out, question)
+		if err != nil {
+			return result, err
+		}
+		if !ok {
+			return result, fmt.Errorf("abstract generation cancelled: prompt of %d tokens not confirmed (use --yes to skip this check)", estimate.InputTokens)
+		}
+	}
+
+	// --- Generate Abstract ---
+	var abstractResult AbstractGenerationResult
+	if cfg.RegeneratePlan {
+		log.Printf("Regenerating the chapter plan using Gemini model: %s, output language: %s", modelName, language)
+		abstractResult = regeneratePlan(refineInput)
+	} else if cfg.RefineFrom != "" {
+		log.Printf("Initiating abstract refinement using Gemini model: %s, output language: %s", modelName, language)
+		abstractResult = refineAbstract(refineInput)
+	} else {
+		log.Printf("Initiating abstract generation using Gemini model: %s, output language: %s, chapters: %d", modelName, language, numChapters)
+		abstractResult = generateAbstract(generateInput)
+	}
+
+	usage.AddUsage(abstractResult.InputTokens, abstractResult.OutputTokens, abstractResult.Cost)
+	result.InputTokens, result.OutputTokens, result.Cost = usage.Summary()
+	if abstractResult.Err != nil {
+		log.Printf("Abstract generation failed. Tokens used: Input %d, Output %d. Cost: %s", result.InputTokens, result.OutputTokens, aiEndpoint.FormatCost(result.Cost))
+		if strings.TrimSpace(abstractResult.Abstract) != "" && result.OutputPath != "" {
+			partialPath := result.OutputPath + ".partial"
+			writeErr := os.MkdirAll(filepath.Dir(partialPath), 0755)
+			if writeErr == nil {
+				writeErr = os.WriteFile(partialPath, []byte(abstractResult.Abstract), file.FileMode())
+			}
+			if writeErr != nil {
+				log.Printf("Warning: Failed to save partial abstract to '%s': %v", partialPath, writeErr)
+			} else {
+				log.Printf("Partial abstract text saved to: %s", partialPath)
+				return result, fmt.Errorf("error generating abstract (partial text saved to '%s', cost %s): %w", partialPath, aiEndpoint.FormatCost(result.Cost), abstractResult.Err)
+			}
+		}
+		return result, fmt.Errorf("error generating abstract (cost %s): %w", aiEndpoint.FormatCost(result.Cost), abstractResult.Err)
+	}
+	if cfg.NumChapters > 0 {
+		abstractResult = ensurePlannedChapters(abstractResult, cfg.NumChapters, refineBase, usage)
+		result.InputTokens, result.OutputTokens, result.Cost = usage.Summary()
+	}
+	if cfg.InteractiveIn != nil {
+		out := cfg.InteractiveOut
+		if out == nil {
+			out = os.Stdout
+		}
+		var err error
+		abstractResult, err = reviseAbstractInteractively(cfg.InteractiveIn, out, abstractResult, refineBase, usage)
+		result.InputTokens, result.OutputTokens, result.Cost = usage.Summary()
+		if err != nil {
+			return result, err
+		}
+	}
+	abstract := abstractResult.Abstract
+	abstractRaw := ""
+	if cfg.Normalize {
+		abstractRaw = abstract
+		abstract = file.NormalizeAbstract(abstractRaw)
+		log.Printf("Normalized abstract Markdown to plain text (%d -> %d characters).", len(abstractRaw), len(abstract))
+	}
+	result.Abstract = abstract
+	result.ThoughtSignature = abstractResult.ThoughtSignature
+	result.Language = language
+	result.StylePrompt = stylePrompt
+	log.Printf("Abstract generation complete. Input tokens: %d, Output tokens: %d, Cost: %s", result.InputTokens, result.OutputTokens, aiEndpoint.FormatCost(result.Cost))
+
+	// --- Get pure chapter count from Gemini ---
+	// The count is cached in the abstract file so the story command can skip its own paid count call.
+	log.Printf("Sending abstract to Gemini to get pure chapter count...")
+	chapterCountResult := getChapterCountFromGemini(GetChapterCountInput{
+		Ctx:            ctx,
+		APIKey:         apiKey,
+		ModelName:      modelName,
+		ThinkingLevel:  thinkingLevel,
+		Abstract:       abstract,
+		Seed:           cfg.Seed,
+		Timeout:        cfg.Timeout,
+		ThinkingBudget: thinkingBudget,
+		Limiter:        cfg.Limiter,
+		Client:         client,
+		HTTPClient:     cfg.HTTPClient,
+		CacheDir:       cfg.CacheDir,
+	})
+	// The call is billed even when it fails, so its usage is always part of the totals.
+	usage.AddUsage(chapterCountResult.InputTokens, chapterCountResult.OutputTokens, chapterCountResult.Cost)
+	result.InputTokens, result.OutputTokens, result.Cost = usage.Summary()
+	if chapterCountResult.Err != nil {
+		log.Printf("Warning: Failed to get pure chapter count from Gemini: %v. Proceeding without this information. Input tokens: %d, Output tokens: %d, Cost: %s",
+			chapterCountResult.Err, chapterCountResult.InputTokens, chapterCountResult.OutputTokens, aiEndpoint.FormatCost(chapterCountResult.Cost))
+	} else {
+		result.ChapterCount = chapterCountResult.Count
+		log.Printf("Pure chapter count from Gemini: %d. Input tokens: %d, Output tokens: %d, Cost: %s", chapterCountResult.Count, chapterCountResult.InputTokens, chapterCountResult.OutputTokens, aiEndpoint.FormatCost(chapterCountResult.Cost))
+	}
+
+	if cfg.SkipSave {
+		log.Printf("Total accumulated tokens for abstract generation process: Input %d, Output %d. Total accumulated cost: %s",
+			result.InputTokens, result.OutputTokens, aiEndpoint.FormatCost(result.Cost))
+		return result, nil
+	}
+
+	// --- Save Abstract, Thought Signature, and Chapter Count to YAML File ---
+	err = file.WriteAbstractFile(result.OutputPath, file.AbstractOutput{
+		Abstract:         abstract,
+		ThoughtSignature: result.ThoughtSignature,
+		ChapterCount:     result.ChapterCount,
+		StylePrompt:      stylePrompt,
+		AbstractRaw:      abstractRaw,
+		Language:         language,
+	})
+	if err != nil {
+		return result, fmt.Errorf("error saving abstract: %w", err)
+	}
+
+	log.Printf("Abstract saved to: %s", result.OutputPath)
+	log.Printf("Total accumulated tokens for abstract generation process: Input %d, Output %d. Total accumulated cost: %s",
+		result.InputTokens, result.OutputTokens, aiEndpoint.FormatCost(result.Cost))
+	return result, nil
+}
@@ -0,0 +1,40 @@
+package abstract
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/cli"
+)
+
+func TestNegativeChaptersIsUsageError(t *testing.T) {
+	for _, chapters := range []int{-1, -30} {
+		if _, err := GenerateAbstractStory(context.Background(), AbstractConfig{NumChapters: chapters, Mock: true}); !errors.Is(err, cli.ErrUsage) {
+			t.Errorf("GenerateAbstractStory(NumChapters: %d) error = %v, want a usage error", chapters, err)
+		}
+	}
+
+	// Execute applies its flags process-wide; undo them for the tests that run afterwards.
+	t.Cleanup(func() { aiEndpoint.SetMockMode(false) })
+	t.Cleanup(aiEndpoint.SaveCostFormat())
+	t.Cleanup(aiEndpoint.SavePricing())
+	outputDir := t.TempDir()
+	if err := Execute([]string{"--chapters", "-3", "--mock", "--output-dir", outputDir}); !errors.Is(err, cli.ErrUsage) {
+		t.Errorf("Execute(--chapters -3) error = %v, want a usage error", err)
+	}
+}
+
+func TestMockIsPerCall(t *testing.T) {
+	result, err := GenerateAbstractStory(context.Background(), AbstractConfig{Instruction: "A storm.", NumChapters: 3, Mock: true, SkipSave: true})
+	if err != nil {
+		t.Fatalf("GenerateAbstractStory(Mock: true) error = %v", err)
+	}
+	if result.Abstract == "" || result.Cost != 0 {
+		t.Errorf("GenerateAbstractStory(Mock: true) = (%d characters, cost %g), want a free mock abstract", len(result.Abstract), result.Cost)
+	}
+	if aiEndpoint.MockMode() {
+		t.Errorf("MockMode() = true after GenerateAbstractStory(Mock: true), want mock mode left off")
+	}
+}
@@ -0,0 +1,69 @@
+package abstract
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+)
+
+// Commands understood by the interactive revision loop.
+const (
+	interactiveAccept = "accept"
+	interactiveQuit   = "quit"
+)
+
+// reviseAbstractInteractively prints the abstract in result and reads revision requests from in,
+// one per line, sending each to refineAbstract as a new turn that carries the latest thought
+// signature. It returns when the user types "accept" (or in reaches EOF), with the accepted
+// abstract. The usage of every revision is added to usage, which should already hold the
+// initial generation so the running totals shown are complete.
+// Typing "quit" discards the abstract and returns an error.
+func reviseAbstractInteractively(in io.Reader, out io.Writer, result AbstractGenerationResult, base RefineAbstractInput, usage *aiEndpoint.CostTracker) (AbstractGenerationResult, error) {
+	scanner := bufio.NewScanner(in)
+	for {
+		inputTokens, outputTokens, cost := usage.Summary()
+		fmt.Fprintf(out, "\n--- Current Abstract ---\n%s\n--- End Current Abstract ---\n", strings.TrimSpace(result.Abstract))
+		fmt.Fprintf(out, "Tokens so far: Input %d, Output %d. Cost so far: %s\n", inputTokens, outputTokens, aiEndpoint.FormatCost(cost))
+		fmt.Fprintf(out, "Enter a revision (e.g. 'shorten chapter 3'), '%s' to save, or '%s' to discard: ", interactiveAccept, interactiveQuit)
+
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return result, fmt.Errorf("failed to read revision: %w", err)
+			}
+			fmt.Fprintln(out)
+			log.Printf("End of input reached; accepting the current abstract.")
+			return result, nil
+		}
+
+		revision := strings.TrimSpace(scanner.Text())
+		switch strings.ToLower(revision) {
+		case "":
+			continue
+		case interactiveAccept:
+			return result, nil
+		case interactiveQuit:
+			return result, fmt.Errorf("abstract discarded by user")
+		}
+
+		refineInput := base
+		refineInput.Original = result.Abstract
+		refineInput.ThoughtSignature = result.ThoughtSignature
+		refineInput.Instruction = revision
+		revised := refineAbstract(refineInput)
+		usage.AddUsage(revised.InputTokens, revised.OutputTokens, revised.Cost) // A failed revision is still billed
+		if revised.Err != nil {
+			// Keep the current abstract so a transient failure does not lose the session.
+			fmt.Fprintf(out, "Revision failed: %v\n", revised.Err)
+			log.Printf("Warning: Interactive revision failed: %v. Keeping the current abstract.", revised.Err)
+			continue
+		}
+
+		log.Printf("Revision complete. Input tokens: %d, Output tokens: %d, Cost: %s", revised.InputTokens, revised.OutputTokens, aiEndpoint.FormatCost(revised.Cost))
+		result.Abstract = revised.Abstract
+		result.ThoughtSignature = revised.ThoughtSignature
+	}
+}
@@ -0,0 +1,76 @@
+package abstract
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+)
+
+// DefaultGenres are the genres a random premise is drawn from when no --genre is given.
+var DefaultGenres = []string{
+	"fantasy",
+	"science fiction",
+	"mystery",
+	"thriller",
+	"romance",
+	"horror",
+	"historical fiction",
+	"adventure",
+	"literary fiction",
+	"comedy",
+}
+
+// pickGenre returns a random genre from genres, or from DefaultGenres when genres is empty.
+func pickGenre(genres []string, rng *rand.Rand) string {
+	if len(genres) == 0 {
+		genres = DefaultGenres
+	}
+	return genres[rng.Intn(len(genres))]
+}
+
+// buildPremisePrompt asks Gemini for one original story premise in genre.
+func buildPremisePrompt(genre, language string) string {
+	return fmt.Sprintf(`Invent one original, compelling premise for a %s novel.
+Describe it in one paragraph of about 80 words: the setting, the protagonist and what they want, the central conflict, and what is at stake. Avoid the most familiar clichés of the genre.
+Return ONLY the premise, with no title, heading, or explanation.
+Write the premise in %s.`, genre, language)
+}
+
+// generatePremise asks Gemini for a random premise in genre, for generating a plan when no
+// instruction is given. It uses the model and sampling settings of input, and returns the
+// premise in the Abstract field.
+func generatePremise(input GenerateAbstractInput, genre string) AbstractGenerationResult {
+	var result AbstractGenerationResult
+
+	apiResponse := aiEndpoint.CallGeminiAPI(aiEndpoint.CallGeminiAPIInput{
+		Ctx:               contextOrBackground(input.Ctx),
+		APIKey:            input.APIKey,
+		ModelName:         input.ModelName,
+		Prompt:            buildPremisePrompt(genre, input.Language),
+		ThinkingLevel:     input.ThinkingLevel,
+		SystemInstruction: input.StylePrompt,
+		Seed:              input.Seed,
+		Temperature:       input.Temperature,
+		TopP:              input.TopP,
+		ThinkingBudget:    input.ThinkingBudget,
+		Timeout:           input.Timeout,
+		Limiter:           input.Limiter,
+		Client:            input.Client,
+		HTTPClient:        input.HTTPClient,
+		CacheDir:          input.CacheDir,
+	})
+
+	result.Abstract = strings.TrimSpace(apiResponse.GeneratedText)
+	result.InputTokens = apiResponse.InputTokens
+	result.OutputTokens = apiResponse.OutputTokens
+	result.Cost = apiResponse.Cost
+	switch {
+	case apiResponse.Err != nil:
+		result.Err = fmt.Errorf("error generating a story premise with Gemini: %w", apiResponse.Err)
+	case result.Abstract == "":
+		result.Err = fmt.Errorf("Gemini returned an empty story premise")
+	}
+	return result
+}
@@ -0,0 +1,53 @@
+package abstract
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+)
+
+// ensurePlannedChapters checks that the abstract in result plans numChapters chapters, using
+// file.CountPlannedChapters. When it plans fewer, it asks Gemini once to expand the plan to the
+// requested count and returns the expanded abstract if the expansion succeeds. The usage of the
+// expansion is added to usage. A plan whose chapters cannot be counted locally is returned as is.
+func ensurePlannedChapters(result AbstractGenerationResult, numChapters int, base RefineAbstractInput, usage *aiEndpoint.CostTracker) AbstractGenerationResult {
+	planned := file.CountPlannedChapters(result.Abstract)
+	switch {
+	case planned == 0:
+		log.Printf("Could not count the chapters of the generated plan locally; skipping the check for %d chapters.", numChapters)
+		return result
+	case planned >= numChapters:
+		if planned > numChapters {
+			log.Printf("Warning: The generated plan has %d chapters, more than the %d requested. Keeping it as is.", planned, numChapters)
+		}
+		return result
+	}
+
+	log.Printf("Warning: The generated plan has only %d of the %d requested chapters. Asking Gemini to expand it.", planned, numChapters)
+	expandInput := base
+	expandInput.Original = result.Abstract
+	expandInput.ThoughtSignature = result.ThoughtSignature
+	expandInput.NumChapters = numChapters
+	expandInput.Instruction = fmt.Sprintf("The plan covers only %d chapters, but the story needs %d. Expand it to exactly %d chapters, numbered \"Chapter 1\" to \"Chapter %d\", by adding chapters and splitting long ones where the story needs more room.",
+		planned, numChapters, numChapters, numChapters)
+	expanded := refineAbstract(expandInput)
+	usage.AddUsage(expanded.InputTokens, expanded.OutputTokens, expanded.Cost) // A failed expansion is still billed
+	if expanded.Err != nil {
+		log.Printf("Warning: Failed to expand the plan to %d chapters: %v. Keeping the %d-chapter plan.", numChapters, expanded.Err, planned)
+		return result
+	}
+
+	replanned := file.CountPlannedChapters(expanded.Abstract)
+	log.Printf("Expanded the plan from %d to %d chapters. Input tokens: %d, Output tokens: %d, Cost: %s",
+		planned, replanned, expanded.InputTokens, expanded.OutputTokens, aiEndpoint.FormatCost(expanded.Cost))
+	if replanned < planned {
+		log.Printf("Warning: The expanded plan has fewer chapters than the original. Keeping the %d-chapter plan.", planned)
+		return result
+	}
+	if replanned < numChapters {
+		log.Printf("Warning: The expanded plan still has %d of the %d requested chapters.", replanned, numChapters)
+	}
+	return expanded
+}
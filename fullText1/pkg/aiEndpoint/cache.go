@@ -0,0 +1,122 @@
+package aiEndpoint
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+)
+
+var (
+	cacheDirMu sync.RWMutex
+	cacheDir   string // "" disables the response cache
+)
+
+// SetResponseCacheDir makes CallGeminiAPI cache successful responses in dir, one JSON file per
+// request, and answer identical requests from it at no cost. The directory is created if needed;
+// delete it to clear the cache. "" disables caching.
+func SetResponseCacheDir(dir string) error {
+	dir, err := PrepareCacheDir(dir)
+	if err != nil {
+		return err
+	}
+	cacheDirMu.Lock()
+	defer cacheDirMu.Unlock()
+	cacheDir = dir
+	return nil
+}
+
+// PrepareCacheDir expands a leading ~ in dir and creates the directory, for use as
+// CallGeminiAPIInput.CacheDir of one run. "" is returned unchanged and disables caching.
+func PrepareCacheDir(dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
+	dir = ExpandHome(dir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create cache directory '%s': %w", dir, err)
+	}
+	log.Printf("Caching Gemini responses in '%s'. Delete the directory to clear the cache.", dir)
+	return dir, nil
+}
+
+// responseCacheDir returns the directory set by SetResponseCacheDir, or "".
+func responseCacheDir() string {
+	cacheDirMu.RLock()
+	defer cacheDirMu.RUnlock()
+	return cacheDir
+}
+
+// responseCacheKey hashes everything in input that shapes the response: the model, prompt, system
+// instruction, conversation history and thought signatures, and the thinking and sampling settings.
+func responseCacheKey(input CallGeminiAPIInput) string {
+	keyData, _ := json.Marshal(struct {
+		Model             string
+		Prompt            string
+		SystemInstruction string
+		ThinkingLevel     string
+		ThinkingBudget    *int32
+		ThoughtSignature  []byte
+		History           []HistoryTurn
+		Seed              *int
+		Temperature       *float32
+		TopP              *float32
+		MaxOutputTokens   int
+	}{
+		input.ModelName, input.Prompt, input.SystemInstruction, input.ThinkingLevel, input.ThinkingBudget,
+		input.ThoughtSignature, conversationHistory(input), input.Seed, input.Temperature, input.TopP, input.MaxOutputTokens,
+	})
+	sum := sha256.Sum256(keyData)
+	return hex.EncodeToString(sum[:])
+}
+
+// cachedResponse is the on-disk form of a cached GeminiAPIResponse.
+type cachedResponse struct {
+	Model            string `json:"model"`
+	GeneratedText    string `json:"generated_text"`
+	ThoughtSignature []byte `json:"thought_signature,omitempty"`
+	FinishReason     string `json:"finish_reason,omitempty"`
+}
+
+// loadCachedResponse returns the cached response for key, if any. Tokens and cost are 0, since
+// nothing is billed for a cache hit.
+func loadCachedResponse(dir, key string) (GeminiAPIResponse, bool) {
+	data, err := os.ReadFile(filepath.Join(dir, key+".json"))
+	if err != nil {
+		return GeminiAPIResponse{}, false
+	}
+	var cached cachedResponse
+	if err := json.Unmarshal(data, &cached); err != nil {
+		log.Printf("Warning: Ignoring unreadable cache entry '%s': %v", filepath.Join(dir, key+".json"), err)
+		return GeminiAPIResponse{}, false
+	}
+	return GeminiAPIResponse{
+		GeneratedText:    cached.GeneratedText,
+		ThoughtSignature: cached.ThoughtSignature,
+		FinishReason:     cached.FinishReason,
+		Cached:           true,
+	}, true
+}
+
+// storeCachedResponse saves a successful response under key. Failures are logged, not returned,
+// since the response itself is still good.
+func storeCachedResponse(dir, key, model string, response GeminiAPIResponse) {
+	data, err := json.MarshalIndent(cachedResponse{
+		Model:            model,
+		GeneratedText:    response.GeneratedText,
+		ThoughtSignature: response.ThoughtSignature,
+		FinishReason:     response.FinishReason,
+	}, "", "  ")
+	if err == nil {
+		err = os.WriteFile(filepath.Join(dir, key+".json"), data, file.FileMode())
+	}
+	if err != nil {
+		log.Printf("Warning: Failed to cache Gemini response: %v", err)
+	}
+}
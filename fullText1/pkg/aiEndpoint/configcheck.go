@@ -0,0 +1,161 @@
+package aiEndpoint
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// Severities of a ConfigCheck.
+const (
+	CheckOK      = "OK"
+	CheckWarning = "WARN"
+	CheckError   = "ERROR"
+)
+
+// ConfigCheck is the outcome of one check made by CheckGeminiConfig.
+type ConfigCheck struct {
+	Name     string // The setting checked, e.g. "api_key" or "model_name"
+	Severity string // CheckOK, CheckWarning, or CheckError
+	Detail   string
+}
+
+// ConfigReport collects the checks made on a Gemini config file.
+type ConfigReport struct {
+	ConfigPath string // The file checked; "" when no config file was given or found
+	Checks     []ConfigCheck
+}
+
+// add appends a check to the report.
+func (r *ConfigReport) add(name, severity, format string, args ...any) {
+	r.Checks = append(r.Checks, ConfigCheck{Name: name, Severity: severity, Detail: fmt.Sprintf(format, args...)})
+}
+
+// Count returns the number of checks with the given severity.
+func (r ConfigReport) Count(severity string) int {
+	n := 0
+	for _, c := range r.Checks {
+		if c.Severity == severity {
+			n++
+		}
+	}
+	return n
+}
+
+// CheckConfigOptions selects what CheckGeminiConfig checks.
+type CheckConfigOptions struct {
+	ConfigPath string // Config file to check; "" searches DefaultConfigPaths
+	APIKeyFile string // As --api-key-file, taking precedence over the config file and environment
+	VerifyKey  bool   // Make a free CountTokens call to confirm Gemini accepts the API key
+}
+
+// KnownModels returns the names of the models with prices, sorted, including any loaded from a pricing file.
+func KnownModels() []string {
+	pricingMu.RLock()
+	defer pricingMu.RUnlock()
+	models := make([]string, 0, len(modelPricing))
+	for model := range modelPricing {
+		models = append(models, model)
+	}
+	sort.Strings(models)
+	return models
+}
+
+// CheckGeminiConfig validates a Gemini config file without generating anything: that it parses,
+// that an API key can be resolved from it or the environment, and that the model name, thinking
+// level, thinking budget, and sampling settings are valid. Unknown JSON fields, deprecated or
+// unpriced models, and thinking settings the model ignores are reported as warnings.
+func CheckGeminiConfig(ctx context.Context, opts CheckConfigOptions) ConfigReport {
+	var report ConfigReport
+	configPath := ExpandHome(opts.ConfigPath)
+	if configPath == "" {
+		configPath = findDefaultConfigFile()
+	}
+	report.ConfigPath = configPath
+
+	config := &GeminiConfig{}
+	if configPath == "" {
+		report.add("config", CheckWarning, "No --config given and no default config file found; using the environment and default model '%s'.", DefaultGeminiModel)
+	} else {
+		loaded, err := LoadGeminiConfig(configPath)
+		if err != nil {
+			report.add("config", CheckError, "%v", err)
+			return report
+		}
+		config = loaded
+		report.add("config", CheckOK, "Parsed '%s'.", configPath)
+		if config.Extends != "" {
+			report.add("extends", CheckOK, "Settings not set in '%s' are taken from '%s'.", configPath, config.Extends)
+		}
+		if data, err := os.ReadFile(configPath); err == nil {
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			decoder.DisallowUnknownFields()
+			if err := decoder.Decode(&GeminiConfig{}); err != nil {
+				report.add("config", CheckWarning, "%v; the setting is ignored (misspelled?).", err)
+			}
+		}
+	}
+
+	if err := ValidateThinkingLevel(config.ThinkingLevel); err != nil {
+		report.add("thinking_level", CheckError, "%v", err)
+	}
+	if err := ValidateThinkingBudget(config.ThinkingBudget); err != nil {
+		report.add("thinking_budget", CheckError, "%v", err)
+	}
+	if err := ValidateSampling(config.Temperature, config.TopP); err != nil {
+		report.add("sampling", CheckError, "%v", err)
+	}
+
+	model := config.ModelName
+	if model == "" {
+		model = DefaultGeminiModel
+		report.add("model_name", CheckOK, "Not set; using the default model '%s'.", model)
+	} else if canonical, deprecated := NormalizeModelName(model); deprecated {
+		report.add("model_name", CheckWarning, "'%s' is deprecated and is called and priced as '%s'.", model, canonical)
+		model = canonical
+	}
+	if _, err := GetPricingTier(model, 0); err != nil {
+		report.add("model_name", CheckWarning, "'%s' is not a known model (known: %v); costs will be reported as 0. Check the spelling or add its prices with --pricing-file.", model, KnownModels())
+	} else if config.ModelName != "" {
+		report.add("model_name", CheckOK, "'%s' is a known model.", model)
+	}
+
+	if config.ThinkingLevel != "" && ValidateThinkingLevel(config.ThinkingLevel) == nil {
+		if modelSupportsThinkingLevel(model) {
+			report.add("thinking_level", CheckOK, "'%s'.", NormalizeThinkingLevel(config.ThinkingLevel))
+		} else {
+			report.add("thinking_level", CheckWarning, "'%s' does not support thinking_level; '%s' is ignored.", model, config.ThinkingLevel)
+		}
+	}
+	if config.ThinkingBudget != nil && !modelSupportsThinkingBudget(model) {
+		report.add("thinking_budget", CheckWarning, "'%s' does not support thinking budgets; %d is ignored.", model, *config.ThinkingBudget)
+	}
+
+	var loadedConfig *GeminiConfig
+	if configPath != "" {
+		loadedConfig = config
+	}
+	key, source, err := resolveAPIKey(opts.APIKeyFile, configPath, loadedConfig)
+	switch {
+	case err != nil:
+		report.add("api_key", CheckError, "%v", err)
+		return report
+	case key == "":
+		report.add("api_key", CheckError, "No API key: set api_key or api_key_file in the config file, %s, %s, or --api-key-file.", APIKeyEnvVar, APIKeyFileEnvVar)
+		return report
+	default:
+		report.add("api_key", CheckOK, "Found in %s.", source)
+	}
+
+	if opts.VerifyKey {
+		if _, err := CountPromptTokens(ctx, key, model, "ping"); err != nil {
+			report.add("api_key", CheckError, "Gemini rejected the key or model '%s': %v", model, err)
+		} else {
+			report.add("api_key", CheckOK, "Gemini accepted the key for model '%s'.", model)
+		}
+	}
+	return report
+}
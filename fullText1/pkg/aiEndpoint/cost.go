@@ -0,0 +1,93 @@
+package aiEndpoint
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// CostFormat controls how FormatCost renders costs. Costs are always computed in USD and
+// converted with ExchangeRate only for display.
+type CostFormat struct {
+	Currency     string  // ISO 4217 code, e.g. "USD" or "EUR"
+	ExchangeRate float64 // Units of Currency per 1 USD
+	Decimals     int     // Digits after the decimal point
+}
+
+// DefaultCostFormat shows costs in USD with six decimals, e.g. "$0.001234".
+var DefaultCostFormat = CostFormat{Currency: "USD", ExchangeRate: 1, Decimals: 6}
+
+// currencySymbols holds the prefix symbol for common currencies; others are shown as a code suffix.
+var currencySymbols = map[string]string{
+	"USD": "$",
+	"EUR": "€",
+	"GBP": "£",
+	"JPY": "¥",
+	"INR": "₹",
+	"KRW": "₩",
+}
+
+var (
+	costFormatMu sync.RWMutex
+	costFormat   = DefaultCostFormat
+)
+
+// ValidateCostFormat checks the exchange rate and decimals of format without applying it.
+func ValidateCostFormat(format CostFormat) error {
+	if format.ExchangeRate <= 0 {
+		return fmt.Errorf("exchange rate must be positive, got %g", format.ExchangeRate)
+	}
+	if format.Decimals < 0 || format.Decimals > 12 {
+		return fmt.Errorf("cost decimals must be between 0 and 12, got %d", format.Decimals)
+	}
+	return nil
+}
+
+// SetCostFormat changes how FormatCost renders costs for the rest of the process.
+// The currency code is upper-cased; an empty code means USD.
+func SetCostFormat(format CostFormat) error {
+	format.Currency = strings.ToUpper(strings.TrimSpace(format.Currency))
+	if format.Currency == "" {
+		format.Currency = DefaultCostFormat.Currency
+	}
+	if err := ValidateCostFormat(format); err != nil {
+		return err
+	}
+
+	costFormatMu.Lock()
+	defer costFormatMu.Unlock()
+	// Warned once, when the format is set, not again when the same format is applied a second time.
+	if format != costFormat && format.Currency != DefaultCostFormat.Currency && format.ExchangeRate == 1 {
+		log.Printf("Warning: Showing costs in %s with an exchange rate of 1; pass --exchange-rate to convert from USD.", format.Currency)
+	}
+	costFormat = format
+	return nil
+}
+
+// SaveCostFormat records the current cost format and returns a function restoring it, so a
+// caller can undo SetCostFormat once it is done.
+func SaveCostFormat() (restore func()) {
+	costFormatMu.RLock()
+	saved := costFormat
+	costFormatMu.RUnlock()
+	return func() {
+		costFormatMu.Lock()
+		defer costFormatMu.Unlock()
+		costFormat = saved
+	}
+}
+
+// FormatCost renders a USD cost in the configured currency and precision, e.g. "$0.001234" or "€0.0011".
+// It is the single place cost display is formatted, for both the abstract and story commands.
+func FormatCost(cost float64) string {
+	costFormatMu.RLock()
+	format := costFormat
+	costFormatMu.RUnlock()
+
+	amount := fmt.Sprintf("%.*f", format.Decimals, cost*format.ExchangeRate)
+	if symbol, ok := currencySymbols[format.Currency]; ok {
+		return symbol + amount
+	}
+	return amount + " " + format.Currency
+}
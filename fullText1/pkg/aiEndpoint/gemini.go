@@ -3,51 +3,100 @@ package aiEndpoint
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath" // Added
-	"time"          // Added
-
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time" // Added
+	"unicode/utf8"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+	"golang.org/x/time/rate"
 	"google.golang.org/genai"
 )
 
 const DefaultGeminiModel = "gemini-3-flash-preview"
 
-// Pricing constants per 1 million tokens
-const (
-	// Gemini 2.5 Pro (prompts <= 200k tokens)
-	Gemini25ProInputPriceLowTierPerMillion  float64 = 1.25
-	Gemini25ProOutputPriceLowTierPerMillion float64 = 10.00
-
-	// Gemini 2.5 Pro (prompts > 200k tokens)
-	Gemini25ProInputPriceHighTierPerMillion  float64 = 2.50
-	Gemini25ProOutputPriceHighTierPerMillion float64 = 15.00
+// FinishReasonMaxTokens is the GeminiAPIResponse.FinishReason of a response cut off by the output token limit.
+const FinishReasonMaxTokens = string(genai.FinishReasonMaxTokens)
+
+// Sentinel errors returned (wrapped) by LoadGeminiConfig, LoadGeminiConfigWithFallback, and CallGeminiAPI.
+// Use errors.Is to tell a missing API key apart from a broken config file, or IsConfigError and
+// IsAPIError to classify an error.
+var (
+	ErrNoAPIKey              = errors.New("no Gemini API key found")
+	ErrConfigUnreadable      = errors.New("Gemini config file unreadable")
+	ErrConfigInvalidJSON     = errors.New("Gemini config file is not valid JSON")
+	ErrInvalidThinkingLevel  = errors.New("invalid thinking_level")
+	ErrInvalidSampling       = errors.New("invalid sampling setting")
+	ErrInvalidThinkingBudget = errors.New("invalid thinking_budget")
+	ErrTimeout               = errors.New("Gemini API call timed out")
+	ErrSafetyBlocked         = errors.New("Gemini blocked the response for safety reasons")
+	ErrAPI                   = errors.New("error generating content from Gemini")
+	ErrNoContent             = errors.New("Gemini returned no content") // Wrapped with ErrAPI once the empty-response retries are exhausted
+)
 
-	// Gemini 3 Pro Preview (prompts <= 200k tokens)
-	Gemini3ProPreviewInputPriceLowTierPerMillion  float64 = 2.00
-	Gemini3ProPreviewOutputPriceLowTierPerMillion float64 = 12.00
+// Allowed ranges for the sampling settings.
+const (
+	MaxTemperature = 2.0
+	MaxTopP        = 1.0
+)
 
-	// Gemini 3 Pro Preview (prompts > 200k tokens)
-	Gemini3ProPreviewInputPriceHighTierPerMillion  float64 = 4.00
-	Gemini3ProPreviewOutputPriceHighTierPerMillion float64 = 18.00
+// ValidateSampling checks the optional temperature (0 to 2) and top-p (0 to 1) values.
+// Nil values leave the SDK default in place and are always valid.
+func ValidateSampling(temperature, topP *float32) error {
+	if temperature != nil && (*temperature < 0 || *temperature > MaxTemperature) {
+		return fmt.Errorf("%w: temperature %g must be between 0 and %g", ErrInvalidSampling, *temperature, MaxTemperature)
+	}
+	if topP != nil && (*topP < 0 || *topP > MaxTopP) {
+		return fmt.Errorf("%w: top_p %g must be between 0 and %g", ErrInvalidSampling, *topP, MaxTopP)
+	}
+	return nil
+}
 
-	// Gemini 3 Flash Preview
-	Gemini3FlashPreviewInputPricePerMillion  float64 = 0.50
-	Gemini3FlashPreviewOutputPricePerMillion float64 = 3.00
+// DynamicThinkingBudget lets the model decide how many tokens to think with. It is the budget
+// sent to models that accept budgets when no thinking_budget is configured.
+const DynamicThinkingBudget = -1
 
-	Gemini25ProPromptTokenThreshold = 200000
+// ValidateThinkingBudget checks an optional thinking budget: DynamicThinkingBudget, 0 to turn
+// thinking off where the model allows it, or a positive token count. Nil is always valid.
+func ValidateThinkingBudget(budget *int32) error {
+	if budget != nil && *budget < DynamicThinkingBudget {
+		return fmt.Errorf("%w %d: must be -1 (dynamic), 0 (off), or a positive number of tokens", ErrInvalidThinkingBudget, *budget)
+	}
+	return nil
+}
 
-	// Gemini 2.5 Flash
-	Gemini25FlashInputPricePerMillion  float64 = 0.30
-	Gemini25FlashOutputPricePerMillion float64 = 2.50
+// validThinkingLevels lists the thinking_level values accepted by Gemini, in increasing order of effort.
+var validThinkingLevels = []string{"MINIMAL", "LOW", "MEDIUM", "HIGH"}
 
-	// Gemini 2.5 Flash Lite
-	Gemini25FlashLiteInputPricePerMillion  float64 = 0.10
-	Gemini25FlashLiteOutputPricePerMillion float64 = 0.40
+// ValidateThinkingLevel checks a thinking_level value against the levels Gemini accepts.
+// Matching is case-insensitive and an empty level (use the model default) is always valid.
+// Use NormalizeThinkingLevel to get the form sent to the API.
+func ValidateThinkingLevel(level string) error {
+	if level == "" {
+		return nil
+	}
+	normalized := NormalizeThinkingLevel(level)
+	for _, valid := range validThinkingLevels {
+		if normalized == valid {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w '%s': must be one of %s (case-insensitive), or empty for the model default", ErrInvalidThinkingLevel, level, strings.Join(validThinkingLevels, ", "))
+}
 
-	TokensPerMillion float64 = 1_000_000.0
-)
+// NormalizeThinkingLevel returns the upper-case form of a thinking level used by the genai API.
+func NormalizeThinkingLevel(level string) string {
+	return strings.ToUpper(strings.TrimSpace(level))
+}
 
 // HistoryTurn represents a single turn in the conversation history used for preserving thought chains.
 type HistoryTurn struct {
@@ -56,122 +105,264 @@ type HistoryTurn struct {
 	ThoughtSignature []byte
 }
 
-// ModelPrices holds the per-million token pricing for a specific model tier.
-type ModelPrices struct {
-	InputPricePerMillion  float64
-	OutputPricePerMillion float64
-}
-
-// GetModelPrices returns the input and output prices per 1 million tokens for a given model and input token count.
-// The inputTokens parameter is crucial for determining the pricing tier for models like gemini-2.5-pro.
-func GetModelPrices(modelName string, inputTokens int) (*ModelPrices, error) {
-	switch modelName {
-	case "gemini-2.5-pro", "gemini-1.5-pro", "gemini-pro": // Treat "gemini-1.5-pro" and "gemini-pro" as "gemini-2.5-pro" for pricing based on available rates.
-		if inputTokens <= Gemini25ProPromptTokenThreshold {
-			return &ModelPrices{
-				InputPricePerMillion:  Gemini25ProInputPriceLowTierPerMillion,
-				OutputPricePerMillion: Gemini25ProOutputPriceLowTierPerMillion,
-			}, nil
-		} else {
-			return &ModelPrices{
-				InputPricePerMillion:  Gemini25ProInputPriceHighTierPerMillion,
-				OutputPricePerMillion: Gemini25ProOutputPriceHighTierPerMillion,
-			}, nil
-		}
-	case "gemini-3-pro-preview":
-		if inputTokens <= Gemini25ProPromptTokenThreshold {
-			return &ModelPrices{
-				InputPricePerMillion:  Gemini3ProPreviewInputPriceLowTierPerMillion,
-				OutputPricePerMillion: Gemini3ProPreviewOutputPriceLowTierPerMillion,
-			}, nil
-		} else {
-			return &ModelPrices{
-				InputPricePerMillion:  Gemini3ProPreviewInputPriceHighTierPerMillion,
-				OutputPricePerMillion: Gemini3ProPreviewOutputPriceHighTierPerMillion,
-			}, nil
-		}
-	case "gemini-3-flash-preview":
-		return &ModelPrices{
-			InputPricePerMillion:  Gemini3FlashPreviewInputPricePerMillion,
-			OutputPricePerMillion: Gemini3FlashPreviewOutputPricePerMillion,
-		}, nil
-	case "gemini-2.5-flash":
-		return &ModelPrices{
-			InputPricePerMillion:  Gemini25FlashInputPricePerMillion,
-			OutputPricePerMillion: Gemini25FlashOutputPricePerMillion,
-		}, nil
-	case "gemini-2.5-flash-lite":
-		return &ModelPrices{
-			InputPricePerMillion:  Gemini25FlashLiteInputPricePerMillion,
-			OutputPricePerMillion: Gemini25FlashLiteOutputPricePerMillion,
-		}, nil
-	default:
-		return nil, fmt.Errorf("unsupported model for pricing: %s", modelName)
-	}
-}
-
-// GeminiConfig holds the API key, model name, and thinking level for Gemini.
+// GeminiConfig holds the API key, model name, thinking level, optional style prompt, and optional
+// sampling settings for Gemini.
 type GeminiConfig struct {
-	APIKey        string `json:"api_key"`
-	ModelName     string `json:"model_name"`
-	ThinkingLevel string `json:"thinking_level"`
+	APIKey        string   `json:"api_key"`
+	ModelName     string   `json:"model_name"`
+	ThinkingLevel string   `json:"thinking_level"`
+	StylePrompt   string   `json:"style_prompt"`
+	Temperature   *float32 `json:"temperature,omitempty"`
+	TopP          *float32 `json:"top_p,omitempty"`
+	// ThinkingBudget fixes the thinking tokens for the configured model, e.g. a low budget for
+	// gemini-2.5-flash-lite; nil keeps the dynamic budget.
+	ThinkingBudget *int32 `json:"thinking_budget,omitempty"`
+	// APIKeyFile names a file holding the API key, used when APIKey is empty. A relative path is
+	// resolved against the directory of the config file that sets it.
+	APIKeyFile string `json:"api_key_file,omitempty"`
+	// Extends names a base config file whose settings apply wherever this file does not set them,
+	// e.g. a shared base.json with the API key and thinking level. A relative path is resolved
+	// against this config file's directory; the base may extend another file in turn.
+	Extends string `json:"extends,omitempty"`
 }
 
 // GeminiConfigDetails holds configuration loaded or derived for Gemini API access.
 type GeminiConfigDetails struct {
-	APIKey        string
-	ModelName     string
-	ThinkingLevel string
-	Err           error // To propagate errors gracefully from LoadGeminiConfigWithFallback
+	APIKey         string
+	ModelName      string
+	ThinkingLevel  string
+	StylePrompt    string
+	Temperature    *float32 // Nil uses the SDK default
+	TopP           *float32 // Nil uses the SDK default
+	ThinkingBudget *int32   // Nil uses DynamicThinkingBudget on models that accept budgets
+	Err            error    // To propagate errors gracefully from LoadGeminiConfigWithFallback
 }
 
-// LoadGeminiConfig reads the Gemini configuration from the specified JSON file.
-// It returns a *GeminiConfig and an error. If the file is not found or unreadable,
-// it returns an error, allowing the caller to decide on fallback behavior.
+// LoadGeminiConfig reads the Gemini configuration from the specified JSON file, merged over the
+// base config named by its "extends" field, if any: every field the file sets, even to an empty
+// value, wins over the base's. It returns a *GeminiConfig and an error. If the file or a base is
+// not found or unreadable, it returns an error wrapping ErrConfigUnreadable; if one is not valid
+// JSON, or the "extends" chain loops, the error wraps ErrConfigInvalidJSON. This allows the caller
+// to decide on fallback behavior.
 func LoadGeminiConfig(configPath string) (*GeminiConfig, error) {
-	data, err := os.ReadFile(configPath)
+	fields, err := loadConfigFields(configPath, nil)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(fields)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read config file '%s': %w", configPath, err)
+		return nil, fmt.Errorf("%w: failed to merge config file '%s': %w", ErrConfigInvalidJSON, configPath, err)
 	}
 
 	var config GeminiConfig
 	if err := json.Unmarshal(data, &config); err != nil {
-		return nil, fmt.Errorf("failed to parse config file '%s': %w", configPath, err)
+		return nil, fmt.Errorf("%w: failed to parse config file '%s': %w", ErrConfigInvalidJSON, configPath, err)
 	}
 
 	return &config, nil
 }
 
+// maxConfigExtendsDepth limits how many base configs an "extends" chain may go through.
+const maxConfigExtendsDepth = 10
+
+// loadConfigFields reads the top-level fields of the config file at configPath and merges them
+// over the fields of the base config it extends. chain lists the files extending this one, nearest
+// last, to report which file referenced a missing base and to detect loops. A relative
+// api_key_file set in a base is made absolute, since it is relative to the base's directory
+// rather than to the file that extends it.
+func loadConfigFields(configPath string, chain []string) (map[string]json.RawMessage, error) {
+	data, err := os.ReadFile(configPath)
+	if err != nil {
+		if len(chain) > 0 {
+			return nil, fmt.Errorf("%w: failed to read base config '%s' extended by '%s': %w", ErrConfigUnreadable, configPath, chain[len(chain)-1], err)
+		}
+		return nil, fmt.Errorf("%w: failed to read config file '%s': %w", ErrConfigUnreadable, configPath, err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("%w: failed to parse config file '%s': %w", ErrConfigInvalidJSON, configPath, err)
+	}
+	if fields == nil {
+		fields = map[string]json.RawMessage{}
+	}
+
+	if len(chain) > 0 {
+		if raw, ok := fields["api_key_file"]; ok {
+			var keyFile string
+			if json.Unmarshal(raw, &keyFile) == nil && keyFile != "" {
+				keyFile = ExpandHome(keyFile)
+				if !filepath.IsAbs(keyFile) {
+					if abs, err := filepath.Abs(filepath.Join(filepath.Dir(configPath), keyFile)); err == nil {
+						fields["api_key_file"], _ = json.Marshal(abs)
+					}
+				}
+			}
+		}
+	}
+
+	raw, ok := fields["extends"]
+	if !ok {
+		return fields, nil
+	}
+	var basePath string
+	if err := json.Unmarshal(raw, &basePath); err != nil {
+		return nil, fmt.Errorf("%w: \"extends\" in config file '%s' must be a file path: %w", ErrConfigInvalidJSON, configPath, err)
+	}
+	if basePath == "" {
+		return fields, nil
+	}
+	basePath = ExpandHome(basePath)
+	if !filepath.IsAbs(basePath) {
+		basePath = filepath.Join(filepath.Dir(configPath), basePath)
+	}
+	chain = append(chain, configPath)
+	for _, visited := range chain {
+		if sameFile(visited, basePath) {
+			return nil, fmt.Errorf("%w: config file '%s' extends '%s', which is already part of its \"extends\" chain (%s)", ErrConfigInvalidJSON, configPath, basePath, strings.Join(chain, " -> "))
+		}
+	}
+	if len(chain) > maxConfigExtendsDepth {
+		return nil, fmt.Errorf("%w: \"extends\" chain of config file '%s' is deeper than %d files", ErrConfigInvalidJSON, chain[0], maxConfigExtendsDepth)
+	}
+
+	merged, err := loadConfigFields(basePath, chain)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range fields {
+		merged[name] = value
+	}
+	return merged, nil
+}
+
+// sameFile reports whether the two paths name the same file, comparing absolute paths when the files cannot be stat'ed.
+func sameFile(a, b string) bool {
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	if errA == nil && errB == nil {
+		return os.SameFile(infoA, infoB)
+	}
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+// Environment variables holding the Gemini API key, or the path of a file containing it.
+const (
+	APIKeyEnvVar     = "GEMINI_API_KEY"
+	APIKeyFileEnvVar = "GEMINI_API_KEY_FILE"
+)
+
+// ReadAPIKeyFile reads an API key from path, e.g. a mounted CI secret, trimming surrounding
+// whitespace such as the trailing newline most secret files end with. An empty file is an error.
+func ReadAPIKeyFile(path string) (string, error) {
+	data, err := os.ReadFile(ExpandHome(path))
+	if err != nil {
+		return "", fmt.Errorf("failed to read API key file '%s': %w", path, err)
+	}
+	key := strings.TrimSpace(string(data))
+	if key == "" {
+		return "", fmt.Errorf("API key file '%s' is empty", path)
+	}
+	return key, nil
+}
+
+// resolveAPIKey picks the API key in order of precedence: the apiKeyFile argument (--api-key-file),
+// api_key in the config file, api_key_file in the config file (relative to the config file's
+// directory), the GEMINI_API_KEY env var, then the file named by GEMINI_API_KEY_FILE. It returns
+// the key and a description of its source for logging; the key itself is never logged. A key
+// file that is named but unreadable or empty is an error rather than a reason to fall back.
+func resolveAPIKey(apiKeyFile, configPath string, config *GeminiConfig) (string, string, error) {
+	if apiKeyFile != "" {
+		key, err := ReadAPIKeyFile(apiKeyFile)
+		return key, fmt.Sprintf("--api-key-file '%s'", apiKeyFile), err
+	}
+	if config != nil {
+		if config.APIKey != "" {
+			if config.APIKeyFile != "" {
+				log.Printf("Warning: Config file '%s' sets both api_key and api_key_file; using api_key.", configPath)
+			}
+			return config.APIKey, fmt.Sprintf("config file '%s'", configPath), nil
+		}
+		if config.APIKeyFile != "" {
+			path := ExpandHome(config.APIKeyFile)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(filepath.Dir(configPath), path)
+			}
+			key, err := ReadAPIKeyFile(path)
+			if err != nil {
+				err = fmt.Errorf("api_key_file in config file '%s': %w", configPath, err)
+			}
+			return key, fmt.Sprintf("api_key_file '%s'", path), err
+		}
+		log.Printf("Warning: API Key is missing in the config file '%s'. Attempting to use %s or %s environment variable.", configPath, APIKeyEnvVar, APIKeyFileEnvVar)
+	}
+	if key := os.Getenv(APIKeyEnvVar); key != "" {
+		return key, APIKeyEnvVar + " environment variable", nil
+	}
+	if path := os.Getenv(APIKeyFileEnvVar); path != "" {
+		key, err := ReadAPIKeyFile(path)
+		if err != nil {
+			err = fmt.Errorf("%s: %w", APIKeyFileEnvVar, err)
+		}
+		return key, fmt.Sprintf("%s '%s'", APIKeyFileEnvVar, path), err
+	}
+	return "", "", nil
+}
+
 // LoadGeminiConfigWithFallback attempts to load configuration from a file.
-// If the file is not provided or fails to load, it falls back to environment variables
-// and default model names. It returns GeminiConfigDetails.
+// When configPath is empty, the default locations from DefaultConfigPaths are searched first.
+// If no file is found or it fails to load, it falls back to environment variables
+// and default model names. It returns GeminiConfigDetails. When no API key can be found,
+// Err wraps ErrNoAPIKey, and additionally ErrConfigUnreadable or ErrConfigInvalidJSON when
+// the config file was the reason the fallback was needed. A thinking_level outside the
+// allowed set is reported up front with an Err wrapping ErrInvalidThinkingLevel.
 func LoadGeminiConfigWithFallback(configPath string) GeminiConfigDetails { // Changed return signature
+	return LoadGeminiConfigWithKeyFile(configPath, "")
+}
+
+// LoadGeminiConfigWithKeyFile is LoadGeminiConfigWithFallback with an API key file that takes
+// precedence over every other key source (see --api-key-file). An empty apiKeyFile resolves the
+// key from the config file and environment as LoadGeminiConfigWithFallback does.
+func LoadGeminiConfigWithKeyFile(configPath, apiKeyFile string) GeminiConfigDetails {
 	var details GeminiConfigDetails
 
+	configPath = ExpandHome(configPath)
+	if configPath == "" {
+		if found := findDefaultConfigFile(); found != "" {
+			log.Printf("No --config file specified. Using default config file '%s'.", found)
+			configPath = found
+		}
+	}
+
+	var geminiConfig *GeminiConfig
+	var configErr error
 	if configPath != "" {
-		geminiConfig, err := LoadGeminiConfig(configPath)
-		if err != nil {
-			log.Printf("Warning: Could not load Gemini configuration from '%s': %v. Falling back to environment variable GEMINI_API_KEY and default model '%s'.", configPath, err, DefaultGeminiModel)
-			details.APIKey = os.Getenv("GEMINI_API_KEY")
-			if details.APIKey == "" {
-				details.Err = fmt.Errorf("GEMINI_API_KEY environment variable is not set, and the specified config file '%s' could not be loaded or was invalid. Please set GEMINI_API_KEY or provide a valid --config file", configPath)
-				return details
-			}
-			details.ModelName = DefaultGeminiModel
+		geminiConfig, configErr = LoadGeminiConfig(configPath)
+		if configErr != nil {
+			log.Printf("Warning: Could not load Gemini configuration from '%s': %v. Falling back to environment variable %s and default model '%s'.", configPath, configErr, APIKeyEnvVar, DefaultGeminiModel)
 		} else {
-			details.APIKey = geminiConfig.APIKey
+			log.Printf("Loaded Gemini configuration from '%s'.", configPath)
 			details.ModelName = geminiConfig.ModelName
 			details.ThinkingLevel = geminiConfig.ThinkingLevel
+			details.StylePrompt = geminiConfig.StylePrompt
+			details.Temperature = geminiConfig.Temperature
+			details.TopP = geminiConfig.TopP
+			details.ThinkingBudget = geminiConfig.ThinkingBudget
 
-			// If API key is missing in the config file, try environment variable as a secondary source.
-			if details.APIKey == "" {
-				log.Printf("Warning: API Key is missing in the config file '%s'. Attempting to use GEMINI_API_KEY environment variable.", configPath)
-				details.APIKey = os.Getenv("GEMINI_API_KEY")
-				if details.APIKey == "" {
-					details.Err = fmt.Errorf("API Key is missing in the config file and GEMINI_API_KEY environment variable is not set. Please provide an API key")
-					return details
-				}
+			if err := ValidateThinkingLevel(details.ThinkingLevel); err != nil {
+				details.Err = fmt.Errorf("config file '%s': %w", configPath, err)
+				return details
 			}
+			if err := ValidateSampling(details.Temperature, details.TopP); err != nil {
+				details.Err = fmt.Errorf("config file '%s': %w", configPath, err)
+				return details
+			}
+			if err := ValidateThinkingBudget(details.ThinkingBudget); err != nil {
+				details.Err = fmt.Errorf("config file '%s': %w", configPath, err)
+				return details
+			}
+			details.ThinkingLevel = NormalizeThinkingLevel(details.ThinkingLevel)
 
 			// If model name is missing in the config file, use the default.
 			if details.ModelName == "" {
@@ -180,29 +371,90 @@ func LoadGeminiConfigWithFallback(configPath string) GeminiConfigDetails { // Ch
 			}
 		}
 	} else {
-		// No config path was provided, so directly use environment variable.
-		log.Printf("No --config file specified. Attempting to use GEMINI_API_KEY environment variable and default model '%s'.", DefaultGeminiModel)
-		details.APIKey = os.Getenv("GEMINI_API_KEY")
-		if details.APIKey == "" {
-			details.Err = fmt.Errorf("GEMINI_API_KEY environment variable is not set. Please set GEMINI_API_KEY or provide a valid --config file")
-			return details
-		}
-		details.ModelName = DefaultGeminiModel // Use the hardcoded default model
+		// No config path was provided, so directly use the environment.
+		log.Printf("No --config file specified. Attempting to use %s or %s environment variable and default model '%s'.", APIKeyEnvVar, APIKeyFileEnvVar, DefaultGeminiModel)
+	}
+
+	if details.ModelName != "" {
+		details.ModelName = CanonicalModelName(details.ModelName)
 	}
 
-	// Final check to ensure we have an API key and model name
-	if details.APIKey == "" {
-		details.Err = fmt.Errorf("no API key found after checking config file (if provided) and environment variable. Please provide it")
+	key, source, err := resolveAPIKey(apiKeyFile, configPath, geminiConfig)
+	if err != nil {
+		details.Err = fmt.Errorf("%w: %w", ErrNoAPIKey, err)
 		return details
 	}
-	if details.ModelName == "" { // This case should theoretically be covered by the logic above, but good for robustness.
+	if key == "" {
+		switch {
+		case configErr != nil:
+			details.Err = fmt.Errorf("%w: %s environment variable is not set, and the specified config file '%s' could not be loaded or was invalid (%w). Please set %s or provide a valid --config file", ErrNoAPIKey, APIKeyEnvVar, configPath, configErr, APIKeyEnvVar)
+		case geminiConfig != nil:
+			details.Err = fmt.Errorf("%w: API Key is missing in the config file and neither %s nor %s environment variable is set. Please provide an API key", ErrNoAPIKey, APIKeyEnvVar, APIKeyFileEnvVar)
+		default:
+			details.Err = fmt.Errorf("%w: %s environment variable is not set. Please set %s, %s, or --api-key-file, or provide a valid --config file", ErrNoAPIKey, APIKeyEnvVar, APIKeyEnvVar, APIKeyFileEnvVar)
+		}
+		return details
+	}
+	details.APIKey = key
+	log.Printf("Using the Gemini API key from %s.", source)
+
+	if details.ModelName == "" {
 		details.ModelName = DefaultGeminiModel
-		log.Printf("Warning: Model name was somehow still empty, defaulting to %s.", details.ModelName)
 	}
 
 	return details
 }
 
+// modelSupportsThinkingLevel reports whether the model accepts ThinkingConfig.ThinkingLevel (the Gemini 3 family).
+func modelSupportsThinkingLevel(modelName string) bool {
+	return strings.HasPrefix(modelName, "gemini-3-")
+}
+
+// modelSupportsThinkingBudget reports whether the model accepts ThinkingConfig.ThinkingBudget
+// (the Gemini 2.5 family, plus Gemini 3 which still honours budgets for compatibility).
+func modelSupportsThinkingBudget(modelName string) bool {
+	return strings.HasPrefix(modelName, "gemini-2.5-") || strings.HasPrefix(modelName, "gemini-3-")
+}
+
+// DefaultConfigPaths returns the config files searched, in order, when no --config is given:
+// $XDG_CONFIG_HOME/ai-story/config.json, ~/.config/ai-story/config.json, and ~/.ai-story.json.
+func DefaultConfigPaths() []string {
+	var paths []string
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		paths = append(paths, filepath.Join(ExpandHome(xdg), "ai-story", "config.json"))
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		paths = append(paths,
+			filepath.Join(home, ".config", "ai-story", "config.json"),
+			filepath.Join(home, ".ai-story.json"),
+		)
+	}
+	return paths
+}
+
+// findDefaultConfigFile returns the first of DefaultConfigPaths that exists, or "" if none does.
+func findDefaultConfigFile() string {
+	for _, path := range DefaultConfigPaths() {
+		if info, err := os.Stat(path); err == nil && !info.IsDir() {
+			return path
+		}
+	}
+	return ""
+}
+
+// ExpandHome replaces a leading "~" or "~/" in path with the current user's home directory.
+// Paths such as "~user/..." and paths where the home directory is unknown are returned unchanged.
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~"))
+}
+
 // CallGeminiAPIInput holds all input parameters for the CallGeminiAPI function.
 type CallGeminiAPIInput struct {
 	Ctx              context.Context
@@ -212,6 +464,35 @@ type CallGeminiAPIInput struct {
 	ThinkingLevel    string
 	PreviousTurn     *HistoryTurn
 	ThoughtSignature []byte
+	// PreviousTurns are earlier turns of the conversation, oldest first, each with its thought
+	// signature. They are sent before PreviousTurn, which stays the most recent turn when both are set.
+	PreviousTurns []HistoryTurn
+	Limiter       *rate.Limiter // Optional; when set, the call waits for a token before contacting the API
+	// SystemInstruction is an optional style/system prompt sent as the request's system instruction.
+	SystemInstruction string
+	// Seed, when set, fixes the sampling seed so the same prompt yields reproducible output.
+	Seed *int
+	// MaxOutputTokens caps the tokens the model may generate for this call; 0 leaves the model default.
+	// A response stopped by the cap reports FinishReasonMaxTokens.
+	MaxOutputTokens int
+	// Client, when set, is used instead of a real Gemini client created from APIKey, e.g. a
+	// MockClient for one run. Its answers are never cached.
+	Client GenaiClient
+	// HTTPClient, when set, carries the requests of the real Gemini client instead of the one
+	// installed with SetHTTPClient, e.g. one built by ConfiguredHTTPClient for this run.
+	HTTPClient *http.Client
+	// CacheDir, when set, caches the response in this directory instead of the one set with
+	// SetResponseCacheDir. It should come from PrepareCacheDir.
+	CacheDir string
+	// Temperature and TopP, when set, override the SDK's sampling defaults.
+	Temperature *float32
+	TopP        *float32
+	// ThinkingBudget, when set, replaces the default DynamicThinkingBudget on models that accept
+	// budgets. It also wins over ThinkingLevel if both are given.
+	ThinkingBudget *int32
+	// Timeout, when positive, bounds the call (token count and generation, not the rate-limiter wait).
+	// A call that runs out of time fails with an error wrapping ErrTimeout.
+	Timeout time.Duration
 }
 
 // GeminiAPIResponse holds all output parameters for the CallGeminiAPI function.
@@ -221,7 +502,20 @@ type GeminiAPIResponse struct {
 	InputTokens      int
 	OutputTokens     int
 	Cost             float64
-	Err              error // To propagate errors gracefully from the API call
+	EstimatedTokens  bool   // True when InputTokens or OutputTokens (and so Cost) was estimated, because counting the prompt failed or the response had no usage metadata
+	FinishReason     string // Why the model stopped, e.g. "STOP" or "MAX_TOKENS" (truncated); empty if not reported
+	Cached           bool   // True when the response came from the response cache; tokens and cost are then 0
+	Err              error  // To propagate errors gracefully from the API call
+}
+
+// NewRateLimiter returns a token-bucket limiter allowing rpm requests per minute (with no bursts),
+// or nil, meaning no limit, when rpm is not positive. Share one limiter across all calls of a
+// command so the whole command respects a single rate.
+func NewRateLimiter(rpm int) *rate.Limiter {
+	if rpm <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Every(time.Minute/time.Duration(rpm)), 1)
 }
 
 // ChapterCountResult holds the result of chapter count operations.
@@ -233,35 +527,204 @@ type ChapterCountResult struct {
 	Err          error // To propagate errors gracefully
 }
 
+// chapterCountPattern matches the first integer in a chapter count response.
+var chapterCountPattern = regexp.MustCompile(`\d+`)
+
+// ParseChapterCount extracts the chapter count from a model response that should be a bare number
+// but may be decorated, e.g. "30.", "**30**", "Chapters: 30" or "The plan has 30 chapters.": the
+// first integer anywhere in the response is used. A response without digits (such as "Thirty")
+// or with a count of zero is an error.
+func ParseChapterCount(response string) (int, error) {
+	digits := chapterCountPattern.FindString(response)
+	if digits == "" {
+		return 0, fmt.Errorf("no number in chapter count response %q", truncateForError(response))
+	}
+	count, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, fmt.Errorf("chapter count %s in response %q is out of range: %w", digits, truncateForError(response), err)
+	}
+	if count == 0 {
+		return 0, fmt.Errorf("chapter count in response %q is zero", truncateForError(response))
+	}
+	return count, nil
+}
+
+// truncateForError shortens a model response for inclusion in an error message.
+func truncateForError(text string) string {
+	const maxRunes = 80
+	text = strings.TrimSpace(text)
+	if runes := []rune(text); len(runes) > maxRunes {
+		return string(runes[:maxRunes]) + "..."
+	}
+	return text
+}
+
+// GenaiClient is the subset of the genai models API used by this package. *genai.Models (the
+// Models field of a genai.Client) implements it; tests and offline runs can supply a fake instead.
+type GenaiClient interface {
+	CountTokens(ctx context.Context, model string, contents []*genai.Content, config *genai.CountTokensConfig) (*genai.CountTokensResponse, error)
+	GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error)
+}
+
+// newGenaiClient returns client when it is set, a MockClient in mock mode, or a real Gemini
+// client for apiKey otherwise, sending its requests through httpClient when it is set.
+func newGenaiClient(ctx context.Context, client GenaiClient, apiKey string, httpClient *http.Client) (GenaiClient, error) {
+	if client != nil {
+		return client, nil
+	}
+	if MockMode() {
+		return MockClient{}, nil
+	}
+	if httpClient == nil {
+		httpClient = currentHTTPClient()
+	}
+	genaiClient, err := genai.NewClient(ctx, &genai.ClientConfig{APIKey: apiKey, HTTPClient: httpClient})
+	if err != nil {
+		return nil, fmt.Errorf("error creating Gemini client: %w", err)
+	}
+	return genaiClient.Models, nil
+}
+
+// CountPromptTokens asks Gemini how many input tokens prompt would use for the model,
+// without generating anything. Counting is free, so callers can use it to estimate cost up front.
+func CountPromptTokens(ctx context.Context, apiKey, modelName, prompt string) (int, error) {
+	return CountInputTokens(CallGeminiAPIInput{Ctx: ctx, APIKey: apiKey, ModelName: modelName, Prompt: prompt})
+}
+
+// CountInputTokens is CountPromptTokens for the prompt of input, counted through the same client
+// CallGeminiAPI would use: input.Client, or a real client using input.HTTPClient.
+func CountInputTokens(input CallGeminiAPIInput) (int, error) {
+	ctx := input.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	modelName := CanonicalModelName(input.ModelName)
+	client, err := newGenaiClient(ctx, input.Client, input.APIKey, input.HTTPClient)
+	if err != nil {
+		return 0, err
+	}
+
+	contents := []*genai.Content{genai.NewContentFromText(input.Prompt, genai.RoleUser)}
+	countResp, err := client.CountTokens(ctx, modelName, contents, &genai.CountTokensConfig{})
+	if err != nil {
+		return 0, fmt.Errorf("%w: error counting tokens: %w", ErrAPI, err)
+	}
+	return int(countResp.TotalTokens), nil
+}
+
+// EstimateTokens approximates the token count of text at about four characters per token, for
+// when the API cannot count it.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// estimateContentTokens approximates the token count of the text parts of contents.
+func estimateContentTokens(contents []*genai.Content) int {
+	var b strings.Builder
+	for _, content := range contents {
+		b.WriteString(contentText(content))
+	}
+	return EstimateTokens(b.String())
+}
+
+// conversationHistory returns the turns sent before the prompt: PreviousTurns, then PreviousTurn.
+func conversationHistory(input CallGeminiAPIInput) []HistoryTurn {
+	turns := append([]HistoryTurn(nil), input.PreviousTurns...)
+	if input.PreviousTurn != nil {
+		turns = append(turns, *input.PreviousTurn)
+	}
+	return turns
+}
+
+// callCost returns the USD cost of a call with the given token counts at prices.
+func callCost(inputTokens, outputTokens int, prices *ModelPrices) float64 {
+	return (float64(inputTokens)/TokensPerMillion)*prices.InputPricePerMillion +
+		(float64(outputTokens)/TokensPerMillion)*prices.OutputPricePerMillion
+}
+
+// dumpSequence numbers the request/response dumps written by this process.
+var dumpSequence atomic.Uint64
+
+// dumpFilePaths returns the temp-dir paths of the request and response dumps of one Gemini call.
+// The timestamp is followed by the process ID and a per-process sequence number, so calls made
+// concurrently or within the same microsecond, even by separate processes, never share a file.
+func dumpFilePaths() (reqFileName, respFileName string) {
+	id := fmt.Sprintf("%s_%d_%d", time.Now().Format("20060102_150405.000000"), os.Getpid(), dumpSequence.Add(1))
+	return filepath.Join(os.TempDir(), fmt.Sprintf("gemini_req_%s.json", id)),
+		filepath.Join(os.TempDir(), fmt.Sprintf("gemini_resp_%s.json", id))
+}
+
 // CallGeminiAPI sends a prompt to the Gemini API and returns the generated text, thought signature,
 // along with the input and output token counts, and the calculated cost.
 // It supports an optional thinkingLevel and previous conversation history for thought chain continuity.
 func CallGeminiAPI(input CallGeminiAPIInput) GeminiAPIResponse { // Updated signature
+	input.ModelName = CanonicalModelName(input.ModelName)
 	log.Printf("Gemini API Call: Initiating call to model '%s'. Thinking Level: '%s'. Prompt length: %d characters.", input.ModelName, input.ThinkingLevel, len(input.Prompt))
+	if logging.Enabled(logging.VerbosityVerbose) {
+		if input.SystemInstruction != "" {
+			log.Printf("Gemini API Call: System instruction:\n%s", input.SystemInstruction)
+		}
+		log.Printf("Gemini API Call: Prompt:\n%s", input.Prompt)
+	}
 
 	var response GeminiAPIResponse
 
-	client, err := genai.NewClient(input.Ctx, &genai.ClientConfig{APIKey: input.APIKey})
+	// Identical requests are answered from the cache, when enabled, without contacting the API.
+	// Only answers of the real API are cached: mock and injected clients never are, so their
+	// responses cannot answer a later real request.
+	cacheDir, cacheKey := input.CacheDir, ""
+	if cacheDir == "" {
+		cacheDir = responseCacheDir()
+	}
+	if input.Client != nil || MockMode() {
+		cacheDir = ""
+	}
+	if cacheDir != "" {
+		cacheKey = responseCacheKey(input)
+		if cached, ok := loadCachedResponse(cacheDir, cacheKey); ok {
+			log.Printf("Gemini API Call: Cache hit (%s); returning the cached response at no cost.", cacheKey[:12])
+			return cached
+		}
+	}
+
+	if input.Limiter != nil {
+		if err := input.Limiter.Wait(input.Ctx); err != nil {
+			response.Err = fmt.Errorf("error waiting for rate limiter: %w", err)
+			return response
+		}
+	}
+
+	if input.Timeout > 0 {
+		parent := input.Ctx
+		if parent == nil {
+			parent = context.Background()
+		}
+		ctx, cancel := context.WithTimeout(parent, input.Timeout)
+		defer cancel()
+		input.Ctx = ctx
+	}
+
+	client, err := newGenaiClient(input.Ctx, input.Client, input.APIKey, input.HTTPClient)
 	if err != nil {
-		response.Err = fmt.Errorf("error creating Gemini client: %w", err)
+		response.Err = err
 		return response
 	}
 
 	// Construct request contents, potentially including history
 	var reqContents []*genai.Content
 
-	if input.PreviousTurn != nil {
+	for _, turn := range conversationHistory(input) {
 		reqContents = append(reqContents, &genai.Content{
 			Role: "user",
 			Parts: []*genai.Part{{
-				Text: input.PreviousTurn.UserPrompt,
+				Text: turn.UserPrompt,
 			}},
 		})
 		reqContents = append(reqContents, &genai.Content{
 			Role: "model",
 			Parts: []*genai.Part{{
-				Text:             input.PreviousTurn.ModelResponse,
-				ThoughtSignature: input.PreviousTurn.ThoughtSignature,
+				Text:             turn.ModelResponse,
+				ThoughtSignature: turn.ThoughtSignature,
 			}},
 		})
 	}
@@ -275,35 +738,66 @@ func CallGeminiAPI(input CallGeminiAPIInput) GeminiAPIResponse { // Updated sign
 		}},
 	})
 
-	var genConfig *genai.GenerateContentConfig
+	genConfig := &genai.GenerateContentConfig{}
 
-	if (input.ModelName == "gemini-3-pro-preview" || input.ModelName == "gemini-3-flash-preview") && input.ThinkingLevel != "" {
-		// If thinking level is set for the supported model, use it and do NOT set thinking budget.
-		genConfig = &genai.GenerateContentConfig{
-			ThinkingConfig: &genai.ThinkingConfig{
-				ThinkingLevel: genai.ThinkingLevel(input.ThinkingLevel),
-			},
+	switch {
+	case input.ThinkingBudget != nil && modelSupportsThinkingBudget(input.ModelName):
+		if input.ThinkingLevel != "" {
+			log.Printf("Warning: Both a thinking budget (%d) and thinking_level '%s' are set for model '%s'; using the thinking budget.", *input.ThinkingBudget, input.ThinkingLevel, input.ModelName)
+		}
+		budget := *input.ThinkingBudget
+		genConfig.ThinkingConfig = &genai.ThinkingConfig{
+			ThinkingBudget: &budget,
+		}
+	case input.ThinkingLevel != "" && modelSupportsThinkingLevel(input.ModelName):
+		// If thinking level is set for a supported model, use it and do NOT set thinking budget.
+		genConfig.ThinkingConfig = &genai.ThinkingConfig{
+			ThinkingLevel: genai.ThinkingLevel(input.ThinkingLevel),
+		}
+	case modelSupportsThinkingBudget(input.ModelName):
+		if input.ThinkingLevel != "" {
+			log.Printf("Warning: Model '%s' does not support thinking_level; ignoring '%s' and using a dynamic thinking budget.", input.ModelName, input.ThinkingLevel)
 		}
-	} else {
 		// Default behavior: Use dynamic thinking budget (-1).
-		thinking := int32(-1)
-		genConfig = &genai.GenerateContentConfig{
-			ThinkingConfig: &genai.ThinkingConfig{
-				ThinkingBudget: &thinking,
-			},
+		thinking := int32(DynamicThinkingBudget)
+		genConfig.ThinkingConfig = &genai.ThinkingConfig{
+			ThinkingBudget: &thinking,
+		}
+	default:
+		if input.ThinkingLevel != "" {
+			log.Printf("Warning: Model '%s' does not support thinking configuration; ignoring thinking_level '%s'.", input.ModelName, input.ThinkingLevel)
+		}
+		if input.ThinkingBudget != nil {
+			log.Printf("Warning: Model '%s' does not support thinking configuration; ignoring thinking budget %d.", input.ModelName, *input.ThinkingBudget)
+		}
+	}
+
+	if input.Seed != nil {
+		seed := int32(*input.Seed)
+		genConfig.Seed = &seed
+	}
+
+	genConfig.Temperature = input.Temperature
+	genConfig.TopP = input.TopP
+
+	if input.MaxOutputTokens > 0 {
+		genConfig.MaxOutputTokens = int32(input.MaxOutputTokens)
+	}
+
+	if input.SystemInstruction != "" {
+		genConfig.SystemInstruction = &genai.Content{
+			Parts: []*genai.Part{{Text: input.SystemInstruction}},
 		}
 	}
 
 	// --- Log Request Body ---
-	timestamp := time.Now().Format("20060102_150405.000000") // More precise timestamp
-	reqFileName := filepath.Join(os.TempDir(), fmt.Sprintf("gemini_req_%s.json", timestamp))
-	respFileName := filepath.Join(os.TempDir(), fmt.Sprintf("gemini_resp_%s.json", timestamp))
+	reqFileName, respFileName := dumpFilePaths()
 
 	reqBodyBytes, errMarshalReq := json.MarshalIndent(reqContents, "", "  ")
 	if errMarshalReq != nil {
 		log.Printf("Warning: Failed to marshal Gemini request contents for logging: %v", errMarshalReq)
 	} else {
-		if errWriteReq := os.WriteFile(reqFileName, reqBodyBytes, 0644); errWriteReq != nil {
+		if errWriteReq := os.WriteFile(reqFileName, reqBodyBytes, file.FileMode()); errWriteReq != nil {
 			log.Printf("Warning: Failed to write Gemini request body to '%s': %v", reqFileName, errWriteReq)
 		} else {
 			log.Printf("Gemini API Call: Request body saved to: %s", reqFileName)
@@ -312,13 +806,14 @@ func CallGeminiAPI(input CallGeminiAPIInput) GeminiAPIResponse { // Updated sign
 	// --- End Log Request Body ---
 
 	// First, count input tokens to determine pricing tier
-	countResp, err := client.Models.CountTokens(input.Ctx, input.ModelName, reqContents, &genai.CountTokensConfig{})
-	if err != nil {
-		log.Printf("Warning: Failed to count input tokens for prompt: %v. Proceeding with generation and assuming 0 input tokens for cost calculation.", err)
-		// Don't return error here, proceed with generation but log 0 for input tokens
-	}
-	response.InputTokens = 0
-	if countResp != nil {
+	countResp, err := client.CountTokens(input.Ctx, input.ModelName, reqContents, &genai.CountTokensConfig{})
+	if err != nil || countResp == nil {
+		// Don't return error here, proceed with generation. Assuming 0 tokens would price a long
+		// prompt at the low tier and under-report its cost, so estimate them from the length instead.
+		response.InputTokens = estimateContentTokens(reqContents) + EstimateTokens(input.SystemInstruction)
+		response.EstimatedTokens = true
+		log.Printf("Warning: Failed to count input tokens for prompt: %v. Proceeding with generation and estimating %d input tokens from the prompt length for the cost; the cost is approximate.", err, response.InputTokens)
+	} else {
 		response.InputTokens = int(countResp.TotalTokens)
 	}
 
@@ -328,9 +823,30 @@ func CallGeminiAPI(input CallGeminiAPIInput) GeminiAPIResponse { // Updated sign
 		log.Printf("Warning: Could not get pricing for model '%s': %v. Cost will be reported as 0.", input.ModelName, err)
 		modelPrices = &ModelPrices{} // Default to zero prices if not found
 	}
+	if _, ok := client.(MockClient); ok {
+		modelPrices = &ModelPrices{} // Mock calls are free
+	}
 
-	// Generate content
-	resp, err := client.Models.GenerateContent(input.Ctx, input.ModelName, reqContents, genConfig)
+	// Generate content. An empty response that is neither an error nor a safety block is usually
+	// transient, so it is retried a few times before ErrNoContent is returned. Every attempt is
+	// billed for its prompt, and for the output tokens an empty response still reports.
+	resp, err := client.GenerateContent(input.Ctx, input.ModelName, reqContents, genConfig)
+	promptTokens, discardedOutputTokens := response.InputTokens, 0
+	for retry := 1; retry <= maxEmptyResponseRetries && err == nil && isEmptyResponse(resp); retry++ {
+		delay := emptyResponseRetryDelay << (retry - 1)
+		log.Printf("Warning: Gemini API Call: Empty response with no error; retrying in %s (retry %d/%d).", delay, retry, maxEmptyResponseRetries)
+		if err = sleepContext(input.Ctx, delay); err == nil && input.Limiter != nil {
+			err = input.Limiter.Wait(input.Ctx)
+		}
+		if err != nil {
+			break // Cancelled while waiting: the empty response stays the last one and is accounted for below.
+		}
+		if resp.UsageMetadata != nil {
+			discardedOutputTokens += int(resp.UsageMetadata.CandidatesTokenCount)
+		}
+		response.InputTokens += promptTokens
+		resp, err = client.GenerateContent(input.Ctx, input.ModelName, reqContents, genConfig)
+	}
 
 	// --- Log Response Body ---
 	if resp != nil {
@@ -338,7 +854,7 @@ func CallGeminiAPI(input CallGeminiAPIInput) GeminiAPIResponse { // Updated sign
 		if errMarshalResp != nil {
 			log.Printf("Warning: Failed to marshal Gemini response for logging: %v", errMarshalResp)
 		} else {
-			if errWriteResp := os.WriteFile(respFileName, respBodyBytes, 0644); errWriteResp != nil {
+			if errWriteResp := os.WriteFile(respFileName, respBodyBytes, file.FileMode()); errWriteResp != nil {
 				log.Printf("Warning: Failed to write Gemini response body to '%s': %v", respFileName, errWriteResp)
 			} else {
 				log.Printf("Gemini API Call: Response body saved to: %s", respFileName)
@@ -351,31 +867,83 @@ func CallGeminiAPI(input CallGeminiAPIInput) GeminiAPIResponse { // Updated sign
 
 	if err != nil {
 		log.Printf("Gemini API Call: Error generating content: %v", err)
-		response.Err = fmt.Errorf("error generating content from Gemini: %w", err)
+		response.Err = fmt.Errorf("%w: %w", ErrAPI, err)
+		if input.Timeout > 0 && errors.Is(input.Ctx.Err(), context.DeadlineExceeded) {
+			response.Err = fmt.Errorf("%w: no response from '%s' within %s (raise --timeout if the model needs longer): %v", ErrTimeout, input.ModelName, input.Timeout, err)
+		}
+		if resp != nil {
+			// Keep whatever the API returned alongside the error so callers can save it and account for its cost.
+			response.GeneratedText = resp.Text()
+			response.OutputTokens = discardedOutputTokens
+			if resp.UsageMetadata != nil {
+				response.OutputTokens += int(resp.UsageMetadata.CandidatesTokenCount)
+			}
+		} else {
+			// No response came back, so this attempt's prompt was not billed; earlier empty ones were.
+			response.InputTokens -= promptTokens
+			response.OutputTokens = discardedOutputTokens
+		}
+		response.Cost = callCost(response.InputTokens, response.OutputTokens, modelPrices)
 		return response
 	}
 
-	if len(resp.Candidates) == 0 || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0 {
-		log.Printf("Gemini API Call: No content generated for the given instruction.")
-		response.Err = fmt.Errorf("no content generated from Gemini for the given instruction")
+	if blockErr := safetyBlockError(resp); blockErr != nil {
+		log.Printf("Gemini API Call: %v", blockErr)
+		response.Err = blockErr
+		if len(resp.Candidates) > 0 && resp.Candidates[0] != nil {
+			response.FinishReason = string(resp.Candidates[0].FinishReason)
+		}
+		// A blocked call is still billed for the tokens it reports.
+		response.OutputTokens = discardedOutputTokens
+		if resp.UsageMetadata != nil {
+			response.OutputTokens += int(resp.UsageMetadata.CandidatesTokenCount)
+		}
+		response.Cost = callCost(response.InputTokens, response.OutputTokens, modelPrices)
+		return response
+	}
+
+	if isEmptyResponse(resp) {
+		log.Printf("Gemini API Call: No content generated for the given instruction after %d retries.", maxEmptyResponseRetries)
+		response.Err = fmt.Errorf("%w: %w: no content generated for the given instruction after %d retries", ErrAPI, ErrNoContent, maxEmptyResponseRetries)
+		response.OutputTokens = discardedOutputTokens
+		if resp.UsageMetadata != nil {
+			response.OutputTokens += int(resp.UsageMetadata.CandidatesTokenCount)
+		}
+		response.Cost = callCost(response.InputTokens, response.OutputTokens, modelPrices)
 		return response
 	}
 
 	response.GeneratedText = resp.Text()
+	response.FinishReason = string(resp.Candidates[0].FinishReason)
+	if resp.Candidates[0].FinishReason == genai.FinishReasonMaxTokens {
+		log.Printf("Warning: Gemini API Call: Response was cut off by the output token limit (finish reason %s).", response.FinishReason)
+	}
 	if len(resp.Candidates) > 0 && len(resp.Candidates[0].Content.Parts) > 0 {
 		response.ThoughtSignature = resp.Candidates[0].Content.Parts[0].ThoughtSignature
 	}
 
-	response.OutputTokens = 0
+	response.OutputTokens = discardedOutputTokens
 	if resp.UsageMetadata != nil {
-		response.OutputTokens = int(resp.UsageMetadata.CandidatesTokenCount)
+		response.OutputTokens += int(resp.UsageMetadata.CandidatesTokenCount)
 	} else {
-		log.Println("failed to count output token, output tokens will be 0 for cost calculation.")
+		// Without usage metadata, count the generated text ourselves so cost is not under-reported.
+		response.EstimatedTokens = true
+		outputContents := []*genai.Content{genai.NewContentFromText(response.GeneratedText, genai.RoleModel)}
+		outputCount, errCount := client.CountTokens(input.Ctx, input.ModelName, outputContents, &genai.CountTokensConfig{})
+		if errCount != nil || outputCount == nil {
+			log.Printf("Warning: Response has no usage metadata and counting the generated text failed: %v. Output tokens will be 0 for cost calculation.", errCount)
+		} else {
+			response.OutputTokens += int(outputCount.TotalTokens)
+			log.Printf("Warning: Response has no usage metadata; estimated %d output tokens by counting the generated text. Cost is approximate.", response.OutputTokens)
+		}
 	}
 
 	// Calculate cost
-	response.Cost = (float64(response.InputTokens)/TokensPerMillion)*modelPrices.InputPricePerMillion +
-		(float64(response.OutputTokens)/TokensPerMillion)*modelPrices.OutputPricePerMillion
+	response.Cost = callCost(response.InputTokens, response.OutputTokens, modelPrices)
 
+	// Only successful responses reach this point, so errors are never cached.
+	if cacheDir != "" {
+		storeCachedResponse(cacheDir, cacheKey, input.ModelName, response)
+	}
 	return response
 }
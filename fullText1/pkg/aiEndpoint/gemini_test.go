@@ -0,0 +1,352 @@
+package aiEndpoint
+
+import (
+	"context"
+	"errors"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// fakeResult is one answer of fakeClient.GenerateContent.
+type fakeResult struct {
+	resp *genai.GenerateContentResponse
+	err  error
+}
+
+// fakeClient is a GenaiClient that answers from a script instead of the API.
+type fakeClient struct {
+	promptTokens int32        // CountTokens answer for the request
+	outputTokens int32        // CountTokens answer for generated text, counted when usage metadata is missing
+	countErr     error        // When set, every CountTokens call fails
+	results      []fakeResult // GenerateContent answers in order; the last one repeats
+	block        bool         // GenerateContent waits for the context to be done and returns its error
+	calls        int          // GenerateContent calls made
+}
+
+// CountTokens returns promptTokens for a request and outputTokens for generated text.
+func (c *fakeClient) CountTokens(ctx context.Context, model string, contents []*genai.Content, config *genai.CountTokensConfig) (*genai.CountTokensResponse, error) {
+	if c.countErr != nil {
+		return nil, c.countErr
+	}
+	if len(contents) > 0 && contents[len(contents)-1].Role == genai.RoleModel {
+		return &genai.CountTokensResponse{TotalTokens: c.outputTokens}, nil
+	}
+	return &genai.CountTokensResponse{TotalTokens: c.promptTokens}, nil
+}
+
+// GenerateContent returns the next scripted result.
+func (c *fakeClient) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	c.calls++
+	if c.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	result := c.results[min(c.calls, len(c.results))-1]
+	return result.resp, result.err
+}
+
+// textResponse returns a finished response with text that reports outputTokens of usage.
+func textResponse(text string, outputTokens int32) *genai.GenerateContentResponse {
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{
+			Content:      genai.NewContentFromText(text, genai.RoleModel),
+			FinishReason: genai.FinishReasonStop,
+		}},
+		UsageMetadata: &genai.GenerateContentResponseUsageMetadata{CandidatesTokenCount: outputTokens},
+	}
+}
+
+// emptyResponse returns a response with no candidates that reports outputTokens of usage.
+func emptyResponse(outputTokens int32) *genai.GenerateContentResponse {
+	return &genai.GenerateContentResponse{
+		UsageMetadata: &genai.GenerateContentResponseUsageMetadata{CandidatesTokenCount: outputTokens},
+	}
+}
+
+// testInput returns the input of a call answered by client. The request and response dumps go to
+// a temporary directory.
+func testInput(t *testing.T, client GenaiClient, model string) CallGeminiAPIInput {
+	t.Helper()
+	t.Setenv("TMPDIR", t.TempDir())
+	return CallGeminiAPIInput{Ctx: context.Background(), ModelName: model, Prompt: "Write Chapter 1.", Client: client}
+}
+
+// almostEqual reports whether two costs are equal up to floating-point rounding.
+func almostEqual(a, b float64) bool {
+	return math.Abs(a-b) < 1e-12
+}
+
+func TestCallGeminiAPICost(t *testing.T) {
+	tests := []struct {
+		name         string
+		model        string
+		promptTokens int32
+		outputTokens int32
+		want         float64
+	}{
+		{"flat price", "gemini-2.5-flash", 1000, 2000, 1000*0.30/1e6 + 2000*2.50/1e6},
+		{"low tier", "gemini-2.5-pro", 1000, 2000, 1000*1.25/1e6 + 2000*10.00/1e6},
+		{"tier threshold is inclusive", "gemini-2.5-pro", Gemini25ProPromptTokenThreshold, 100, 200000*1.25/1e6 + 100*10.00/1e6},
+		{"high tier", "gemini-2.5-pro", 300000, 1000, 300000*2.50/1e6 + 1000*15.00/1e6},
+		{"deprecated alias", "gemini-pro", 1000, 2000, 1000*1.25/1e6 + 2000*10.00/1e6},
+		{"unknown model costs nothing", "no-such-model", 1000, 2000, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeClient{promptTokens: tt.promptTokens, results: []fakeResult{{resp: textResponse("text", tt.outputTokens)}}}
+			resp := CallGeminiAPI(testInput(t, client, tt.model))
+			if resp.Err != nil {
+				t.Fatalf("CallGeminiAPI() error = %v", resp.Err)
+			}
+			if !almostEqual(resp.Cost, tt.want) {
+				t.Errorf("Cost = %v, want %v", resp.Cost, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallGeminiAPITokenAccounting(t *testing.T) {
+	t.Run("counted prompt and reported output", func(t *testing.T) {
+		client := &fakeClient{promptTokens: 1234, results: []fakeResult{{resp: textResponse("Chapter text", 567)}}}
+		resp := CallGeminiAPI(testInput(t, client, "gemini-2.5-flash"))
+		if resp.Err != nil {
+			t.Fatalf("CallGeminiAPI() error = %v", resp.Err)
+		}
+		if resp.InputTokens != 1234 || resp.OutputTokens != 567 || resp.EstimatedTokens {
+			t.Errorf("tokens = (%d, %d, estimated %v), want (1234, 567, estimated false)", resp.InputTokens, resp.OutputTokens, resp.EstimatedTokens)
+		}
+		if resp.GeneratedText != "Chapter text" || resp.FinishReason != string(genai.FinishReasonStop) {
+			t.Errorf("response = (%q, %q), want (%q, %q)", resp.GeneratedText, resp.FinishReason, "Chapter text", genai.FinishReasonStop)
+		}
+	})
+
+	t.Run("output counted when usage metadata is missing", func(t *testing.T) {
+		noUsage := textResponse("Chapter text", 0)
+		noUsage.UsageMetadata = nil
+		client := &fakeClient{promptTokens: 100, outputTokens: 42, results: []fakeResult{{resp: noUsage}}}
+		resp := CallGeminiAPI(testInput(t, client, "gemini-2.5-flash"))
+		if resp.Err != nil {
+			t.Fatalf("CallGeminiAPI() error = %v", resp.Err)
+		}
+		if resp.InputTokens != 100 || resp.OutputTokens != 42 || !resp.EstimatedTokens {
+			t.Errorf("tokens = (%d, %d, estimated %v), want (100, 42, estimated true)", resp.InputTokens, resp.OutputTokens, resp.EstimatedTokens)
+		}
+	})
+
+	t.Run("prompt estimated when counting fails", func(t *testing.T) {
+		client := &fakeClient{countErr: errors.New("count failed"), results: []fakeResult{{resp: textResponse("Chapter text", 10)}}}
+		input := testInput(t, client, "gemini-2.5-flash")
+		resp := CallGeminiAPI(input)
+		if resp.Err != nil {
+			t.Fatalf("CallGeminiAPI() error = %v", resp.Err)
+		}
+		if want := EstimateTokens(input.Prompt); resp.InputTokens != want || !resp.EstimatedTokens {
+			t.Errorf("InputTokens = %d (estimated %v), want %d (estimated true)", resp.InputTokens, resp.EstimatedTokens, want)
+		}
+		if want := float64(resp.InputTokens)*0.30/1e6 + 10*2.50/1e6; !almostEqual(resp.Cost, want) {
+			t.Errorf("Cost = %v, want %v", resp.Cost, want)
+		}
+	})
+}
+
+func TestCallGeminiAPIErrorPropagation(t *testing.T) {
+	rateLimited := genai.APIError{Code: http.StatusTooManyRequests, Message: "Resource has been exhausted", Status: "RESOURCE_EXHAUSTED"}
+
+	t.Run("API error without a response", func(t *testing.T) {
+		client := &fakeClient{promptTokens: 500, results: []fakeResult{{err: rateLimited}}}
+		resp := CallGeminiAPI(testInput(t, client, "gemini-2.5-flash"))
+		if !errors.Is(resp.Err, ErrAPI) || !IsAPIError(resp.Err) || !IsRateLimited(resp.Err) {
+			t.Fatalf("Err = %v, want a rate-limited API error", resp.Err)
+		}
+		if client.calls != 1 {
+			t.Errorf("GenerateContent calls = %d, want 1: errors are retried by the caller", client.calls)
+		}
+		if resp.InputTokens != 0 || resp.OutputTokens != 0 || resp.Cost != 0 {
+			t.Errorf("usage = (%d, %d, %v), want nothing billed without a response", resp.InputTokens, resp.OutputTokens, resp.Cost)
+		}
+	})
+
+	t.Run("API error with a partial response", func(t *testing.T) {
+		client := &fakeClient{promptTokens: 500, results: []fakeResult{{resp: textResponse("partial", 20), err: errors.New("stream broken")}}}
+		resp := CallGeminiAPI(testInput(t, client, "gemini-2.5-flash"))
+		if !errors.Is(resp.Err, ErrAPI) {
+			t.Fatalf("Err = %v, want ErrAPI", resp.Err)
+		}
+		if resp.GeneratedText != "partial" || resp.InputTokens != 500 || resp.OutputTokens != 20 {
+			t.Errorf("response = (%q, %d, %d), want (%q, 500, 20)", resp.GeneratedText, resp.InputTokens, resp.OutputTokens, "partial")
+		}
+		if want := 500*0.30/1e6 + 20*2.50/1e6; !almostEqual(resp.Cost, want) {
+			t.Errorf("Cost = %v, want %v", resp.Cost, want)
+		}
+	})
+
+	t.Run("safety block", func(t *testing.T) {
+		blocked := textResponse("", 3)
+		blocked.Candidates[0].FinishReason = genai.FinishReasonSafety
+		client := &fakeClient{promptTokens: 500, results: []fakeResult{{resp: blocked}}}
+		resp := CallGeminiAPI(testInput(t, client, "gemini-2.5-flash"))
+		if !errors.Is(resp.Err, ErrSafetyBlocked) || !IsAPIError(resp.Err) {
+			t.Fatalf("Err = %v, want ErrSafetyBlocked", resp.Err)
+		}
+		if client.calls != 1 {
+			t.Errorf("GenerateContent calls = %d, want 1: a blocked prompt is not retried", client.calls)
+		}
+		if resp.InputTokens != 500 || resp.OutputTokens != 3 {
+			t.Errorf("tokens = (%d, %d), want (500, 3)", resp.InputTokens, resp.OutputTokens)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		client := &fakeClient{promptTokens: 500, block: true}
+		input := testInput(t, client, "gemini-2.5-flash")
+		input.Timeout = time.Millisecond
+		resp := CallGeminiAPI(input)
+		if !errors.Is(resp.Err, ErrTimeout) || !IsAPIError(resp.Err) {
+			t.Fatalf("Err = %v, want ErrTimeout", resp.Err)
+		}
+	})
+}
+
+func TestRetryDelay(t *testing.T) {
+	rateLimited := genai.APIError{Code: http.StatusTooManyRequests}
+	serverError := genai.APIError{Code: http.StatusInternalServerError}
+	tests := []struct {
+		name    string
+		err     error
+		attempt int
+		want    time.Duration
+	}{
+		{"other failure", serverError, 3, RetryBaseDelay},
+		{"network failure", errors.New("connection reset"), 2, RetryBaseDelay},
+		{"rate limited, first retry", rateLimited, 1, RetryBaseDelay},
+		{"rate limited, second retry", rateLimited, 2, 2 * RetryBaseDelay},
+		{"rate limited, third retry", rateLimited, 3, 4 * RetryBaseDelay},
+		{"rate limited, capped", rateLimited, 4, maxRetryDelay},
+		{"rate limited, stays capped", rateLimited, 10, maxRetryDelay},
+		{"wrapped rate limit", errors.Join(ErrAPI, &rateLimited), 2, 2 * RetryBaseDelay},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := RetryDelay(tt.err, tt.attempt); got != tt.want {
+				t.Errorf("RetryDelay(%v, %d) = %s, want %s", tt.err, tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+// noEmptyResponseDelay makes CallGeminiAPI retry empty responses without waiting for the rest of the test.
+func noEmptyResponseDelay(t *testing.T) {
+	t.Helper()
+	saved := emptyResponseRetryDelay
+	emptyResponseRetryDelay = 0
+	t.Cleanup(func() { emptyResponseRetryDelay = saved })
+}
+
+func TestCallGeminiAPIEmptyResponseRetry(t *testing.T) {
+	noEmptyResponseDelay(t)
+
+	t.Run("empty then valid", func(t *testing.T) {
+		client := &fakeClient{promptTokens: 100, results: []fakeResult{{resp: emptyResponse(5)}, {resp: textResponse("Chapter text", 50)}}}
+		resp := CallGeminiAPI(testInput(t, client, "gemini-2.5-flash"))
+		if resp.Err != nil {
+			t.Fatalf("CallGeminiAPI() error = %v", resp.Err)
+		}
+		if client.calls != 2 {
+			t.Errorf("GenerateContent calls = %d, want 2", client.calls)
+		}
+		if resp.GeneratedText != "Chapter text" {
+			t.Errorf("GeneratedText = %q, want %q", resp.GeneratedText, "Chapter text")
+		}
+		// Both attempts are billed: the prompt twice, and the output the empty response reported.
+		if resp.InputTokens != 200 || resp.OutputTokens != 55 {
+			t.Errorf("tokens = (%d, %d), want (200, 55)", resp.InputTokens, resp.OutputTokens)
+		}
+	})
+
+	t.Run("every attempt empty", func(t *testing.T) {
+		client := &fakeClient{promptTokens: 100, results: []fakeResult{{resp: emptyResponse(1)}}}
+		resp := CallGeminiAPI(testInput(t, client, "gemini-2.5-flash"))
+		if !errors.Is(resp.Err, ErrNoContent) || !errors.Is(resp.Err, ErrAPI) {
+			t.Fatalf("Err = %v, want ErrNoContent", resp.Err)
+		}
+		if want := maxEmptyResponseRetries + 1; client.calls != want {
+			t.Errorf("GenerateContent calls = %d, want %d", client.calls, want)
+		}
+		if want := (maxEmptyResponseRetries + 1) * 100; resp.InputTokens != want || resp.OutputTokens != maxEmptyResponseRetries+1 {
+			t.Errorf("tokens = (%d, %d), want (%d, %d)", resp.InputTokens, resp.OutputTokens, want, maxEmptyResponseRetries+1)
+		}
+	})
+
+	t.Run("error after an empty response", func(t *testing.T) {
+		client := &fakeClient{promptTokens: 100, results: []fakeResult{{resp: emptyResponse(5)}, {err: errors.New("connection reset")}}}
+		resp := CallGeminiAPI(testInput(t, client, "gemini-2.5-flash"))
+		if !errors.Is(resp.Err, ErrAPI) || errors.Is(resp.Err, ErrNoContent) {
+			t.Fatalf("Err = %v, want the API error of the retry", resp.Err)
+		}
+		// The retry returned nothing, so only the empty attempt is billed.
+		if resp.InputTokens != 100 || resp.OutputTokens != 5 {
+			t.Errorf("tokens = (%d, %d), want (100, 5)", resp.InputTokens, resp.OutputTokens)
+		}
+	})
+}
+
+func TestParseChapterCount(t *testing.T) {
+	tests := []struct {
+		response string
+		want     int
+		wantErr  string
+	}{
+		{response: "30", want: 30},
+		{response: "30.", want: 30},
+		{response: "**30**", want: 30},
+		{response: "The plan has 30 chapters.", want: 30},
+		{response: "Chapters: 12\n", want: 12},
+		{response: "Thirty", wantErr: `no number in chapter count response "Thirty"`},
+		{response: "0", wantErr: "is zero"},
+		{response: "99999999999999999999", wantErr: "out of range"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.response, func(t *testing.T) {
+			got, err := ParseChapterCount(tt.response)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("ParseChapterCount(%q) error = %v, want one containing %q", tt.response, err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("ParseChapterCount(%q) = %d, %v; want %d", tt.response, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSavePricing(t *testing.T) {
+	before, err := GetModelPrices("gemini-2.5-flash", 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "pricing.json")
+	if err := os.WriteFile(path, []byte(`{"gemini-2.5-flash": [{"input_price_per_million": 9, "output_price_per_million": 99}]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	restore := SavePricing()
+	if err := LoadPricingFile(path); err != nil {
+		t.Fatalf("LoadPricingFile() error = %v", err)
+	}
+	if loaded, _ := GetModelPrices("gemini-2.5-flash", 1000); loaded.InputPricePerMillion != 9 {
+		t.Fatalf("GetModelPrices() after LoadPricingFile = %+v, want the file's prices", loaded)
+	}
+	restore()
+	if after, _ := GetModelPrices("gemini-2.5-flash", 1000); *after != *before {
+		t.Errorf("GetModelPrices() after restore = %+v, want %+v", after, before)
+	}
+}
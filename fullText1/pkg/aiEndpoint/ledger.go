@@ -0,0 +1,74 @@
+package aiEndpoint
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+)
+
+// ledgerHeader is the first row of a --ledger file.
+var ledgerHeader = []string{"timestamp", "command", "model", "input_tokens", "output_tokens", "cost_usd", "output_file"}
+
+// LedgerEntry is one row of a --ledger file: the usage of a single command run.
+type LedgerEntry struct {
+	Time         time.Time
+	Command      string // e.g. "abstract", "story", or "story continue"
+	Model        string
+	InputTokens  int     // Tokens of this run only, not the totals accumulated over resumed runs
+	OutputTokens int     // Tokens of this run only
+	Cost         float64 // USD cost of this run only
+	OutputPath   string  // The abstract or story file written
+}
+
+// record returns the CSV fields of e in ledgerHeader order.
+func (e LedgerEntry) record() []string {
+	return []string{
+		e.Time.Format(time.RFC3339),
+		e.Command,
+		e.Model,
+		strconv.Itoa(e.InputTokens),
+		strconv.Itoa(e.OutputTokens),
+		strconv.FormatFloat(e.Cost, 'f', 6, 64),
+		e.OutputPath,
+	}
+}
+
+// AppendLedger appends entry as one row to the CSV ledger at path, for tracking spend across runs.
+// A missing or empty file is created with a header row first. The file is locked while the row is
+// written, so commands running at the same time can share one ledger.
+func AppendLedger(path string, entry LedgerEntry) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, file.FileMode())
+	if err != nil {
+		return fmt.Errorf("failed to open ledger '%s': %w", path, err)
+	}
+	defer f.Close()
+	if err := lockFile(f); err != nil {
+		return fmt.Errorf("failed to lock ledger '%s': %w", path, err)
+	}
+	defer unlockFile(f)
+
+	// The size is read under the lock, so only one of several new runs writes the header.
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to read ledger '%s': %w", path, err)
+	}
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if info.Size() == 0 {
+		w.Write(ledgerHeader)
+	}
+	w.Write(entry.record())
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to format ledger row: %w", err)
+	}
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write ledger '%s': %w", path, err)
+	}
+	return nil
+}
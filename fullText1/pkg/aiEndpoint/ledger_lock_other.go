@@ -0,0 +1,16 @@
+//go:build !unix
+
+package aiEndpoint
+
+import "os"
+
+// lockFile does nothing where flock is not available. AppendLedger still writes each row with a
+// single append, which keeps concurrent rows whole on local file systems.
+func lockFile(f *os.File) error {
+	return nil
+}
+
+// unlockFile releases the lock taken by lockFile.
+func unlockFile(f *os.File) error {
+	return nil
+}
@@ -0,0 +1,203 @@
+package aiEndpoint
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"google.golang.org/genai"
+)
+
+// MockAPIKey is the placeholder API key used in mock mode, where no real key is needed.
+const MockAPIKey = "mock"
+
+// mockMaxWords caps the length of a mock response, whatever the prompt asks for.
+const mockMaxWords = 20000
+
+// mockMode routes every Gemini client through MockClient when set by SetMockMode.
+var mockMode atomic.Bool
+
+// SetMockMode makes every Gemini call made from now on answered locally by MockClient instead of
+// the API: no API key is needed, nothing is sent over the network, and every call costs 0. The
+// response cache is bypassed, so mock text never answers a later real request.
+func SetMockMode(enabled bool) {
+	if enabled {
+		logMockMode()
+	}
+	mockMode.Store(enabled)
+}
+
+// NewMockClient returns a MockClient for the calls of one run, passed as CallGeminiAPIInput.Client,
+// and logs that they are answered locally. Unlike SetMockMode, it leaves other calls untouched.
+func NewMockClient() GenaiClient {
+	logMockMode()
+	return MockClient{}
+}
+
+// logMockMode logs that Gemini calls are answered by MockClient.
+func logMockMode() {
+	log.Printf("Mock mode: Gemini calls are answered locally with placeholder text at no cost.")
+}
+
+// MockMode reports whether SetMockMode enabled mock mode.
+func MockMode() bool {
+	return mockMode.Load()
+}
+
+// Patterns MockClient uses to recognise what a prompt asks for.
+var (
+	mockChapterCountPattern = regexp.MustCompile(`(?i)total number of chapters`)
+	mockPlanPattern         = regexp.MustCompile(`(?i)plan for all (\d+) chapters`)
+	mockChapterPattern      = regexp.MustCompile(`(?i)write Chapter \d+`)
+	mockStoryPattern        = regexp.MustCompile(`(?i)entire story in (\d+) chapters`)
+	mockNoTitlePattern      = regexp.MustCompile(`(?i)without a chapter title|not give the chapter a title`)
+	mockChapterRefPattern   = regexp.MustCompile(`(?i)\bchapter (\d+)\b`)
+	mockWordsPattern        = regexp.MustCompile(`(?i)(\d+) words`)
+)
+
+// mockWords is the vocabulary of the placeholder text.
+var mockWords = strings.Fields(`lorem ipsum dolor sit amet consectetur adipiscing elit sed do eiusmod tempor
+incididunt ut labore et dolore magna aliqua enim ad minim veniam quis nostrud exercitation ullamco laboris
+nisi aliquip ex ea commodo consequat duis aute irure in reprehenderit voluptate velit esse cillum eu fugiat
+nulla pariatur excepteur sint occaecat cupidatat non proident sunt culpa qui officia deserunt mollit anim id est laborum`)
+
+// MockClient is a GenaiClient that answers locally with deterministic lorem ipsum text, for demos
+// and offline runs (see SetMockMode). It recognises the prompts of this module well enough to keep
+// the pipeline working: a chapter count prompt gets a number, an abstract prompt gets a plan with
+// one line per chapter, a whole-story prompt gets "## Chapter N" sections, and a chapter prompt gets a titled chapter of the requested word count.
+// Token counts are estimated from the text length; CallGeminiAPI reports every mock call at no cost.
+type MockClient struct{}
+
+// CountTokens estimates the tokens of contents at about four characters per token.
+func (MockClient) CountTokens(ctx context.Context, model string, contents []*genai.Content, config *genai.CountTokensConfig) (*genai.CountTokensResponse, error) {
+	return &genai.CountTokensResponse{TotalTokens: int32(estimateContentTokens(contents))}, nil
+}
+
+// GenerateContent answers the last prompt in contents with placeholder text.
+func (MockClient) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	if ctx != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	prompt := ""
+	if len(contents) > 0 {
+		prompt = contentText(contents[len(contents)-1])
+	}
+	text := mockResponseText(prompt)
+	output := []*genai.Content{genai.NewContentFromText(text, genai.RoleModel)}
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{Content: output[0], FinishReason: genai.FinishReasonStop}},
+		UsageMetadata: &genai.GenerateContentResponseUsageMetadata{
+			PromptTokenCount:     int32(estimateContentTokens(contents)),
+			CandidatesTokenCount: int32(estimateContentTokens(output)),
+		},
+	}, nil
+}
+
+// contentText joins the text parts of content.
+func contentText(content *genai.Content) string {
+	if content == nil {
+		return ""
+	}
+	var b strings.Builder
+	for _, part := range content.Parts {
+		if part != nil {
+			b.WriteString(part.Text)
+		}
+	}
+	return b.String()
+}
+
+// mockResponseText returns the placeholder answer to prompt. The same prompt always gets the same answer.
+func mockResponseText(prompt string) string {
+	h := fnv.New32a()
+	h.Write([]byte(prompt))
+	offset := int(h.Sum32() % uint32(len(mockWords)))
+
+	if mockChapterCountPattern.MatchString(prompt) {
+		count := 0
+		for _, m := range mockChapterRefPattern.FindAllStringSubmatch(prompt, -1) {
+			if n, err := strconv.Atoi(m[1]); err == nil && n > count {
+				count = n
+			}
+		}
+		if count == 0 {
+			count = 3
+		}
+		return strconv.Itoa(count)
+	}
+	if m := mockPlanPattern.FindStringSubmatch(prompt); m != nil {
+		chapters, _ := strconv.Atoi(m[1])
+		var b strings.Builder
+		fmt.Fprintf(&b, "Title: %s\n\nSetting: %s\n", mockTitle(offset), mockParagraph(offset, 40))
+		for i := 1; i <= chapters; i++ {
+			fmt.Fprintf(&b, "\nChapter %d: %s\n", i, mockParagraph(offset+i*7, 30))
+		}
+		return b.String()
+	}
+	words := 150
+	if m := mockWordsPattern.FindStringSubmatch(prompt); m != nil {
+		words, _ = strconv.Atoi(m[1])
+		words = min(max(words, 1), mockMaxWords)
+	}
+	if m := mockStoryPattern.FindStringSubmatch(prompt); m != nil {
+		chapters, _ := strconv.Atoi(m[1])
+		var b strings.Builder
+		for i := 1; i <= chapters; i++ {
+			fmt.Fprintf(&b, "## Chapter %d\n\n", i)
+			if !mockNoTitlePattern.MatchString(prompt) {
+				fmt.Fprintf(&b, "%s\n\n", mockTitle(offset+i*7))
+			}
+			fmt.Fprintf(&b, "%s\n\n", mockText(offset+i*7, max(words/chapters, 1)))
+		}
+		return b.String()
+	}
+	if mockChapterPattern.MatchString(prompt) && !mockNoTitlePattern.MatchString(prompt) {
+		return mockTitle(offset) + "\n\n" + mockText(offset, words)
+	}
+	return mockText(offset, words)
+}
+
+// mockTitle returns a short title-cased phrase of placeholder words.
+func mockTitle(offset int) string {
+	words := make([]string, 3)
+	for i := range words {
+		w := mockWords[(offset+i)%len(mockWords)]
+		words[i] = strings.ToUpper(w[:1]) + w[1:]
+	}
+	return strings.Join(words, " ")
+}
+
+// mockParagraph returns words placeholder words as sentences of twelve words.
+func mockParagraph(offset, words int) string {
+	var b strings.Builder
+	for i := 0; i < words; i++ {
+		w := mockWords[(offset+i)%len(mockWords)]
+		switch {
+		case i%12 == 0:
+			if i > 0 {
+				b.WriteString(". ")
+			}
+			w = strings.ToUpper(w[:1]) + w[1:]
+		default:
+			b.WriteByte(' ')
+		}
+		b.WriteString(w)
+	}
+	b.WriteByte('.')
+	return b.String()
+}
+
+// mockText returns words placeholder words in paragraphs of up to 96 words.
+func mockText(offset, words int) string {
+	const paragraphWords = 96
+	var paragraphs []string
+	for done := 0; done < words; done += paragraphWords {
+		paragraphs = append(paragraphs, mockParagraph(offset+done, min(paragraphWords, words-done)))
+	}
+	return strings.Join(paragraphs, "\n\n")
+}
@@ -0,0 +1,48 @@
+package aiEndpoint
+
+import (
+	"log"
+	"strings"
+	"sync"
+)
+
+// deprecatedModelAliases maps retired or unversioned model names to the model used in their place.
+// The replacement is what is both called and priced, so the reported and billed models agree.
+var deprecatedModelAliases = map[string]string{
+	"gemini-pro":     "gemini-2.5-pro",
+	"gemini-1.5-pro": "gemini-2.5-pro",
+}
+
+// warnedModelAliases records the deprecated aliases already warned about, so each is logged once.
+var warnedModelAliases sync.Map
+
+// NormalizeModelName returns the canonical name of a model and whether name is a deprecated alias
+// of it, e.g. "gemini-pro" gives ("gemini-2.5-pro", true). Other names are returned trimmed.
+func NormalizeModelName(name string) (canonical string, deprecated bool) {
+	name = strings.TrimSpace(name)
+	if replacement, ok := deprecatedModelAliases[name]; ok {
+		return replacement, true
+	}
+	return name, false
+}
+
+// CanonicalModelName is NormalizeModelName that logs a warning the first time each deprecated
+// alias is used.
+func CanonicalModelName(name string) string {
+	canonical, deprecated := NormalizeModelName(name)
+	if deprecated {
+		if _, warned := warnedModelAliases.LoadOrStore(name, true); !warned {
+			log.Printf("Warning: Model name '%s' is deprecated; calling and pricing '%s' instead. Set model_name to '%s' to silence this warning.", name, canonical, canonical)
+		}
+	}
+	return canonical
+}
+
+// DeprecatedModelAliases returns a copy of the deprecated model names and the models they map to.
+func DeprecatedModelAliases() map[string]string {
+	aliases := make(map[string]string, len(deprecatedModelAliases))
+	for alias, model := range deprecatedModelAliases {
+		aliases[alias] = model
+	}
+	return aliases
+}
@@ -0,0 +1,263 @@
+package aiEndpoint
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"sync"
+)
+
+// Pricing constants per 1 million tokens
+const (
+	// Gemini 2.5 Pro (prompts <= 200k tokens)
+	Gemini25ProInputPriceLowTierPerMillion  float64 = 1.25
+	Gemini25ProOutputPriceLowTierPerMillion float64 = 10.00
+
+	// Gemini 2.5 Pro (prompts > 200k tokens)
+	Gemini25ProInputPriceHighTierPerMillion  float64 = 2.50
+	Gemini25ProOutputPriceHighTierPerMillion float64 = 15.00
+
+	// Gemini 3 Pro Preview (prompts <= 200k tokens)
+	Gemini3ProPreviewInputPriceLowTierPerMillion  float64 = 2.00
+	Gemini3ProPreviewOutputPriceLowTierPerMillion float64 = 12.00
+
+	// Gemini 3 Pro Preview (prompts > 200k tokens)
+	Gemini3ProPreviewInputPriceHighTierPerMillion  float64 = 4.00
+	Gemini3ProPreviewOutputPriceHighTierPerMillion float64 = 18.00
+
+	// Gemini 3 Flash Preview
+	Gemini3FlashPreviewInputPricePerMillion  float64 = 0.50
+	Gemini3FlashPreviewOutputPricePerMillion float64 = 3.00
+
+	Gemini25ProPromptTokenThreshold = 200000
+
+	// Gemini 2.5 Flash
+	Gemini25FlashInputPricePerMillion  float64 = 0.30
+	Gemini25FlashOutputPricePerMillion float64 = 2.50
+
+	// Gemini 2.5 Flash Lite
+	Gemini25FlashLiteInputPricePerMillion  float64 = 0.10
+	Gemini25FlashLiteOutputPricePerMillion float64 = 0.40
+
+	TokensPerMillion float64 = 1_000_000.0
+)
+
+// PricingFileEnvVar names the environment variable holding the path to a pricing override file.
+const PricingFileEnvVar = "GEMINI_PRICING_FILE"
+
+// ModelPrices holds the per-million token pricing for a specific model tier.
+type ModelPrices struct {
+	InputPricePerMillion  float64
+	OutputPricePerMillion float64
+}
+
+// PricingTier describes the prompt-size tier that applies to a request.
+type PricingTier struct {
+	Index          int // Position of the tier among the model's tiers, 0 being the cheapest
+	Count          int // Number of tiers the model has
+	MaxInputTokens int // Largest prompt the tier applies to; 0 means no upper bound
+	Prices         ModelPrices
+}
+
+// IsHigherTier reports whether a more expensive tier than the model's base tier applies.
+func (t PricingTier) IsHigherTier() bool {
+	return t.Index > 0
+}
+
+// tierPricing is the price of one prompt-size tier of a model. MaxInputTokens is the largest
+// prompt (inclusive) the tier applies to; 0 means no upper bound.
+type tierPricing struct {
+	MaxInputTokens        int     `json:"max_input_tokens,omitempty"`
+	InputPricePerMillion  float64 `json:"input_price_per_million"`
+	OutputPricePerMillion float64 `json:"output_price_per_million"`
+}
+
+var (
+	pricingMu sync.RWMutex
+	// modelPricing maps model names to their tiers, ordered by MaxInputTokens with the unbounded tier last.
+	modelPricing = defaultModelPricing()
+)
+
+// defaultModelPricing returns the built-in pricing table used when no pricing file overrides it.
+func defaultModelPricing() map[string][]tierPricing {
+	return map[string][]tierPricing{
+		"gemini-2.5-pro": {
+			{MaxInputTokens: Gemini25ProPromptTokenThreshold, InputPricePerMillion: Gemini25ProInputPriceLowTierPerMillion, OutputPricePerMillion: Gemini25ProOutputPriceLowTierPerMillion},
+			{InputPricePerMillion: Gemini25ProInputPriceHighTierPerMillion, OutputPricePerMillion: Gemini25ProOutputPriceHighTierPerMillion},
+		},
+		"gemini-3-pro-preview": {
+			{MaxInputTokens: Gemini25ProPromptTokenThreshold, InputPricePerMillion: Gemini3ProPreviewInputPriceLowTierPerMillion, OutputPricePerMillion: Gemini3ProPreviewOutputPriceLowTierPerMillion},
+			{InputPricePerMillion: Gemini3ProPreviewInputPriceHighTierPerMillion, OutputPricePerMillion: Gemini3ProPreviewOutputPriceHighTierPerMillion},
+		},
+		"gemini-3-flash-preview": {
+			{InputPricePerMillion: Gemini3FlashPreviewInputPricePerMillion, OutputPricePerMillion: Gemini3FlashPreviewOutputPricePerMillion},
+		},
+		"gemini-2.5-flash": {
+			{InputPricePerMillion: Gemini25FlashInputPricePerMillion, OutputPricePerMillion: Gemini25FlashOutputPricePerMillion},
+		},
+		"gemini-2.5-flash-lite": {
+			{InputPricePerMillion: Gemini25FlashLiteInputPricePerMillion, OutputPricePerMillion: Gemini25FlashLiteOutputPricePerMillion},
+		},
+	}
+}
+
+// GetModelPrices returns the input and output prices per 1 million tokens for a given model and input token count.
+// The inputTokens parameter is crucial for determining the pricing tier for models like gemini-2.5-pro.
+// Prices come from the built-in table, overridden by any pricing file loaded with LoadPricingFile.
+func GetModelPrices(modelName string, inputTokens int) (*ModelPrices, error) {
+	tier, err := GetPricingTier(modelName, inputTokens)
+	if err != nil {
+		return nil, err
+	}
+	return &tier.Prices, nil
+}
+
+// GetPricingTier returns the pricing tier that applies to a prompt of inputTokens tokens for the model.
+// Deprecated aliases are priced as the model NormalizeModelName maps them to.
+func GetPricingTier(modelName string, inputTokens int) (PricingTier, error) {
+	modelName, _ = NormalizeModelName(modelName)
+	pricingMu.RLock()
+	tiers, ok := modelPricing[modelName]
+	pricingMu.RUnlock()
+	if !ok || len(tiers) == 0 {
+		return PricingTier{}, fmt.Errorf("unsupported model for pricing: %s", modelName)
+	}
+
+	// The prompt may exceed every bounded tier; then the largest one applies.
+	index := len(tiers) - 1
+	for i, tier := range tiers {
+		if tier.MaxInputTokens == 0 || inputTokens <= tier.MaxInputTokens {
+			index = i
+			break
+		}
+	}
+	tier := tiers[index]
+	return PricingTier{
+		Index:          index,
+		Count:          len(tiers),
+		MaxInputTokens: tier.MaxInputTokens,
+		Prices: ModelPrices{
+			InputPricePerMillion:  tier.InputPricePerMillion,
+			OutputPricePerMillion: tier.OutputPricePerMillion,
+		},
+	}, nil
+}
+
+// ModelPricing is the pricing of one model, as listed by PricedModels.
+type ModelPricing struct {
+	Model string
+	Tiers []PricingTier // Ordered by MaxInputTokens, the unbounded tier last
+}
+
+// PricedModels returns every model in the pricing table, including those added or overridden by a
+// pricing file, sorted by name.
+func PricedModels() []ModelPricing {
+	pricingMu.RLock()
+	defer pricingMu.RUnlock()
+	models := make([]ModelPricing, 0, len(modelPricing))
+	for model, tiers := range modelPricing {
+		entry := ModelPricing{Model: model}
+		for i, tier := range tiers {
+			entry.Tiers = append(entry.Tiers, PricingTier{
+				Index:          i,
+				Count:          len(tiers),
+				MaxInputTokens: tier.MaxInputTokens,
+				Prices: ModelPrices{
+					InputPricePerMillion:  tier.InputPricePerMillion,
+					OutputPricePerMillion: tier.OutputPricePerMillion,
+				},
+			})
+		}
+		models = append(models, entry)
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Model < models[j].Model })
+	return models
+}
+
+// LoadPricingFile merges model prices from a JSON file into the pricing table. The file maps
+// model names to a list of tiers, for example:
+//
+//	{
+//	  "gemini-2.5-pro": [
+//	    {"max_input_tokens": 200000, "input_price_per_million": 1.25, "output_price_per_million": 10.0},
+//	    {"input_price_per_million": 2.5, "output_price_per_million": 15.0}
+//	  ]
+//	}
+//
+// A tier without max_input_tokens has no upper bound. Models in the file replace the built-in
+// entry of the same name; built-in models not listed keep their default prices.
+func LoadPricingFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read pricing file '%s': %w", path, err)
+	}
+
+	var overrides map[string][]tierPricing
+	if err := json.Unmarshal(data, &overrides); err != nil {
+		return fmt.Errorf("failed to parse pricing file '%s': %w", path, err)
+	}
+
+	for model, tiers := range overrides {
+		if len(tiers) == 0 {
+			return fmt.Errorf("pricing file '%s' has no tiers for model '%s'", path, model)
+		}
+		for _, tier := range tiers {
+			if tier.MaxInputTokens < 0 || tier.InputPricePerMillion < 0 || tier.OutputPricePerMillion < 0 {
+				return fmt.Errorf("pricing file '%s' has negative values for model '%s'", path, model)
+			}
+		}
+		// Bounded tiers in ascending order, the unbounded tier (0) last.
+		sort.SliceStable(tiers, func(i, j int) bool {
+			if tiers[i].MaxInputTokens == 0 {
+				return false
+			}
+			if tiers[j].MaxInputTokens == 0 {
+				return true
+			}
+			return tiers[i].MaxInputTokens < tiers[j].MaxInputTokens
+		})
+	}
+
+	pricingMu.Lock()
+	defer pricingMu.Unlock()
+	for model, tiers := range overrides {
+		if canonical, deprecated := NormalizeModelName(model); deprecated {
+			log.Printf("Warning: Pricing file '%s' prices deprecated model name '%s'; the prices apply to '%s'.", path, model, canonical)
+			model = canonical
+		}
+		modelPricing[model] = tiers
+	}
+	log.Printf("Loaded pricing for %d model(s) from '%s'.", len(overrides), path)
+	return nil
+}
+
+// SavePricing records the current model prices and returns a function restoring them, so a
+// caller can undo LoadPricingFile or LoadPricingOverrides once it is done.
+func SavePricing() (restore func()) {
+	pricingMu.RLock()
+	saved := make(map[string][]tierPricing, len(modelPricing))
+	for model, tiers := range modelPricing {
+		saved[model] = tiers // LoadPricingFile replaces tier slices and never changes them in place
+	}
+	pricingMu.RUnlock()
+	return func() {
+		pricingMu.Lock()
+		defer pricingMu.Unlock()
+		modelPricing = saved
+	}
+}
+
+// LoadPricingOverrides loads the pricing file given by flagPath or, when it is empty, by the
+// GEMINI_PRICING_FILE environment variable. It does nothing when neither is set.
+func LoadPricingOverrides(flagPath string) error {
+	path := flagPath
+	if path == "" {
+		path = os.Getenv(PricingFileEnvVar)
+	}
+	if path == "" {
+		return nil
+	}
+	return LoadPricingFile(path)
+}
@@ -0,0 +1,131 @@
+package aiEndpoint
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/genai"
+)
+
+// RetryBaseDelay is the wait before retrying a failed Gemini call.
+const RetryBaseDelay = 20 * time.Second
+
+// maxEmptyResponseRetries is how many times CallGeminiAPI retries a response that has no content
+// but no error either, before returning ErrNoContent.
+const maxEmptyResponseRetries = 2
+
+// emptyResponseRetryDelay is the wait before the first retry of an empty response; it doubles for
+// each further retry. It is shorter than RetryBaseDelay, as an empty response is not a rate limit.
+var emptyResponseRetryDelay = 2 * time.Second
+
+// maxRetryDelay caps the exponential backoff applied to rate-limited calls.
+const maxRetryDelay = 2 * time.Minute
+
+// APIErrorCode returns the HTTP status code of the genai.APIError wrapped in err, or 0 when err
+// did not come from the Gemini API (for example a network failure or an empty response).
+func APIErrorCode(err error) int {
+	if apiErr, ok := asAPIError(err); ok {
+		return apiErr.Code
+	}
+	return 0
+}
+
+// asAPIError returns the genai.APIError wrapped in err, by value or by pointer.
+func asAPIError(err error) (genai.APIError, bool) {
+	var apiErr genai.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr, true
+	}
+	var apiErrPtr *genai.APIError
+	if errors.As(err, &apiErrPtr) && apiErrPtr != nil {
+		return *apiErrPtr, true
+	}
+	return genai.APIError{}, false
+}
+
+// IsAuthError reports whether err is Gemini rejecting the API key: a 401 or 403, or the 400
+// "API key not valid" answer.
+func IsAuthError(err error) bool {
+	apiErr, ok := asAPIError(err)
+	if !ok {
+		return false
+	}
+	switch apiErr.Code {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return true
+	case http.StatusBadRequest:
+		return strings.Contains(apiErr.Message, "API key") || strings.Contains(apiErr.Message, "API_KEY_INVALID")
+	}
+	return false
+}
+
+// IsConfigError reports whether err comes from the Gemini configuration rather than from a call:
+// a missing API key, an unreadable or invalid config file or setting, or a rejected API key.
+func IsConfigError(err error) bool {
+	for _, sentinel := range []error{ErrNoAPIKey, ErrConfigUnreadable, ErrConfigInvalidJSON, ErrInvalidThinkingLevel, ErrInvalidSampling, ErrInvalidThinkingBudget} {
+		if errors.Is(err, sentinel) {
+			return true
+		}
+	}
+	return IsAuthError(err)
+}
+
+// IsAPIError reports whether err is a failed Gemini call: an error answer from the API, a
+// network failure, a timeout, a safety block, or an empty response.
+func IsAPIError(err error) bool {
+	if errors.Is(err, ErrAPI) || errors.Is(err, ErrTimeout) || errors.Is(err, ErrSafetyBlocked) {
+		return true
+	}
+	_, ok := asAPIError(err)
+	return ok
+}
+
+// isEmptyResponse reports whether resp has no content to return although the call succeeded.
+// Safety blocks are not empty responses: retrying the same prompt would be blocked again.
+func isEmptyResponse(resp *genai.GenerateContentResponse) bool {
+	if resp == nil || safetyBlockError(resp) != nil {
+		return false
+	}
+	return len(resp.Candidates) == 0 || resp.Candidates[0] == nil || resp.Candidates[0].Content == nil || len(resp.Candidates[0].Content.Parts) == 0
+}
+
+// sleepContext waits for d, or returns the context's error when ctx is done first.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	if ctx == nil {
+		time.Sleep(d)
+		return nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// IsRateLimited reports whether err is a Gemini 429 (RESOURCE_EXHAUSTED) error, which is worth retrying after a wait.
+func IsRateLimited(err error) bool {
+	return APIErrorCode(err) == http.StatusTooManyRequests
+}
+
+// RetryDelay returns how long to wait before retry number attempt (starting at 1) after err.
+// Rate-limited calls back off exponentially from RetryBaseDelay up to two minutes; other
+// failures wait RetryBaseDelay.
+func RetryDelay(err error, attempt int) time.Duration {
+	if !IsRateLimited(err) || attempt <= 1 {
+		return RetryBaseDelay
+	}
+	delay := RetryBaseDelay
+	for i := 1; i < attempt && delay < maxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
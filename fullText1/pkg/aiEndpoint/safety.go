@@ -0,0 +1,62 @@
+package aiEndpoint
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/genai"
+)
+
+// safetyFinishReasons are the candidate finish reasons that mean the response was withheld or cut
+// off by Gemini's content filters rather than by the model finishing or running out of tokens.
+var safetyFinishReasons = map[genai.FinishReason]bool{
+	genai.FinishReasonSafety:            true,
+	genai.FinishReasonProhibitedContent: true,
+	genai.FinishReasonBlocklist:         true,
+	genai.FinishReasonSPII:              true,
+	genai.FinishReasonRecitation:        true,
+}
+
+// safetyBlockError returns an error wrapping ErrSafetyBlocked when resp was blocked, either because
+// the prompt was rejected (PromptFeedback.BlockReason) or because the first candidate stopped for a
+// safety reason. The error names the block reason and the harm categories that were flagged. It
+// returns nil for a response that was not blocked.
+func safetyBlockError(resp *genai.GenerateContentResponse) error {
+	if resp == nil {
+		return nil
+	}
+	if feedback := resp.PromptFeedback; feedback != nil && feedback.BlockReason != "" {
+		return fmt.Errorf("%w: prompt blocked (reason %s%s)", ErrSafetyBlocked, feedback.BlockReason, blockedCategories(feedback.SafetyRatings))
+	}
+	if len(resp.Candidates) > 0 && resp.Candidates[0] != nil {
+		candidate := resp.Candidates[0]
+		if safetyFinishReasons[candidate.FinishReason] {
+			return fmt.Errorf("%w: response stopped (finish reason %s%s)", ErrSafetyBlocked, candidate.FinishReason, blockedCategories(candidate.SafetyRatings))
+		}
+	}
+	return nil
+}
+
+// blockedCategories formats the harm categories rated as blocked, or as high probability when none
+// is marked blocked, as ", categories: A, B". It returns "" when no category stands out.
+func blockedCategories(ratings []*genai.SafetyRating) string {
+	var blocked, high []string
+	for _, rating := range ratings {
+		if rating == nil {
+			continue
+		}
+		switch {
+		case rating.Blocked:
+			blocked = append(blocked, string(rating.Category))
+		case rating.Probability == genai.HarmProbabilityHigh:
+			high = append(high, string(rating.Category))
+		}
+	}
+	if len(blocked) == 0 {
+		blocked = high
+	}
+	if len(blocked) == 0 {
+		return ""
+	}
+	return ", categories: " + strings.Join(blocked, ", ")
+}
@@ -0,0 +1,35 @@
+package aiEndpoint
+
+import "sync"
+
+// CostTracker accumulates the tokens and cost of Gemini calls. It is safe for concurrent use,
+// and its zero value is an empty tracker ready to use. Pass it by pointer.
+type CostTracker struct {
+	mu           sync.Mutex
+	inputTokens  int
+	outputTokens int
+	cost         float64
+}
+
+// Add records the usage of one call. Usage reported alongside an error is counted too, since
+// the API bills it.
+func (t *CostTracker) Add(resp GeminiAPIResponse) {
+	t.AddUsage(resp.InputTokens, resp.OutputTokens, resp.Cost)
+}
+
+// AddUsage records usage that has already been summed, such as a chapter's total over its
+// retries and follow-up calls, or the totals saved by an earlier run.
+func (t *CostTracker) AddUsage(inputTokens, outputTokens int, cost float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.inputTokens += inputTokens
+	t.outputTokens += outputTokens
+	t.cost += cost
+}
+
+// Summary returns the tokens and USD cost recorded so far.
+func (t *CostTracker) Summary() (inputTokens, outputTokens int, cost float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.inputTokens, t.outputTokens, t.cost
+}
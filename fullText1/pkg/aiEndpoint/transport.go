@@ -0,0 +1,115 @@
+package aiEndpoint
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+// HTTPClientOptions configures the HTTP client used for every Gemini call. The zero value keeps
+// the SDK's default client, which already honours the HTTPS_PROXY and NO_PROXY environment variables.
+type HTTPClientOptions struct {
+	Proxy      string        // Proxy URL, e.g. "http://proxy.corp:3128"; "" uses HTTPS_PROXY/HTTP_PROXY from the environment
+	CACertPath string        // PEM bundle of extra trusted CA certificates, added to the system pool
+	Timeout    time.Duration // Overall limit for each HTTP request; 0 means no limit
+}
+
+var (
+	httpClientMu sync.RWMutex
+	httpClient   *http.Client // nil uses the SDK's default client
+)
+
+// SetHTTPClient makes every Gemini client created afterwards send its requests through client,
+// e.g. one with a custom proxy or TLS configuration. nil restores the SDK's default client.
+func SetHTTPClient(client *http.Client) {
+	httpClientMu.Lock()
+	defer httpClientMu.Unlock()
+	httpClient = client
+}
+
+// currentHTTPClient returns the client set by SetHTTPClient, or nil.
+func currentHTTPClient() *http.Client {
+	httpClientMu.RLock()
+	defer httpClientMu.RUnlock()
+	return httpClient
+}
+
+// NewHTTPClient builds an HTTP client from opts. Proxy settings come from opts.Proxy, falling back
+// to the standard proxy environment variables, and the CA bundle at opts.CACertPath is trusted in
+// addition to the system roots.
+func NewHTTPClient(opts HTTPClientOptions) (*http.Client, error) {
+	if opts.Timeout < 0 {
+		return nil, fmt.Errorf("HTTP timeout must not be negative, got %s", opts.Timeout)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = http.ProxyFromEnvironment
+	if opts.Proxy != "" {
+		proxyURL, err := url.Parse(opts.Proxy)
+		if err != nil || proxyURL.Scheme == "" || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy URL '%s': expected e.g. 'http://proxy.example.com:3128'", redactProxyURL(opts.Proxy))
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+	if opts.CACertPath != "" {
+		pem, err := os.ReadFile(opts.CACertPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA certificate file '%s': %w", opts.CACertPath, err)
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			log.Printf("Warning: Failed to load the system certificate pool: %v. Trusting only '%s'.", err, opts.CACertPath)
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("CA certificate file '%s' contains no PEM certificates", opts.CACertPath)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	}
+	return &http.Client{Transport: transport, Timeout: opts.Timeout}, nil
+}
+
+// ConfigureHTTPClient builds a client from opts with ConfiguredHTTPClient and installs it with
+// SetHTTPClient. Zero options leave the SDK's default client in place.
+func ConfigureHTTPClient(opts HTTPClientOptions) error {
+	client, err := ConfiguredHTTPClient(opts)
+	if err != nil || client == nil {
+		return err
+	}
+	SetHTTPClient(client)
+	return nil
+}
+
+// ConfiguredHTTPClient builds a client from opts with NewHTTPClient and logs the proxy and CA
+// bundle it uses, for use as CallGeminiAPIInput.HTTPClient of one run. Zero options return nil,
+// which keeps the SDK's default client.
+func ConfiguredHTTPClient(opts HTTPClientOptions) (*http.Client, error) {
+	if opts == (HTTPClientOptions{}) {
+		return nil, nil
+	}
+	client, err := NewHTTPClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Proxy != "" {
+		log.Printf("Sending Gemini requests through proxy %s.", redactProxyURL(opts.Proxy))
+	}
+	if opts.CACertPath != "" {
+		log.Printf("Trusting extra CA certificates from '%s'.", opts.CACertPath)
+	}
+	return client, nil
+}
+
+// redactProxyURL hides the password of a proxy URL so it can be logged.
+func redactProxyURL(proxy string) string {
+	u, err := url.Parse(proxy)
+	if err != nil {
+		return proxy
+	}
+	return u.Redacted()
+}
@@ -0,0 +1,31 @@
+// Package cli holds the errors the commands share with main to pick the process exit code.
+package cli
+
+import "errors"
+
+// ErrUsage marks errors caused by bad flags or arguments, as opposed to failures while running.
+// Match it with errors.Is.
+var ErrUsage = errors.New("invalid usage")
+
+// usageError wraps an error in ErrUsage without changing its message.
+type usageError struct {
+	err error
+}
+
+// Error returns the wrapped error's message.
+func (e *usageError) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap exposes both ErrUsage and the wrapped error to errors.Is and errors.As.
+func (e *usageError) Unwrap() []error {
+	return []error{ErrUsage, e.err}
+}
+
+// UsageError marks err as a usage error, keeping its message. It returns nil for a nil err.
+func UsageError(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &usageError{err: err}
+}
@@ -0,0 +1,79 @@
+// Package config implements the 'config' command, which checks Gemini configuration files.
+package config
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/cli"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+)
+
+// ErrInvalidConfig is returned by 'config validate' when a check fails.
+var ErrInvalidConfig = errors.New("config validation failed")
+
+// Execute is the main entry point for the 'config' subcommand. Its first argument selects a
+// nested subcommand; 'validate' is the only one.
+func Execute(args []string) error {
+	if len(args) == 0 {
+		return cli.UsageError(fmt.Errorf("missing config subcommand (available: validate)"))
+	}
+	switch args[0] {
+	case "validate":
+		return executeValidate(args[1:])
+	default:
+		return cli.UsageError(fmt.Errorf("unknown config subcommand '%s' (available: validate)", args[0]))
+	}
+}
+
+// executeValidate implements 'config validate', which checks a config file and the API key it
+// resolves to without generating anything, and prints an OK/WARN/ERROR line per check.
+func executeValidate(args []string) error {
+	cmd := flag.NewFlagSet("config validate", flag.ContinueOnError)
+	cmd.Usage = func() {
+		fmt.Fprintf(cmd.Output(), "Usage of %s config validate:\n", os.Args[0])
+		cmd.PrintDefaults()
+	}
+	configPath := cmd.String("config", "", "Path to the Gemini configuration JSON file to check (optional). Defaults to the first existing default config file.")
+	apiKeyFile := cmd.String("api-key-file", "", "Path to a file containing the Gemini API key, checked as the other commands would use it (optional).")
+	verifyKey := cmd.Bool("check-key", false, "Also make a free CountTokens call to confirm Gemini accepts the API key for the configured model. Requires network access.")
+	pricingFile := cmd.String("pricing-file", "", "Path to a pricing JSON file whose models count as known (optional). Defaults to the GEMINI_PRICING_FILE env var.")
+	if err := cmd.Parse(args); err != nil {
+		return cli.UsageError(fmt.Errorf("failed to parse config validate flags: %w", err))
+	}
+
+	// The report is the command's output, so keep the log lines out of it.
+	originalLogOutput := log.Writer()
+	log.SetOutput(logging.Console(os.Stderr))
+	defer log.SetOutput(originalLogOutput)
+
+	if err := aiEndpoint.LoadPricingOverrides(*pricingFile); err != nil {
+		return err
+	}
+	report := aiEndpoint.CheckGeminiConfig(context.Background(), aiEndpoint.CheckConfigOptions{
+		ConfigPath: *configPath,
+		APIKeyFile: *apiKeyFile,
+		VerifyKey:  *verifyKey,
+	})
+
+	if report.ConfigPath != "" {
+		fmt.Printf("Config file: %s\n", report.ConfigPath)
+	} else {
+		fmt.Printf("Config file: none\n")
+	}
+	for _, check := range report.Checks {
+		fmt.Printf("  %-5s %-15s %s\n", check.Severity, check.Name, check.Detail)
+	}
+	errorCount, warningCount := report.Count(aiEndpoint.CheckError), report.Count(aiEndpoint.CheckWarning)
+	if errorCount > 0 {
+		fmt.Printf("Result: FAILED (%d error(s), %d warning(s))\n", errorCount, warningCount)
+		return fmt.Errorf("%w: %d error(s)", ErrInvalidConfig, errorCount)
+	}
+	fmt.Printf("Result: OK (%d warning(s))\n", warningCount)
+	return nil
+}
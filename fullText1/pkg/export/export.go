@@ -0,0 +1,232 @@
+// Package export renders story text into the output file formats supported by the
+// story command. The format is chosen from the output file's extension.
+package export
+
+import (
+	"fmt"
+	"html"
+	"io"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Supported output formats.
+const (
+	FormatText     = "txt"
+	FormatMarkdown = "md"
+	FormatHTML     = "html"
+)
+
+// titleLinePattern matches a header title line such as "--- Full Story: 2025-01-02 15:04:05 ---".
+var titleLinePattern = regexp.MustCompile(`^-{3} (.+) -{3}$`)
+
+// separatorLinePattern matches a line made only of dashes, used to close the story header.
+var separatorLinePattern = regexp.MustCompile(`^-{4,}$`)
+
+// blankLinePattern separates paragraphs.
+var blankLinePattern = regexp.MustCompile(`\n[ \t]*\n`)
+
+// Writer renders a story: one header block followed by its chapters in order.
+// Close must be called once all chapters have been written.
+type Writer interface {
+	WriteHeader(header string) error
+	WriteChapter(number int, body string) error
+	Close() error
+}
+
+// FormatFromPath returns the output format for a file path based on its extension.
+// Unrecognised extensions fall back to plain text.
+func FormatFromPath(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".md", ".markdown":
+		return FormatMarkdown
+	case ".html", ".htm":
+		return FormatHTML
+	default:
+		return FormatText
+	}
+}
+
+// Layout customizes the chapter headings and header separator a Writer produces. The zero value
+// is the standard layout.
+type Layout struct {
+	// ChapterHeading returns the heading line of a chapter and the text written under it, e.g.
+	// "# Chapter 3: The Storm" and the body without its title line. nil writes "## Chapter N"
+	// and the body unchanged.
+	ChapterHeading func(number int, body string) (heading, text string)
+	// Separator replaces the dashed line that ends the header in plain text; "" keeps it.
+	// Markdown always uses a horizontal rule and HTML none.
+	Separator string
+}
+
+// chapter returns the heading and text of a chapter under l.
+func (l Layout) chapter(number int, body string) (heading, text string) {
+	if l.ChapterHeading == nil {
+		return fmt.Sprintf("## Chapter %d", number), body
+	}
+	return l.ChapterHeading(number, body)
+}
+
+// NewWriter returns a Writer for the given format that writes to w in the standard layout.
+func NewWriter(w io.Writer, format string) (Writer, error) {
+	return NewWriterWithLayout(w, format, Layout{})
+}
+
+// NewWriterWithLayout returns a Writer for the given format that writes to w in layout.
+func NewWriterWithLayout(w io.Writer, format string, layout Layout) (Writer, error) {
+	switch format {
+	case FormatText:
+		return &textWriter{w: w, layout: layout}, nil
+	case FormatMarkdown:
+		return &markdownWriter{w: w, layout: layout}, nil
+	case FormatHTML:
+		return &htmlWriter{w: w, layout: layout}, nil
+	default:
+		return nil, fmt.Errorf("unsupported output format '%s'", format)
+	}
+}
+
+// textWriter writes the plain text layout used by the story files: the header as-is,
+// then a "## Chapter N" line (or the layout's heading) before each chapter.
+type textWriter struct {
+	w      io.Writer
+	layout Layout
+}
+
+// WriteHeader writes the header text, with its separator line replaced by the layout's.
+func (t *textWriter) WriteHeader(header string) error {
+	if t.layout.Separator != "" {
+		lines := strings.Split(header, "\n")
+		for i, line := range lines {
+			if separatorLinePattern.MatchString(strings.TrimSpace(line)) {
+				lines[i] = t.layout.Separator
+			}
+		}
+		header = strings.Join(lines, "\n")
+	}
+	_, err := io.WriteString(t.w, header)
+	return err
+}
+
+// WriteChapter writes a chapter header line followed by the chapter text.
+func (t *textWriter) WriteChapter(number int, body string) error {
+	heading, text := t.layout.chapter(number, body)
+	_, err := fmt.Fprintf(t.w, "%s\n\n%s\n\n", heading, strings.TrimSpace(text))
+	return err
+}
+
+// Close is a no-op for plain text.
+func (t *textWriter) Close() error {
+	return nil
+}
+
+// markdownWriter writes Markdown: the header title becomes a top-level heading, the
+// header separator a horizontal rule, and each chapter gets a "## Chapter N" heading, or the
+// layout's heading made a level-2 heading when it is not one already.
+type markdownWriter struct {
+	w      io.Writer
+	layout Layout
+}
+
+// WriteHeader writes the header with its title and separator lines converted to Markdown.
+func (m *markdownWriter) WriteHeader(header string) error {
+	lines := strings.Split(header, "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		if match := titleLinePattern.FindStringSubmatch(trimmed); match != nil {
+			lines[i] = "# " + match[1]
+		} else if separatorLinePattern.MatchString(trimmed) {
+			lines[i] = "---"
+		}
+	}
+	_, err := io.WriteString(m.w, strings.Join(lines, "\n"))
+	return err
+}
+
+// WriteChapter writes a chapter heading followed by the chapter text.
+func (m *markdownWriter) WriteChapter(number int, body string) error {
+	heading, text := m.layout.chapter(number, body)
+	if !strings.HasPrefix(heading, "#") {
+		heading = "## " + heading
+	}
+	_, err := fmt.Fprintf(m.w, "%s\n\n%s\n\n", heading, strings.TrimSpace(text))
+	return err
+}
+
+// Close is a no-op for Markdown.
+func (m *markdownWriter) Close() error {
+	return nil
+}
+
+// htmlWriter writes a standalone HTML document. Text is escaped and split into
+// paragraphs on blank lines.
+type htmlWriter struct {
+	w      io.Writer
+	layout Layout
+}
+
+// WriteHeader opens the document and writes the header as a <header> element.
+func (h *htmlWriter) WriteHeader(header string) error {
+	title := "Story"
+	var b strings.Builder
+	for _, para := range splitParagraphs(header) {
+		if match := titleLinePattern.FindStringSubmatch(para); match != nil {
+			title = match[1]
+			fmt.Fprintf(&b, "<h1>%s</h1>\n", html.EscapeString(match[1]))
+		} else if separatorLinePattern.MatchString(para) {
+			continue
+		} else {
+			writeHTMLParagraph(&b, para)
+		}
+	}
+
+	_, err := fmt.Fprintf(h.w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>%s</title>\n</head>\n<body>\n<header>\n%s</header>\n<hr>\n",
+		html.EscapeString(title), b.String())
+	return err
+}
+
+// WriteChapter writes a chapter as a <section> with a heading and its paragraphs. Markdown
+// heading markers in the layout's heading are dropped.
+func (h *htmlWriter) WriteChapter(number int, body string) error {
+	heading, text := fmt.Sprintf("Chapter %d", number), body
+	if h.layout.ChapterHeading != nil {
+		heading, text = h.layout.ChapterHeading(number, body)
+		heading = strings.TrimSpace(strings.TrimLeft(heading, "#"))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "<section id=\"chapter-%d\">\n<h2>%s</h2>\n", number, html.EscapeString(heading))
+	for _, para := range splitParagraphs(text) {
+		writeHTMLParagraph(&b, para)
+	}
+	b.WriteString("</section>\n")
+	_, err := io.WriteString(h.w, b.String())
+	return err
+}
+
+// Close ends the HTML document.
+func (h *htmlWriter) Close() error {
+	_, err := io.WriteString(h.w, "</body>\n</html>\n")
+	return err
+}
+
+// splitParagraphs splits text on blank lines and returns the trimmed, non-empty paragraphs.
+func splitParagraphs(text string) []string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	var paragraphs []string
+	for _, para := range blankLinePattern.Split(text, -1) {
+		if para = strings.TrimSpace(para); para != "" {
+			paragraphs = append(paragraphs, para)
+		}
+	}
+	return paragraphs
+}
+
+// writeHTMLParagraph writes an escaped paragraph, keeping single line breaks as <br>.
+func writeHTMLParagraph(b *strings.Builder, para string) {
+	lines := strings.Split(para, "\n")
+	for i, line := range lines {
+		lines[i] = html.EscapeString(strings.TrimSpace(line))
+	}
+	fmt.Fprintf(b, "<p>%s</p>\n", strings.Join(lines, "<br>\n"))
+}
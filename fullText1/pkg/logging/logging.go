@@ -0,0 +1,143 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Supported values for the --log-format flag.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// Log levels attached to every event.
+const (
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// Fields holds structured key/value pairs attached to a log event.
+type Fields map[string]interface{}
+
+// Logger emits named log events. The text implementation prints only the message,
+// while the JSON implementation keeps every field as a first-class key.
+type Logger interface {
+	Info(event, msg string, fields Fields)
+	Warn(event, msg string, fields Fields)
+	Error(event, msg string, fields Fields)
+}
+
+// ValidateFormat checks that format is one of the supported log formats.
+func ValidateFormat(format string) error {
+	switch format {
+	case FormatText, FormatJSON:
+		return nil
+	default:
+		return fmt.Errorf("unsupported log format '%s' (valid values: %s, %s)", format, FormatText, FormatJSON)
+	}
+}
+
+// textLogger writes events through the standard log package, keeping the existing free-form lines.
+type textLogger struct{}
+
+// NewTextLogger returns a Logger that prints each event's message via the standard log package.
+func NewTextLogger() Logger {
+	return textLogger{}
+}
+
+func (textLogger) Info(event, msg string, fields Fields)  { log.Output(3, msg) }
+func (textLogger) Warn(event, msg string, fields Fields)  { log.Output(3, "Warning: "+msg) }
+func (textLogger) Error(event, msg string, fields Fields) { log.Output(3, "Error: "+msg) }
+
+// JSONLogger writes one JSON object per line, e.g.
+// {"ts":"...","level":"info","event":"chapter_done","msg":"...","chapter":3,"input_tokens":1234,"cost":0.01}.
+type JSONLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewJSONLogger returns a JSONLogger writing to w.
+func NewJSONLogger(w io.Writer) *JSONLogger {
+	return &JSONLogger{w: w}
+}
+
+func (l *JSONLogger) Info(event, msg string, fields Fields)  { l.emit(LevelInfo, event, msg, fields) }
+func (l *JSONLogger) Warn(event, msg string, fields Fields)  { l.emit(LevelWarn, event, msg, fields) }
+func (l *JSONLogger) Error(event, msg string, fields Fields) { l.emit(LevelError, event, msg, fields) }
+
+// emit encodes a single event. The fixed keys come first, followed by the fields in sorted order.
+func (l *JSONLogger) emit(level, event, msg string, fields Fields) {
+	var buf bytes.Buffer
+	buf.WriteString("{")
+	writeJSONField(&buf, "ts", time.Now().UTC().Format(time.RFC3339Nano), true)
+	writeJSONField(&buf, "level", level, false)
+	writeJSONField(&buf, "event", event, false)
+	writeJSONField(&buf, "msg", msg, false)
+
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		switch k {
+		case "ts", "level", "event", "msg":
+			continue // Reserved keys cannot be overridden by fields
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		writeJSONField(&buf, k, fields[k], false)
+	}
+	buf.WriteString("}\n")
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(buf.Bytes())
+}
+
+// writeJSONField appends `"key":value` to buf, falling back to the value's string form if it cannot be marshaled.
+func writeJSONField(buf *bytes.Buffer, key string, value interface{}, first bool) {
+	if !first {
+		buf.WriteString(",")
+	}
+	keyBytes, _ := json.Marshal(key)
+	buf.Write(keyBytes)
+	buf.WriteString(":")
+	valueBytes, err := json.Marshal(value)
+	if err != nil {
+		valueBytes, _ = json.Marshal(fmt.Sprint(value))
+	}
+	buf.Write(valueBytes)
+}
+
+// Writer returns an io.Writer that turns free-form lines (such as those written by the
+// standard log package) into "log" events, so every line in a JSON log file is parseable.
+// Lines starting with "Warning:" are emitted at warn level.
+func (l *JSONLogger) Writer() io.Writer {
+	return lineWriter{logger: l}
+}
+
+type lineWriter struct {
+	logger *JSONLogger
+}
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "Warning:") {
+			w.logger.Warn("log", line, nil)
+		} else {
+			w.logger.Info("log", line, nil)
+		}
+	}
+	return len(p), nil
+}
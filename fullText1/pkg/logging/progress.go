@@ -0,0 +1,83 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Progress draws a single status line, such as "Chapter 13/40 (32%) — $2.14 spent", at the bottom
+// of a terminal. While it is shown, lines written through Console are printed above it, so the
+// status line stays in place. It never reaches the log file, which only receives log lines.
+type Progress struct {
+	mu   sync.Mutex
+	w    io.Writer
+	line string
+}
+
+// activeProgress is the progress line Console writers print around, or nil when none is shown.
+var activeProgress atomic.Pointer[Progress]
+
+// clearLine returns the cursor to the start of the line and erases it.
+const clearLine = "\r\033[K"
+
+// IsTerminal reports whether f is a terminal rather than a file or pipe.
+func IsTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// StartProgress shows a progress line on stderr when both stdout and stderr are terminals and the
+// console is not in quiet mode. Otherwise it returns nil; the methods of a nil *Progress do nothing,
+// so callers need not check. Call Done when finished.
+func StartProgress() *Progress {
+	if !Enabled(VerbosityNormal) || !IsTerminal(os.Stderr) || !IsTerminal(os.Stdout) {
+		return nil
+	}
+	p := &Progress{w: os.Stderr}
+	activeProgress.Store(p)
+	return p
+}
+
+// Update replaces the progress line with the formatted text.
+func (p *Progress) Update(format string, args ...interface{}) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.line = fmt.Sprintf(format, args...)
+	fmt.Fprint(p.w, clearLine+p.line)
+}
+
+// Done erases the progress line and stops Console writers from printing around it.
+func (p *Progress) Done() {
+	if p == nil {
+		return
+	}
+	activeProgress.CompareAndSwap(p, nil)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.line != "" {
+		fmt.Fprint(p.w, clearLine)
+		p.line = ""
+	}
+}
+
+// writeAbove erases the progress line, writes b to w, and redraws the progress line below it.
+func (p *Progress) writeAbove(w io.Writer, b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.line == "" {
+		return w.Write(b)
+	}
+	fmt.Fprint(p.w, clearLine)
+	n, err := w.Write(b)
+	fmt.Fprint(p.w, p.line)
+	return n, err
+}
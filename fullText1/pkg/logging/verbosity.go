@@ -0,0 +1,96 @@
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// Verbosity controls how much the commands print to the console. The log file, when there is
+// one, always receives every line regardless of verbosity.
+type Verbosity int32
+
+// Console verbosity levels, from least to most output.
+const (
+	// VerbosityQuiet prints only the final results, such as the output path and total cost.
+	VerbosityQuiet Verbosity = -1
+	// VerbosityNormal also prints per-chapter progress and the log lines on stderr. It is the default.
+	VerbosityNormal Verbosity = 0
+	// VerbosityVerbose also logs the prompts sent to Gemini.
+	VerbosityVerbose Verbosity = 1
+)
+
+// verbosity holds the current level; its zero value is VerbosityNormal.
+var verbosity atomic.Int32
+
+// SetVerbosity sets the console verbosity for the whole program.
+func SetVerbosity(v Verbosity) {
+	verbosity.Store(int32(v))
+}
+
+// CurrentVerbosity returns the console verbosity set by SetVerbosity.
+func CurrentVerbosity() Verbosity {
+	return Verbosity(verbosity.Load())
+}
+
+// Enabled reports whether output at the given level is shown.
+func Enabled(level Verbosity) bool {
+	return CurrentVerbosity() >= level
+}
+
+// Printf prints to stdout when the current verbosity is at least level. Use VerbosityQuiet for
+// output that is always shown.
+func Printf(level Verbosity, format string, args ...interface{}) {
+	if Enabled(level) {
+		fmt.Printf(format, args...)
+	}
+}
+
+// Console wraps a console writer such as os.Stderr so that nothing is written to it in quiet mode.
+// The verbosity is checked on every write, so it may be set after the writer is created. While a
+// Progress line is shown, each write is printed above it.
+func Console(w io.Writer) io.Writer {
+	return consoleWriter{w: w}
+}
+
+type consoleWriter struct {
+	w io.Writer
+}
+
+func (c consoleWriter) Write(p []byte) (int, error) {
+	if !Enabled(VerbosityNormal) {
+		return len(p), nil
+	}
+	if progress := activeProgress.Load(); progress != nil {
+		return progress.writeAbove(c.w, p)
+	}
+	return c.w.Write(p)
+}
+
+// VerbosityFlags holds the --verbose and --quiet flags shared by the commands.
+type VerbosityFlags struct {
+	Verbose bool
+	Quiet   bool
+}
+
+// Register adds --verbose and --quiet to cmd.
+func (f *VerbosityFlags) Register(cmd *flag.FlagSet) {
+	cmd.BoolVar(&f.Verbose, "verbose", false, "Print more: also log the prompts sent to Gemini.")
+	cmd.BoolVar(&f.Quiet, "quiet", false, "Print only the final output path and total cost. The log file still receives every line.")
+}
+
+// Apply validates the flags and sets the program's verbosity from them.
+func (f VerbosityFlags) Apply() error {
+	switch {
+	case f.Verbose && f.Quiet:
+		return fmt.Errorf("--verbose and --quiet cannot be used together")
+	case f.Verbose:
+		SetVerbosity(VerbosityVerbose)
+	case f.Quiet:
+		SetVerbosity(VerbosityQuiet)
+	default:
+		SetVerbosity(VerbosityNormal)
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+package story
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/export"
+)
+
+// anthologySeparator is written between the existing content of an --append-to file and a new story.
+const anthologySeparator = "\n\n* * *\n\n"
+
+// validateAppendTo checks that --append-to names a text or Markdown file, since an HTML document
+// cannot simply be extended at its end.
+func validateAppendTo(path string) error {
+	if path == "" {
+		return nil
+	}
+	if format := export.FormatFromPath(path); format == export.FormatHTML {
+		return fmt.Errorf("--append-to '%s': only .txt and .md files can be appended to", path)
+	}
+	return nil
+}
+
+// startAppend records where the story begins in the --append-to file. A new target starts at the
+// file's current end, past everything already in it, whose chapters are never read or counted. A
+// resumed story keeps the position saved in its status file.
+func startAppend(cfg FullStoryConfig, state *StoryProgressState) error {
+	if cfg.AppendTo == "" || cfg.AppendTo == state.AppendTo {
+		return nil
+	}
+	if state.AppendTo != "" {
+		log.Printf("Warning: The story was appended to '%s'; appending it to '%s' from now on. '%s' keeps the chapters already written to it.", state.AppendTo, cfg.AppendTo, state.AppendTo)
+	}
+	info, err := os.Stat(cfg.AppendTo)
+	switch {
+	case err == nil:
+		state.AppendOffset = info.Size()
+	case os.IsNotExist(err):
+		state.AppendOffset = 0
+	default:
+		return fmt.Errorf("failed to check --append-to file '%s': %w", cfg.AppendTo, err)
+	}
+	state.AppendTo = cfg.AppendTo
+	state.AppendLength = 0
+	log.Printf("Appending the story to '%s' after its existing %d bytes.", cfg.AppendTo, state.AppendOffset)
+	return nil
+}
+
+// appendStory writes the story so far to the --append-to file, replacing the copy written by the
+// previous save, so the file holds its original content, a separator, and then this story. It
+// refuses to touch the file if its size changed since the previous save, e.g. because another
+// story was appended after this one, rather than truncate someone else's text.
+func appendStory(state *StoryProgressState, sync bool) error {
+	rendered, err := renderStory(state.PreviousChapters, export.FormatFromPath(state.AppendTo), nil, state.layout.exportLayout(state.ChapterTitles))
+	if err != nil {
+		return fmt.Errorf("failed to render story for --append-to file: %w", err)
+	}
+	if state.AppendOffset > 0 {
+		rendered = append([]byte(anthologySeparator), rendered...)
+	}
+
+	f, err := os.OpenFile(state.AppendTo, os.O_RDWR|os.O_CREATE, file.FileMode())
+	if err != nil {
+		return fmt.Errorf("failed to open --append-to file '%s': %w", state.AppendTo, err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to check --append-to file '%s': %w", state.AppendTo, err)
+	}
+	if want := state.AppendOffset + state.AppendLength; info.Size() != want {
+		return fmt.Errorf("--append-to file '%s' is %d bytes, expected %d; it changed since the last save, so the story was not updated there", state.AppendTo, info.Size(), want)
+	}
+	if err := f.Truncate(state.AppendOffset); err != nil {
+		return fmt.Errorf("failed to update --append-to file '%s': %w", state.AppendTo, err)
+	}
+	if _, err := f.Seek(state.AppendOffset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to update --append-to file '%s': %w", state.AppendTo, err)
+	}
+	if _, err := f.Write(rendered); err != nil {
+		return fmt.Errorf("failed to update --append-to file '%s': %w", state.AppendTo, err)
+	}
+	if sync {
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("failed to sync --append-to file '%s': %w", state.AppendTo, err)
+		}
+	}
+	state.AppendLength = int64(len(rendered))
+	return nil
+}
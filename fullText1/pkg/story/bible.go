@@ -0,0 +1,139 @@
+package story
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/cli"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+)
+
+// bibleFileSuffix is appended to the story output path (minus its extension) to name the character bible.
+const bibleFileSuffix = ".bible.yaml"
+
+// parseBibleFlags parses and validates the flags for the 'story bible' subcommand.
+// It returns the story configuration and the path the bible will be written to.
+func parseBibleFlags(args []string) (FullStoryConfig, string, error) {
+	var cfg FullStoryConfig
+	var biblePath string
+	cmd := newSubcommandFlagSet("story bible")
+	cmd.StringVar(&cfg.ConfigPath, "config", "", "Path to Gemini configuration JSON file (optional). If not provided, API key is taken from GEMINI_API_KEY env var.")
+	cmd.StringVar(&cfg.APIKeyFile, "api-key-file", "", "Path to a file containing the Gemini API key, e.g. a mounted CI secret (optional). Takes precedence over the config file and the GEMINI_API_KEY and GEMINI_API_KEY_FILE env vars; surrounding whitespace is trimmed.")
+	cmd.StringVar(&cfg.AbstractFilePath, "abstract", "", "Path to the abstract file the story was generated from.")
+	cmd.StringVar(&cfg.OutputPath, "output", "", "Path to the full story file to extract the character bible from.")
+	cmd.StringVar(&biblePath, "bible-output", "", "Path to save the character bible (default: <output without extension>.bible.yaml).")
+	cmd.StringVar(&cfg.LogFormat, "log-format", logging.FormatText, "Log format for stderr and the log file: 'text' or 'json'.")
+	cmd.StringVar(&cfg.PricingFile, "pricing-file", "", "Path to a pricing JSON file overriding the built-in model prices (optional). Defaults to the GEMINI_PRICING_FILE env var.")
+	addCostFlags(cmd, &cfg.CostFormat)
+	addHTTPFlags(cmd, &cfg.HTTP)
+	addFileModeFlag(cmd, &cfg.FileMode)
+	addCacheFlag(cmd, &cfg.CacheDir)
+	cfg.Verbosity.Register(cmd)
+
+	if err := cmd.Parse(args); err != nil {
+		return cfg, "", fmt.Errorf("failed to parse story bible flags: %w", err)
+	}
+	if err := cfg.Verbosity.Apply(); err != nil {
+		return cfg, "", err
+	}
+
+	if cfg.AbstractFilePath == "" {
+		return cfg, "", fmt.Errorf("--abstract is required for story bible")
+	}
+	if cfg.OutputPath == "" {
+		return cfg, "", fmt.Errorf("--output is required for story bible and must point to the full story file")
+	}
+	if err := logging.ValidateFormat(cfg.LogFormat); err != nil {
+		return cfg, "", fmt.Errorf("invalid --log-format: %w", err)
+	}
+	if err := aiEndpoint.LoadPricingOverrides(cfg.PricingFile); err != nil {
+		return cfg, "", err
+	}
+	if err := aiEndpoint.SetCostFormat(cfg.CostFormat); err != nil {
+		return cfg, "", fmt.Errorf("invalid cost display flags: %w", err)
+	}
+	if err := aiEndpoint.ConfigureHTTPClient(cfg.HTTP); err != nil {
+		return cfg, "", fmt.Errorf("invalid HTTP client flags: %w", err)
+	}
+	if err := aiEndpoint.SetResponseCacheDir(cfg.CacheDir); err != nil {
+		return cfg, "", err
+	}
+	if err := applyFileMode(cfg.FileMode); err != nil {
+		return cfg, "", err
+	}
+	if biblePath == "" {
+		biblePath = sidecarFilePath(cfg.OutputPath, bibleFileSuffix)
+	}
+	return cfg, biblePath, nil
+}
+
+// buildBiblePrompt asks Gemini for a character bible in the schema of file.CharacterBible.
+func buildBiblePrompt(abstract, storyText string) string {
+	return fmt.Sprintf(`Read the following story abstract (plan) and the chapters written so far, and produce a "character bible" for every named character.
+For each character, record concrete details that must stay consistent (appearance such as eye and hair color, age, occupation, personality traits), their relationships to other characters, and their arc across the story.
+
+Return ONLY YAML in exactly this schema, with no explanation and no code fences:
+characters:
+  - name: <character name>
+    traits:
+      - <trait>
+    relationships:
+      - <relationship to another character>
+    arc: <one or two sentences describing the character's arc>
+
+--- Story Abstract ---
+%s
+--- End Story Abstract ---
+
+--- Story Text ---
+%s
+--- End Story Text ---
+`, abstract, storyText)
+}
+
+// executeBible implements 'story bible', which extracts a character bible from the abstract and the
+// chapters written so far and saves it as YAML for use with --bible.
+func executeBible(args []string) error {
+	cfg, biblePath, err := parseBibleFlags(args)
+	if err != nil {
+		return cli.UsageError(err)
+	}
+
+	defer startStoryLogging(&cfg)()
+
+	if err := loadGeminiAPIConfig(&cfg); err != nil {
+		return err
+	}
+
+	abstractData, err := readAbstract(cfg.AbstractFilePath, file.AbstractFormatAuto)
+	if err != nil {
+		return fmt.Errorf("failed to read and parse abstract file '%s': %w", cfg.AbstractFilePath, err)
+	}
+
+	storyText, err := os.ReadFile(cfg.OutputPath)
+	if err != nil {
+		return fmt.Errorf("failed to read story file '%s': %w", cfg.OutputPath, err)
+	}
+
+	log.Printf("Asking Gemini to extract a character bible from '%s'...", cfg.OutputPath)
+	apiResponse := aiEndpoint.CallGeminiAPI(newAPIInput(cfg, buildBiblePrompt(abstractData.Abstract, stripFrontMatter(string(storyText)))))
+	if apiResponse.Err != nil {
+		return fmt.Errorf("error generating character bible: %w", apiResponse.Err)
+	}
+
+	bible, err := file.ParseCharacterBible([]byte(apiResponse.GeneratedText))
+	if err != nil {
+		return fmt.Errorf("Gemini returned an unusable character bible: %w", err)
+	}
+	if err := file.WriteCharacterBible(biblePath, bible); err != nil {
+		return err
+	}
+
+	logging.Printf(logging.VerbosityQuiet, "Character bible with %d characters saved to: %s\n", len(bible.Characters), biblePath)
+	log.Printf("Character bible saved to: %s. Input tokens: %d, Output tokens: %d, Cost: %s", biblePath, apiResponse.InputTokens, apiResponse.OutputTokens, aiEndpoint.FormatCost(apiResponse.Cost))
+	logging.Printf(logging.VerbosityQuiet, "Total cost for character bible extraction: %s\n", aiEndpoint.FormatCost(apiResponse.Cost))
+	return nil
+}
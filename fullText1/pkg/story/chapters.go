@@ -0,0 +1,100 @@
+package story
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// chapterHeaderPattern matches the chapter header lines written by generateStoryChapters ("## Chapter N").
+var chapterHeaderPattern = regexp.MustCompile(`(?m)^## Chapter (\d+)[ \t]*$`)
+
+// storyChapter is a single chapter parsed from story text.
+type storyChapter struct {
+	Number int
+	Body   string
+}
+
+// storyHeaderEnd returns the offset just past the header separator line when content starts with
+// the header block written by storyHeader, or 0 otherwise. Chapter headers are only searched
+// after it, so "## Chapter N" lines inside an abstract pasted into the header are not mistaken
+// for chapters. The title line may carry a timestamp or not, and a header whose title line was
+// removed is still recognised by its abstract paragraph or separator.
+func storyHeaderEnd(content string) int {
+	if !strings.HasPrefix(content, storyTitlePrefix) && !strings.HasPrefix(content, storyAbstractLabel) {
+		if strings.HasPrefix(content, storyHeaderSeparator+"\n") {
+			return len(storyHeaderSeparator) + 1
+		}
+		return 0
+	}
+	idx := strings.Index(content, "\n"+storyHeaderSeparator+"\n")
+	if idx < 0 {
+		return 0
+	}
+	return idx + len(storyHeaderSeparator) + 2
+}
+
+// parseStoryText splits story text into the header block that precedes the first
+// chapter and the chapters themselves, in file order. This is a purely local parse,
+// so it costs nothing and works whether or not the header contains the abstract. A header line
+// that repeats the number of the chapter it appears in (e.g. the model echoing
+// "## Chapter 3" at the top of its text) is kept as part of that chapter's body.
+func parseStoryText(content string) (header string, chapters []storyChapter) {
+	start := storyHeaderEnd(content)
+	matches := chapterHeaderPattern.FindAllStringSubmatchIndex(content[start:], -1)
+	if len(matches) == 0 {
+		return content, nil
+	}
+	for _, m := range matches {
+		for j := range m {
+			m[j] += start
+		}
+	}
+
+	header = content[:matches[0][0]]
+	for i, m := range matches {
+		num, err := strconv.Atoi(content[m[2]:m[3]])
+		if err != nil {
+			continue
+		}
+		bodyEnd := len(content)
+		if i+1 < len(matches) {
+			bodyEnd = matches[i+1][0]
+		}
+		body := content[m[1]:bodyEnd]
+
+		if len(chapters) > 0 && chapters[len(chapters)-1].Number == num {
+			last := &chapters[len(chapters)-1]
+			last.Body += content[m[0]:m[1]] + body
+			continue
+		}
+		chapters = append(chapters, storyChapter{Number: num, Body: body})
+	}
+
+	for i := range chapters {
+		chapters[i].Body = strings.TrimSpace(chapters[i].Body)
+	}
+	return header, chapters
+}
+
+// hasChapter reports whether the story text already contains a "## Chapter N" header for number.
+func hasChapter(storyText string, number int) bool {
+	_, chapters := parseStoryText(storyText)
+	for _, c := range chapters {
+		if c.Number == number {
+			return true
+		}
+	}
+	return false
+}
+
+// highestChapterNumber returns the largest chapter number present in the chapters, or 0 if there are none.
+func highestChapterNumber(chapters []storyChapter) int {
+	highest := 0
+	for _, c := range chapters {
+		if c.Number > highest {
+			highest = c.Number
+		}
+	}
+	return highest
+}
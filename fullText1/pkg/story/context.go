@@ -0,0 +1,82 @@
+package story
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+)
+
+// trimmedChaptersText returns the chapters from index drop on in the standard "## Chapter N"
+// layout, preceded by a note telling the model which earlier chapters were left out.
+func trimmedChaptersText(chapters []storyChapter, drop int) string {
+	var b strings.Builder
+	if drop > 0 {
+		fmt.Fprintf(&b, "[Chapters %d-%d are omitted here to fit the model's context window; continue from the chapters below, consistent with the abstract.]\n\n",
+			chapters[0].Number, chapters[drop-1].Number)
+	}
+	for _, c := range chapters[drop:] {
+		fmt.Fprintf(&b, "## Chapter %d\n\n%s\n\n", c.Number, c.Body)
+	}
+	return b.String()
+}
+
+// promptTokens measures prompt with a (free) CountTokens call. When counting fails, the failure is
+// logged and the tokens are estimated from the prompt's length instead, so the limit still applies.
+func promptTokens(cfg FullStoryConfig, chapterNum int, prompt string) int {
+	tokens, err := aiEndpoint.CountInputTokens(newAPIInput(cfg, prompt))
+	if err != nil {
+		tokens = aiEndpoint.EstimateTokens(prompt)
+		cfg.Logger.Warn("context_count_failed", fmt.Sprintf("Failed to count the tokens of the Chapter %d prompt: %v. Checking --max-context-tokens against an estimate of %d tokens from its length.", chapterNum, err, tokens),
+			logging.Fields{"chapter": chapterNum, "estimated_tokens": tokens, "error": err.Error()})
+	}
+	return tokens
+}
+
+// fitPromptToContext keeps the chapter prompt under cfg.MaxContextTokens. The prompt is measured
+// with promptTokens; when it is over the limit, the oldest chapters are dropped from the story so
+// far, always keeping the abstract and at least the latest chapter, until it fits. The number of
+// chapters to drop is first estimated from the excess, so usually only one more count is needed.
+// The system instruction and any --history-turns turns are not part of the measured prompt.
+func fitPromptToContext(cfg FullStoryConfig, state *StoryProgressState, chapterNum, totalChapters, targetWords int, prompt string) (string, error) {
+	if cfg.MaxContextTokens <= 0 {
+		return prompt, nil
+	}
+	tokens := promptTokens(cfg, chapterNum, prompt)
+	if tokens <= cfg.MaxContextTokens {
+		return prompt, nil
+	}
+
+	_, chapters := parseStoryText(state.PreviousChapters)
+	fullTokens := tokens
+	excessChars := float64(tokens-cfg.MaxContextTokens) * float64(len(prompt)) / float64(tokens)
+	drop, droppedChars := 0, 0
+	for drop < len(chapters)-1 && (drop == 0 || float64(droppedChars) < excessChars) {
+		droppedChars += len(chapters[drop].Body)
+		drop++
+	}
+
+	trimmedPrompt := prompt
+	for ; drop > 0 && drop < len(chapters); drop++ {
+		trimmedState := *state
+		trimmedState.PreviousChapters = trimmedChaptersText(chapters, drop)
+		var err error
+		trimmedPrompt, err = buildChapterPrompt(cfg, &trimmedState, chapterNum, totalChapters, targetWords)
+		if err != nil {
+			return "", err
+		}
+		tokens = promptTokens(cfg, chapterNum, trimmedPrompt)
+		if tokens <= cfg.MaxContextTokens {
+			cfg.Logger.Warn("context_trimmed", fmt.Sprintf("Chapter %d prompt of %d tokens exceeds --max-context-tokens %d; left out the %d oldest chapters (Chapters %d-%d), leaving %d tokens.",
+				chapterNum, fullTokens, cfg.MaxContextTokens, drop, chapters[0].Number, chapters[drop-1].Number, tokens),
+				logging.Fields{"chapter": chapterNum, "prompt_tokens": fullTokens, "trimmed_tokens": tokens, "max_context_tokens": cfg.MaxContextTokens, "dropped_chapters": drop})
+			return trimmedPrompt, nil
+		}
+	}
+
+	cfg.Logger.Warn("context_over_limit", fmt.Sprintf("Chapter %d prompt is still %d tokens, over --max-context-tokens %d, with at most the latest chapter as context. Sending it anyway; consider --context-mode summary or --outline.",
+		chapterNum, tokens, cfg.MaxContextTokens),
+		logging.Fields{"chapter": chapterNum, "prompt_tokens": tokens, "max_context_tokens": cfg.MaxContextTokens})
+	return trimmedPrompt, nil
+}
@@ -0,0 +1,204 @@
+package story
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/cli"
+	"github.com/zicongmei/ai-story/fullText1/pkg/export"
+)
+
+// parseContinueFlags parses and validates the flags for the 'story continue' subcommand.
+// It returns the story configuration and the number of extra chapters to write.
+func parseContinueFlags(args []string) (FullStoryConfig, int, error) {
+	var cfg FullStoryConfig
+	var extraChapters int
+	cmd := newStoryFlagSet("story continue", &cfg)
+	cmd.StringVar(&cfg.AbstractFilePath, "abstract", "", "Path to the abstract file the story was generated from, or '-' to read it from stdin. Optional when the story file has a metadata block: the abstract file it records is read, or else the abstract in the story header.")
+	cmd.StringVar(&cfg.OutputPath, "output", "", "Path to the existing full story file to extend.")
+	cmd.IntVar(&extraChapters, "extra-chapters", 0, "Number of additional chapters to write after the last existing chapter.")
+
+	if err := cmd.Parse(args); err != nil {
+		return cfg, 0, fmt.Errorf("failed to parse story continue flags: %w", err)
+	}
+	if err := cfg.Verbosity.Apply(); err != nil {
+		return cfg, 0, err
+	}
+
+	if cfg.OutputPath == "" {
+		return cfg, 0, fmt.Errorf("--output is required for story continue and must point to an existing full story file")
+	}
+	if extraChapters <= 0 {
+		return cfg, 0, fmt.Errorf("--extra-chapters must be a positive number")
+	}
+	if err := checkGenerationFlags(cfg); err != nil {
+		return cfg, 0, err
+	}
+	cmd.Visit(func(f *flag.Flag) { cfg.wordsPerChapterSet = cfg.wordsPerChapterSet || f.Name == "words-per-chapter" })
+	// applyGenerationFlags resolves --output inside --output-dir only later, after the log is opened.
+	outputPath := resolveInOutputDir(cfg.OutputDir, cfg.OutputPath)
+	if _, err := os.Stat(outputPath); err != nil {
+		return cfg, 0, fmt.Errorf("cannot continue story '%s': %w", outputPath, err)
+	}
+	return cfg, extraChapters, nil
+}
+
+// loadStateForContinue loads the story state from the status file when it exists. Otherwise the
+// full text file itself becomes the context and the written chapters are counted locally from
+// its "## Chapter N" headers, or the headings of layout, which are converted back to the standard
+// layout first.
+func loadStateForContinue(statusFilePath, outputFilePath string, layout *storyLayout) (StoryProgressState, error) {
+	if _, err := os.Stat(statusFilePath); err == nil {
+		return initializeStoryState(statusFilePath, "")
+	}
+
+	if format := export.FormatFromPath(outputFilePath); format != export.FormatText {
+		return StoryProgressState{}, fmt.Errorf("status file '%s' not found; a %s story file cannot be read back, so continuing requires its status file", statusFilePath, format)
+	}
+
+	log.Printf("No status file found at '%s'. Reading chapters from '%s'.", statusFilePath, outputFilePath)
+	content, err := os.ReadFile(outputFilePath)
+	if err != nil {
+		return StoryProgressState{}, fmt.Errorf("failed to read story file '%s': %w", outputFilePath, err)
+	}
+
+	// Front matter written by --frontmatter is not story text; it is rewritten on the next save.
+	storyText := layout.canonicalStoryText(stripFrontMatter(string(content)))
+	_, chapters := parseStoryText(storyText)
+	state := StoryProgressState{
+		PreviousChapters:       strings.TrimRight(storyText, "\n") + "\n\n",
+		ChaptersAlreadyWritten: highestChapterNumber(chapters),
+		Usage:                  &aiEndpoint.CostTracker{},
+		Planning:               &aiEndpoint.CostTracker{},
+		Summaries:              &aiEndpoint.CostTracker{},
+		Reviews:                &aiEndpoint.CostTracker{},
+	}
+	state.FirstNewChapter = state.ChaptersAlreadyWritten + 1
+	return state, nil
+}
+
+// addExtensionNote records an extension in the story header, just above the header separator.
+// The note is dated only when timestamp is set.
+func addExtensionNote(storyText string, firstChapter, lastChapter int, timestamp bool) string {
+	note := fmt.Sprintf("Story Extension: Chapters %d-%d added, continuing the story beyond the original plan.\n\n", firstChapter, lastChapter)
+	if timestamp {
+		note = fmt.Sprintf("Story Extension: Chapters %d-%d added on %s, continuing the story beyond the original plan.\n\n",
+			firstChapter, lastChapter, time.Now().Format("2006-01-02 15:04:05"))
+	}
+
+	idx := strings.Index(storyText, storyHeaderSeparator)
+	if idx < 0 {
+		return note + storyText
+	}
+	return storyText[:idx] + note + storyText[idx:]
+}
+
+// executeContinue implements 'story continue', which appends --extra-chapters chapters to an
+// existing story, numbered after its last chapter, with the whole story as context.
+func executeContinue(args []string) error {
+	cfg, extraChapters, err := parseContinueFlags(args)
+	if err != nil {
+		return cli.UsageError(err)
+	}
+
+	defer startStoryLogging(&cfg)()
+	interrupt, stopInterrupts := handleInterrupts()
+	defer stopInterrupts()
+	cfg.interrupt = interrupt
+
+	if err := applyGenerationFlags(&cfg); err != nil {
+		return err
+	}
+	if err := loadGeminiAPIConfig(&cfg); err != nil {
+		return err
+	}
+
+	statusOutputPath := determineStatusFilePath(cfg.OutputPath)
+	layout, err := newStoryLayout(cfg.ChapterHeaderFormat, cfg.Separator)
+	if err != nil {
+		return err
+	}
+	state, err := loadStateForContinue(statusOutputPath, cfg.OutputPath, layout)
+	if err != nil {
+		return err
+	}
+
+	// The metadata block, when the story has one, stands in for --abstract and --words-per-chapter.
+	meta, metaErr := parseStoryMetadata(state.PreviousChapters)
+	if metaErr != nil && !errors.Is(metaErr, ErrNoStoryMetadata) {
+		log.Printf("Warning: %v", metaErr)
+	}
+	var abstractData file.AbstractOutput
+	if cfg.AbstractFilePath != "" {
+		abstractData, err = readAbstract(cfg.AbstractFilePath, cfg.AbstractFormat)
+		if err != nil {
+			return fmt.Errorf("failed to read and parse abstract file '%s': %w", cfg.AbstractFilePath, err)
+		}
+	} else {
+		if errors.Is(metaErr, ErrNoStoryMetadata) {
+			return cli.UsageError(fmt.Errorf("--abstract is required to continue a story without a metadata block"))
+		}
+		if abstractData, err = abstractFromMetadata(meta, state.PreviousChapters); err != nil {
+			return err
+		}
+		log.Printf("Using the abstract recorded in the story metadata of '%s'.", cfg.OutputPath)
+	}
+	if !cfg.wordsPerChapterSet && meta.WordsPerChapter > 0 {
+		cfg.WordsPerChapter = meta.WordsPerChapter
+	}
+	cfg.AbstractContent = abstractData.Abstract
+	resolveStylePrompt(&cfg, abstractData.StylePrompt)
+	resolveLanguage(&cfg, abstractData.Language)
+	resolveAppendPrompts(&cfg, &state)
+	resolveChapterTitles(&cfg, &state)
+	if err := resolveStoryLayout(cfg, &state); err != nil {
+		return err
+	}
+	if state.ChaptersAlreadyWritten == 0 {
+		return fmt.Errorf("no chapters found in '%s'; use the story command to generate the story first", cfg.OutputPath)
+	}
+
+	totalChapters := state.ChaptersAlreadyWritten + extraChapters
+	cfg.ExtensionAfterChapter = state.ChaptersAlreadyWritten
+	cfg.ExtensionLastChapter = totalChapters
+	if err := file.ValidateChapterPlan(cfg.ChapterPlan, totalChapters); err != nil {
+		return err
+	}
+
+	log.Printf("Extending story '%s' from Chapter %d to Chapter %d.", cfg.OutputPath, state.FirstNewChapter, totalChapters)
+	state.PreviousChapters = addExtensionNote(state.PreviousChapters, state.FirstNewChapter, totalChapters, !cfg.NoTimestampHeader)
+	if metaErr == nil {
+		meta.TotalChapters = totalChapters
+		if state.PreviousChapters, err = replaceStoryMetadata(state.PreviousChapters, meta); err != nil {
+			log.Printf("Warning: Failed to update the story metadata: %v", err)
+		}
+	}
+	if err := saveStateToFiles(&state, statusOutputPath, cfg.OutputPath, !cfg.NoSync, newFrontMatter(cfg, &state)); err != nil {
+		return fmt.Errorf("failed to save story state before extension: %w", err)
+	}
+
+	if err := generateStoryChapters(cfg, totalChapters, &state, statusOutputPath, cfg.OutputPath); err != nil {
+		appendStoryLedger(cfg, "story continue", newStoryResult(cfg, &state, cfg.OutputPath, statusOutputPath, totalChapters))
+		return err
+	}
+	// The review saves the status and story files again, so it runs before an inline table of contents is inserted.
+	reviewPath := reviewStory(cfg, &state, statusOutputPath, cfg.OutputPath)
+	if err := writeTOC(cfg, &state, cfg.OutputPath); err != nil {
+		return err
+	}
+
+	reportStoryCompletion(cfg, &state, cfg.OutputPath)
+	result := newStoryResult(cfg, &state, cfg.OutputPath, statusOutputPath, totalChapters)
+	appendStoryLedger(cfg, "story continue", result)
+	result.ReviewPath = reviewPath
+	result.WordCounts = newWordCountSummary(cfg, state.ChapterMetrics)
+	printStoryResult(result)
+	return nil
+}
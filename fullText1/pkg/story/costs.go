@@ -0,0 +1,93 @@
+package story
+
+import (
+	"fmt"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+)
+
+// usageTotals returns the totals of tracker for the status file, or nil when nothing was spent.
+func usageTotals(tracker *aiEndpoint.CostTracker) *file.UsageTotals {
+	inputTokens, outputTokens, cost := tracker.Summary()
+	if inputTokens == 0 && outputTokens == 0 && cost == 0 {
+		return nil
+	}
+	return &file.UsageTotals{InputTokens: inputTokens, OutputTokens: outputTokens, Cost: cost}
+}
+
+// addUsageTotals adds totals loaded from the status file to tracker. nil totals add nothing.
+func addUsageTotals(tracker *aiEndpoint.CostTracker, totals *file.UsageTotals) {
+	if totals != nil {
+		tracker.AddUsage(totals.InputTokens, totals.OutputTokens, totals.Cost)
+	}
+}
+
+// CostBreakdown splits a story's accumulated usage by the kind of Gemini call.
+type CostBreakdown struct {
+	Planning   file.UsageTotals // Chapter count calls made before generation, and the abstract with --from-instruction
+	Summaries  file.UsageTotals // Rolling summary updates in --context-mode summary and --include-chapter-summaries recaps
+	Review     file.UsageTotals // --review passes over the finished story
+	Generation file.UsageTotals // Chapter writing, including retries, continuations, expansions, and regenerations
+}
+
+// String formats the costs of b on one line, e.g. for "Cost breakdown: ...". The review is
+// listed only when one was paid for.
+func (b CostBreakdown) String() string {
+	s := fmt.Sprintf("planning %s, context summaries %s, chapter generation %s",
+		aiEndpoint.FormatCost(b.Planning.Cost), aiEndpoint.FormatCost(b.Summaries.Cost), aiEndpoint.FormatCost(b.Generation.Cost))
+	if b.Review != (file.UsageTotals{}) {
+		s += ", review " + aiEndpoint.FormatCost(b.Review.Cost)
+	}
+	return s
+}
+
+// newCostBreakdown splits state.Usage into planning, summary, review, and generation usage. Generation is
+// what remains of the total, so usage from status files written before the breakdown was recorded
+// counts as generation.
+func newCostBreakdown(state *StoryProgressState) CostBreakdown {
+	var b CostBreakdown
+	b.Planning.InputTokens, b.Planning.OutputTokens, b.Planning.Cost = state.Planning.Summary()
+	b.Summaries.InputTokens, b.Summaries.OutputTokens, b.Summaries.Cost = state.Summaries.Summary()
+	b.Review.InputTokens, b.Review.OutputTokens, b.Review.Cost = state.Reviews.Summary()
+	inputTokens, outputTokens, cost := state.Usage.Summary()
+	b.Generation = file.UsageTotals{
+		InputTokens:  inputTokens - b.Planning.InputTokens - b.Summaries.InputTokens - b.Review.InputTokens,
+		OutputTokens: outputTokens - b.Planning.OutputTokens - b.Summaries.OutputTokens - b.Review.OutputTokens,
+		Cost:         cost - b.Planning.Cost - b.Summaries.Cost - b.Review.Cost,
+	}
+	return b
+}
+
+// reportCostBreakdown logs how the story's accumulated cost splits between planning, context
+// summaries, the review, and chapter generation, with the average generation cost per chapter. Resuming reads
+// the status file or counts chapter headers locally, so it has no cost of its own.
+func reportCostBreakdown(cfg FullStoryConfig, state *StoryProgressState) {
+	b := newCostBreakdown(state)
+	perChapter := 0.0
+	if state.ChaptersAlreadyWritten > 0 {
+		perChapter = b.Generation.Cost / float64(state.ChaptersAlreadyWritten)
+	}
+	cfg.Logger.Info("cost_breakdown", fmt.Sprintf("Cost breakdown: planning %s (Input %d, Output %d tokens), context summaries %s (Input %d, Output %d tokens), review %s (Input %d, Output %d tokens), chapter generation %s (Input %d, Output %d tokens; %s per chapter over %d chapters). Resume detection is local and free.",
+		aiEndpoint.FormatCost(b.Planning.Cost), b.Planning.InputTokens, b.Planning.OutputTokens,
+		aiEndpoint.FormatCost(b.Summaries.Cost), b.Summaries.InputTokens, b.Summaries.OutputTokens,
+		aiEndpoint.FormatCost(b.Review.Cost), b.Review.InputTokens, b.Review.OutputTokens,
+		aiEndpoint.FormatCost(b.Generation.Cost), b.Generation.InputTokens, b.Generation.OutputTokens,
+		aiEndpoint.FormatCost(perChapter), state.ChaptersAlreadyWritten),
+		logging.Fields{
+			"planning_cost":               b.Planning.Cost,
+			"planning_input_tokens":       b.Planning.InputTokens,
+			"planning_output_tokens":      b.Planning.OutputTokens,
+			"summary_cost":                b.Summaries.Cost,
+			"summary_input_tokens":        b.Summaries.InputTokens,
+			"summary_output_tokens":       b.Summaries.OutputTokens,
+			"review_cost":                 b.Review.Cost,
+			"review_input_tokens":         b.Review.InputTokens,
+			"review_output_tokens":        b.Review.OutputTokens,
+			"generation_cost":             b.Generation.Cost,
+			"generation_input_tokens":     b.Generation.InputTokens,
+			"generation_output_tokens":    b.Generation.OutputTokens,
+			"generation_cost_per_chapter": perChapter,
+		})
+}
@@ -0,0 +1,90 @@
+package story
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+// frontMatterDelimiter opens and closes the YAML front matter block written with --frontmatter.
+const frontMatterDelimiter = "---"
+
+// frontMatter is the YAML block written above the story with --frontmatter, in the form static
+// site generators such as Jekyll and Hugo read. It replaces the abstract paragraph of the header.
+type frontMatter struct {
+	Title    string  `yaml:"title,omitempty"`
+	Chapters int     `yaml:"chapters"` // Chapters written so far
+	Model    string  `yaml:"model,omitempty"`
+	Cost     float64 `yaml:"cost"` // Accumulated USD cost
+	Abstract string  `yaml:"abstract,omitempty"`
+}
+
+// newFrontMatter returns the front matter for the story's current state, or nil when
+// --frontmatter is not set.
+func newFrontMatter(cfg FullStoryConfig, state *StoryProgressState) *frontMatter {
+	if !cfg.FrontMatter {
+		return nil
+	}
+	_, _, cost := state.Usage.Summary()
+	return &frontMatter{
+		Title:    storyTitle(cfg.AbstractContent),
+		Chapters: state.ChaptersAlreadyWritten,
+		Model:    cfg.ModelName,
+		Cost:     cost,
+		Abstract: strings.TrimSpace(cfg.AbstractContent),
+	}
+}
+
+// render returns the front matter block, delimiters included, followed by a blank line.
+func (fm *frontMatter) render() ([]byte, error) {
+	body, err := yaml.Marshal(fm)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal front matter: %w", err)
+	}
+	return []byte(frontMatterDelimiter + "\n" + string(body) + frontMatterDelimiter + "\n\n"), nil
+}
+
+// withoutAbstract removes the abstract paragraph written by storyHeader from header, since the
+// front matter already carries the abstract.
+func (fm *frontMatter) withoutAbstract(header string) string {
+	if fm.Abstract == "" {
+		return header
+	}
+	return strings.Replace(header, fmt.Sprintf("%s\n%s\n\n", storyAbstractLabel, fm.Abstract), "", 1)
+}
+
+// storyTitle takes the story title from the first non-empty line of the abstract, dropping
+// Markdown heading and emphasis markers and a leading "Title:" label. It returns "" when that
+// line is too long to be a title.
+func storyTitle(abstract string) string {
+	for _, line := range strings.Split(abstract, "\n") {
+		line = strings.Trim(strings.TrimSpace(line), "#*_ ")
+		if line == "" {
+			continue
+		}
+		if label, rest, ok := strings.Cut(line, ":"); ok && strings.EqualFold(strings.Trim(label, "*_ "), "title") {
+			line = strings.Trim(rest, "*_ ")
+		}
+		if utf8.RuneCountInString(line) > maxTitleLength {
+			return ""
+		}
+		return line
+	}
+	return ""
+}
+
+// stripFrontMatter removes a leading YAML front matter block from a story file's content so the
+// rest can be parsed as story text. Content without front matter is returned unchanged.
+func stripFrontMatter(content string) string {
+	if !strings.HasPrefix(content, frontMatterDelimiter+"\n") {
+		return content
+	}
+	rest := content[len(frontMatterDelimiter)+1:]
+	end := strings.Index(rest, "\n"+frontMatterDelimiter+"\n")
+	if end < 0 {
+		return content
+	}
+	return strings.TrimLeft(rest[end+len(frontMatterDelimiter)+2:], "\n")
+}
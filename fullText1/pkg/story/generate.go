@@ -0,0 +1,254 @@
+package story
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract"
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/cli"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+)
+
+// StoryResult is the outcome of GenerateStory.
+type StoryResult struct {
+	OutputPath    string                // The full story file
+	StatusPath    string                // The status file used to resume the run
+	TotalChapters int                   // Chapters in the finished story
+	InputTokens   int                   // Accumulated over every run of this story, including setup calls
+	OutputTokens  int                   // Accumulated over every run of this story
+	Cost          float64               // Accumulated USD cost over every run of this story
+	RunUsage      file.UsageTotals      // Tokens and cost of this call alone, without earlier runs of the story
+	Model         string                // Model the chapters were written with
+	Chapters      []file.ChapterMetrics // Per-chapter metrics, including chapters from earlier runs
+	Breakdown     CostBreakdown         // Accumulated usage split into planning, context summaries, review, and chapter generation
+	RunDuration   time.Duration         // Time spent generating chapters in this call
+	ReviewPath    string                // The --review report, or "" when no review was saved
+	AbstractPath  string                // The abstract saved from --from-instruction, or "" when it was not saved
+	WordCounts    WordCountSummary      // Chapter word counts against their targets and --word-tolerance
+}
+
+// newStoryResult collects the result of a run from its final state.
+func newStoryResult(cfg FullStoryConfig, state *StoryProgressState, outputPath, statusPath string, totalChapters int) StoryResult {
+	inputTokens, outputTokens, cost := state.Usage.Summary()
+	return StoryResult{
+		OutputPath:    outputPath,
+		StatusPath:    statusPath,
+		TotalChapters: totalChapters,
+		InputTokens:   inputTokens,
+		OutputTokens:  outputTokens,
+		Cost:          cost,
+		RunUsage: file.UsageTotals{
+			InputTokens:  inputTokens - state.StartUsage.InputTokens,
+			OutputTokens: outputTokens - state.StartUsage.OutputTokens,
+			Cost:         cost - state.StartUsage.Cost,
+		},
+		Model:       cfg.ModelName,
+		Chapters:    state.ChapterMetrics,
+		Breakdown:   newCostBreakdown(state),
+		RunDuration: state.RunDuration,
+	}
+}
+
+// checkStoryFlags checks the settings of a GenerateStory call, the shared generation flags included
+// (see checkGenerationFlags), without changing anything.
+func checkStoryFlags(cfg FullStoryConfig) error {
+	if cfg.AbstractFilePath == "" && cfg.AbstractContent == "" && cfg.FromInstruction == "" {
+		return fmt.Errorf("an abstract is required for story generation")
+	}
+	if cfg.FromInstruction != "" {
+		switch {
+		case cfg.AbstractFilePath != "" || cfg.AbstractContent != "":
+			return fmt.Errorf("--from-instruction cannot be used together with --abstract")
+		case strings.TrimSpace(cfg.FromInstruction) == "":
+			return fmt.Errorf("--from-instruction must not be empty")
+		case cfg.ResumeOnly:
+			return fmt.Errorf("--from-instruction starts a new story and cannot be used with --resume-only")
+		}
+	} else if cfg.SaveAbstractPath != "" {
+		return fmt.Errorf("--save-abstract requires --from-instruction")
+	}
+	if err := checkGenerationFlags(cfg); err != nil {
+		return err
+	}
+	if cfg.Overwrite && cfg.ResumeOnly {
+		return fmt.Errorf("--overwrite and --resume-only cannot be used together")
+	}
+	if cfg.ResumeFrom < 0 {
+		return fmt.Errorf("--resume-from must not be negative")
+	}
+	if cfg.ResumeFrom > 0 && (cfg.Overwrite || cfg.FromInstruction != "") {
+		return fmt.Errorf("--resume-from keeps the chapters before it, so it cannot be used with --overwrite or --from-instruction")
+	}
+	if cfg.SingleShotMaxChapters < 0 {
+		return fmt.Errorf("--single-shot-max-chapters must not be negative")
+	}
+	if cfg.PrintPromptOnly && (cfg.Overwrite || cfg.FromInstruction != "") {
+		return fmt.Errorf("--print-prompt-only changes nothing, so it cannot be used with --overwrite or --from-instruction")
+	}
+	return nil
+}
+
+// DefaultFullStoryConfig returns a FullStoryConfig holding the same defaults as the story command's flags.
+// Library callers start from it and set AbstractFilePath (or AbstractContent) and any other fields they need.
+func DefaultFullStoryConfig() FullStoryConfig {
+	var cfg FullStoryConfig
+	cmd := newStoryFlagSet("story", &cfg)
+	_ = cmd.Parse(nil) // Applies the flag defaults; parsing no arguments cannot fail.
+	return cfg
+}
+
+// GenerateStory generates a full story from an abstract, resuming from the status file next to the
+// output when one exists. It does no flag parsing, so it can be called from other Go programs:
+// start from DefaultFullStoryConfig, set AbstractFilePath, AbstractContent, or FromInstruction, and
+// either APIKey (with optional ModelName) or ConfigPath. When Logger is nil, events go to the standard log package.
+// Cancelling ctx stops generation before the next chapter and aborts in-flight API calls.
+// Invalid settings are reported as cli.ErrUsage errors. Mock, HTTP, and CacheDir apply to this
+// call only; PricingFile, CostFormat, and FileMode are process-wide while it runs and restored
+// when it returns, so concurrent calls should agree on them.
+func GenerateStory(ctx context.Context, cfg FullStoryConfig) (StoryResult, error) {
+	if err := checkStoryFlags(cfg); err != nil {
+		return StoryResult{}, cli.UsageError(err)
+	}
+	defer saveProcessSettings()()
+	if err := applyGenerationFlags(&cfg); err != nil {
+		return StoryResult{}, err
+	}
+	cfg.ctx = ctx
+	if cfg.Logger == nil {
+		cfg.Logger = logging.NewTextLogger()
+	}
+
+	// Load Gemini configuration unless the caller supplied the API key directly.
+	if cfg.APIKey == "" {
+		if err := loadGeminiAPIConfig(&cfg); err != nil {
+			return StoryResult{}, err
+		}
+	} else if cfg.ModelName == "" {
+		cfg.ModelName = aiEndpoint.DefaultGeminiModel
+	} else {
+		cfg.ModelName = aiEndpoint.CanonicalModelName(cfg.ModelName)
+	}
+
+	// Determine output paths
+	finalOutputPath := determineOutputFilePath(abstractNamePath(cfg), cfg.OutputPath, cfg.OutputDir)
+	statusOutputPath := determineStatusFilePath(finalOutputPath)
+	// Derived names go into the default output directory or --output-dir, which are created as
+	// needed; the directory of an explicit --output must exist unless --create-dirs is given.
+	outputDir := filepath.Dir(finalOutputPath)
+	createOutputDir := cfg.CreateDirs || cfg.OutputPath == "" || (cfg.OutputDir != "" && filepath.Clean(cfg.OutputDir) == outputDir)
+	if err := file.EnsureOutputDir(outputDir, createOutputDir); err != nil {
+		return StoryResult{}, err
+	}
+
+	// Checked before the chapter count call, so --resume-only fails without spending anything.
+	if err := prepareOutputForRun(cfg, finalOutputPath, statusOutputPath); err != nil {
+		return StoryResult{}, err
+	}
+
+	// Generate the abstract first with --from-instruction. Its chapter count is reused below.
+	var abstractResult abstract.AbstractStoryResult
+	if cfg.FromInstruction != "" {
+		if err := checkFromInstructionOutput(statusOutputPath); err != nil {
+			return StoryResult{}, err
+		}
+		var err error
+		abstractResult, err = generateAbstractFromInstruction(&cfg)
+		if err != nil {
+			return StoryResult{}, fmt.Errorf("failed to generate the abstract from --from-instruction (cost %s): %w", aiEndpoint.FormatCost(abstractResult.Cost), err)
+		}
+	}
+
+	// Read abstract and determine total chapters
+	totalChapters, initialInputTokens, initialOutputTokens, initialCost, err := readAbstractAndDetermineTotalChapters(&cfg)
+	if err != nil {
+		return StoryResult{}, err
+	}
+	if err := file.ValidateChapterPlan(cfg.ChapterPlan, totalChapters); err != nil {
+		return StoryResult{}, err
+	}
+	if cfg.ResumeFrom > totalChapters {
+		return StoryResult{}, cli.UsageError(fmt.Errorf("--resume-from %d is beyond the story's %d chapters", cfg.ResumeFrom, totalChapters))
+	}
+
+	// Initialize story state (resume logic based on status file)
+	headerAbstract := cfg.AbstractContent
+	if cfg.NoAbstractInHeader {
+		headerAbstract = ""
+	}
+	generated := ""
+	if !cfg.NoTimestampHeader {
+		generated = time.Now().Format(time.RFC3339)
+	}
+	meta := newStoryMetadata(cfg, totalChapters, generated)
+	state, err := initializeStoryState(statusOutputPath, storyHeader(headerAbstract, !cfg.NoTimestampHeader, &meta))
+	if err != nil {
+		return StoryResult{}, err
+	}
+	if cfg.ResumeFrom > 0 {
+		if err := truncateStoryForResume(&state, cfg.ResumeFrom); err != nil {
+			return StoryResult{}, cli.UsageError(err)
+		}
+	}
+	resolveAppendPrompts(&cfg, &state)
+	resolveChapterTitles(&cfg, &state)
+	if err := resolveStoryLayout(cfg, &state); err != nil {
+		return StoryResult{}, err
+	}
+	singleShot := useSingleShot(cfg, &state, totalChapters)
+	if cfg.PrintPromptOnly && singleShot {
+		prompt := buildSingleShotPrompt(cfg, totalChapters)
+		fmt.Printf("===== Single-shot prompt for all %d chapters (%d characters) =====\n%s\n\n", totalChapters, len(prompt), formatChapterPrompt(cfg, prompt))
+		return newStoryResult(cfg, &state, finalOutputPath, statusOutputPath, totalChapters), nil
+	}
+	if cfg.PrintPromptOnly {
+		if err := printChapterPrompts(cfg, &state, totalChapters); err != nil {
+			return StoryResult{}, err
+		}
+		return newStoryResult(cfg, &state, finalOutputPath, statusOutputPath, totalChapters), nil
+	}
+	if err := startAppend(cfg, &state); err != nil {
+		return StoryResult{}, err
+	}
+
+	// Add this run's setup cost (the chapter count call, if any) to the accumulator.
+	state.Usage.AddUsage(initialInputTokens, initialOutputTokens, initialCost)
+	state.Planning.AddUsage(initialInputTokens, initialOutputTokens, initialCost)
+	state.Usage.AddUsage(abstractResult.InputTokens, abstractResult.OutputTokens, abstractResult.Cost)
+	state.Planning.AddUsage(abstractResult.InputTokens, abstractResult.OutputTokens, abstractResult.Cost)
+
+	// If starting fresh (no chapters written), save initial state and file content immediately.
+	// A newly started --append-to is saved too, so its separator and header are written up front, and
+	// so is a story cut by --resume-from, so its files no longer hold the discarded chapters.
+	if state.ChaptersAlreadyWritten == 0 || (state.AppendTo != "" && state.AppendLength == 0) || cfg.ResumeFrom > 0 {
+		if err := saveStateToFiles(&state, statusOutputPath, finalOutputPath, !cfg.NoSync, newFrontMatter(cfg, &state)); err != nil {
+			return StoryResult{}, fmt.Errorf("failed to save initial story state: %w", err)
+		}
+	}
+
+	// Generate story chapter by chapter, after the chapters a single-shot call provided
+	if singleShot {
+		if err := writeStorySingleShot(cfg, totalChapters, &state, statusOutputPath, finalOutputPath); err != nil {
+			return newStoryResult(cfg, &state, finalOutputPath, statusOutputPath, totalChapters), err
+		}
+	}
+	if err := generateStoryChapters(cfg, totalChapters, &state, statusOutputPath, finalOutputPath); err != nil {
+		return newStoryResult(cfg, &state, finalOutputPath, statusOutputPath, totalChapters), err
+	}
+	// The review saves the status and story files again, so it runs before an inline table of contents is inserted.
+	reviewPath := reviewStory(cfg, &state, statusOutputPath, finalOutputPath)
+	if err := writeTOC(cfg, &state, finalOutputPath); err != nil {
+		return newStoryResult(cfg, &state, finalOutputPath, statusOutputPath, totalChapters), err
+	}
+
+	reportStoryCompletion(cfg, &state, finalOutputPath)
+	result := newStoryResult(cfg, &state, finalOutputPath, statusOutputPath, totalChapters)
+	result.ReviewPath = reviewPath
+	result.WordCounts = newWordCountSummary(cfg, state.ChapterMetrics)
+	result.AbstractPath = abstractResult.OutputPath
+	return result, nil
+}
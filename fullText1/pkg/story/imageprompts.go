@@ -0,0 +1,114 @@
+package story
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+	"gopkg.in/yaml.v3"
+)
+
+// imagePromptsFileSuffix is appended to the story file name, without its extension, to name the
+// --image-prompts file.
+const imagePromptsFileSuffix = ".imageprompts.yaml"
+
+// imagePromptMaxWords caps the length of a single --image-prompts prompt.
+const imagePromptMaxWords = 60
+
+// readImagePrompts loads the image prompts saved at path, keyed by chapter number. A missing file
+// holds no prompts.
+func readImagePrompts(path string) (map[int]string, error) {
+	prompts := make(map[int]string)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return prompts, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read image prompts file '%s': %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, &prompts); err != nil {
+		return nil, fmt.Errorf("failed to parse image prompts file '%s': %w", path, err)
+	}
+	if prompts == nil {
+		prompts = make(map[int]string)
+	}
+	return prompts, nil
+}
+
+// buildImagePromptPrompt asks for a text-to-image prompt of one key scene of a chapter. The prompt
+// is always written in English, which image generators follow best.
+func buildImagePromptPrompt(chapterNum int, body string) string {
+	return fmt.Sprintf(`Write a text-to-image prompt, of no more than %d words, for an illustration of the single most striking visual scene of the following story chapter.
+Describe the setting, the characters in it (their appearance and what they are doing), the lighting, and the mood, in concrete visual terms. Do not name the story or the chapter, and do not include dialogue.
+Return only the prompt, in English, on a single line.
+
+--- Chapter %d ---
+%s
+--- End Chapter %d ---`, imagePromptMaxWords, chapterNum, strings.TrimSpace(body), chapterNum)
+}
+
+// updateImagePrompts implements --image-prompts: it asks Gemini for a text-to-image prompt of a key
+// scene of every written chapter that has none yet and saves them to <output>.imageprompts.yaml,
+// keyed by chapter number, for an external image generator. Placeholders of failed chapters are
+// skipped. A prompt that fails is logged and left out, so it is retried after the next chapter.
+// The returned usage is for the caller to add to the story's totals.
+func updateImagePrompts(cfg FullStoryConfig, state *StoryProgressState, outputFilePath string) summaryUpdateResult {
+	var result summaryUpdateResult
+	path := sidecarFilePath(outputFilePath, imagePromptsFileSuffix)
+	prompts, err := readImagePrompts(path)
+	if err != nil {
+		cfg.Logger.Warn("image_prompt_failed", fmt.Sprintf("%v. Image prompts are not updated.", err), logging.Fields{"error": err.Error()})
+		return result
+	}
+
+	added := 0
+	_, chapters := parseStoryText(state.PreviousChapters)
+	for _, c := range chapters {
+		if _, ok := prompts[c.Number]; ok {
+			continue
+		}
+		if strings.HasPrefix(c.Body, "Error generating Chapter") || strings.Contains(c.Body, "was blocked by Gemini's safety filters") {
+			continue
+		}
+
+		apiInput := newAPIInput(cfg, buildImagePromptPrompt(c.Number, c.Body))
+		apiInput.SystemInstruction = "" // The style prompt is for story text, not for the image prompt.
+		apiResponse := aiEndpoint.CallGeminiAPI(apiInput)
+		result.InputTokens += apiResponse.InputTokens
+		result.OutputTokens += apiResponse.OutputTokens
+		result.Cost += apiResponse.Cost
+		imagePrompt := strings.Join(strings.Fields(apiResponse.GeneratedText), " ")
+		if apiResponse.Err != nil || imagePrompt == "" {
+			err := apiResponse.Err
+			if err == nil {
+				err = fmt.Errorf("empty image prompt returned")
+			}
+			cfg.Logger.Warn("image_prompt_failed", fmt.Sprintf("Failed to write the image prompt of Chapter %d: %v. It will be retried after the next chapter.", c.Number, err),
+				logging.Fields{"chapter": c.Number, "error": err.Error()})
+			continue
+		}
+
+		prompts[c.Number] = imagePrompt
+		added++
+		cfg.Logger.Info("image_prompt", fmt.Sprintf("Chapter %d image prompt: %s Input Tokens %d, Output Tokens %d, Cost: %s",
+			c.Number, imagePrompt, apiResponse.InputTokens, apiResponse.OutputTokens, aiEndpoint.FormatCost(apiResponse.Cost)),
+			logging.Fields{"chapter": c.Number, "image_prompt": imagePrompt, "input_tokens": apiResponse.InputTokens, "output_tokens": apiResponse.OutputTokens, "cost": apiResponse.Cost})
+	}
+	if added == 0 {
+		return result
+	}
+
+	data, err := yaml.Marshal(prompts)
+	if err == nil {
+		err = file.WriteFile(path, data, file.FileMode(), !cfg.NoSync)
+	}
+	if err != nil {
+		cfg.Logger.Warn("image_prompt_failed", fmt.Sprintf("Failed to save the image prompts to '%s': %v", path, err),
+			logging.Fields{"path": path, "error": err.Error()})
+	}
+	return result
+}
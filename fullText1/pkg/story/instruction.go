@@ -0,0 +1,83 @@
+package story
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract"
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+)
+
+// abstractNamePath returns the abstract path the story, status, and log file names are derived
+// from: --abstract, or --save-abstract when the abstract is generated from --from-instruction.
+func abstractNamePath(cfg FullStoryConfig) string {
+	if cfg.AbstractFilePath != "" {
+		return cfg.AbstractFilePath
+	}
+	return cfg.SaveAbstractPath
+}
+
+// checkFromInstructionOutput refuses to generate a new abstract into a story that is already in
+// progress: its chapters were written from a different plan, which a resumed run must reuse.
+func checkFromInstructionOutput(statusFilePath string) error {
+	_, err := os.Stat(statusFilePath)
+	if err == nil {
+		return fmt.Errorf("a story is already in progress (status file '%s'); resume it with --abstract and its saved abstract, or pass --overwrite to start over", statusFilePath)
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to check status file '%s': %w", statusFilePath, err)
+	}
+	return nil
+}
+
+// generateAbstractFromInstruction generates the abstract for --from-instruction in memory, saving
+// it to cfg.SaveAbstractPath when set, and stores it on cfg so the chapter count found during
+// abstract generation is reused instead of asking Gemini again. It returns the abstract result,
+// whose usage the caller adds to the story's planning cost.
+func generateAbstractFromInstruction(cfg *FullStoryConfig) (abstract.AbstractStoryResult, error) {
+	stylePrompt := cfg.StylePrompt
+	if stylePrompt == "" {
+		stylePrompt = cfg.configStylePrompt
+	}
+	log.Printf("Generating the story abstract from --from-instruction before writing chapters...")
+	result, err := abstract.GenerateAbstractStory(contextOrBackground(cfg.ctx), abstract.AbstractConfig{
+		APIKey:         cfg.APIKey,
+		ModelName:      cfg.ModelName,
+		ThinkingLevel:  cfg.ThinkingLevel,
+		Instruction:    cfg.FromInstruction,
+		Language:       cfg.Language,
+		NumChapters:    cfg.TotalChapters,
+		StylePrompt:    stylePrompt,
+		Seed:           cfg.Seed,
+		Temperature:    cfg.Temperature,
+		TopP:           cfg.TopP,
+		ThinkingBudget: cfg.ThinkingBudget,
+		OutputPath:     cfg.SaveAbstractPath,
+		OutputDir:      cfg.OutputDir,
+		CreateDirs:     cfg.CreateDirs,
+		SkipSave:       cfg.SaveAbstractPath == "",
+		Timeout:        cfg.Timeout,
+		Limiter:        cfg.Limiter,
+		Mock:           cfg.Mock,
+		HTTPClient:     cfg.httpClient,
+		CacheDir:       cfg.CacheDir,
+	})
+	if err != nil {
+		return result, err
+	}
+	cfg.generatedAbstract = &file.AbstractOutput{
+		Abstract:         result.Abstract,
+		ThoughtSignature: result.ThoughtSignature,
+		ChapterCount:     result.ChapterCount,
+		StylePrompt:      result.StylePrompt,
+		Language:         result.Language,
+	}
+	if result.OutputPath != "" {
+		log.Printf("Abstract saved to: %s", result.OutputPath)
+	}
+	log.Printf("Abstract phase complete. Input tokens: %d, Output tokens: %d, Cost: %s", result.InputTokens, result.OutputTokens, aiEndpoint.FormatCost(result.Cost))
+	return result, nil
+}
@@ -0,0 +1,66 @@
+package story
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync/atomic"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+)
+
+// ErrInterrupted is returned when generation stops at a chapter boundary after a SIGINT.
+var ErrInterrupted = errors.New("story generation interrupted")
+
+// interruptExitCode is the conventional exit status of a process killed by SIGINT.
+const interruptExitCode = 130
+
+// interruptState records whether a SIGINT asked generation to stop after the current chapter.
+type interruptState struct {
+	requested atomic.Bool
+	done      chan struct{} // Closed on the first SIGINT
+}
+
+// Requested reports whether a SIGINT was received. It is false for a nil *interruptState.
+func (s *interruptState) Requested() bool {
+	return s != nil && s.requested.Load()
+}
+
+// Done returns a channel closed on the first SIGINT, for waits that should end early. It is nil,
+// and so never ready, for a nil *interruptState.
+func (s *interruptState) Done() <-chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.done
+}
+
+// handleInterrupts installs a SIGINT handler for a generating command. The first SIGINT marks
+// the returned state, so the chapter loop finishes and saves the current chapter and then stops,
+// leaving the story and status files at a clean resume point. A second SIGINT exits immediately.
+// Call the returned function to restore the default SIGINT behaviour.
+func handleInterrupts() (*interruptState, func()) {
+	state := &interruptState{done: make(chan struct{})}
+	signals := make(chan os.Signal, 2)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt)
+	go func() {
+		for {
+			select {
+			case <-signals:
+				if state.requested.Swap(true) {
+					logging.Printf(logging.VerbosityQuiet, "\nSecond interrupt received; exiting immediately.\n")
+					os.Exit(interruptExitCode)
+				}
+				close(state.done)
+				logging.Printf(logging.VerbosityQuiet, "\nInterrupt received; finishing the current chapter, saving, and exiting. Press Ctrl+C again to exit immediately.\n")
+			case <-done:
+				return
+			}
+		}
+	}()
+	return state, func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
@@ -0,0 +1,135 @@
+package story
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+	"google.golang.org/genai"
+)
+
+// failingChapterConfig returns a config whose chapter calls fail at once, so the first one is retried.
+func failingChapterConfig(t *testing.T) FullStoryConfig {
+	t.Helper()
+	t.Setenv("TMPDIR", t.TempDir()) // Request and response dumps
+	return FullStoryConfig{ModelName: "gemini-2.5-flash", AbstractContent: "A plan.", WordsPerChapter: 100, NoProgress: true, Logger: logging.NewTextLogger()}
+}
+
+func TestChapterRetryStopsOnInterrupt(t *testing.T) {
+	cfg := failingChapterConfig(t)
+	// The SIGINT arrives while the first attempt is in flight, after the chapter loop checked for it.
+	cfg.interrupt = &interruptState{done: make(chan struct{})}
+	close(cfg.interrupt.done)
+
+	start := time.Now()
+	err := generateStoryChapters(cfg, 1, &StoryProgressState{FirstNewChapter: 1, Usage: &aiEndpoint.CostTracker{}}, "", "")
+	if !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("generateStoryChapters() error = %v, want %v", err, ErrInterrupted)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("generateStoryChapters() took %v, want the retry backoff cut short", elapsed)
+	}
+}
+
+func TestChapterRetryStopsOnCancel(t *testing.T) {
+	cfg := failingChapterConfig(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cfg.ctx = ctx
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	err := generateStoryChapters(cfg, 1, &StoryProgressState{FirstNewChapter: 1, Usage: &aiEndpoint.CostTracker{}}, "", "")
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("generateStoryChapters() error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("generateStoryChapters() took %v, want the retry backoff cut short", elapsed)
+	}
+}
+
+// chapterErrorClient is an aiEndpoint.GenaiClient that counts its chapter calls and answers each
+// with resp and err.
+type chapterErrorClient struct {
+	resp  *genai.GenerateContentResponse
+	err   error
+	calls int
+}
+
+// CountTokens reports a fixed prompt size.
+func (c *chapterErrorClient) CountTokens(ctx context.Context, model string, contents []*genai.Content, config *genai.CountTokensConfig) (*genai.CountTokensResponse, error) {
+	return &genai.CountTokensResponse{TotalTokens: 100}, nil
+}
+
+// GenerateContent returns the configured answer.
+func (c *chapterErrorClient) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	c.calls++
+	return c.resp, c.err
+}
+
+func TestChapterRetryStopsOnAuthError(t *testing.T) {
+	cfg := failingChapterConfig(t)
+	client := &chapterErrorClient{err: genai.APIError{Code: http.StatusUnauthorized, Message: "API key not valid"}}
+	cfg.client = client
+
+	err := generateStoryChapters(cfg, 2, &StoryProgressState{FirstNewChapter: 1, Usage: &aiEndpoint.CostTracker{}}, "", "")
+	if !aiEndpoint.IsAuthError(err) {
+		t.Fatalf("generateStoryChapters() error = %v, want the auth error", err)
+	}
+	if client.calls != 1 {
+		t.Errorf("generateStoryChapters() made %d calls, want 1 without retries or later chapters", client.calls)
+	}
+}
+
+func TestChapterRetrySkipsSafetyBlock(t *testing.T) {
+	cfg := failingChapterConfig(t)
+	client := &chapterErrorClient{resp: &genai.GenerateContentResponse{PromptFeedback: &genai.GenerateContentResponsePromptFeedback{BlockReason: genai.BlockedReasonSafety}}}
+	cfg.client = client
+	dir := t.TempDir()
+	outputPath := filepath.Join(dir, "story.txt")
+
+	statusPath := filepath.Join(dir, "story.status.json")
+	state, err := initializeStoryState(statusPath, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := generateStoryChapters(cfg, 1, &state, statusPath, outputPath); err != nil {
+		t.Fatalf("generateStoryChapters() error = %v", err)
+	}
+	if client.calls != 1 {
+		t.Errorf("generateStoryChapters() made %d calls, want 1 for a safety block", client.calls)
+	}
+	story, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(story), "blocked by Gemini's safety filters") {
+		t.Errorf("story = %q, want the safety block placeholder", story)
+	}
+}
+
+func TestChapterFallbackSkippedOnInterrupt(t *testing.T) {
+	cfg := failingChapterConfig(t)
+	cfg.FallbackModel = "gemini-2.5-pro"
+	// A safety block is not retried, so the fallback model would be next.
+	client := &chapterErrorClient{resp: &genai.GenerateContentResponse{PromptFeedback: &genai.GenerateContentResponsePromptFeedback{BlockReason: genai.BlockedReasonSafety}}}
+	cfg.client = client
+	// The SIGINT arrives while the attempt is in flight, after the chapter loop checked for it.
+	cfg.interrupt = &interruptState{done: make(chan struct{})}
+	close(cfg.interrupt.done)
+
+	err := generateStoryChapters(cfg, 1, &StoryProgressState{FirstNewChapter: 1, Usage: &aiEndpoint.CostTracker{}}, "", "")
+	if !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("generateStoryChapters() error = %v, want %v", err, ErrInterrupted)
+	}
+	if client.calls != 1 {
+		t.Errorf("generateStoryChapters() made %d calls, want no fallback call after the interrupt", client.calls)
+	}
+}
@@ -0,0 +1,98 @@
+package story
+
+import (
+	"strings"
+	"unicode"
+)
+
+// languageScripts maps lower-case language names to the Unicode scripts their text is written in.
+// Languages not listed here are not checked.
+var languageScripts = map[string][]*unicode.RangeTable{
+	"english":    {unicode.Latin},
+	"french":     {unicode.Latin},
+	"spanish":    {unicode.Latin},
+	"german":     {unicode.Latin},
+	"italian":    {unicode.Latin},
+	"portuguese": {unicode.Latin},
+	"dutch":      {unicode.Latin},
+	"polish":     {unicode.Latin},
+	"turkish":    {unicode.Latin},
+	"vietnamese": {unicode.Latin},
+	"indonesian": {unicode.Latin},
+	"chinese":    {unicode.Han},
+	"mandarin":   {unicode.Han},
+	"cantonese":  {unicode.Han},
+	"japanese":   {unicode.Han, unicode.Hiragana, unicode.Katakana},
+	"korean":     {unicode.Hangul, unicode.Han},
+	"russian":    {unicode.Cyrillic},
+	"ukrainian":  {unicode.Cyrillic},
+	"bulgarian":  {unicode.Cyrillic},
+	"greek":      {unicode.Greek},
+	"arabic":     {unicode.Arabic},
+	"persian":    {unicode.Arabic},
+	"hebrew":     {unicode.Hebrew},
+	"hindi":      {unicode.Devanagari},
+	"thai":       {unicode.Thai},
+}
+
+// scriptNames gives a readable name for each script used in languageScripts, for log messages.
+var scriptNames = map[*unicode.RangeTable]string{
+	unicode.Latin:      "Latin",
+	unicode.Han:        "Han",
+	unicode.Hiragana:   "Hiragana",
+	unicode.Katakana:   "Katakana",
+	unicode.Hangul:     "Hangul",
+	unicode.Cyrillic:   "Cyrillic",
+	unicode.Greek:      "Greek",
+	unicode.Arabic:     "Arabic",
+	unicode.Hebrew:     "Hebrew",
+	unicode.Devanagari: "Devanagari",
+	unicode.Thai:       "Thai",
+}
+
+// minExpectedScriptShare is the fraction of letters that must be in the requested language's
+// scripts before a chapter is accepted as written in that language.
+const minExpectedScriptShare = 0.5
+
+// checkChapterLanguage reports whether text appears to be written in the given language, judged by
+// the share of its letters that belong to the language's scripts. dominantScript names the script
+// most letters are in. Unknown languages and text without letters always pass.
+func checkChapterLanguage(text, language string) (ok bool, dominantScript string) {
+	expected, known := languageScripts[strings.ToLower(strings.TrimSpace(language))]
+	if !known {
+		return true, ""
+	}
+
+	counts := make(map[*unicode.RangeTable]int)
+	letters, matching := 0, 0
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, script := range expected {
+			if unicode.Is(script, r) {
+				matching++
+				break
+			}
+		}
+		for script := range scriptNames {
+			if unicode.Is(script, r) {
+				counts[script]++
+				break
+			}
+		}
+	}
+	if letters == 0 {
+		return true, ""
+	}
+
+	best := 0
+	for script, n := range counts {
+		if n > best {
+			best = n
+			dominantScript = scriptNames[script]
+		}
+	}
+	return float64(matching)/float64(letters) >= minExpectedScriptShare, dominantScript
+}
@@ -0,0 +1,194 @@
+package story
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/export"
+)
+
+// Placeholders rendered into a --chapter-header-format template to derive the pattern that reads
+// its headings back.
+const (
+	headingNumPlaceholder   = "\x00num\x00"
+	headingTitlePlaceholder = "\x00title\x00"
+)
+
+// ChapterHeadingData is the data passed to a --chapter-header-format template.
+type ChapterHeadingData struct {
+	Num   int    // Chapter number
+	Title string // Chapter title written by the model; may be empty
+}
+
+// storyLayout is the chapter heading format and header separator of the rendered story file. The
+// status file and the prompts always use the standard "## Chapter N" layout; storyLayout is
+// applied only when the story file is written, and undone when a story file is read back without
+// its status file.
+type storyLayout struct {
+	HeadingFormat string // --chapter-header-format; "" writes "## Chapter N"
+	Separator     string // --separator; "" writes storyHeaderSeparator
+
+	heading   *template.Template
+	pattern   *regexp.Regexp // Matches a rendered heading line; group 1 is the number, group 2 the title when usesTitle
+	usesTitle bool           // The heading carries the chapter title, which is then dropped from the body
+	noTitles  bool           // --no-chapter-titles: a chapter without a recorded title has none, so its first line is not taken as one
+}
+
+// newStoryLayout parses and checks a --chapter-header-format template and --separator. Both empty
+// is the standard layout. The heading must render to a single line that contains the chapter
+// number and can be read back, so that stories written with it can be continued.
+func newStoryLayout(headingFormat, separator string) (*storyLayout, error) {
+	layout := &storyLayout{HeadingFormat: headingFormat, Separator: separator}
+	if strings.ContainsAny(separator, "\r\n") {
+		return nil, fmt.Errorf("--separator must be a single line")
+	}
+	if headingFormat == "" {
+		return layout, nil
+	}
+
+	tmpl, err := template.New("chapter-header-format").Option("missingkey=error").Parse(headingFormat)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --chapter-header-format: %w", err)
+	}
+	var probe strings.Builder
+	if err := tmpl.Execute(&probe, struct{ Num, Title string }{headingNumPlaceholder, headingTitlePlaceholder}); err != nil {
+		return nil, fmt.Errorf("invalid --chapter-header-format: %w", err)
+	}
+	rendered := probe.String()
+	if strings.ContainsAny(rendered, "\r\n") {
+		return nil, fmt.Errorf("--chapter-header-format must render a single line")
+	}
+	if strings.Count(rendered, headingNumPlaceholder) != 1 || strings.Count(rendered, headingTitlePlaceholder) > 1 {
+		return nil, fmt.Errorf("--chapter-header-format must contain {{.Num}} exactly once and {{.Title}} at most once, unformatted")
+	}
+	layout.heading = tmpl
+	layout.usesTitle = strings.Contains(rendered, headingTitlePlaceholder)
+
+	pattern := regexp.QuoteMeta(strings.TrimSpace(rendered))
+	pattern = strings.Replace(pattern, regexp.QuoteMeta(headingNumPlaceholder), `(\d+)`, 1)
+	pattern = strings.Replace(pattern, regexp.QuoteMeta(headingTitlePlaceholder), `(.*?)`, 1)
+	layout.pattern = regexp.MustCompile(`(?m)^[ \t]*` + pattern + `[ \t]*$`)
+
+	heading, _ := layout.chapterHeading(7, "Sample Title\n\nText.", nil)
+	if m := layout.pattern.FindStringSubmatch(heading); m == nil || m[1] != "7" {
+		return nil, fmt.Errorf("--chapter-header-format '%s' renders headings that cannot be read back", headingFormat)
+	}
+	return layout, nil
+}
+
+// isStandard reports whether l writes the standard layout; a nil *storyLayout does.
+func (l *storyLayout) isStandard() bool {
+	return l == nil || (l.heading == nil && l.Separator == "")
+}
+
+// chapterHeading renders the heading of a chapter and returns the body to write under it. When the
+// heading carries the title and the body starts with that title line, the line is dropped so the
+// title is not written twice. titles holds the titles recorded during generation.
+func (l *storyLayout) chapterHeading(number int, body string, titles map[int]string) (heading, text string) {
+	title := titles[number]
+	if title == "" && !l.noTitles {
+		title = extractChapterTitle(body)
+	}
+	var b strings.Builder
+	if err := l.heading.Execute(&b, ChapterHeadingData{Num: number, Title: title}); err != nil {
+		// The template was executed successfully at startup, so this is not expected.
+		log.Printf("Warning: Failed to render the heading of Chapter %d: %v", number, err)
+		return fmt.Sprintf("## Chapter %d", number), body
+	}
+	text = body
+	if l.usesTitle && title != "" {
+		trimmed := strings.TrimLeft(body, " \t\r\n")
+		firstLine, rest, _ := strings.Cut(trimmed, "\n")
+		if extractChapterTitle(firstLine) == title {
+			text = rest
+		}
+	}
+	return b.String(), text
+}
+
+// exportLayout returns the export.Layout that writes l, using titles for {{.Title}}.
+func (l *storyLayout) exportLayout(titles map[int]string) export.Layout {
+	if l.isStandard() {
+		return export.Layout{}
+	}
+	layout := export.Layout{Separator: l.Separator}
+	if l.heading != nil {
+		layout.ChapterHeading = func(number int, body string) (string, string) {
+			return l.chapterHeading(number, body, titles)
+		}
+	}
+	return layout
+}
+
+// canonicalStoryText converts story text written in l back to the standard layout, so that
+// parseStoryText can read it: the separator line becomes storyHeaderSeparator again, and each
+// heading becomes "## Chapter N", followed by its title line when the heading carried one. Only
+// headings with increasing chapter numbers are converted, so a line in a chapter body that
+// happens to look like a heading stays text.
+func (l *storyLayout) canonicalStoryText(content string) string {
+	if l.isStandard() {
+		return content
+	}
+	if l.Separator != "" {
+		lines := strings.Split(content, "\n")
+		for i, line := range lines {
+			if strings.TrimSpace(line) == strings.TrimSpace(l.Separator) {
+				lines[i] = storyHeaderSeparator
+				break
+			}
+		}
+		content = strings.Join(lines, "\n")
+	}
+	if l.pattern == nil {
+		return content
+	}
+
+	start := storyHeaderEnd(content)
+	var b strings.Builder
+	b.WriteString(content[:start])
+	last, pos := 0, start
+	for _, m := range l.pattern.FindAllStringSubmatchIndex(content[start:], -1) {
+		number, err := strconv.Atoi(content[start+m[2] : start+m[3]])
+		if err != nil || number <= last {
+			continue
+		}
+		last = number
+		b.WriteString(content[pos : start+m[0]])
+		fmt.Fprintf(&b, "## Chapter %d", number)
+		if l.usesTitle {
+			if title := strings.TrimSpace(content[start+m[4] : start+m[5]]); title != "" {
+				fmt.Fprintf(&b, "\n\n%s", title)
+			}
+		}
+		pos = start + m[1]
+	}
+	b.WriteString(content[pos:])
+	return b.String()
+}
+
+// resolveStoryLayout picks the layout of the story file. Flags given on the command line replace
+// the layout saved in the status file, with a warning since earlier chapters are rewritten in the
+// new layout; otherwise the saved layout is reused so a resumed story keeps its format. The result
+// is stored in state to be persisted and applied on every save.
+func resolveStoryLayout(cfg FullStoryConfig, state *StoryProgressState) error {
+	headingFormat, separator := cfg.ChapterHeaderFormat, cfg.Separator
+	if headingFormat == "" && separator == "" {
+		headingFormat, separator = state.ChapterHeaderFormat, state.Separator
+		if headingFormat != "" || separator != "" {
+			log.Printf("Using the chapter header format and separator saved in the status file.")
+		}
+	} else if (state.ChapterHeaderFormat != "" || state.Separator != "") && (headingFormat != state.ChapterHeaderFormat || separator != state.Separator) {
+		log.Printf("Warning: --chapter-header-format and --separator replace the layout saved in the status file; the whole story file is rewritten in the new layout.")
+	}
+	layout, err := newStoryLayout(headingFormat, separator)
+	if err != nil {
+		return err
+	}
+	layout.noTitles = state.NoChapterTitles
+	state.ChapterHeaderFormat, state.Separator, state.layout = headingFormat, separator, layout
+	return nil
+}
@@ -0,0 +1,140 @@
+package story
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/cli"
+	"github.com/zicongmei/ai-story/fullText1/pkg/export"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+)
+
+// splitChapterFilePattern matches the per-chapter file names written by --split-dir.
+var splitChapterFilePattern = regexp.MustCompile(`^chapter-(\d+)\.md$`)
+
+// MergeChapters reads the chapter-NNN.md files in dir in numeric order and returns them combined
+// in the standard "## Chapter N" layout, without a header block. The chapters must be numbered
+// 1 to N with no gaps. A file's own "## Chapter N" line is optional, but when present it must
+// match the number in its file name.
+func MergeChapters(dir string) (string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read split directory '%s': %w", dir, err)
+	}
+
+	files := make(map[int]string)
+	var numbers []int
+	for _, entry := range entries {
+		match := splitChapterFilePattern.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+		num, err := strconv.Atoi(match[1])
+		if err != nil || num <= 0 {
+			return "", fmt.Errorf("invalid chapter file name '%s'", entry.Name())
+		}
+		if other, ok := files[num]; ok {
+			return "", fmt.Errorf("chapter %d appears in both '%s' and '%s'", num, other, entry.Name())
+		}
+		files[num] = entry.Name()
+		numbers = append(numbers, num)
+	}
+	if len(numbers) == 0 {
+		return "", fmt.Errorf("no chapter-NNN.md files found in '%s'", dir)
+	}
+	sort.Ints(numbers)
+
+	var missing []string
+	for want, i := 1, 0; want <= numbers[len(numbers)-1]; want++ {
+		if numbers[i] == want {
+			i++
+			continue
+		}
+		missing = append(missing, strconv.Itoa(want))
+	}
+	if len(missing) > 0 {
+		return "", fmt.Errorf("chapter numbering in '%s' has gaps: missing chapter(s) %s", dir, strings.Join(missing, ", "))
+	}
+
+	var merged strings.Builder
+	for _, num := range numbers {
+		path := filepath.Join(dir, files[num])
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read chapter file '%s': %w", path, err)
+		}
+
+		body := strings.TrimSpace(string(content))
+		if _, chapters := parseStoryText(string(content)); len(chapters) > 0 {
+			if len(chapters) > 1 || chapters[0].Number != num {
+				return "", fmt.Errorf("chapter file '%s' contains a header for a different chapter", path)
+			}
+			body = chapters[0].Body
+		}
+		fmt.Fprintf(&merged, "## Chapter %d\n\n%s\n\n", num, body)
+	}
+	return merged.String(), nil
+}
+
+// executeMerge implements 'story merge', which recombines the per-chapter files of --split-dir
+// (possibly hand-edited) into a single full story file with the standard header block.
+func executeMerge(args []string) error {
+	cmd := newSubcommandFlagSet("story merge")
+	splitDir := cmd.String("split-dir", "", "Directory holding the chapter-001.md, chapter-002.md, ... files to merge.")
+	outputPath := cmd.String("output", "", "Path of the merged story file (default: fulltext-yyyy-mm-dd-hh-mm-ss.txt). The extension selects the format: .txt, .md, or .html.")
+	abstractPath := cmd.String("abstract", "", "Path to the abstract file, whose plan is included in the header block (optional).")
+	var fileMode string
+	addFileModeFlag(cmd, &fileMode)
+	var noTimestamp bool
+	addTimestampHeaderFlag(cmd, &noTimestamp)
+	var verbosity logging.VerbosityFlags
+	verbosity.Register(cmd)
+	if err := cmd.Parse(args); err != nil {
+		return cli.UsageError(fmt.Errorf("failed to parse story merge flags: %w", err))
+	}
+	if err := verbosity.Apply(); err != nil {
+		return cli.UsageError(err)
+	}
+	if err := applyFileMode(fileMode); err != nil {
+		return err
+	}
+	if *splitDir == "" {
+		return cli.UsageError(fmt.Errorf("--split-dir is required for story merge"))
+	}
+
+	abstractContent := ""
+	if *abstractPath != "" {
+		abstractData, err := readAbstract(*abstractPath, file.AbstractFormatAuto)
+		if err != nil {
+			return fmt.Errorf("failed to read and parse abstract file '%s': %w", *abstractPath, err)
+		}
+		abstractContent = abstractData.Abstract
+	}
+
+	chapters, err := MergeChapters(*splitDir)
+	if err != nil {
+		return err
+	}
+
+	output := determineOutputFilePath("", *outputPath, "")
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory for '%s': %w", output, err)
+	}
+	rendered, err := renderStory(storyHeader(abstractContent, !noTimestamp, nil)+chapters, export.FormatFromPath(output), nil, export.Layout{})
+	if err != nil {
+		return fmt.Errorf("failed to render merged story: %w", err)
+	}
+	if err := file.WriteFile(output, rendered, file.FileMode(), true); err != nil {
+		return fmt.Errorf("failed to write merged story file: %w", err)
+	}
+
+	_, merged := parseStoryText(chapters)
+	logging.Printf(logging.VerbosityQuiet, "Merged %d chapters from '%s' into: %s\n", len(merged), *splitDir, output)
+	return nil
+}
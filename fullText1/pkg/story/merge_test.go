@@ -0,0 +1,93 @@
+package story
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeChapterFiles creates dir with the given files, keyed by file name.
+func writeChapterFiles(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestMergeChapters(t *testing.T) {
+	dir := writeChapterFiles(t, map[string]string{
+		// Unpadded numbers sort numerically, not by name: Chapter 10 comes last.
+		"chapter-10.md":  "## Chapter 10\n\nThe end.\n",
+		"chapter-1.md":   "## Chapter 1\n\nThe Storm\n\nRain fell.\n",
+		"chapter-002.md": "  Written by hand, without a header line.  \n",
+		"chapter-3.md":   "## Chapter 3\n\nThree.",
+		"chapter-4.md":   "Four.",
+		"chapter-5.md":   "Five.",
+		"chapter-6.md":   "Six.",
+		"chapter-7.md":   "Seven.",
+		"chapter-8.md":   "Eight.",
+		"chapter-9.md":   "Nine.",
+		"notes.md":       "Not a chapter.",
+		"chapter-x.md":   "Not a chapter either.",
+	})
+	got, err := MergeChapters(dir)
+	if err != nil {
+		t.Fatalf("MergeChapters() error = %v", err)
+	}
+	want := "## Chapter 1\n\nThe Storm\n\nRain fell.\n\n" +
+		"## Chapter 2\n\nWritten by hand, without a header line.\n\n" +
+		"## Chapter 3\n\nThree.\n\n" +
+		"## Chapter 4\n\nFour.\n\n" +
+		"## Chapter 5\n\nFive.\n\n" +
+		"## Chapter 6\n\nSix.\n\n" +
+		"## Chapter 7\n\nSeven.\n\n" +
+		"## Chapter 8\n\nEight.\n\n" +
+		"## Chapter 9\n\nNine.\n\n" +
+		"## Chapter 10\n\nThe end.\n\n"
+	if got != want {
+		t.Errorf("MergeChapters() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestMergeChaptersSplitRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	story := "## Chapter 1\n\nOne.\n\n## Chapter 2\n\nTwo.\n\n"
+	if _, err := backfillSplitChapters(dir, story, false); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := MergeChapters(dir); err != nil || got != story {
+		t.Errorf("MergeChapters() = %q, %v; want %q", got, err, story)
+	}
+}
+
+func TestMergeChaptersErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		wantErr string
+	}{
+		{"missing chapter", map[string]string{"chapter-001.md": "One.", "chapter-002.md": "Two.", "chapter-004.md": "Four."}, "missing chapter(s) 3"},
+		{"missing first chapters", map[string]string{"chapter-003.md": "Three."}, "missing chapter(s) 1, 2"},
+		{"duplicate chapter", map[string]string{"chapter-001.md": "One.", "chapter-1.md": "Also one."}, "chapter 1 appears in both"},
+		{"header of another chapter", map[string]string{"chapter-001.md": "## Chapter 2\n\nTwo."}, "contains a header for a different chapter"},
+		{"chapter zero", map[string]string{"chapter-000.md": "Zero."}, "invalid chapter file name 'chapter-000.md'"},
+		{"no chapter files", map[string]string{"notes.md": "Notes."}, "no chapter-NNN.md files found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := MergeChapters(writeChapterFiles(t, tt.files))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("MergeChapters() error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := MergeChapters(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("MergeChapters() of a missing directory error = nil")
+	}
+}
@@ -0,0 +1,177 @@
+package story
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"gopkg.in/yaml.v3"
+)
+
+// storyMetadataLabel starts the metadata block written in the header of a new story file.
+const storyMetadataLabel = "Story Metadata:"
+
+// storyMetadataIndent indents the YAML lines of the metadata block under its label.
+const storyMetadataIndent = "  "
+
+// ErrNoStoryMetadata is returned by ReadStoryMetadata for a story without a metadata block, such as
+// one written before the block existed.
+var ErrNoStoryMetadata = errors.New("the story has no metadata block")
+
+// StoryMetadata records how a story was generated. It is written as an indented YAML block in the
+// header of every new story file, so later commands such as 'story status' and 'story continue'
+// can work from the story file alone, without the abstract file or the original flags.
+type StoryMetadata struct {
+	Model           string `yaml:"model,omitempty"`
+	TotalChapters   int    `yaml:"total_chapters,omitempty"` // Planned chapters, raised by 'story continue'
+	WordsPerChapter int    `yaml:"words_per_chapter,omitempty"`
+	AbstractPath    string `yaml:"abstract_path,omitempty"` // Absolute path of the abstract file; empty for stdin or an unsaved abstract
+	Language        string `yaml:"language,omitempty"`
+	Generated       string `yaml:"generated,omitempty"` // Creation time in RFC 3339; left out with --no-timestamp-header
+}
+
+// newStoryMetadata returns the metadata of a new story generated with cfg.
+func newStoryMetadata(cfg FullStoryConfig, totalChapters int, generated string) StoryMetadata {
+	meta := StoryMetadata{
+		Model:           cfg.ModelName,
+		TotalChapters:   totalChapters,
+		WordsPerChapter: cfg.WordsPerChapter,
+		Language:        cfg.Language,
+		Generated:       generated,
+	}
+	if path := abstractNamePath(cfg); path != "" && path != stdinAbstractPath {
+		if abs, err := filepath.Abs(path); err == nil {
+			meta.AbstractPath = abs
+		}
+	}
+	return meta
+}
+
+// WriteStoryMetadata writes meta to w as the metadata block of a story header: the
+// "Story Metadata:" label, the fields as YAML indented under it, and a blank line.
+func WriteStoryMetadata(w io.Writer, meta StoryMetadata) error {
+	body, err := yaml.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("failed to marshal story metadata: %w", err)
+	}
+	var b strings.Builder
+	b.WriteString(storyMetadataLabel + "\n")
+	for _, line := range strings.Split(strings.TrimRight(string(body), "\n"), "\n") {
+		b.WriteString(storyMetadataIndent + line + "\n")
+	}
+	b.WriteString("\n")
+	_, err = io.WriteString(w, b.String())
+	return err
+}
+
+// ReadStoryMetadata reads the metadata block from the header of the story text in r, which may
+// start with --frontmatter front matter. It returns ErrNoStoryMetadata when there is no block.
+func ReadStoryMetadata(r io.Reader) (StoryMetadata, error) {
+	content, err := io.ReadAll(r)
+	if err != nil {
+		return StoryMetadata{}, fmt.Errorf("failed to read story: %w", err)
+	}
+	return parseStoryMetadata(stripFrontMatter(string(content)))
+}
+
+// storyMetadataBlock returns the metadata block of meta as text.
+func storyMetadataBlock(meta StoryMetadata) (string, error) {
+	var b strings.Builder
+	if err := WriteStoryMetadata(&b, meta); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// findStoryMetadata returns the start and end offsets of the metadata block in the header of
+// storyText, the blank line after it included, or ok false when there is none.
+func findStoryMetadata(storyText string) (start, end int, ok bool) {
+	headerEnd := storyHeaderEnd(storyText)
+	idx := strings.Index(storyText[:headerEnd], "\n"+storyMetadataLabel+"\n")
+	if idx < 0 {
+		return 0, 0, false
+	}
+	start = idx + 1
+	end = start + len(storyMetadataLabel) + 1
+	for end < headerEnd {
+		line, _, _ := strings.Cut(storyText[end:], "\n")
+		if !strings.HasPrefix(line, storyMetadataIndent) {
+			break
+		}
+		end += len(line) + 1
+	}
+	if strings.HasPrefix(storyText[end:], "\n") {
+		end++
+	}
+	return start, end, true
+}
+
+// parseStoryMetadata parses the metadata block in the header of storyText.
+func parseStoryMetadata(storyText string) (StoryMetadata, error) {
+	var meta StoryMetadata
+	start, end, ok := findStoryMetadata(storyText)
+	if !ok {
+		return meta, ErrNoStoryMetadata
+	}
+	lines := strings.Split(storyText[start+len(storyMetadataLabel)+1:end], "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimPrefix(line, storyMetadataIndent)
+	}
+	if err := yaml.Unmarshal([]byte(strings.Join(lines, "\n")), &meta); err != nil {
+		return meta, fmt.Errorf("failed to parse the story metadata block: %w", err)
+	}
+	return meta, nil
+}
+
+// replaceStoryMetadata returns storyText with its metadata block replaced by meta. Story text
+// without a block is returned unchanged.
+func replaceStoryMetadata(storyText string, meta StoryMetadata) (string, error) {
+	start, end, ok := findStoryMetadata(storyText)
+	if !ok {
+		return storyText, nil
+	}
+	block, err := storyMetadataBlock(meta)
+	if err != nil {
+		return storyText, err
+	}
+	return storyText[:start] + block + storyText[end:], nil
+}
+
+// headerAbstract returns the abstract paragraph written by storyHeader in the header of
+// storyText, without any extension notes added after it, or "" when the header has none.
+func headerAbstract(storyText string) string {
+	header := storyText[:storyHeaderEnd(storyText)]
+	idx := strings.Index(header, storyAbstractLabel+"\n")
+	if idx < 0 {
+		return ""
+	}
+	abstract := header[idx+len(storyAbstractLabel)+1:]
+	abstract = strings.TrimSuffix(strings.TrimRight(abstract, "\n"), storyHeaderSeparator)
+	if note := strings.Index(abstract, "\n\nStory Extension: "); note >= 0 {
+		abstract = abstract[:note]
+	}
+	return strings.TrimSpace(abstract)
+}
+
+// abstractFromMetadata returns the abstract of a story when no --abstract is given: the abstract
+// file recorded in meta when it can still be read, or else the abstract paragraph of the header.
+func abstractFromMetadata(meta StoryMetadata, storyText string) (file.AbstractOutput, error) {
+	if meta.AbstractPath != "" {
+		if _, err := os.Stat(meta.AbstractPath); err == nil {
+			abstractData, err := readAbstract(meta.AbstractPath, file.AbstractFormatAuto)
+			if abstractData.ChapterCount == 0 {
+				abstractData.ChapterCount = meta.TotalChapters
+			}
+			return abstractData, err
+		}
+	}
+	abstract := headerAbstract(storyText)
+	if abstract == "" {
+		return file.AbstractOutput{}, fmt.Errorf("the abstract file recorded in the story metadata ('%s') is missing and the story header has no abstract; pass --abstract", meta.AbstractPath)
+	}
+	return file.AbstractOutput{Abstract: abstract, ChapterCount: meta.TotalChapters, Language: meta.Language}, nil
+}
@@ -0,0 +1,51 @@
+package story
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// Kinds of file DeriveOutputName can name after an abstract.
+const (
+	OutputKindFullText = "fulltext" // The full story, e.g. fulltext-2025-01-02-03-04-05.txt
+	OutputKindLog      = "log"      // The story log, e.g. log-2025-01-02-03-04-05.log
+)
+
+// abstractNamePrefix starts the names the abstract command gives its files.
+const abstractNamePrefix = "abstract-"
+
+// derivedExtensions maps each output kind to the extension of the file derived for it.
+var derivedExtensions = map[string]string{
+	OutputKindFullText: ".txt",
+	OutputKindLog:      ".log",
+}
+
+// abstractExtensions are the abstract file extensions DeriveOutputName accepts, compared in lower case.
+// An empty extension accepts a bare name such as "abstract-2025-01-02".
+var abstractExtensions = map[string]bool{"": true, ".txt": true, ".json": true, ".yaml": true, ".yml": true}
+
+// DeriveOutputName returns the file name of the given kind (OutputKindFullText or OutputKindLog)
+// that belongs to an abstract following the abstract command's naming, e.g. "abstract-X.yaml"
+// gives "fulltext-X.txt" or "log-X.log". The prefix and extension are matched case-insensitively
+// and the rest of the name is kept as is. It returns "" when the name does not follow that pattern,
+// when the abstract is read from stdin, or for an unknown kind; callers then fall back to a
+// timestamped name. Only the base name of abstractPath is used.
+func DeriveOutputName(abstractPath, kind string) string {
+	newExt, ok := derivedExtensions[kind]
+	if !ok || abstractPath == "" || abstractPath == stdinAbstractPath {
+		return ""
+	}
+	base := filepath.Base(abstractPath)
+	if !strings.HasPrefix(strings.ToLower(base), abstractNamePrefix) {
+		return ""
+	}
+	ext := filepath.Ext(base)
+	if !abstractExtensions[strings.ToLower(ext)] {
+		return ""
+	}
+	stem := strings.TrimSuffix(base[len(abstractNamePrefix):], ext)
+	if stem == "" {
+		return ""
+	}
+	return kind + "-" + stem + newExt
+}
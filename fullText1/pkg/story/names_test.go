@@ -0,0 +1,41 @@
+package story
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestDeriveOutputName(t *testing.T) {
+	tests := []struct {
+		name     string
+		abstract string
+		kind     string
+		want     string
+	}{
+		{"txt", "abstract-2025-01-02-03-04-05.txt", OutputKindFullText, "fulltext-2025-01-02-03-04-05.txt"},
+		{"json", "abstract-2025-01-02-03-04-05.json", OutputKindFullText, "fulltext-2025-01-02-03-04-05.txt"},
+		{"yaml", "abstract-2025-01-02-03-04-05.yaml", OutputKindFullText, "fulltext-2025-01-02-03-04-05.txt"},
+		{"yml", "abstract-2025-01-02-03-04-05.yml", OutputKindFullText, "fulltext-2025-01-02-03-04-05.txt"},
+		{"log kind", "abstract-2025-01-02-03-04-05.yaml", OutputKindLog, "log-2025-01-02-03-04-05.log"},
+		{"uppercase extension", "abstract-dragons.YAML", OutputKindFullText, "fulltext-dragons.txt"},
+		{"uppercase prefix", "Abstract-dragons.yml", OutputKindLog, "log-dragons.log"},
+		{"no extension", "abstract-dragons", OutputKindFullText, "fulltext-dragons.txt"},
+		{"nested directory", filepath.Join("runs", "2025", "abstract-dragons.yaml"), OutputKindFullText, "fulltext-dragons.txt"},
+		{"extension already the derived one", "abstract-dragons.txt", OutputKindFullText, "fulltext-dragons.txt"},
+		{"stem keeps inner dots", "abstract-v1.2.yaml", OutputKindFullText, "fulltext-v1.2.txt"},
+		{"derived name is not an abstract", "fulltext-dragons.txt", OutputKindFullText, ""},
+		{"no abstract prefix", "dragons.yaml", OutputKindFullText, ""},
+		{"unknown extension", "abstract-dragons.md", OutputKindFullText, ""},
+		{"prefix only", "abstract-.yaml", OutputKindFullText, ""},
+		{"stdin", stdinAbstractPath, OutputKindFullText, ""},
+		{"empty path", "", OutputKindFullText, ""},
+		{"unknown kind", "abstract-dragons.yaml", "status", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := DeriveOutputName(tt.abstract, tt.kind); got != tt.want {
+				t.Errorf("DeriveOutputName(%q, %q) = %q, want %q", tt.abstract, tt.kind, got, tt.want)
+			}
+		})
+	}
+}
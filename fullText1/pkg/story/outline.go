@@ -0,0 +1,146 @@
+package story
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/cli"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+)
+
+// outlineFileSuffix replaces the abstract file's extension to name the story outline.
+const outlineFileSuffix = ".outline.yaml"
+
+// parseOutlineFlags parses and validates the flags for the 'story outline' subcommand.
+// It returns the story configuration and the path the outline will be written to.
+func parseOutlineFlags(args []string) (FullStoryConfig, string, error) {
+	var cfg FullStoryConfig
+	var outlinePath string
+	cmd := newSubcommandFlagSet("story outline")
+	cmd.StringVar(&cfg.ConfigPath, "config", "", "Path to Gemini configuration JSON file (optional). If not provided, API key is taken from GEMINI_API_KEY env var.")
+	cmd.StringVar(&cfg.APIKeyFile, "api-key-file", "", "Path to a file containing the Gemini API key, e.g. a mounted CI secret (optional). Takes precedence over the config file and the GEMINI_API_KEY and GEMINI_API_KEY_FILE env vars; surrounding whitespace is trimmed.")
+	cmd.StringVar(&cfg.AbstractFilePath, "abstract", "", "Path to the abstract file to expand into a per-chapter outline.")
+	cmd.StringVar(&outlinePath, "outline-output", "", "Path to save the outline (default: <abstract without extension>.outline.yaml).")
+	cmd.IntVar(&cfg.TotalChapters, "total-chapters", 0, "Number of chapters to outline (optional). Defaults to the chapter count stored in the abstract file, then asks Gemini.")
+	cmd.StringVar(&cfg.Language, "language", "", "Language the outline is written in (optional). Defaults to the language saved in the abstract file.")
+	cmd.StringVar(&cfg.LogFormat, "log-format", logging.FormatText, "Log format for stderr and the log file: 'text' or 'json'.")
+	cmd.StringVar(&cfg.PricingFile, "pricing-file", "", "Path to a pricing JSON file overriding the built-in model prices (optional). Defaults to the GEMINI_PRICING_FILE env var.")
+	addCostFlags(cmd, &cfg.CostFormat)
+	addHTTPFlags(cmd, &cfg.HTTP)
+	addFileModeFlag(cmd, &cfg.FileMode)
+	addCacheFlag(cmd, &cfg.CacheDir)
+	cfg.Verbosity.Register(cmd)
+
+	if err := cmd.Parse(args); err != nil {
+		return cfg, "", fmt.Errorf("failed to parse story outline flags: %w", err)
+	}
+	if err := cfg.Verbosity.Apply(); err != nil {
+		return cfg, "", err
+	}
+
+	if cfg.AbstractFilePath == "" {
+		return cfg, "", fmt.Errorf("--abstract is required for story outline")
+	}
+	if cfg.AbstractFilePath == stdinAbstractPath && outlinePath == "" {
+		return cfg, "", fmt.Errorf("--outline-output is required when the abstract is read from stdin")
+	}
+	if cfg.TotalChapters < 0 {
+		return cfg, "", fmt.Errorf("--total-chapters must not be negative")
+	}
+	if err := logging.ValidateFormat(cfg.LogFormat); err != nil {
+		return cfg, "", fmt.Errorf("invalid --log-format: %w", err)
+	}
+	if err := aiEndpoint.LoadPricingOverrides(cfg.PricingFile); err != nil {
+		return cfg, "", err
+	}
+	if err := aiEndpoint.SetCostFormat(cfg.CostFormat); err != nil {
+		return cfg, "", fmt.Errorf("invalid cost display flags: %w", err)
+	}
+	if err := aiEndpoint.ConfigureHTTPClient(cfg.HTTP); err != nil {
+		return cfg, "", fmt.Errorf("invalid HTTP client flags: %w", err)
+	}
+	if err := aiEndpoint.SetResponseCacheDir(cfg.CacheDir); err != nil {
+		return cfg, "", err
+	}
+	if err := applyFileMode(cfg.FileMode); err != nil {
+		return cfg, "", err
+	}
+	if outlinePath == "" {
+		outlinePath = sidecarFilePath(cfg.AbstractFilePath, outlineFileSuffix)
+	}
+	return cfg, outlinePath, nil
+}
+
+// buildOutlinePrompt asks Gemini for a per-chapter outline in the schema of file.StoryOutline.
+func buildOutlinePrompt(abstract string, totalChapters int, language string) string {
+	languageRule := ""
+	if language != "" {
+		languageRule = fmt.Sprintf("\nWrite the titles and beats in %s.", language)
+	}
+	return fmt.Sprintf(`Expand the following story abstract (plan) into a detailed outline of exactly %d chapters.
+For each chapter, write one paragraph of beats: the events, in order, that the chapter must cover, which characters appear, and how the chapter ends. Keep the chapters consistent with each other and with the abstract.%s
+
+Return ONLY YAML in exactly this schema, with no explanation and no code fences:
+chapters:
+  - chapter: 1
+    title: <short chapter title>
+    beats: <one paragraph of beats>
+
+--- Story Abstract ---
+%s
+--- End Story Abstract ---
+`, totalChapters, languageRule, abstract)
+}
+
+// executeOutline implements 'story outline', which expands the abstract into one paragraph of beats
+// per chapter and saves it as YAML for use with --outline.
+func executeOutline(args []string) error {
+	cfg, outlinePath, err := parseOutlineFlags(args)
+	if err != nil {
+		return cli.UsageError(err)
+	}
+
+	defer startStoryLogging(&cfg)()
+
+	if err := loadGeminiAPIConfig(&cfg); err != nil {
+		return err
+	}
+
+	totalChapters, countInputTokens, countOutputTokens, countCost, err := readAbstractAndDetermineTotalChapters(&cfg)
+	if err != nil {
+		return err
+	}
+	usage := &aiEndpoint.CostTracker{}
+	usage.AddUsage(countInputTokens, countOutputTokens, countCost)
+
+	log.Printf("Asking Gemini to outline %d chapters of '%s'...", totalChapters, cfg.AbstractFilePath)
+	apiResponse := aiEndpoint.CallGeminiAPI(newAPIInput(cfg, buildOutlinePrompt(cfg.AbstractContent, totalChapters, cfg.Language)))
+	usage.Add(apiResponse)
+	if apiResponse.Err != nil {
+		return fmt.Errorf("error generating story outline: %w", apiResponse.Err)
+	}
+
+	outline, err := file.ParseStoryOutline([]byte(apiResponse.GeneratedText))
+	if err != nil {
+		return fmt.Errorf("Gemini returned an unusable story outline: %w", err)
+	}
+	for chapterNum := 1; chapterNum <= totalChapters; chapterNum++ {
+		if _, ok := outline.Chapter(chapterNum); !ok {
+			log.Printf("Warning: The outline has no beats for Chapter %d; that chapter will be written from the full abstract.", chapterNum)
+		}
+	}
+	if len(outline.Chapters) != totalChapters {
+		log.Printf("Warning: The outline has %d chapters, but the abstract plans %d.", len(outline.Chapters), totalChapters)
+	}
+	if err := file.WriteStoryOutline(outlinePath, outline); err != nil {
+		return err
+	}
+
+	inputTokens, outputTokens, cost := usage.Summary()
+	logging.Printf(logging.VerbosityQuiet, "Story outline with %d chapters saved to: %s\n", len(outline.Chapters), outlinePath)
+	log.Printf("Story outline saved to: %s. Input tokens: %d, Output tokens: %d, Cost: %s", outlinePath, inputTokens, outputTokens, aiEndpoint.FormatCost(cost))
+	logging.Printf(logging.VerbosityQuiet, "Total cost for story outline: %s\n", aiEndpoint.FormatCost(cost))
+	return nil
+}
@@ -0,0 +1,46 @@
+package story
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+)
+
+// formatChapterPrompt lays out the system instruction (the style prompt), when there is one, and
+// the fully assembled prompt of a chapter for --print-prompt and --print-prompt-only.
+func formatChapterPrompt(cfg FullStoryConfig, prompt string) string {
+	var b strings.Builder
+	if cfg.StylePrompt != "" {
+		fmt.Fprintf(&b, "--- System Instruction ---\n%s\n--- End System Instruction ---\n\n", cfg.StylePrompt)
+	}
+	b.WriteString(prompt)
+	return b.String()
+}
+
+// logChapterPrompt logs the prompt about to be sent for a chapter, for --print-prompt.
+func logChapterPrompt(cfg FullStoryConfig, chapterNum int, prompt string) {
+	cfg.Logger.Info("chapter_prompt", fmt.Sprintf("Prompt for Chapter %d (%d characters):\n%s", chapterNum, len(prompt), formatChapterPrompt(cfg, prompt)),
+		logging.Fields{"chapter": chapterNum, "system_instruction": cfg.StylePrompt, "prompt": prompt})
+}
+
+// printChapterPrompts implements --print-prompt-only: it prints the prompt of every chapter still to
+// be written to stdout, without calling the API or writing the story. Chapters are not generated, so
+// each prompt carries the story as it is now; the prompts of later chapters will also contain the
+// chapters written before them.
+func printChapterPrompts(cfg FullStoryConfig, state *StoryProgressState, totalChapters int) error {
+	printed := 0
+	for chapterNum := state.FirstNewChapter; chapterNum <= totalChapters; chapterNum++ {
+		if hasChapter(state.PreviousChapters, chapterNum) {
+			continue
+		}
+		prompt, err := buildChapterPrompt(cfg, state, chapterNum, totalChapters, targetWordsForChapter(cfg, chapterNum))
+		if err != nil {
+			return err
+		}
+		fmt.Printf("===== Chapter %d of %d: prompt (%d characters) =====\n%s\n\n", chapterNum, totalChapters, len(prompt), formatChapterPrompt(cfg, prompt))
+		printed++
+	}
+	logging.Printf(logging.VerbosityNormal, "Printed %d chapter prompt(s) without calling the API; nothing was written.\n", printed)
+	return nil
+}
@@ -0,0 +1,146 @@
+package story
+
+import (
+	_ "embed"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// defaultChapterPromptTemplate is the chapter prompt used when --prompt-template is not given.
+//
+//go:embed templates/chapter_prompt.tmpl
+var defaultChapterPromptTemplate string
+
+// ChapterPromptData is the data passed to the chapter prompt template. A custom --prompt-template
+// may reference any of these fields, e.g. {{.ChapterNum}} or {{.Abstract}}.
+type ChapterPromptData struct {
+	ChapterNum            int    // Number of the chapter being written
+	TotalChapters         int    // Total number of chapters in the story
+	WordsPerChapter       int    // Target word count for this chapter (from --chapter-plan or --words-per-chapter)
+	Abstract              string // The full story abstract (plan)
+	ChapterTitle          string // Planned title of this chapter from --outline; may be empty
+	ChapterBeats          string // This chapter's beats from --outline; empty when there is no outline entry
+	NoChapterTitle        bool   // Set by --no-chapter-titles: the chapter must start with its text, without a title
+	PreviousChapters      string // Story text written so far, including the header with the abstract; only the latest chapters when StorySummary is set
+	StorySummary          string // Rolling summary of the earlier chapters in --context-mode summary; empty otherwise
+	ChapterRecaps         string // "- Chapter N: recap" lines for the earlier chapters with --include-chapter-summaries; empty otherwise
+	CharacterBible        string // Character bible YAML from --bible; empty when not set
+	Language              string // Language the chapter must be written in; empty when not specified
+	ExtensionAfterChapter int    // Last chapter of the original plan when this chapter extends a finished story; 0 otherwise
+	ExtensionLastChapter  int    // Final chapter of the extension; 0 unless ExtensionAfterChapter is set
+}
+
+// loadChapterPromptTemplate parses the chapter prompt template from path, or the embedded default when
+// path is empty. The template is executed once against sample data so that references to unknown
+// fields fail at startup rather than in the middle of a run.
+func loadChapterPromptTemplate(path string) (*template.Template, error) {
+	text := defaultChapterPromptTemplate
+	name := "chapter_prompt.tmpl"
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read --prompt-template '%s': %w", path, err)
+		}
+		text = string(data)
+		name = path
+	}
+
+	tmpl, err := template.New(name).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse prompt template '%s': %w", name, err)
+	}
+
+	sample := ChapterPromptData{
+		ChapterNum:            1,
+		TotalChapters:         1,
+		WordsPerChapter:       1,
+		Abstract:              "abstract",
+		ChapterTitle:          "title",
+		ChapterBeats:          "beats",
+		NoChapterTitle:        true,
+		PreviousChapters:      "previous chapters",
+		StorySummary:          "summary",
+		ChapterRecaps:         "recaps",
+		CharacterBible:        "bible",
+		Language:              "english",
+		ExtensionAfterChapter: 1,
+		ExtensionLastChapter:  2,
+	}
+	if err := tmpl.Execute(&strings.Builder{}, sample); err != nil {
+		return nil, fmt.Errorf("invalid prompt template '%s': %w", name, err)
+	}
+	return tmpl, nil
+}
+
+// buildChapterPrompt assembles the prompt used to generate a single chapter from the configured template.
+func buildChapterPrompt(cfg FullStoryConfig, state *StoryProgressState, chapterNum, totalChapters, targetWords int) (string, error) {
+	tmpl := cfg.promptTemplate
+	if tmpl == nil {
+		var err error
+		if tmpl, err = loadChapterPromptTemplate(""); err != nil {
+			return "", err
+		}
+	}
+
+	data := ChapterPromptData{
+		ChapterNum:       chapterNum,
+		TotalChapters:    totalChapters,
+		WordsPerChapter:  targetWords,
+		Abstract:         cfg.AbstractContent,
+		PreviousChapters: state.PreviousChapters,
+		CharacterBible:   strings.TrimSpace(cfg.BibleText),
+		Language:         cfg.Language,
+		NoChapterTitle:   cfg.NoChapterTitles,
+	}
+	if outlined, ok := cfg.Outline.Chapter(chapterNum); ok {
+		if !cfg.NoChapterTitles {
+			data.ChapterTitle = strings.TrimSpace(outlined.Title)
+		}
+		data.ChapterBeats = strings.TrimSpace(outlined.Beats)
+	} else if len(cfg.Outline.Chapters) > 0 {
+		log.Printf("Warning: The outline has no beats for Chapter %d; sending the full abstract instead.", chapterNum)
+	}
+	if cfg.ContextMode == ContextModeSummary && state.StorySummary != "" {
+		data.StorySummary = state.StorySummary
+		data.PreviousChapters = recentChaptersText(state.PreviousChapters, summaryRecentChapters)
+	}
+	if cfg.ChapterSummaries {
+		data.ChapterRecaps = chapterRecapList(state.ChapterRecaps, chapterNum)
+	}
+	if cfg.ExtensionAfterChapter > 0 && chapterNum > cfg.ExtensionAfterChapter {
+		data.ExtensionAfterChapter = cfg.ExtensionAfterChapter
+		data.ExtensionLastChapter = cfg.ExtensionLastChapter
+	}
+
+	var prompt strings.Builder
+	if err := tmpl.Execute(&prompt, data); err != nil {
+		return "", fmt.Errorf("failed to render prompt for Chapter %d: %w", chapterNum, err)
+	}
+	if len(cfg.AppendPrompts) > 0 {
+		prompt.WriteString("\nAdditional instructions for this chapter:\n")
+		for _, instruction := range cfg.AppendPrompts {
+			fmt.Fprintf(&prompt, "- %s\n", strings.TrimSpace(instruction))
+		}
+	}
+	return prompt.String(), nil
+}
+
+// resolveAppendPrompts picks the --append-prompt instructions for the run. Instructions given on the
+// command line replace the ones saved in the status file; otherwise the saved ones are reused so a
+// resumed story keeps the same prompts. The result is stored in state to be persisted.
+func resolveAppendPrompts(cfg *FullStoryConfig, state *StoryProgressState) {
+	switch {
+	case len(cfg.AppendPrompts) > 0:
+		if len(state.AppendPrompts) > 0 && !slices.Equal(cfg.AppendPrompts, state.AppendPrompts) {
+			log.Printf("Warning: --append-prompt replaces the %d instruction(s) saved in the status file for the remaining chapters.", len(state.AppendPrompts))
+		}
+		state.AppendPrompts = cfg.AppendPrompts
+	case len(state.AppendPrompts) > 0:
+		cfg.AppendPrompts = state.AppendPrompts
+		log.Printf("Using %d --append-prompt instruction(s) saved in the status file.", len(state.AppendPrompts))
+	}
+}
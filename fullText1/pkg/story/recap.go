@@ -0,0 +1,73 @@
+package story
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+)
+
+// recapMaxWords caps the length of a single --include-chapter-summaries recap.
+const recapMaxWords = 40
+
+// updateChapterRecaps asks Gemini for a one-sentence recap of every written chapter that does
+// not have one yet, storing them in state.ChapterRecaps. A chapter whose recap fails is logged
+// and left out, so it is retried before the next chapter.
+func updateChapterRecaps(cfg FullStoryConfig, state *StoryProgressState) summaryUpdateResult {
+	var result summaryUpdateResult
+	_, chapters := parseStoryText(state.PreviousChapters)
+	for _, c := range chapters {
+		if _, ok := state.ChapterRecaps[c.Number]; ok {
+			continue
+		}
+		prompt := fmt.Sprintf(`Summarize the following story chapter in a single sentence of no more than %d words, naming the characters involved and the main event, so that a reader can recall what happened.
+Return only the sentence.
+
+--- Chapter %d ---
+%s
+--- End Chapter %d ---`, recapMaxWords, c.Number, strings.TrimSpace(c.Body), c.Number)
+		if cfg.Language != "" {
+			prompt += fmt.Sprintf("\n\nWrite the sentence in %s.", cfg.Language)
+		}
+
+		apiInput := newAPIInput(cfg, prompt)
+		apiInput.SystemInstruction = "" // The style prompt is for story text, not for the recap.
+		apiResponse := aiEndpoint.CallGeminiAPI(apiInput)
+		result.InputTokens += apiResponse.InputTokens
+		result.OutputTokens += apiResponse.OutputTokens
+		result.Cost += apiResponse.Cost
+		recap := strings.Join(strings.Fields(apiResponse.GeneratedText), " ")
+		if apiResponse.Err != nil || recap == "" {
+			err := apiResponse.Err
+			if err == nil {
+				err = fmt.Errorf("empty recap returned")
+			}
+			cfg.Logger.Warn("recap_failed", fmt.Sprintf("Failed to summarize Chapter %d for the story-so-far list: %v. It will be retried before the next chapter.", c.Number, err),
+				logging.Fields{"chapter": c.Number, "error": err.Error()})
+			continue
+		}
+
+		if state.ChapterRecaps == nil {
+			state.ChapterRecaps = make(map[int]string)
+		}
+		state.ChapterRecaps[c.Number] = recap
+		cfg.Logger.Info("recap_updated", fmt.Sprintf("Chapter %d recap: %s Input Tokens %d, Output Tokens %d, Cost: %s",
+			c.Number, recap, apiResponse.InputTokens, apiResponse.OutputTokens, aiEndpoint.FormatCost(apiResponse.Cost)),
+			logging.Fields{"chapter": c.Number, "recap": recap, "input_tokens": apiResponse.InputTokens, "output_tokens": apiResponse.OutputTokens, "cost": apiResponse.Cost})
+	}
+	return result
+}
+
+// chapterRecapList renders the recaps of the chapters before chapterNum as a "Story so far"
+// bullet list, one "- Chapter N: sentence" line per chapter in order. Chapters without a recap
+// are skipped.
+func chapterRecapList(recaps map[int]string, chapterNum int) string {
+	var b strings.Builder
+	for n := 1; n < chapterNum; n++ {
+		if recap, ok := recaps[n]; ok {
+			fmt.Fprintf(&b, "- Chapter %d: %s\n", n, recap)
+		}
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
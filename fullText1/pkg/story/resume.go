@@ -0,0 +1,74 @@
+package story
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+)
+
+// chapterStartOffset returns the offset in storyText of the first "## Chapter N" header with a
+// number of at least chapterNum, or -1 when there is none. Headers inside the story's header block
+// are not chapters and are skipped, as in parseStoryText.
+func chapterStartOffset(storyText string, chapterNum int) int {
+	start := storyHeaderEnd(storyText)
+	for _, m := range chapterHeaderPattern.FindAllStringSubmatchIndex(storyText[start:], -1) {
+		if num, err := strconv.Atoi(storyText[start+m[2] : start+m[3]]); err == nil && num >= chapterNum {
+			return start + m[0]
+		}
+	}
+	return -1
+}
+
+// truncateStoryForResume implements --resume-from: it drops Chapter chapterNum and every later
+// chapter from the loaded state, so they are written again from the abstract as it is now. The
+// story text is cut just before the "## Chapter N" header, and the metrics, titles, and recaps of the
+// dropped chapters are removed with it. A rolling summary that covered a dropped chapter is
+// discarded and rebuilt, and the last thought signature, which belongs to a dropped chapter, is
+// cleared. The accumulated usage is kept, as it was paid for. The files are rewritten by the next save.
+func truncateStoryForResume(state *StoryProgressState, chapterNum int) error {
+	if chapterNum > state.ChaptersAlreadyWritten+1 {
+		return fmt.Errorf("--resume-from %d: the story has only %d chapters, so it can resume from Chapter %d at most",
+			chapterNum, state.ChaptersAlreadyWritten, state.ChaptersAlreadyWritten+1)
+	}
+	if chapterNum == state.ChaptersAlreadyWritten+1 {
+		log.Printf("--resume-from %d: Chapter %d is the next chapter anyway; nothing to discard.", chapterNum, chapterNum)
+		return nil
+	}
+
+	if offset := chapterStartOffset(state.PreviousChapters, chapterNum); offset >= 0 {
+		state.PreviousChapters = strings.TrimRight(state.PreviousChapters[:offset], "\n") + "\n\n"
+	}
+	discarded := state.ChaptersAlreadyWritten - chapterNum + 1
+	state.ChaptersAlreadyWritten = chapterNum - 1
+	state.FirstNewChapter = chapterNum
+	state.LastThoughtSignature = nil
+
+	var metrics []file.ChapterMetrics
+	for _, m := range state.ChapterMetrics {
+		if m.Chapter < chapterNum {
+			metrics = append(metrics, m)
+		}
+	}
+	state.ChapterMetrics = metrics
+	for num := range state.ChapterTitles {
+		if num >= chapterNum {
+			delete(state.ChapterTitles, num)
+		}
+	}
+	for num := range state.ChapterRecaps {
+		if num >= chapterNum {
+			delete(state.ChapterRecaps, num)
+		}
+	}
+	if state.SummaryChapter >= chapterNum {
+		state.StorySummary = ""
+		state.SummaryChapter = 0
+	}
+
+	log.Printf("Warning: --resume-from %d: Discarded %d chapter(s) (Chapters %d-%d) from the story; they will be written again.",
+		chapterNum, discarded, chapterNum, chapterNum+discarded-1)
+	return nil
+}
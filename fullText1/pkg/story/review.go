@@ -0,0 +1,84 @@
+package story
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+)
+
+// reviewFileSuffix replaces the story file's extension to name the --review report.
+const reviewFileSuffix = ".review.md"
+
+// buildReviewPrompt asks Gemini to review the finished story against its plan for problems worth
+// fixing by regenerating chapters.
+func buildReviewPrompt(abstract, storyText, language string) string {
+	languageRule := ""
+	if language != "" {
+		languageRule = fmt.Sprintf("\nWrite the review in %s.", language)
+	}
+	return fmt.Sprintf(`You are the continuity editor of the finished story below. Read the whole story against its plan and list its problems.
+Return a Markdown document with exactly these three sections, each a bullet list:
+## Continuity Errors
+(contradictions in names, ages, appearances, relationships, places, objects, timeline, or facts between chapters)
+## Plot Holes
+(events that do not follow from what came before, unexplained motives or abilities, steps of the plan that were skipped)
+## Unresolved Threads
+(setups, mysteries, or characters that are introduced and never paid off)
+Start each bullet with the chapter numbers involved, e.g. "**Chapters 3, 7:**", and say briefly how to fix it. Write "- None found." under a section with no problems. Do not praise the story or summarize it.%s
+
+--- Story Plan (Abstract) ---
+%s
+--- End Story Plan (Abstract) ---
+
+--- Full Story ---
+%s
+--- End Full Story ---
+`, languageRule, abstract, storyText)
+}
+
+// reviewStory runs the --review pass once every chapter is written: one Gemini call that reads
+// the complete story and lists continuity errors, plot holes, and unresolved threads, saved next
+// to the story as <output>.review.md. The cost is added to state.Usage and state.Reviews and saved
+// to the status file. It returns the path of the saved review, or "" when --review is off or the
+// review failed, which is logged as a warning since the story itself is already complete.
+func reviewStory(cfg FullStoryConfig, state *StoryProgressState, statusFilePath, outputFilePath string) string {
+	if !cfg.Review {
+		return ""
+	}
+	reviewPath := sidecarFilePath(outputFilePath, reviewFileSuffix)
+	log.Printf("Reviewing the complete story for continuity errors, plot holes, and unresolved threads...")
+
+	apiInput := newAPIInput(cfg, buildReviewPrompt(cfg.AbstractContent, state.PreviousChapters, cfg.Language))
+	apiInput.SystemInstruction = "" // The style prompt is for story text, not for the review.
+	apiResponse := aiEndpoint.CallGeminiAPI(apiInput)
+	state.Usage.AddUsage(apiResponse.InputTokens, apiResponse.OutputTokens, apiResponse.Cost)
+	state.Reviews.AddUsage(apiResponse.InputTokens, apiResponse.OutputTokens, apiResponse.Cost)
+	if err := saveStateToFiles(state, statusFilePath, outputFilePath, !cfg.NoSync, newFrontMatter(cfg, state)); err != nil {
+		log.Printf("Warning: Failed to save the review cost to the status file: %v", err)
+	}
+
+	review := strings.TrimSpace(apiResponse.GeneratedText)
+	if apiResponse.Err != nil || review == "" {
+		err := apiResponse.Err
+		if err == nil {
+			err = fmt.Errorf("empty review returned")
+		}
+		cfg.Logger.Warn("review_failed", fmt.Sprintf("Failed to review the story: %v. The story is complete; run the same command with --review again to retry.", err),
+			logging.Fields{"error": err.Error()})
+		return ""
+	}
+
+	content := fmt.Sprintf("# Review of %s\n\n%s\n", outputFilePath, review)
+	if err := file.WriteFile(reviewPath, []byte(content), file.FileMode(), !cfg.NoSync); err != nil {
+		cfg.Logger.Warn("review_failed", fmt.Sprintf("Failed to save the story review: %v", err), logging.Fields{"error": err.Error()})
+		return ""
+	}
+	cfg.Logger.Info("review_saved", fmt.Sprintf("Story review saved to: %s. Input Tokens %d, Output Tokens %d, Cost: %s",
+		reviewPath, apiResponse.InputTokens, apiResponse.OutputTokens, aiEndpoint.FormatCost(apiResponse.Cost)),
+		logging.Fields{"review_path": reviewPath, "input_tokens": apiResponse.InputTokens, "output_tokens": apiResponse.OutputTokens, "cost": apiResponse.Cost})
+	return reviewPath
+}
@@ -0,0 +1,96 @@
+package story
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"google.golang.org/genai"
+)
+
+// recordingClient is an aiEndpoint.GenaiClient that records the contents of the last request and
+// answers it with a short chapter.
+type recordingClient struct {
+	contents []*genai.Content
+}
+
+// CountTokens reports a fixed prompt size.
+func (c *recordingClient) CountTokens(ctx context.Context, model string, contents []*genai.Content, config *genai.CountTokensConfig) (*genai.CountTokensResponse, error) {
+	return &genai.CountTokensResponse{TotalTokens: 100}, nil
+}
+
+// GenerateContent records contents and returns a chapter.
+func (c *recordingClient) GenerateContent(ctx context.Context, model string, contents []*genai.Content, config *genai.GenerateContentConfig) (*genai.GenerateContentResponse, error) {
+	c.contents = contents
+	return &genai.GenerateContentResponse{
+		Candidates: []*genai.Candidate{{Content: genai.NewContentFromText("Chapter text.", genai.RoleModel), FinishReason: genai.FinishReasonStop}},
+	}, nil
+}
+
+func TestAbstractSignatureReachesChapterOne(t *testing.T) {
+	t.Setenv("TMPDIR", t.TempDir()) // Request and response dumps
+	signature := []byte{0x00, 0xff, 's', 'i', 'g', 0x00, 0x80}
+	abstractPath := filepath.Join(t.TempDir(), "abstract-test.yaml")
+	if err := file.WriteAbstractFile(abstractPath, file.AbstractOutput{Abstract: "Chapter 1: A storm.", ThoughtSignature: signature, ChapterCount: 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The story command reads the signature back byte for byte through its base64 storage.
+	cfg := FullStoryConfig{AbstractFilePath: abstractPath, TotalChapters: 1, ModelName: "gemini-2.5-flash"}
+	if _, _, _, _, err := readAbstractAndDetermineTotalChapters(&cfg); err != nil {
+		t.Fatalf("readAbstractAndDetermineTotalChapters() error = %v", err)
+	}
+	if !bytes.Equal(cfg.AbstractSignature, signature) {
+		t.Fatalf("AbstractSignature = %v, want %v", cfg.AbstractSignature, signature)
+	}
+
+	// It is sent on the model turn holding the abstract, before the chapter 1 prompt.
+	client := &recordingClient{}
+	input := newAPIInput(cfg, "Write Chapter 1.")
+	input.PreviousTurn = abstractTurn(cfg, &StoryProgressState{}, 1)
+	input.Client = client
+	if resp := aiEndpoint.CallGeminiAPI(input); resp.Err != nil {
+		t.Fatalf("CallGeminiAPI() error = %v", resp.Err)
+	}
+	if len(client.contents) != 3 {
+		t.Fatalf("request has %d contents, want the abstract turn (2) and the prompt", len(client.contents))
+	}
+	modelTurn := client.contents[1]
+	if modelTurn.Role != genai.RoleModel || modelTurn.Parts[0].Text != cfg.AbstractContent {
+		t.Errorf("second content = (%s, %q), want the abstract as the model turn", modelTurn.Role, modelTurn.Parts[0].Text)
+	}
+	if !bytes.Equal(modelTurn.Parts[0].ThoughtSignature, signature) {
+		t.Errorf("model turn signature = %v, want %v", modelTurn.Parts[0].ThoughtSignature, signature)
+	}
+}
+
+func TestAbstractTurn(t *testing.T) {
+	cfg := FullStoryConfig{AbstractContent: "A plan.", AbstractSignature: []byte("sig")}
+	tests := []struct {
+		name    string
+		cfg     FullStoryConfig
+		state   StoryProgressState
+		chapter int
+		want    bool
+	}{
+		{"chapter 1 of a new story", cfg, StoryProgressState{}, 1, true},
+		{"later chapter", cfg, StoryProgressState{}, 2, false},
+		{"resumed story with its own signature", cfg, StoryProgressState{LastThoughtSignature: []byte("last")}, 1, false},
+		{"abstract without signature", FullStoryConfig{AbstractContent: "A plan."}, StoryProgressState{}, 1, false},
+		{"--no-abstract-signature", FullStoryConfig{AbstractContent: "A plan.", AbstractSignature: []byte("sig"), NoAbstractSignature: true}, StoryProgressState{}, 1, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			turn := abstractTurn(tt.cfg, &tt.state, tt.chapter)
+			if (turn != nil) != tt.want {
+				t.Fatalf("abstractTurn() = %v, want a turn: %v", turn, tt.want)
+			}
+			if turn != nil && (turn.ModelResponse != "A plan." || !bytes.Equal(turn.ThoughtSignature, []byte("sig"))) {
+				t.Errorf("abstractTurn() = %+v, want the abstract with its signature", turn)
+			}
+		})
+	}
+}
@@ -0,0 +1,201 @@
+package story
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+	"github.com/zicongmei/ai-story/fullText1/pkg/utils"
+)
+
+// useSingleShot reports whether the story is written in one call: with --single-shot, or when it has
+// at most --single-shot-max-chapters chapters. Only a story with no chapters yet qualifies; the rest
+// of a resumed story is always written chapter by chapter.
+func useSingleShot(cfg FullStoryConfig, state *StoryProgressState, totalChapters int) bool {
+	if !cfg.SingleShot && (cfg.SingleShotMaxChapters == 0 || totalChapters > cfg.SingleShotMaxChapters) {
+		return false
+	}
+	if state.ChaptersAlreadyWritten > 0 {
+		if cfg.SingleShot {
+			log.Printf("--single-shot: The story already has %d chapters; writing the remaining chapters one by one.", state.ChaptersAlreadyWritten)
+		}
+		return false
+	}
+	return true
+}
+
+// buildSingleShotPrompt asks for the whole story, with a "## Chapter N" header line before every
+// chapter so the response can be split like a story file.
+func buildSingleShotPrompt(cfg FullStoryConfig, totalChapters int) string {
+	totalWords := 0
+	var targets strings.Builder
+	for chapterNum := 1; chapterNum <= totalChapters; chapterNum++ {
+		words := targetWordsForChapter(cfg, chapterNum)
+		totalWords += words
+		fmt.Fprintf(&targets, "- Chapter %d: about %d words", chapterNum, words)
+		if outlined, ok := cfg.Outline.Chapter(chapterNum); ok {
+			if title := strings.TrimSpace(outlined.Title); title != "" && !cfg.NoChapterTitles {
+				fmt.Fprintf(&targets, ", titled \"%s\"", title)
+			}
+			fmt.Fprintf(&targets, ", covering: %s", strings.Join(strings.Fields(outlined.Beats), " "))
+		}
+		targets.WriteString("\n")
+	}
+
+	chapterLayout := "followed by a line with a short title for the chapter, then the chapter text"
+	titles := ", including the chapter titles,"
+	if cfg.NoChapterTitles {
+		chapterLayout = "followed directly by the chapter text, without a chapter title"
+		titles = ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, `Given the following complete story abstract (plan), please write the entire story in %d chapters, about %d words in total.
+Start every chapter with a line containing only "## Chapter N" (N being the chapter number), %s.
+Write nothing before the first chapter or after the last one.
+`, totalChapters, totalWords, chapterLayout)
+	if cfg.Language != "" {
+		fmt.Fprintf(&b, "Write the entire story%s in %s, even if parts of the context below are in another language. Keep the \"## Chapter N\" lines in English.\n", titles, cfg.Language)
+	}
+	fmt.Fprintf(&b, "\nChapter lengths:\n%s", targets.String())
+	if bible := strings.TrimSpace(cfg.BibleText); bible != "" {
+		fmt.Fprintf(&b, "\n--- Character Bible (keep every character detail below consistent) ---\n%s\n--- End Character Bible ---\n", bible)
+	}
+	fmt.Fprintf(&b, "\n--- Full Story Abstract (Plan) ---\n%s\n--- End Full Story Abstract (Plan) ---\n", cfg.AbstractContent)
+	if len(cfg.AppendPrompts) > 0 {
+		b.WriteString("\nAdditional instructions for every chapter:\n")
+		for _, instruction := range cfg.AppendPrompts {
+			fmt.Fprintf(&b, "- %s\n", strings.TrimSpace(instruction))
+		}
+	}
+	fmt.Fprintf(&b, "\nWrite all %d chapters now.", totalChapters)
+	return b.String()
+}
+
+// singleShotChapters returns the chapters of a single-shot response that can be kept: Chapters 1
+// to N in order, stopping at the first gap. When the response was cut off by the output token
+// limit, its last chapter is dropped as it is likely unfinished.
+func singleShotChapters(text string, totalChapters int, finishReason string) []storyChapter {
+	_, parsed := parseStoryText(strings.TrimSpace(text))
+	var chapters []storyChapter
+	for _, c := range parsed {
+		if c.Number != len(chapters)+1 || c.Number > totalChapters || c.Body == "" {
+			break
+		}
+		chapters = append(chapters, c)
+	}
+	if finishReason == aiEndpoint.FinishReasonMaxTokens && len(chapters) > 0 {
+		chapters = chapters[:len(chapters)-1]
+	}
+	return chapters
+}
+
+// writeStorySingleShot implements --single-shot: it asks for the whole story in one call, splits the
+// response at its "## Chapter N" lines, and saves the chapters as generateStoryChapters would, so
+// the per-chapter calls, retries, and rate limiting are skipped. The chapters the response does not
+// provide in full (a failed call, a missing header, or a response cut off by the output token
+// limit) are left to generateStoryChapters, which writes them one by one afterwards. The call's
+// tokens and cost are shared among the kept chapters' metrics in proportion to their words. The
+// --split-dir files are written by generateStoryChapters, which backfills them from the story text.
+func writeStorySingleShot(cfg FullStoryConfig, totalChapters int, state *StoryProgressState, statusFilePath, outputFilePath string) error {
+	prompt := buildSingleShotPrompt(cfg, totalChapters)
+	if cfg.PrintPrompt {
+		cfg.Logger.Info("single_shot_prompt", fmt.Sprintf("Single-shot prompt (%d characters):\n%s", len(prompt), formatChapterPrompt(cfg, prompt)),
+			logging.Fields{"system_instruction": cfg.StylePrompt, "prompt": prompt})
+	}
+	cfg.Logger.Info("single_shot_start", fmt.Sprintf("Generating all %d chapters in a single call", totalChapters),
+		logging.Fields{"total_chapters": totalChapters})
+
+	start := time.Now()
+	apiInput := newAPIInput(cfg, prompt)
+	apiInput.PreviousTurn = abstractTurn(cfg, state, 1)
+	apiResponse := aiEndpoint.CallGeminiAPI(apiInput)
+	seconds := time.Since(start).Seconds()
+	state.Usage.AddUsage(apiResponse.InputTokens, apiResponse.OutputTokens, apiResponse.Cost)
+
+	if apiResponse.Err != nil {
+		cfg.Logger.Warn("single_shot_failed", fmt.Sprintf("The single-shot call failed: %v. Writing the story chapter by chapter instead.", apiResponse.Err),
+			logging.Fields{"error": apiResponse.Err.Error(), "cost": apiResponse.Cost})
+		return nil
+	}
+	chapters := singleShotChapters(apiResponse.GeneratedText, totalChapters, apiResponse.FinishReason)
+	if len(chapters) == 0 {
+		cfg.Logger.Warn("single_shot_failed", fmt.Sprintf("The single-shot response has no usable \"## Chapter 1\" section (finish reason %s). Writing the story chapter by chapter instead.", apiResponse.FinishReason),
+			logging.Fields{"finish_reason": apiResponse.FinishReason, "cost": apiResponse.Cost})
+		return nil
+	}
+
+	totalWords := 0
+	words := make([]int, len(chapters))
+	for i, c := range chapters {
+		words[i] = utils.CountWords(c.Body, cfg.Language)
+		totalWords += words[i]
+	}
+	inputLeft, outputLeft := apiResponse.InputTokens, apiResponse.OutputTokens
+	for i, c := range chapters {
+		share := 1.0 / float64(len(chapters))
+		if totalWords > 0 {
+			share = float64(words[i]) / float64(totalWords)
+		}
+		inputTokens := int(float64(apiResponse.InputTokens) * share)
+		outputTokens := int(float64(apiResponse.OutputTokens) * share)
+		if i == len(chapters)-1 {
+			inputTokens, outputTokens = inputLeft, outputLeft // The rounding remainder goes to the last chapter.
+		}
+		inputLeft -= inputTokens
+		outputLeft -= outputTokens
+
+		chapterContentToWrite := c.Body + "\n\n"
+		state.PreviousChapters += fmt.Sprintf("## Chapter %d\n\n", c.Number) + chapterContentToWrite
+		state.ChaptersAlreadyWritten = c.Number
+		if title := extractChapterTitle(c.Body); title != "" && !cfg.NoChapterTitles {
+			if state.ChapterTitles == nil {
+				state.ChapterTitles = make(map[int]string)
+			}
+			state.ChapterTitles[c.Number] = title
+		}
+		state.ChapterMetrics = append(state.ChapterMetrics, file.ChapterMetrics{
+			Chapter:      c.Number,
+			Words:        words[i],
+			InputTokens:  inputTokens,
+			OutputTokens: outputTokens,
+			Cost:         apiResponse.Cost * share,
+			Seconds:      seconds * share,
+			Model:        cfg.ModelName,
+		})
+		if cfg.Language != "" {
+			if ok, script := checkChapterLanguage(c.Body, cfg.Language); !ok {
+				cfg.Logger.Warn("chapter_language", fmt.Sprintf("Chapter %d appears not to be in %s (mostly %s script); consider regenerating it.", c.Number, cfg.Language, script),
+					logging.Fields{"chapter": c.Number, "language": cfg.Language, "dominant_script": script})
+			}
+		}
+	}
+	state.LastThoughtSignature = apiResponse.ThoughtSignature
+	state.FirstNewChapter = len(chapters) + 1
+	if cfg.ImagePrompts {
+		imageUsage := updateImagePrompts(cfg, state, outputFilePath)
+		state.Usage.AddUsage(imageUsage.InputTokens, imageUsage.OutputTokens, imageUsage.Cost)
+	}
+
+	cfg.Logger.Info("single_shot_done", fmt.Sprintf("Single-shot call wrote %d of %d chapters: Words %d, Characters %d, Input Tokens %d, Output Tokens %d, Cost: %s, Time: %.1fs",
+		len(chapters), totalChapters, totalWords, utf8.RuneCountInString(apiResponse.GeneratedText), apiResponse.InputTokens, apiResponse.OutputTokens, aiEndpoint.FormatCost(apiResponse.Cost), seconds),
+		logging.Fields{
+			"chapters":       len(chapters),
+			"total_chapters": totalChapters,
+			"words":          totalWords,
+			"finish_reason":  apiResponse.FinishReason,
+			"input_tokens":   apiResponse.InputTokens,
+			"output_tokens":  apiResponse.OutputTokens,
+			"cost":           apiResponse.Cost,
+			"seconds":        seconds,
+		})
+	if len(chapters) < totalChapters {
+		log.Printf("Warning: The single-shot response covered only %d of %d chapters (finish reason %s); writing the rest chapter by chapter.",
+			len(chapters), totalChapters, apiResponse.FinishReason)
+	}
+	return saveStateToFiles(state, statusFilePath, outputFilePath, !cfg.NoSync, newFrontMatter(cfg, state))
+}
@@ -0,0 +1,52 @@
+package story
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+)
+
+// splitChapterFileName returns the per-chapter file name used by --split-dir, e.g. "chapter-007.md".
+func splitChapterFileName(chapterNum int) string {
+	return fmt.Sprintf("chapter-%03d.md", chapterNum)
+}
+
+// writeSplitChapter writes a single chapter, with its "## Chapter N" header, to its own file in dir,
+// syncing it to disk when sync is true.
+func writeSplitChapter(dir string, chapterNum int, body string, sync bool) error {
+	path := filepath.Join(dir, splitChapterFileName(chapterNum))
+	content := fmt.Sprintf("## Chapter %d\n\n%s\n", chapterNum, strings.TrimSpace(body))
+	if err := file.WriteFile(path, []byte(content), file.FileMode(), sync); err != nil {
+		return fmt.Errorf("failed to write chapter file '%s': %w", path, err)
+	}
+	return nil
+}
+
+// backfillSplitChapters writes a split file for every chapter already in the story text that does
+// not have one yet, so a resumed run leaves dir consistent with the combined file. Existing split
+// files are left untouched. It returns the number of files written.
+func backfillSplitChapters(dir string, storyText string, sync bool) (int, error) {
+	_, chapters := parseStoryText(storyText)
+	written := 0
+	for _, chapter := range chapters {
+		path := filepath.Join(dir, splitChapterFileName(chapter.Number))
+		if _, err := os.Stat(path); err == nil {
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return written, fmt.Errorf("failed to check chapter file '%s': %w", path, err)
+		}
+		if err := writeSplitChapter(dir, chapter.Number, chapter.Body, sync); err != nil {
+			return written, err
+		}
+		written++
+	}
+	if written > 0 {
+		log.Printf("Wrote %d missing chapter file(s) to split directory '%s'.", written, dir)
+	}
+	return written, nil
+}
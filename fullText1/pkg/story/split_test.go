@@ -0,0 +1,112 @@
+package story
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readSplitDir returns the contents of the files in dir, keyed by file name.
+func readSplitDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	for _, entry := range entries {
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[entry.Name()] = string(data)
+	}
+	return files
+}
+
+func TestWriteSplitChapter(t *testing.T) {
+	dir := t.TempDir()
+	if err := writeSplitChapter(dir, 1, "\n\nThe Storm\n\nRain fell.\n\n", false); err != nil {
+		t.Fatalf("writeSplitChapter() error = %v", err)
+	}
+	if err := writeSplitChapter(dir, 12, "Morning came.", true); err != nil {
+		t.Fatalf("writeSplitChapter() error = %v", err)
+	}
+
+	want := map[string]string{
+		"chapter-001.md": "## Chapter 1\n\nThe Storm\n\nRain fell.\n",
+		"chapter-012.md": "## Chapter 12\n\nMorning came.\n",
+	}
+	got := readSplitDir(t, dir)
+	if len(got) != len(want) {
+		t.Errorf("split files = %v, want %v", got, want)
+	}
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s = %q, want %q", name, got[name], content)
+		}
+	}
+}
+
+func TestWriteSplitChapterMissingDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	if err := writeSplitChapter(dir, 1, "Text.", false); err == nil {
+		t.Fatal("writeSplitChapter() into a missing directory error = nil")
+	}
+}
+
+func TestBackfillSplitChapters(t *testing.T) {
+	dir := t.TempDir()
+	// Chapter 2 was hand-edited in the split directory; backfilling must not overwrite it.
+	if err := os.WriteFile(filepath.Join(dir, "chapter-002.md"), []byte("## Chapter 2\n\nEdited.\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	story := "Header\n\n## Chapter 1\n\nOne.\n\n## Chapter 2\n\nTwo.\n\n## Chapter 3\n\nThree.\n\n"
+	written, err := backfillSplitChapters(dir, story, false)
+	if err != nil {
+		t.Fatalf("backfillSplitChapters() error = %v", err)
+	}
+	if written != 2 {
+		t.Errorf("backfillSplitChapters() wrote %d files, want 2", written)
+	}
+
+	want := map[string]string{
+		"chapter-001.md": "## Chapter 1\n\nOne.\n",
+		"chapter-002.md": "## Chapter 2\n\nEdited.\n",
+		"chapter-003.md": "## Chapter 3\n\nThree.\n",
+	}
+	got := readSplitDir(t, dir)
+	for name, content := range want {
+		if got[name] != content {
+			t.Errorf("%s = %q, want %q", name, got[name], content)
+		}
+	}
+
+	// A second run finds every file in place.
+	if written, err := backfillSplitChapters(dir, story, false); err != nil || written != 0 {
+		t.Errorf("second backfillSplitChapters() = %d, %v; want 0, nil", written, err)
+	}
+}
+
+func TestGenerateStoryChaptersAfterSingleShot(t *testing.T) {
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+	defer log.SetOutput(os.Stderr)
+
+	// A single-shot call wrote both chapters, so only the split files are left to write.
+	dir := t.TempDir()
+	state := &StoryProgressState{PreviousChapters: "## Chapter 1\n\nOne.\n\n## Chapter 2\n\nTwo.\n\n", ChaptersAlreadyWritten: 2, FirstNewChapter: 3}
+	cfg := FullStoryConfig{SplitDir: dir, NoProgress: true, NoSync: true}
+	if err := generateStoryChapters(cfg, 2, state, "", ""); err != nil {
+		t.Fatalf("generateStoryChapters() error = %v", err)
+	}
+	if got := readSplitDir(t, dir); len(got) != 2 {
+		t.Errorf("split files = %v, want chapter-001.md and chapter-002.md", got)
+	}
+	if strings.Contains(logs.String(), "Starting full story generation") {
+		t.Errorf("generateStoryChapters() logged %q with no chapter left to write", logs.String())
+	}
+}
@@ -0,0 +1,227 @@
+package story
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/cli"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+	"github.com/zicongmei/ai-story/fullText1/pkg/utils"
+)
+
+// Rough conversion factors used by the local cost estimate, which makes no API calls.
+const (
+	estimateCharsPerToken  = 4   // Average characters per token for English prose
+	estimateTokensPerWord  = 1.4 // Average tokens per generated word
+	estimatePromptOverhead = 300 // Tokens of fixed instructions in each chapter prompt
+)
+
+// estimateTokens approximates the token count of text from its length.
+func estimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + estimateCharsPerToken - 1) / estimateCharsPerToken
+}
+
+// remainingCostEstimate is a local estimate of what the unwritten chapters of a story will cost.
+type remainingCostEstimate struct {
+	Chapters     int
+	InputTokens  int
+	OutputTokens int
+	Cost         float64
+}
+
+// estimateRemainingCost estimates the tokens and cost of generating the chapters after
+// chaptersWritten up to totalChapters in full context mode, where every prompt carries the
+// abstract and the whole story so far. Output per chapter is the average of the recorded
+// chapter metrics when available, otherwise derived from wordsPerChapter. Thinking tokens,
+// expansions, and continuations are not included.
+func estimateRemainingCost(modelName, abstract, storyText string, metrics []file.ChapterMetrics, chaptersWritten, totalChapters, wordsPerChapter int) (remainingCostEstimate, error) {
+	estimate := remainingCostEstimate{Chapters: totalChapters - chaptersWritten}
+	if estimate.Chapters <= 0 {
+		estimate.Chapters = 0
+		return estimate, nil
+	}
+
+	outputPerChapter := int(float64(wordsPerChapter) * estimateTokensPerWord)
+	total, n := 0, 0
+	for _, m := range metrics {
+		if m.OutputTokens > 0 {
+			total += m.OutputTokens
+			n++
+		}
+	}
+	if n > 0 {
+		outputPerChapter = total / n
+	}
+
+	contextTokens := estimatePromptOverhead + estimateTokens(abstract) + estimateTokens(storyText)
+	for i := 0; i < estimate.Chapters; i++ {
+		input := contextTokens + i*outputPerChapter
+		prices, err := aiEndpoint.GetModelPrices(modelName, input)
+		if err != nil {
+			return estimate, err
+		}
+		estimate.InputTokens += input
+		estimate.OutputTokens += outputPerChapter
+		estimate.Cost += (float64(input)/aiEndpoint.TokensPerMillion)*prices.InputPricePerMillion +
+			(float64(outputPerChapter)/aiEndpoint.TokensPerMillion)*prices.OutputPricePerMillion
+	}
+	return estimate, nil
+}
+
+// chapterLooksTruncated reports whether a chapter body appears cut off: a generation-failure
+// placeholder, or text that does not end with sentence-ending punctuation.
+func chapterLooksTruncated(body string) (bool, string) {
+	body = strings.TrimSpace(body)
+	switch {
+	case body == "":
+		return true, "empty"
+	case strings.HasPrefix(body, "Error generating Chapter"):
+		return true, "generation failed; the chapter is a placeholder"
+	case strings.Contains(body, "was blocked by Gemini's safety filters"):
+		return true, "blocked by safety filters; the chapter is a placeholder"
+	}
+	if loc := sentenceEndPattern.FindAllStringIndex(body, -1); len(loc) == 0 || loc[len(loc)-1][1] != len(body) {
+		return true, "ends mid-sentence"
+	}
+	return false, ""
+}
+
+// executeStatus implements 'story status', a read-only report on a story in progress or
+// finished: planned and written chapters, the last chapter's state, and a local estimate
+// of the remaining cost. It makes no API calls.
+func executeStatus(args []string) error {
+	cmd := newSubcommandFlagSet("story status")
+	outputPath := cmd.String("output", "", "Path to the full story file to inspect.")
+	abstractPath := cmd.String("abstract", "", "Path to the abstract file the story is generated from (optional when the story file has a metadata block, which records the abstract file and planned chapters).")
+	configPath := cmd.String("config", "", "Path to Gemini configuration JSON file (optional), used only for the model name in the cost estimate.")
+	modelName := cmd.String("model", "", "Model to price the remaining chapters with (optional). Defaults to the model from the config file.")
+	wordsPerChapter := cmd.Int("words-per-chapter", 5000, "Words per chapter assumed for the remaining chapters when the status file has no chapter metrics. Defaults to the value recorded in the story metadata, then 5000.")
+	pricingFile := cmd.String("pricing-file", "", "Path to a pricing JSON file overriding the built-in model prices (optional). Defaults to the GEMINI_PRICING_FILE env var.")
+	var costFormat aiEndpoint.CostFormat
+	addCostFlags(cmd, &costFormat)
+	var headingFormat, separator string
+	addLayoutFlags(cmd, &headingFormat, &separator)
+	if err := cmd.Parse(args); err != nil {
+		return cli.UsageError(fmt.Errorf("failed to parse story status flags: %w", err))
+	}
+	if *outputPath == "" {
+		return cli.UsageError(fmt.Errorf("--output is required for story status"))
+	}
+	if *wordsPerChapter <= 0 {
+		return cli.UsageError(fmt.Errorf("--words-per-chapter must be a positive number"))
+	}
+	if err := aiEndpoint.LoadPricingOverrides(*pricingFile); err != nil {
+		return err
+	}
+	if err := aiEndpoint.SetCostFormat(costFormat); err != nil {
+		return cli.UsageError(fmt.Errorf("invalid cost display flags: %w", err))
+	}
+	layout, err := newStoryLayout(headingFormat, separator)
+	if err != nil {
+		return cli.UsageError(err)
+	}
+
+	// The report is the command's output, so keep the log lines out of it.
+	originalLogOutput := log.Writer()
+	log.SetOutput(logging.Console(os.Stderr))
+	defer log.SetOutput(originalLogOutput)
+
+	statusPath := determineStatusFilePath(*outputPath)
+	state, err := loadStateForContinue(statusPath, *outputPath, layout)
+	if err != nil {
+		return err
+	}
+	_, chapters := parseStoryText(state.PreviousChapters)
+
+	// The metadata block, when the story has one, stands in for the abstract file and the flags.
+	meta, err := parseStoryMetadata(state.PreviousChapters)
+	if err != nil && !errors.Is(err, ErrNoStoryMetadata) {
+		log.Printf("Warning: %v", err)
+	}
+	var abstractData file.AbstractOutput
+	if *abstractPath != "" {
+		abstractData, err = readAbstract(*abstractPath, file.AbstractFormatAuto)
+		if err != nil {
+			return fmt.Errorf("failed to read and parse abstract file '%s': %w", *abstractPath, err)
+		}
+		if abstractData.ChapterCount == 0 {
+			abstractData.ChapterCount = meta.TotalChapters
+		}
+	} else {
+		if errors.Is(err, ErrNoStoryMetadata) {
+			return cli.UsageError(fmt.Errorf("--abstract is required for a story without a metadata block"))
+		}
+		if abstractData, err = abstractFromMetadata(meta, state.PreviousChapters); err != nil {
+			return err
+		}
+	}
+	wordsSet := false
+	cmd.Visit(func(f *flag.Flag) { wordsSet = wordsSet || f.Name == "words-per-chapter" })
+	if !wordsSet && meta.WordsPerChapter > 0 {
+		*wordsPerChapter = meta.WordsPerChapter
+	}
+
+	model := aiEndpoint.CanonicalModelName(*modelName)
+	if model == "" && *configPath == "" {
+		model = meta.Model
+	}
+	if model == "" {
+		details := aiEndpoint.LoadGeminiConfigWithFallback(*configPath)
+		model = details.ModelName
+		if model == "" {
+			model = aiEndpoint.DefaultGeminiModel
+		}
+	}
+
+	fmt.Printf("Story: %s\n", *outputPath)
+	if _, err := os.Stat(statusPath); err == nil {
+		fmt.Printf("Status file: %s\n", statusPath)
+	} else {
+		fmt.Printf("Status file: none (chapters read from the story file)\n")
+	}
+	if abstractData.ChapterCount > 0 {
+		fmt.Printf("Planned chapters: %d\n", abstractData.ChapterCount)
+	} else {
+		fmt.Printf("Planned chapters: unknown (the abstract file has no chapter_count)\n")
+	}
+	words := 0
+	for _, c := range chapters {
+		words += utils.CountWords(c.Body, abstractData.Language)
+	}
+	fmt.Printf("Chapters written: %d (%d words)\n", state.ChaptersAlreadyWritten, words)
+	if inputTokens, outputTokens, cost := state.Usage.Summary(); cost > 0 {
+		fmt.Printf("Cost so far: %s (Input tokens %d, Output tokens %d)\n", aiEndpoint.FormatCost(cost), inputTokens, outputTokens)
+		fmt.Printf("Cost breakdown: %s\n", newCostBreakdown(&state))
+	}
+
+	if len(chapters) > 0 {
+		last := chapters[len(chapters)-1]
+		if truncated, reason := chapterLooksTruncated(last.Body); truncated {
+			fmt.Printf("Last chapter: Chapter %d looks truncated (%s)\n", last.Number, reason)
+		} else {
+			fmt.Printf("Last chapter: Chapter %d looks complete\n", last.Number)
+		}
+	}
+
+	if abstractData.ChapterCount > 0 {
+		estimate, err := estimateRemainingCost(model, abstractData.Abstract, state.PreviousChapters, state.ChapterMetrics,
+			state.ChaptersAlreadyWritten, abstractData.ChapterCount, *wordsPerChapter)
+		switch {
+		case err != nil:
+			fmt.Printf("Remaining chapters: %d (cost estimate unavailable: %v)\n", estimate.Chapters, err)
+		case estimate.Chapters == 0:
+			fmt.Printf("Remaining chapters: 0 (story complete)\n")
+		default:
+			fmt.Printf("Remaining chapters: %d. Estimated cost with %s: %s (~%d input, ~%d output tokens; local estimate, excludes thinking tokens)\n",
+				estimate.Chapters, model, aiEndpoint.FormatCost(estimate.Cost), estimate.InputTokens, estimate.OutputTokens)
+		}
+	}
+	return nil
+}
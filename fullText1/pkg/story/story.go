@@ -1,28 +1,49 @@
 package story
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
 	"log"
+	"net/http"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"text/tabwriter"
+	"text/template"
 	"time"
 	"unicode/utf8" // Added for character counting
 
 	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
 	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/cli"
+	"github.com/zicongmei/ai-story/fullText1/pkg/export"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+	"github.com/zicongmei/ai-story/fullText1/pkg/utils"
+	"golang.org/x/time/rate"
+	"gopkg.in/yaml.v3"
 )
 
 // GetChapterCountForStoryInput holds input parameters for getChapterCountFromGeminiForStory.
 type GetChapterCountForStoryInput struct {
-	APIKey        string
-	ModelName     string
-	ThinkingLevel string
-	Abstract      string
+	APIKey         string
+	ModelName      string
+	ThinkingLevel  string
+	Abstract       string
+	Limiter        *rate.Limiter
+	Seed           *int
+	Ctx            context.Context // Defaults to context.Background() when nil
+	Timeout        time.Duration   // Per-call limit; 0 waits as long as the API takes
+	ThinkingBudget *int32          // Nil uses a dynamic budget
+	// Client, HTTPClient, and CacheDir are passed on to the call; see aiEndpoint.CallGeminiAPIInput.
+	Client     aiEndpoint.GenaiClient
+	HTTPClient *http.Client
+	CacheDir   string
 }
 
 // getChapterCountFromGeminiForStory sends the abstract to Gemini to get a pure chapter count for story generation.
@@ -39,12 +60,19 @@ If no chapters are explicitly outlined, return 0.
 `, input.Abstract)
 
 	apiInput := aiEndpoint.CallGeminiAPIInput{
-		Ctx:           context.Background(),
-		APIKey:        input.APIKey,
-		ModelName:     input.ModelName,
-		Prompt:        prompt,
-		ThinkingLevel: input.ThinkingLevel,
-		PreviousTurn:  nil,
+		Ctx:            contextOrBackground(input.Ctx),
+		APIKey:         input.APIKey,
+		ModelName:      input.ModelName,
+		Prompt:         prompt,
+		ThinkingLevel:  input.ThinkingLevel,
+		PreviousTurn:   nil,
+		Limiter:        input.Limiter,
+		Timeout:        input.Timeout,
+		ThinkingBudget: input.ThinkingBudget,
+		Seed:           input.Seed,
+		Client:         input.Client,
+		HTTPClient:     input.HTTPClient,
+		CacheDir:       input.CacheDir,
 	}
 	apiResponse := aiEndpoint.CallGeminiAPI(apiInput)
 
@@ -53,15 +81,12 @@ If no chapters are explicitly outlined, return 0.
 		return result
 	}
 
-	countStr := strings.TrimSpace(apiResponse.GeneratedText)
-	countStr = strings.Split(countStr, "\n")[0]
-
-	count, err := strconv.Atoi(countStr)
+	count, err := aiEndpoint.ParseChapterCount(apiResponse.GeneratedText)
 	if err != nil {
 		result.InputTokens = apiResponse.InputTokens
 		result.OutputTokens = apiResponse.OutputTokens
 		result.Cost = apiResponse.Cost
-		result.Err = fmt.Errorf("could not parse chapter count '%s' from Gemini response for story: %w", countStr, err)
+		result.Err = fmt.Errorf("could not parse chapter count from Gemini response for story: %w", err)
 		return result
 	}
 
@@ -72,6 +97,49 @@ If no chapters are explicitly outlined, return 0.
 	return result
 }
 
+// stdinAbstractPath is the --abstract value that reads the abstract from stdin.
+const stdinAbstractPath = "-"
+
+// stdinAbstractFormat is the format used to parse an abstract read from stdin. YAML is tried
+// first because it is what the abstract command writes (and JSON is valid YAML); input that
+// does not parse is treated as plain text.
+const stdinAbstractFormat = file.AbstractFormatYAML
+
+// defaultOutputDir holds derived story and log files when --output-dir is not given.
+const defaultOutputDir = "output"
+
+// storyHeaderSeparator ends the header block written at the top of every full story file.
+const storyHeaderSeparator = "----------------------------------------"
+
+// storyTitlePrefix starts the title line of the header block written by storyHeader, with or
+// without the creation timestamp.
+const storyTitlePrefix = "--- Full Story"
+
+// storyAbstractLabel starts the abstract paragraph of the header block written by storyHeader.
+const storyAbstractLabel = "Story Plan Abstract:"
+
+// storyHeader returns the header block written at the top of a new full story file. The
+// metadata block is written when meta is not nil, the abstract paragraph is left out when
+// abstractContent is empty, and the title line carries the creation time only when timestamp is
+// set, so headers written without it are byte-identical across runs.
+func storyHeader(abstractContent string, timestamp bool, meta *StoryMetadata) string {
+	header := storyTitlePrefix + " ---\n\n"
+	if timestamp {
+		header = fmt.Sprintf("%s: %s ---\n\n", storyTitlePrefix, time.Now().Format("2006-01-02 15:04:05"))
+	}
+	if meta != nil {
+		block, err := storyMetadataBlock(*meta)
+		if err != nil {
+			log.Printf("Warning: Leaving the metadata block out of the story header: %v", err)
+		}
+		header += block
+	}
+	if abstractContent != "" {
+		header += fmt.Sprintf("%s\n%s\n\n", storyAbstractLabel, abstractContent)
+	}
+	return header + storyHeaderSeparator + "\n\n"
+}
+
 // FullStoryConfig holds all configuration needed for story generation.
 type FullStoryConfig struct {
 	ConfigPath       string
@@ -79,156 +147,736 @@ type FullStoryConfig struct {
 	WordsPerChapter  int
 	OutputPath       string
 	APIKey           string
+	APIKeyFile       string // Read for the API key when APIKey is empty, ahead of the config file and environment
 	ModelName        string
 	ThinkingLevel    string
 	AbstractContent  string
+	AbstractFormat   string  // How AbstractFilePath is parsed: file.AbstractFormatAuto (by extension), or text, yaml, or json
+	FromInstruction  string  // Story idea the abstract is generated from in the same run, instead of reading AbstractFilePath
+	SaveAbstractPath string  // Where the abstract generated from FromInstruction is saved; "" keeps it in memory only
+	WordTolerance    float64 // Allowed deviation from a chapter's target word count reported in the summary, e.g. 0.2 for +/- 20%
+	// MinWordRatio is the fraction of WordsPerChapter a chapter must reach before
+	// it is accepted without expansion. Zero disables the check.
+	MinWordRatio       float64
+	MaxExpansionRounds int
+	MaxContinuations   int      // Follow-up prompts allowed for a chapter truncated by the output token limit
+	MaxOutputTokens    int      // Output token cap for every chapter call; 0 uses the model's default limit
+	Temperature        *float32 // Sampling temperature (0-2); nil uses 'temperature' from the config file, then the SDK default
+	TopP               *float32 // Nucleus sampling top-p (0-1); nil uses 'top_p' from the config file, then the SDK default
+	ThinkingBudget     *int32   // Thinking tokens per call; nil uses 'thinking_budget' from the config file, then a dynamic budget
+	ChapterPlanPath    string
+	ChapterPlan        map[int]int // Per-chapter word targets; chapters not listed use WordsPerChapter
+	LogFormat          string
+	Logger             logging.Logger // Set by Execute once logging is configured
+	// ExtensionAfterChapter is the last chapter of the original plan when 'story continue'
+	// extends a finished story, and ExtensionLastChapter is the final chapter of the
+	// extension. Both are 0 for normal generation.
+	ExtensionAfterChapter int
+	ExtensionLastChapter  int
+	FallbackModel         string // Model tried once for a chapter after ModelName exhausts its retries; "" disables
+	RequestsPerMinute     int
+	PricingFile           string                       // Optional pricing.json overriding the built-in model prices
+	LedgerPath            string                       // CSV ledger the CLI appends a row with the run's usage to (--ledger); "" for none
+	Seed                  *int                         // Optional sampling seed sent with every call for reproducible output
+	SplitDir              string                       // Optional directory receiving one chapter-NNN.md file per chapter
+	OutputDir             string                       // Directory for derived file names, and base of relative OutputPath and SplitDir; "" uses "output" for derived names only
+	NoSync                bool                         // Skip fsync after each chapter write (faster, less crash-safe)
+	Overwrite             bool                         // Discard an existing story and its status file and start from Chapter 1
+	CreateDirs            bool                         // Create a missing directory of an explicit OutputPath instead of failing
+	AppendTo              string                       // Existing file (e.g. an anthology) the story is also appended to, after a separator
+	ResumeOnly            bool                         // Fail instead of starting a new story when there is nothing to resume
+	ResumeFrom            int                          // When positive, discard this chapter and every later one from the saved story and write them again
+	SingleShot            bool                         // Write a new story in one call and split it at its chapter headers, instead of one call per chapter
+	SingleShotMaxChapters int                          // Use SingleShot automatically for stories of at most this many chapters; 0 never does
+	NoProgress            bool                         // Do not draw the per-chapter progress line on the terminal
+	FrontMatter           bool                         // Write a YAML front matter block (title, chapters, model, cost, abstract) at the top of the story
+	NoAbstractInHeader    bool                         // Leave the "Story Plan Abstract:" block out of a new story's header
+	NoTimestampHeader     bool                         // Leave the creation time out of a new story's title line and the date out of extension notes
+	AbstractSignature     []byte                       // Thought signature saved with the abstract, sent with chapter 1 of a new story
+	NoAbstractSignature   bool                         // Do not send AbstractSignature, e.g. to compare coherence with and without it
+	Timeout               time.Duration                // Maximum time for each Gemini call, e.g. one chapter attempt; 0 waits as long as the API takes
+	PromptTemplatePath    string                       // Optional text/template file replacing the built-in chapter prompt
+	Language              string                       // Language every chapter is written in; from --language or the abstract file
+	TotalChapters         int                          // Chapters to generate when positive, skipping the chapter count lookup
+	ContextMode           string                       // ContextModeFull or ContextModeSummary: how earlier chapters are sent in each prompt
+	HistoryTurns          int                          // Recent chapter turns sent as conversation history with each chapter prompt; 0 sends none
+	MaxContextTokens      int                          // Chapter prompts over this many tokens drop their oldest chapters until they fit; 0 disables the check
+	ChapterHeaderFormat   string                       // text/template for the story file's chapter headings, with .Num and .Title; "" writes "## Chapter N"
+	Separator             string                       // Line ending the story file's header block; "" writes the dashed separator
+	NoChapterTitles       bool                         // Ask for chapters without a title line, so the story file has bare "## Chapter N" headings
+	Review                bool                         // Send the finished story back for a continuity review saved as <output>.review.md
+	ImagePrompts          bool                         // After each chapter, ask for a text-to-image prompt of a key scene, saved to <output>.imageprompts.yaml
+	ChapterSummaries      bool                         // Recap each chapter in one sentence and send the recaps as a "Story so far" list with each chapter prompt
+	TOC                   string                       // "file" or "inline" writes a table of contents when all chapters are done; "" writes none
+	CostFormat            aiEndpoint.CostFormat        // Display currency and precision for costs
+	HTTP                  aiEndpoint.HTTPClientOptions // Proxy, CA bundle and timeout of the HTTP client used for Gemini calls
+	FileMode              string                       // Octal permission of created files from --file-mode, applied with applyFileMode
+	CacheDir              string                       // Directory caching Gemini responses by request hash; "" disables the cache
+	PrintPrompt           bool                         // Log the fully assembled prompt of every chapter before it is sent
+	PrintPromptOnly       bool                         // Print the prompts of the remaining chapters to stdout and stop without calling the API or writing the story
+	Mock                  bool                         // Answer every call locally with placeholder text (see aiEndpoint.MockClient); no API key is needed
+	Verbosity             logging.VerbosityFlags       // --verbose/--quiet; applied by the CLI with VerbosityFlags.Apply
+	client                aiEndpoint.GenaiClient       // Set by applyGenerationFlags to a MockClient with Mock; nil calls the API
+	httpClient            *http.Client                 // Built by applyGenerationFlags from HTTP; nil uses the SDK's default client
+	ctx                   context.Context              // Set by GenerateStory; nil means context.Background()
+	interrupt             *interruptState              // Set by the CLI; a SIGINT stops generation after the current chapter
+	promptTemplate        *template.Template           // Parsed from PromptTemplatePath, or the embedded default
+	Limiter               *rate.Limiter                // Shared by every Gemini call of the command; nil means unlimited
+	StylePrompt           string                       // Narrative voice sent as the system instruction of every chapter call
+	configStylePrompt     string                       // style_prompt from the config file, the lowest-priority source
+	BiblePath             string
+	BibleText             string // Character bible as YAML, injected into chapter prompts when set
+	OutlinePath           string
+	Outline               file.StoryOutline // Per-chapter beats from --outline, sent instead of the full abstract
+	AppendPrompts         []string          // Extra instructions from --append-prompt, appended to every chapter prompt
+	// ChapterValidator, when set, checks every generated chapter; a chapter it rejects is written
+	// again with the error as feedback, up to MaxValidationRetries times. applyGenerationFlags
+	// adds the validators selected by ForbidWords and RequireWords.
+	ChapterValidator     ChapterValidator
+	ForbidWords          []string             // Words no chapter may contain (--forbid-words)
+	RequireWords         []string             // Words every chapter must mention (--require-words)
+	MaxValidationRetries int                  // Regenerations allowed per chapter that fails ChapterValidator
+	generatedAbstract    *file.AbstractOutput // Set from FromInstruction; read instead of AbstractFilePath
+	wordsPerChapterSet   bool                 // --words-per-chapter was given, so 'story continue' does not take it from the story metadata
 }
 
 // StoryProgressState holds the current state of the story generation,
 // including accumulated tokens and the generated content for context.
 type StoryProgressState struct {
-	AccumulatedInputTokens  int
-	AccumulatedOutputTokens int
-	AccumulatedCost         float64
-	PreviousChapters        string // Content of all chapters written so far, for context
-	LastThoughtSignature    []byte // Last AI thought signature for continuity
-	ChaptersAlreadyWritten  int
-	FirstNewChapter         int
+	Usage                  *aiEndpoint.CostTracker // Tokens and cost accumulated over every run, persisted in the status file
+	StartUsage             file.UsageTotals        // Usage loaded from the status file; the rest of Usage was spent by this run
+	Planning               *aiEndpoint.CostTracker // The part of Usage spent on planning calls (the chapter count, and the abstract with --from-instruction)
+	Summaries              *aiEndpoint.CostTracker // The part of Usage spent on --context-mode summary updates and chapter recaps
+	Reviews                *aiEndpoint.CostTracker // The part of Usage spent on --review passes
+	PreviousChapters       string                  // Content of all chapters written so far, for context
+	LastThoughtSignature   []byte                  // Last AI thought signature for continuity
+	ChaptersAlreadyWritten int
+	FirstNewChapter        int
+	ChapterMetrics         []file.ChapterMetrics    // One entry per generated chapter, persisted in the status file
+	RunDuration            time.Duration            // Wall-clock time spent generating chapters in this run
+	StorySummary           string                   // Rolling summary of the story, maintained in summary context mode
+	ChapterTitles          map[int]string           // Title the model wrote for each chapter, keyed by chapter number
+	SummaryChapter         int                      // Last chapter covered by StorySummary
+	ChapterRecaps          map[int]string           // One-sentence recap of each chapter for --include-chapter-summaries, keyed by chapter number
+	AppendPrompts          []string                 // --append-prompt instructions in effect, persisted for resumed runs
+	RecentTurns            []aiEndpoint.HistoryTurn // Prompts and chapters of this run kept for --history-turns, oldest first; not persisted
+	AppendTo               string                   // --append-to file the story is also written into, persisted in the status file
+	AppendOffset           int64                    // Byte offset in AppendTo where the story starts
+	AppendLength           int64                    // Bytes of AppendTo written by the last save
+	ChapterHeaderFormat    string                   // --chapter-header-format of the story file, persisted in the status file
+	Separator              string                   // --separator of the story file, persisted in the status file
+	NoChapterTitles        bool                     // --no-chapter-titles of the story, persisted in the status file
+	layout                 *storyLayout             // Compiled from ChapterHeaderFormat and Separator; nil writes the standard layout
 }
 
-// parseAndValidateFlags parses command-line flags and performs initial validation.
-func parseAndValidateFlags(args []string) (FullStoryConfig, error) {
-	var cfg FullStoryConfig
-	cmd := flag.NewFlagSet("story", flag.ContinueOnError)
+// newSubcommandFlagSet creates an empty flag set for a story subcommand with the standard usage output.
+func newSubcommandFlagSet(name string) *flag.FlagSet {
+	cmd := flag.NewFlagSet(name, flag.ContinueOnError)
 	cmd.Usage = func() {
-		fmt.Fprintf(cmd.Output(), "Usage of %s story:\n", os.Args[0])
+		fmt.Fprintf(cmd.Output(), "Usage of %s %s:\n", os.Args[0], name)
 		cmd.PrintDefaults()
 	}
+	return cmd
+}
 
-	cmd.StringVar(&cfg.ConfigPath, "config", "", "Path to Gemini configuration JSON file (optional). If not provided, API key is taken from GEMINI_API_KEY env var and model defaults to 'gemini-pro'.")
-	cmd.StringVar(&cfg.AbstractFilePath, "abstract", "", "Path to the abstract file (text, json, or yaml) generated by the 'abstract' command.")
-	cmd.IntVar(&cfg.WordsPerChapter, "words-per-chapter", 5000, "Desired average number of words per chapter (actual count may vary by +/- 20%).")
-	cmd.StringVar(&cfg.OutputPath, "output", "", "Path to save the generated full story file (default: fulltext-yyyy-mm-dd-hh-mm-ss.txt based on abstract filename).")
+// newStoryFlagSet creates the flag set for a story subcommand that generates chapters and
+// registers the generation flags shared by all of them.
+func newStoryFlagSet(name string, cfg *FullStoryConfig) *flag.FlagSet {
+	cmd := newSubcommandFlagSet(name)
+
+	cmd.StringVar(&cfg.ConfigPath, "config", "", "Path to Gemini configuration JSON file (optional). If not provided, API key is taken from GEMINI_API_KEY env var and model defaults to '"+aiEndpoint.DefaultGeminiModel+"'.")
+	cmd.StringVar(&cfg.APIKeyFile, "api-key-file", "", "Path to a file containing the Gemini API key, e.g. a mounted CI secret (optional). Takes precedence over the config file and the GEMINI_API_KEY and GEMINI_API_KEY_FILE env vars; surrounding whitespace is trimmed.")
+	cmd.StringVar(&cfg.AbstractFormat, "abstract-format", file.AbstractFormatAuto, "How to parse --abstract: 'auto' (by file extension: .yaml/.yml, .json, else text; stdin is tried as YAML), 'text', 'yaml', or 'json'. Forces the format of files with a misleading or missing extension.")
+	cmd.IntVar(&cfg.WordsPerChapter, "words-per-chapter", 5000, "Desired average number of words per chapter (actual count may vary by +/- --word-tolerance).")
+	cmd.Float64Var(&cfg.WordTolerance, "word-tolerance", 0.2, "Allowed deviation of a chapter's word count from its target, as a fraction (0.2 means +/- 20%). The final summary reports the average word count and the chapters outside this band.")
+	cmd.Float64Var(&cfg.MinWordRatio, "min-word-ratio", 0.6, "Minimum fraction of --words-per-chapter a chapter must reach; shorter chapters are expanded with follow-up prompts (0 disables).")
+	cmd.StringVar(&cfg.ChapterPlanPath, "chapter-plan", "", "Path to a YAML file mapping chapter numbers to target word counts (e.g. '3: 8000'). Chapters not listed use --words-per-chapter.")
+	cmd.StringVar(&cfg.LogFormat, "log-format", logging.FormatText, "Log format for stderr and the log file: 'text' (free-form lines) or 'json' (one JSON object per line).")
+	cmd.IntVar(&cfg.MaxExpansionRounds, "max-expansion-rounds", 2, "Maximum number of expansion prompts sent for a single chapter that falls below --min-word-ratio.")
+	cmd.IntVar(&cfg.MaxContinuations, "max-continuations", 3, "Maximum number of 'continue from where you left off' prompts sent for a chapter cut off by the output token limit (0 disables).")
+	cmd.IntVar(&cfg.MaxOutputTokens, "max-output-tokens", 0, "Maximum output tokens per Gemini call, capping how long (and costly) a chapter can get (0 uses the model's limit). For thinking models the cap includes thinking tokens. A capped chapter is continued up to --max-continuations times, then trimmed to its last complete sentence.")
+	cmd.StringVar(&cfg.FallbackModel, "fallback-model", "", "Model to retry a chapter with, once, after the configured model exhausts its retries, e.g. 'gemini-2.5-flash' (optional). Chapters written by it are logged and recorded in the status file.")
+	cmd.StringVar(&cfg.StylePrompt, "style", "", "Style prompt sent as the system instruction of every chapter call (optional). Defaults to the style saved in the abstract, then 'style_prompt' from the config file.")
+	cmd.Func("append-prompt", "Instruction appended to every chapter prompt, e.g. 'End each chapter on a cliffhanger' (optional, repeatable). Saved in the status file, so resumed runs keep the same instructions unless the flag is given again.", func(value string) error {
+		if strings.TrimSpace(value) == "" {
+			return fmt.Errorf("--append-prompt must not be empty")
+		}
+		cfg.AppendPrompts = append(cfg.AppendPrompts, value)
+		return nil
+	})
+	addWordListFlag(cmd, "forbid-words", "Comma-separated words no chapter may contain, e.g. 'TODO,lorem ipsum' (optional, repeatable; case-insensitive). A chapter containing one is regenerated with that feedback.", &cfg.ForbidWords)
+	addWordListFlag(cmd, "require-words", "Comma-separated words every chapter must mention, e.g. the protagonist's name (optional, repeatable; case-insensitive). A chapter missing one is regenerated with that feedback.", &cfg.RequireWords)
+	cmd.IntVar(&cfg.MaxValidationRetries, "max-validation-retries", 2, "Maximum times a chapter that fails --forbid-words or --require-words is regenerated. The last draft is kept if it still fails.")
+	cmd.StringVar(&cfg.OutlinePath, "outline", "", "Path to a story outline YAML file (see 'story outline'). Each chapter prompt then carries that chapter's beats instead of the whole abstract, which also cuts input tokens (optional).")
+	cmd.StringVar(&cfg.BiblePath, "bible", "", "Path to a character bible YAML file (see 'story bible') whose details are injected into every chapter prompt (optional).")
+	cmd.IntVar(&cfg.RequestsPerMinute, "rpm", 60, "Maximum Gemini requests per minute across all calls made by the command (0 disables rate limiting).")
+	cmd.Func("seed", "Sampling seed sent with every Gemini call for reproducible generation (optional). The same abstract and seed produce the same story.", func(value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("--seed must be an integer: %w", err)
+		}
+		cfg.Seed = &n
+		return nil
+	})
+	cmd.StringVar(&cfg.Language, "language", "", "Language every chapter must be written in (optional). Defaults to the language saved in the abstract file.")
+	cmd.StringVar(&cfg.PromptTemplatePath, "prompt-template", "", "Path to a Go text/template file replacing the built-in chapter prompt (optional). Available fields: .ChapterNum, .TotalChapters, .WordsPerChapter, .Abstract, .ChapterTitle, .ChapterBeats, .PreviousChapters, .StorySummary, .ChapterRecaps, .CharacterBible, .Language, .ExtensionAfterChapter, .ExtensionLastChapter.")
+	cmd.DurationVar(&cfg.Timeout, "timeout", 10*time.Minute, "Maximum time to wait for each Gemini call, e.g. '5m' or '90s' (0 waits forever). A chapter call that times out is retried like any other failed attempt.")
+	cmd.BoolVar(&cfg.FrontMatter, "frontmatter", false, "Start the story file with a YAML front matter block (title, chapters, model, cost, abstract) for static site generators, instead of the abstract paragraph in the header.")
+	cmd.BoolVar(&cfg.NoAbstractInHeader, "no-abstract-in-header", false, "Leave the 'Story Plan Abstract:' block out of the header of a new story file, keeping only the title/date line and separator. Chapter prompts still include the abstract.")
+	cmd.BoolVar(&cfg.NoAbstractSignature, "no-abstract-signature", false, "Do not send the thought signature saved in the abstract file with Chapter 1. By default chapter 1 continues the reasoning the plan was built with; use this to compare the results without it.")
+	cmd.BoolVar(&cfg.NoProgress, "no-progress", false, "Do not show the 'Chapter 13/40 (32%) — $2.14 spent' progress line on stderr. It is never shown when stdout or stderr is not a terminal, in --quiet mode, or in the log file.")
+	cmd.BoolVar(&cfg.NoSync, "no-sync", false, "Do not fsync the story and status files after each chapter. Faster, but a crash or power loss may lose recently written chapters.")
+	cmd.StringVar(&cfg.OutputDir, "output-dir", "", "Directory for every generated file: the story, its status and table-of-contents sidecars, and the log file (default 'output'). When given, relative --output and --split-dir paths are taken inside it. Created if needed.")
+	cmd.StringVar(&cfg.SplitDir, "split-dir", "", "Directory to also write each chapter to as chapter-001.md, chapter-002.md, ... (optional). Chapters already in the story but missing there are written on resume.")
+	cmd.IntVar(&cfg.HistoryTurns, "history-turns", 0, "Send the last N chapters of this run as conversation turns (prompt and chapter, with their thought signatures) before each chapter prompt, giving the model conversational context (0 disables). Each turn adds its prompt and chapter to the input tokens, so keep N small, especially with --context-mode full, where the prompt already carries the story.")
+	cmd.BoolVar(&cfg.Review, "review", false, "Once all chapters are written, send the complete story to Gemini for a consistency review (continuity errors, plot holes, unresolved threads, by chapter), saved as <output>.review.md. One extra call with the whole story as input; its cost is included in the totals.")
+	cmd.BoolVar(&cfg.ImagePrompts, "image-prompts", false, "After each chapter, ask Gemini for a concise text-to-image prompt describing a key scene of it, saved to <output>.imageprompts.yaml keyed by chapter number for an external image generator. Each prompt costs one small extra call, added to the chapter's cost.")
+	cmd.BoolVar(&cfg.ChapterSummaries, "include-chapter-summaries", false, "After each chapter, ask Gemini for a one-sentence recap of it (saved in the status file) and send the recaps as a compact 'Story so far' bullet list with every later chapter prompt. The previous chapters are still sent according to --context-mode; each recap costs one small extra call.")
+	cmd.IntVar(&cfg.MaxContextTokens, "max-context-tokens", 0, "Keep every chapter prompt under this many input tokens (0 disables). Each prompt is measured with a free CountTokens call before it is sent; one that is over the limit leaves out the oldest chapters of the story so far, keeping the abstract and the latest chapters, and the trimming is logged. Set it below the model's context window to avoid hard API errors deep into long stories.")
+	cmd.StringVar(&cfg.ContextMode, "context-mode", ContextModeFull, "How earlier chapters are given to each chapter prompt: 'full' sends the whole story so far; 'summary' sends a rolling summary plus the last two chapters, cutting input tokens on long stories at some cost to continuity.")
+	cmd.StringVar(&cfg.TOC, "toc", "", "Write a table of contents ('Chapter N — Title' with word counts) once all chapters are done: 'file' saves it as <output>.toc.md, 'inline' inserts it after the story header (optional).")
+	cmd.StringVar(&cfg.PricingFile, "pricing-file", "", "Path to a pricing JSON file overriding the built-in model prices (optional). Defaults to the GEMINI_PRICING_FILE env var.")
+	cmd.StringVar(&cfg.LedgerPath, "ledger", "", "Path to a CSV ledger to append a row with this run's model, tokens, cost, and output file to, e.g. 'ledger.csv' to track spend across runs (optional). Created with a header row if missing; safe to share between commands running at the same time.")
+	addCostFlags(cmd, &cfg.CostFormat)
+	addHTTPFlags(cmd, &cfg.HTTP)
+	addFileModeFlag(cmd, &cfg.FileMode)
+	addCacheFlag(cmd, &cfg.CacheDir)
+	cmd.BoolVar(&cfg.PrintPrompt, "print-prompt", false, "Log the fully assembled prompt of every chapter (with the style prompt and --append-prompt instructions) before it is sent, for debugging a chapter that came out wrong.")
+	cmd.BoolVar(&cfg.Mock, "mock", false, "Answer every Gemini call locally with deterministic placeholder (lorem ipsum) chapters of the requested length instead of calling the API: no API key is needed and the cost is 0. Exercises the file writing, resume, and export pipeline offline, e.g. for demos.")
+	addTimestampHeaderFlag(cmd, &cfg.NoTimestampHeader)
+	addLayoutFlags(cmd, &cfg.ChapterHeaderFormat, &cfg.Separator)
+	cmd.BoolVar(&cfg.NoChapterTitles, "no-chapter-titles", false, "Ask for chapters without a title line, so the story file has bare '## Chapter N' headings and the table of contents lists chapter numbers only. Saved in the status file, so resumed runs keep it.")
+	addSamplingFlags(cmd, &cfg.Temperature, &cfg.TopP)
+	addThinkingBudgetFlag(cmd, &cfg.ThinkingBudget)
+	cfg.Verbosity.Register(cmd)
+	return cmd
+}
+
+// addTimestampHeaderFlag registers --no-timestamp-header, shared by the commands that write a
+// story header.
+func addTimestampHeaderFlag(cmd *flag.FlagSet, noTimestamp *bool) {
+	cmd.BoolVar(noTimestamp, "no-timestamp-header", false, "Leave the creation time out of the '--- Full Story ---' title line of a new story file (and the date out of 'story continue' extension notes), so repeated runs write byte-identical headers, also in --frontmatter mode.")
+}
+
+// addLayoutFlags registers --chapter-header-format and --separator, which set the layout of the
+// story file.
+func addLayoutFlags(cmd *flag.FlagSet, headingFormat, separator *string) {
+	cmd.StringVar(headingFormat, "chapter-header-format", "", "Go text/template for the chapter heading lines of the story file, with .Num and .Title, e.g. '# Chapter {{.Num}}: {{.Title}}' or 'Chapter {{.Num}}' (default '## Chapter {{.Num}}'). A title in the heading is dropped from the chapter text. Saved in the status file, so resumed runs keep it.")
+	cmd.StringVar(separator, "separator", "", "Line that ends the header block of a plain text story file, e.g. '* * *' (default: a line of dashes). Saved in the status file, so resumed runs keep it.")
+}
+
+// addSamplingFlags registers --temperature and --top-p, which are left nil unless given.
+func addSamplingFlags(cmd *flag.FlagSet, temperature, topP **float32) {
+	float32Flag := func(dst **float32, name string) func(string) error {
+		return func(value string) error {
+			f, err := strconv.ParseFloat(value, 32)
+			if err != nil {
+				return fmt.Errorf("--%s must be a number: %w", name, err)
+			}
+			v := float32(f)
+			*dst = &v
+			return nil
+		}
+	}
+	cmd.Func("temperature", "Sampling temperature between 0 and 2; higher is more creative (optional). Overrides 'temperature' in the config file; defaults to the model's own setting.", float32Flag(temperature, "temperature"))
+	cmd.Func("top-p", "Nucleus sampling top-p between 0 and 1 (optional). Overrides 'top_p' in the config file; defaults to the model's own setting.", float32Flag(topP, "top-p"))
+}
+
+// addThinkingBudgetFlag registers --thinking-budget, which is left nil unless given.
+func addThinkingBudgetFlag(cmd *flag.FlagSet, budget **int32) {
+	cmd.Func("thinking-budget", "Thinking tokens per Gemini call: -1 for dynamic, 0 to turn thinking off where the model allows it, or a token count such as 512 to save cost (optional). Overrides 'thinking_budget' in the config file and wins over thinking_level.", func(value string) error {
+		n, err := strconv.ParseInt(value, 10, 32)
+		if err != nil {
+			return fmt.Errorf("--thinking-budget must be an integer: %w", err)
+		}
+		v := int32(n)
+		*budget = &v
+		return nil
+	})
+}
+
+// addCostFlags registers the flags controlling how costs are displayed.
+func addCostFlags(cmd *flag.FlagSet, format *aiEndpoint.CostFormat) {
+	cmd.StringVar(&format.Currency, "currency", aiEndpoint.DefaultCostFormat.Currency, "Currency code costs are displayed in, e.g. 'EUR'. Use with --exchange-rate; JSON logs always carry the raw USD cost.")
+	cmd.Float64Var(&format.ExchangeRate, "exchange-rate", aiEndpoint.DefaultCostFormat.ExchangeRate, "Units of --currency per 1 USD, used to convert displayed costs.")
+	cmd.IntVar(&format.Decimals, "cost-decimals", aiEndpoint.DefaultCostFormat.Decimals, "Number of decimal places shown for costs.")
+}
+
+// addHTTPFlags registers --proxy, --ca-cert and --http-timeout, which configure the HTTP client
+// used for Gemini calls.
+func addHTTPFlags(cmd *flag.FlagSet, opts *aiEndpoint.HTTPClientOptions) {
+	cmd.StringVar(&opts.Proxy, "proxy", "", "Proxy URL for Gemini requests, e.g. 'http://proxy.corp:3128' (optional). Defaults to the HTTPS_PROXY/HTTP_PROXY environment variables.")
+	cmd.StringVar(&opts.CACertPath, "ca-cert", "", "Path to a PEM bundle of extra CA certificates to trust, e.g. a corporate TLS-inspecting proxy's (optional).")
+	cmd.DurationVar(&opts.Timeout, "http-timeout", 0, "Overall limit for each HTTP request to Gemini, e.g. '15m' (0 means no limit). Unlike --timeout, it also applies at the transport level.")
+}
+
+// addFileModeFlag registers --file-mode, the octal permission of the files a command creates.
+func addFileModeFlag(cmd *flag.FlagSet, mode *string) {
+	cmd.StringVar(mode, "file-mode", fmt.Sprintf("%04o", file.DefaultFileMode), "Octal permission of the files the command creates, e.g. '0600' to keep the story, its sidecars and the log private. The umask still applies and existing files keep their permissions. Must include owner read and write (0600).")
+}
+
+// addCacheFlag registers --cache-dir, the directory of the opt-in Gemini response cache.
+func addCacheFlag(cmd *flag.FlagSet, dir *string) {
+	cmd.StringVar(dir, "cache-dir", "", "Directory to cache Gemini responses in, keyed by a hash of the model, prompt, history, and settings (optional, for development). Repeated identical calls are answered from it at no cost; errors are never cached. Delete the directory to clear it.")
+}
+
+// applyFileMode validates --file-mode and makes it the permission of every file created afterwards.
+func applyFileMode(value string) error {
+	mode, err := file.ParseFileMode(value)
+	if err != nil {
+		return fmt.Errorf("invalid --file-mode: %w", err)
+	}
+	file.SetFileMode(mode)
+	return nil
+}
+
+// saveProcessSettings records the process-wide settings applyGenerationFlags changes (pricing,
+// cost display, and file mode) and returns a function restoring them, so a GenerateStory call
+// leaves them as it found them.
+func saveProcessSettings() (restore func()) {
+	restorePricing := aiEndpoint.SavePricing()
+	restoreCostFormat := aiEndpoint.SaveCostFormat()
+	mode := file.FileMode()
+	return func() {
+		restorePricing()
+		restoreCostFormat()
+		file.SetFileMode(mode)
+	}
+}
+
+// checkGenerationFlags checks the values of the shared generation flags. It only reads cfg and
+// changes no settings, so a bad flag is rejected before the log file is opened or any global
+// setting is applied; applyGenerationFlags does the rest.
+func checkGenerationFlags(cfg FullStoryConfig) error {
+	if cfg.WordsPerChapter <= 0 {
+		return fmt.Errorf("--words-per-chapter must be a positive number")
+	}
+	if cfg.AbstractFormat != "" {
+		if err := file.ValidateAbstractFormat(cfg.AbstractFormat); err != nil {
+			return fmt.Errorf("invalid --abstract-format: %w", err)
+		}
+	}
+	if cfg.MinWordRatio < 0 || cfg.MinWordRatio > 1 {
+		return fmt.Errorf("--min-word-ratio must be between 0 and 1")
+	}
+	if cfg.WordTolerance < 0 || cfg.WordTolerance > 1 {
+		return fmt.Errorf("--word-tolerance must be between 0 and 1")
+	}
+	if cfg.MaxExpansionRounds < 0 {
+		return fmt.Errorf("--max-expansion-rounds must not be negative")
+	}
+	if cfg.MaxContinuations < 0 {
+		return fmt.Errorf("--max-continuations must not be negative")
+	}
+	if cfg.TotalChapters < 0 {
+		return fmt.Errorf("--total-chapters must not be negative")
+	}
+	if cfg.MaxOutputTokens < 0 {
+		return fmt.Errorf("--max-output-tokens must not be negative")
+	}
+	if cfg.Timeout < 0 {
+		return fmt.Errorf("--timeout must not be negative")
+	}
+	if cfg.MaxValidationRetries < 0 {
+		return fmt.Errorf("--max-validation-retries must not be negative")
+	}
+	if cfg.HistoryTurns < 0 {
+		return fmt.Errorf("--history-turns must not be negative")
+	}
+	if cfg.MaxContextTokens < 0 {
+		return fmt.Errorf("--max-context-tokens must not be negative")
+	}
+	if err := validateAppendTo(cfg.AppendTo); err != nil {
+		return err
+	}
+	if err := aiEndpoint.ValidateSampling(cfg.Temperature, cfg.TopP); err != nil {
+		return err
+	}
+	if err := aiEndpoint.ValidateThinkingBudget(cfg.ThinkingBudget); err != nil {
+		return err
+	}
+	if err := logging.ValidateFormat(cfg.LogFormat); err != nil {
+		return fmt.Errorf("invalid --log-format: %w", err)
+	}
+	if err := validateContextMode(cfg.ContextMode); err != nil {
+		return err
+	}
+	if err := validateTOCMode(cfg.TOC); err != nil {
+		return err
+	}
+	if _, err := newStoryLayout(cfg.ChapterHeaderFormat, cfg.Separator); err != nil {
+		return err
+	}
+	if cfg.RequestsPerMinute < 0 {
+		return fmt.Errorf("--rpm must not be negative")
+	}
+	if err := aiEndpoint.ValidateCostFormat(cfg.CostFormat); err != nil {
+		return fmt.Errorf("invalid cost display flags: %w", err)
+	}
+	if cfg.FileMode != "" {
+		if _, err := file.ParseFileMode(cfg.FileMode); err != nil {
+			return fmt.Errorf("invalid --file-mode: %w", err)
+		}
+	}
+	return nil
+}
+
+// applyGenerationFlags applies the shared generation flags once checkGenerationFlags has accepted
+// them: it resolves paths inside --output-dir, loads the prompt template, outline, character
+// bible, and chapter plan, and prepares the HTTP client, response cache, and mock client the calls
+// of this run use. Pricing, cost display, and file mode are process-wide settings; GenerateStory
+// restores them with saveProcessSettings when it returns.
+func applyGenerationFlags(cfg *FullStoryConfig) error {
+	cfg.OutputPath = resolveInOutputDir(cfg.OutputDir, cfg.OutputPath)
+	cfg.SplitDir = resolveInOutputDir(cfg.OutputDir, cfg.SplitDir)
+	if cfg.AbstractFormat == "" {
+		cfg.AbstractFormat = file.AbstractFormatAuto
+	}
+	resolveChapterValidator(cfg)
+	if cfg.FallbackModel != "" {
+		cfg.FallbackModel = aiEndpoint.CanonicalModelName(cfg.FallbackModel)
+	}
+	cfg.Limiter = aiEndpoint.NewRateLimiter(cfg.RequestsPerMinute)
+	promptTemplate, err := loadChapterPromptTemplate(cfg.PromptTemplatePath)
+	if err != nil {
+		return err
+	}
+	cfg.promptTemplate = promptTemplate
+	if cfg.SplitDir != "" {
+		if err := os.MkdirAll(cfg.SplitDir, 0755); err != nil {
+			return fmt.Errorf("failed to create --split-dir '%s': %w", cfg.SplitDir, err)
+		}
+	}
+	if err := aiEndpoint.LoadPricingOverrides(cfg.PricingFile); err != nil {
+		return err
+	}
+	if err := aiEndpoint.SetCostFormat(cfg.CostFormat); err != nil {
+		return fmt.Errorf("invalid cost display flags: %w", err)
+	}
+	if cfg.httpClient, err = aiEndpoint.ConfiguredHTTPClient(cfg.HTTP); err != nil {
+		return fmt.Errorf("invalid HTTP client flags: %w", err)
+	}
+	if err := applyFileMode(cfg.FileMode); err != nil {
+		return err
+	}
+	if cfg.CacheDir, err = aiEndpoint.PrepareCacheDir(cfg.CacheDir); err != nil {
+		return err
+	}
+	cfg.client = nil
+	if cfg.Mock {
+		cfg.client = aiEndpoint.NewMockClient()
+	}
+	if cfg.OutlinePath != "" {
+		outline, err := file.ReadStoryOutline(cfg.OutlinePath)
+		if err != nil {
+			return err
+		}
+		cfg.Outline = outline
+		log.Printf("Loaded story outline with %d chapters from '%s'.", len(outline.Chapters), cfg.OutlinePath)
+	}
+	if cfg.BiblePath != "" {
+		bible, err := file.ReadCharacterBible(cfg.BiblePath)
+		if err != nil {
+			return err
+		}
+		bibleYAML, err := yaml.Marshal(bible)
+		if err != nil {
+			return fmt.Errorf("failed to format character bible for prompts: %w", err)
+		}
+		cfg.BibleText = string(bibleYAML)
+	}
+	if cfg.ChapterPlanPath != "" {
+		plan, err := file.ReadChapterPlan(cfg.ChapterPlanPath)
+		if err != nil {
+			return err
+		}
+		cfg.ChapterPlan = plan
+	}
+	return nil
+}
+
+// parseAndValidateFlags parses command-line flags and performs initial validation.
+func parseAndValidateFlags(args []string) (FullStoryConfig, error) {
+	var cfg FullStoryConfig
+	cmd := newStoryFlagSet("story", &cfg)
+	cmd.StringVar(&cfg.AbstractFilePath, "abstract", "", "Path to the abstract file (text, json, or yaml) generated by the 'abstract' command, or '-' to read it from stdin.")
+	cmd.StringVar(&cfg.FromInstruction, "from-instruction", "", "Story idea to generate the abstract from in the same run, instead of --abstract. The abstract is kept in memory and its chapter count is reused, so no separate count call is made; the cost covers both phases.")
+	cmd.StringVar(&cfg.SaveAbstractPath, "save-abstract", "", "With --from-instruction, also save the generated abstract to this path, e.g. to resume the story later with --abstract (optional). Story file names are derived from it like from --abstract.")
+	cmd.IntVar(&cfg.TotalChapters, "total-chapters", 0, "Total number of chapters to generate (optional). When positive, the chapter count stored in the abstract is ignored and Gemini is not asked to count the chapters.")
+	cmd.StringVar(&cfg.OutputPath, "output", "", "Path to save the generated full story file (default: fulltext-yyyy-mm-dd-hh-mm-ss.txt based on abstract filename). The extension selects the format: .txt, .md, or .html.")
+	cmd.StringVar(&cfg.AppendTo, "append-to", "", "Also append the story to this existing .txt or .md file (e.g. an anthology), after a separator and the story's own header. The chapters already in that file are never read or counted; the story still starts at Chapter 1 and is resumable through --output's status file.")
+	cmd.BoolVar(&cfg.Overwrite, "overwrite", false, "Start a fresh story from Chapter 1 even when --output and its status file exist, truncating the story and discarding the saved progress instead of resuming.")
+	cmd.BoolVar(&cfg.CreateDirs, "create-dirs", false, "Create the directory of --output when it does not exist. Without it, a missing directory fails before any paid API call. The default 'output' directory and --output-dir are always created.")
+	cmd.BoolVar(&cfg.PrintPromptOnly, "print-prompt-only", false, "Print the prompt of every chapter still to be written to stdout and exit without calling the API or writing the story, for prompt engineering. Each prompt carries the story as it is now. Needs no API key, but the chapter count must come from --total-chapters or the abstract file.")
+	cmd.IntVar(&cfg.ResumeFrom, "resume-from", 0, "Redo the story from this chapter on (optional): Chapter N and every later chapter are cut from --output and its status file and written again, e.g. after editing the abstract mid-story. Chapters 1 to N-1 are kept as context.")
+	cmd.BoolVar(&cfg.SingleShot, "single-shot", false, "Write the whole story in one Gemini call, split into chapters at the '## Chapter N' lines of the response, for flash fiction and other short works. Skips the per-chapter calls and rate limiting; --prompt-template, expansion, continuation, and chapter validation do not apply. Chapters the response misses or cuts off are then written one by one.")
+	cmd.IntVar(&cfg.SingleShotMaxChapters, "single-shot-max-chapters", 0, "Use --single-shot automatically when the story has at most this many chapters (optional; 0 disables).")
+	cmd.BoolVar(&cfg.ResumeOnly, "resume-only", false, "Only resume an existing story: fail when --output or its status file does not exist instead of starting a new story. Useful for scripted resume jobs.")
 
 	if err := cmd.Parse(args); err != nil {
 		return cfg, fmt.Errorf("failed to parse story subcommand flags: %w", err)
 	}
+	if err := cfg.Verbosity.Apply(); err != nil {
+		return cfg, err
+	}
 
-	if cfg.AbstractFilePath == "" {
-		return cfg, fmt.Errorf("--abstract is required for story generation")
+	if cfg.AbstractFilePath == "" && cfg.FromInstruction == "" {
+		return cfg, fmt.Errorf("--abstract or --from-instruction is required for story generation")
 	}
-	if cfg.WordsPerChapter <= 0 {
-		return cfg, fmt.Errorf("--words-per-chapter must be a positive number")
+	// GenerateStory checks them again for library callers, but a bad flag is rejected here,
+	// before executeGenerate opens the log file.
+	if err := checkStoryFlags(cfg); err != nil {
+		return cfg, err
 	}
 	return cfg, nil
 }
 
-// setupLogging configures file-based logging. It returns the opened log file, which the caller must close.
-func setupLogging(abstractFilePath string) (*os.File, error) {
+// setupLogging configures file-based logging in the requested format. It returns the opened log file,
+// which the caller must close, and the Logger to use for structured events. A usable Logger is
+// returned even when the log file cannot be opened.
+func setupLogging(abstractFilePath, logFormat, outputDir string) (*os.File, logging.Logger, error) {
 	originalLogOutput := log.Writer()
 	originalLogFlags := log.Flags()
 
 	// Ensure output directory exists
-	outputDir := "output"
+	if outputDir == "" {
+		outputDir = defaultOutputDir
+	}
 	if err := os.MkdirAll(outputDir, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create output directory '%s': %w", outputDir, err)
+		return nil, newLogger(logging.Console(os.Stderr), logFormat), fmt.Errorf("failed to create output directory '%s': %w", outputDir, err)
 	}
 
-	abstractFileName := filepath.Base(abstractFilePath)
-	logFileName := ""
-	if strings.HasPrefix(strings.ToLower(abstractFileName), "abstract-") && (strings.HasSuffix(strings.ToLower(abstractFileName), ".txt") || strings.HasSuffix(strings.ToLower(abstractFileName), ".json") || strings.HasSuffix(strings.ToLower(abstractFileName), ".yaml") || strings.HasSuffix(strings.ToLower(abstractFileName), ".yml")) {
-		logFileName = strings.Replace(abstractFileName, "abstract-", "log-", 1)
-		logFileName = strings.Replace(logFileName, ".txt", ".log", 1)
-		logFileName = strings.Replace(logFileName, ".json", ".log", 1)
-		logFileName = strings.Replace(logFileName, ".yaml", ".log", 1)
-		logFileName = strings.Replace(logFileName, ".yml", ".log", 1)
-	} else {
+	logFileName := DeriveOutputName(abstractFilePath, OutputKindLog)
+	if logFileName == "" {
 		timestamp := time.Now().Format("2006-01-02-15-04-05")
 		logFileName = fmt.Sprintf("story-log-%s.log", timestamp)
 	}
-	
+
 	logFilePath := filepath.Join(outputDir, logFileName)
 
-	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	logFile, err := os.OpenFile(logFilePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, file.FileMode())
 	if err != nil {
 		log.Printf("Warning: Failed to open log file '%s': %v. Logging will continue to stderr.", logFilePath, err)
 		log.SetOutput(originalLogOutput) // Ensure logging goes to original output if file fails
 		log.SetFlags(originalLogFlags)
-		return nil, fmt.Errorf("failed to open log file: %w", err)
+		return nil, newLogger(logging.Console(os.Stderr), logFormat), fmt.Errorf("failed to open log file: %w", err)
 	}
 
-	mw := io.MultiWriter(os.Stderr, logFile)
-	log.SetOutput(mw)
-	log.Printf("Logging to file: %s", logFilePath)
-	return logFile, nil
+	mw := io.MultiWriter(logging.Console(os.Stderr), logFile)
+	logger := newLogger(mw, logFormat)
+	logger.Info("log_file", fmt.Sprintf("Logging to file: %s", logFilePath), logging.Fields{"path": logFilePath})
+	return logFile, logger, nil
 }
 
-// loadGeminiAPIConfig loads Gemini API key, model name, and thinking level.
-func loadGeminiAPIConfig(configPath string) (string, string, string, error) {
-	geminiConfigDetails := aiEndpoint.LoadGeminiConfigWithFallback(configPath)
-	if geminiConfigDetails.Err != nil {
-		return "", "", "", geminiConfigDetails.Err
+// newLogger points the standard log package at w and returns the Logger for the given format.
+// In JSON mode, free-form log lines from other packages are wrapped as JSON events too.
+func newLogger(w io.Writer, logFormat string) logging.Logger {
+	if logFormat == logging.FormatJSON {
+		jsonLogger := logging.NewJSONLogger(w)
+		log.SetFlags(0)
+		log.SetOutput(jsonLogger.Writer())
+		return jsonLogger
 	}
-	return geminiConfigDetails.APIKey, geminiConfigDetails.ModelName, geminiConfigDetails.ThinkingLevel, nil
+	log.SetOutput(w)
+	return logging.NewTextLogger()
 }
 
-// readAbstractAndDetermineTotalChapters reads the abstract file and gets the total planned chapters from Gemini.
-func readAbstractAndDetermineTotalChapters(cfg FullStoryConfig) (string, int, int, int, float64, error) {
-	abstractContent, _, err := file.ReadAbstractFile(cfg.AbstractFilePath) // thoughtSignature is currently unused
+// startStoryLogging configures logging for a story subcommand, stores the Logger on cfg,
+// and returns a function that closes the log file and restores the original log settings.
+func startStoryLogging(cfg *FullStoryConfig) func() {
+	originalLogOutput := log.Writer()
+	originalLogFlags := log.Flags()
+
+	logFile, logger, err := setupLogging(abstractNamePath(*cfg), cfg.LogFormat, cfg.OutputDir)
 	if err != nil {
-		return "", 0, 0, 0, 0, fmt.Errorf("failed to read and parse abstract file '%s': %w", cfg.AbstractFilePath, err)
+		// setupLogging already logs a warning and ensures logging goes to stderr.
+	}
+	cfg.Logger = logger
+
+	return func() {
+		if logFile != nil {
+			logFile.Close()
+		}
+		log.SetOutput(originalLogOutput)
+		log.SetFlags(originalLogFlags)
+	}
+}
+
+// loadGeminiAPIConfig loads the Gemini API key, model name, thinking level, and config style prompt into cfg.
+func loadGeminiAPIConfig(cfg *FullStoryConfig) error {
+	if cfg.Mock {
+		cfg.APIKey = aiEndpoint.MockAPIKey
+		cfg.ModelName = aiEndpoint.DefaultGeminiModel
+		return nil
+	}
+	geminiConfigDetails := aiEndpoint.LoadGeminiConfigWithKeyFile(cfg.ConfigPath, cfg.APIKeyFile)
+	if geminiConfigDetails.Err != nil {
+		// --print-prompt-only sends nothing, so it works without an API key.
+		if !cfg.PrintPromptOnly || !errors.Is(geminiConfigDetails.Err, aiEndpoint.ErrNoAPIKey) {
+			return geminiConfigDetails.Err
+		}
+		if geminiConfigDetails.ModelName == "" {
+			geminiConfigDetails.ModelName = aiEndpoint.DefaultGeminiModel
+		}
+	}
+	cfg.APIKey = geminiConfigDetails.APIKey
+	cfg.ModelName = geminiConfigDetails.ModelName
+	cfg.ThinkingLevel = geminiConfigDetails.ThinkingLevel
+	cfg.configStylePrompt = geminiConfigDetails.StylePrompt
+	// Flags take precedence over the config file's sampling settings.
+	if cfg.Temperature == nil {
+		cfg.Temperature = geminiConfigDetails.Temperature
+	}
+	if cfg.TopP == nil {
+		cfg.TopP = geminiConfigDetails.TopP
+	}
+	if cfg.ThinkingBudget == nil {
+		cfg.ThinkingBudget = geminiConfigDetails.ThinkingBudget
+	}
+	return nil
+}
+
+// resolveStylePrompt picks the style prompt for the run: the --style flag wins, then the style
+// saved in the abstract (so resumed and continued runs keep the voice), then the config file.
+func resolveStylePrompt(cfg *FullStoryConfig, abstractStylePrompt string) {
+	switch {
+	case cfg.StylePrompt != "":
+	case abstractStylePrompt != "":
+		cfg.StylePrompt = abstractStylePrompt
+	default:
+		cfg.StylePrompt = cfg.configStylePrompt
+	}
+	if cfg.StylePrompt != "" {
+		log.Printf("Using style prompt: %s", cfg.StylePrompt)
+	}
+}
+
+// resolveLanguage picks the chapter language: the --language flag wins, then the language saved in the abstract.
+func resolveLanguage(cfg *FullStoryConfig, abstractLanguage string) {
+	if cfg.Language == "" {
+		cfg.Language = abstractLanguage
+	}
+	if cfg.Language != "" {
+		log.Printf("Writing chapters in: %s", cfg.Language)
+	}
+}
+
+// readAbstract reads the abstract and its metadata from the given path, or from stdin when the path is
+// stdinAbstractPath, in format (--abstract-format). "" or file.AbstractFormatAuto picks the format from
+// the file extension, and reads stdin as stdinAbstractFormat.
+func readAbstract(abstractFilePath, format string) (file.AbstractOutput, error) {
+	if format == "" {
+		format = file.AbstractFormatAuto
+	}
+	if abstractFilePath == stdinAbstractPath {
+		if format == file.AbstractFormatAuto {
+			format = stdinAbstractFormat
+		}
+		log.Printf("Reading abstract from stdin (format: %s).", format)
+		return file.ReadAbstractOutputReader(os.Stdin, format)
+	}
+	return file.ReadAbstractOutputFileAs(abstractFilePath, format)
+}
+
+// readAbstractAndDetermineTotalChapters reads the abstract file into cfg and determines the total planned chapters,
+// using --total-chapters when set, then the count cached in the abstract file, and asking Gemini otherwise.
+func readAbstractAndDetermineTotalChapters(cfg *FullStoryConfig) (int, int, int, float64, error) {
+	abstractData := file.AbstractOutput{Abstract: cfg.AbstractContent}
+	if cfg.generatedAbstract != nil {
+		abstractData = *cfg.generatedAbstract
+	} else if cfg.AbstractFilePath != "" {
+		var err error
+		abstractData, err = readAbstract(cfg.AbstractFilePath, cfg.AbstractFormat)
+		if err != nil {
+			return 0, 0, 0, 0, fmt.Errorf("failed to read and parse abstract file '%s': %w", cfg.AbstractFilePath, err)
+		}
+	}
+	abstractContent := abstractData.Abstract
+	cfg.AbstractContent = abstractContent
+	cfg.AbstractSignature = abstractData.ThoughtSignature
+	resolveStylePrompt(cfg, abstractData.StylePrompt)
+	resolveLanguage(cfg, abstractData.Language)
+
+	if cfg.TotalChapters > 0 {
+		log.Printf("Using --total-chapters %d; skipping the Gemini chapter count call.", cfg.TotalChapters)
+		if abstractData.ChapterCount > 0 && abstractData.ChapterCount != cfg.TotalChapters {
+			log.Printf("Warning: --total-chapters %d differs from the chapter count %d stored in the abstract file.", cfg.TotalChapters, abstractData.ChapterCount)
+		}
+		logging.Printf(logging.VerbosityNormal, "Total chapters set by --total-chapters for story generation: %d\n", cfg.TotalChapters)
+		return cfg.TotalChapters, 0, 0, 0, nil
+	}
+	if abstractData.ChapterCount > 0 {
+		source := "stored in the abstract file"
+		if cfg.generatedAbstract != nil {
+			source = "determined during abstract generation"
+		}
+		log.Printf("Using chapter count %d %s; skipping the Gemini chapter count call.", abstractData.ChapterCount, source)
+		logging.Printf(logging.VerbosityNormal, "Total chapters %s for story generation: %d\n", source, abstractData.ChapterCount)
+		return abstractData.ChapterCount, 0, 0, 0, nil
 	}
 
+	if cfg.PrintPromptOnly {
+		return 0, 0, 0, 0, fmt.Errorf("--print-prompt-only does not call the API, so the chapter count must come from --total-chapters or the abstract file")
+	}
 	log.Printf("Sending abstract to Gemini to get the total number of chapters planned...")
 	getChapterCountForStoryInput := GetChapterCountForStoryInput{
-		APIKey:        cfg.APIKey,
-		ModelName:     cfg.ModelName,
-		ThinkingLevel: cfg.ThinkingLevel,
-		Abstract:      abstractContent,
+		APIKey:         cfg.APIKey,
+		ModelName:      cfg.ModelName,
+		ThinkingLevel:  cfg.ThinkingLevel,
+		Abstract:       abstractContent,
+		Limiter:        cfg.Limiter,
+		Seed:           cfg.Seed,
+		Ctx:            cfg.ctx,
+		Timeout:        cfg.Timeout,
+		ThinkingBudget: cfg.ThinkingBudget,
+		Client:         cfg.client,
+		HTTPClient:     cfg.httpClient,
+		CacheDir:       cfg.CacheDir,
 	}
 	chapterCountPlanResult := getChapterCountFromGeminiForStory(getChapterCountForStoryInput)
 	if chapterCountPlanResult.Err != nil {
-		return "", 0, 0, 0, 0, fmt.Errorf("failed to get total chapter count from Gemini for story generation: %w", chapterCountPlanResult.Err)
+		return 0, 0, 0, 0, fmt.Errorf("failed to get total chapter count from Gemini for story generation: %w", chapterCountPlanResult.Err)
 	}
 
 	totalChapters := chapterCountPlanResult.Count
 	if totalChapters == 0 {
-		return "", 0, 0, 0, 0, fmt.Errorf("Gemini returned 0 planned chapters for the abstract. Cannot proceed with story generation.")
+		return 0, 0, 0, 0, fmt.Errorf("Gemini returned 0 planned chapters for the abstract. Cannot proceed with story generation.")
 	}
-	log.Printf("Chapter plan determination complete. Input tokens: %d, Output tokens: %d, Cost: $%.6f", chapterCountPlanResult.InputTokens, chapterCountPlanResult.OutputTokens, chapterCountPlanResult.Cost)
-	fmt.Printf("Total chapters identified by Gemini for story generation: %d\n", totalChapters)
+	log.Printf("Chapter plan determination complete. Input tokens: %d, Output tokens: %d, Cost: %s", chapterCountPlanResult.InputTokens, chapterCountPlanResult.OutputTokens, aiEndpoint.FormatCost(chapterCountPlanResult.Cost))
+	logging.Printf(logging.VerbosityNormal, "Total chapters identified by Gemini for story generation: %d\n", totalChapters)
 	log.Printf("Total chapters identified by Gemini for story generation: %d", totalChapters)
 
-	return abstractContent, totalChapters, chapterCountPlanResult.InputTokens, chapterCountPlanResult.OutputTokens, chapterCountPlanResult.Cost, nil
+	return totalChapters, chapterCountPlanResult.InputTokens, chapterCountPlanResult.OutputTokens, chapterCountPlanResult.Cost, nil
 }
 
-// determineOutputFilePath calculates the final output file path.
-func determineOutputFilePath(abstractFilePath, outputPathFlag string) string {
+// determineOutputFilePath calculates the final output file path. A name derived from the abstract
+// is placed in outputDir, or in "output" when outputDir is empty.
+func determineOutputFilePath(abstractFilePath, outputPathFlag, outputDir string) string {
 	if outputPathFlag != "" {
 		return outputPathFlag
 	}
 
-	outputDir := "output"
-	// Note: Directory creation is handled in setupLogging/Execute or main flow, but good to be safe if called independently.
-	// In this flow, we assume the directory might exist or will be created when writing. 
-	// Actually, initializeStoryState writes to status file, and saveStateToFiles writes to output file.
-	// We should probably ensure directory exists here or before writing.
-	// Since setupLogging ensures it, we are likely fine for this execution flow.
-
-	re := strings.NewReplacer("abstract-", "fulltext-")
-	baseName := filepath.Base(abstractFilePath)
-	if strings.HasPrefix(strings.ToLower(baseName), "abstract-") {
-		finalOutputPath := re.Replace(baseName)
-		finalOutputPath = strings.Replace(finalOutputPath, ".json", ".txt", 1)
-		finalOutputPath = strings.Replace(finalOutputPath, ".yaml", ".txt", 1)
-		finalOutputPath = strings.Replace(finalOutputPath, ".yml", ".txt", 1)
-		return filepath.Join(outputDir, finalOutputPath)
+	if outputDir == "" {
+		outputDir = defaultOutputDir
+	}
+	// The directory is created by the caller before anything is written to it.
+	if name := DeriveOutputName(abstractFilePath, OutputKindFullText); name != "" {
+		return filepath.Join(outputDir, name)
 	}
 
 	timestamp := time.Now().Format("2006-01-02-15-04-05")
 	return filepath.Join(outputDir, fmt.Sprintf("fulltext-%s.txt", timestamp))
 }
 
+// resolveInOutputDir places a relative path inside outputDir. Absolute paths, empty paths, and
+// any path when outputDir is empty are returned unchanged.
+func resolveInOutputDir(outputDir, path string) string {
+	if outputDir == "" || path == "" || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(outputDir, path)
+}
+
 // determineStatusFilePath calculates the status file path based on the output file path.
 func determineStatusFilePath(outputFilePath string) string {
 	dir := filepath.Dir(outputFilePath)
@@ -252,10 +900,49 @@ func determineStatusFilePath(outputFilePath string) string {
 	return filepath.Join(dir, newBase)
 }
 
-// initializeStoryState loads existing progress from the status file or initializes a new state.
-func initializeStoryState(statusFilePath string, abstractContent string) (StoryProgressState, error) {
+// sidecarFilePath derives the path of a file stored next to the story output, replacing the
+// output's extension with suffix (e.g. "story.txt" and ".bible.yaml" give "story.bible.yaml").
+func sidecarFilePath(outputFilePath, suffix string) string {
+	return strings.TrimSuffix(outputFilePath, filepath.Ext(outputFilePath)) + suffix
+}
+
+// prepareOutputForRun applies --overwrite and --resume-only before the story state is loaded.
+// --overwrite removes the status file, so initializeStoryState starts a new story, and truncates the
+// story file; --resume-only fails unless both the story and its status file exist.
+func prepareOutputForRun(cfg FullStoryConfig, outputFilePath, statusFilePath string) error {
+	switch {
+	case cfg.Overwrite:
+		if err := os.Remove(statusFilePath); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove status file '%s' for --overwrite: %w", statusFilePath, err)
+		}
+		f, err := os.OpenFile(outputFilePath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, file.FileMode())
+		if err != nil {
+			return fmt.Errorf("failed to truncate story file '%s' for --overwrite: %w", outputFilePath, err)
+		}
+		if err := f.Close(); err != nil {
+			return fmt.Errorf("failed to truncate story file '%s' for --overwrite: %w", outputFilePath, err)
+		}
+		log.Printf("--overwrite: Discarded any existing story in '%s'. Starting from Chapter 1.", outputFilePath)
+	case cfg.ResumeOnly:
+		if _, err := os.Stat(outputFilePath); err != nil {
+			return fmt.Errorf("--resume-only: no story to resume: %w", err)
+		}
+		if _, err := os.Stat(statusFilePath); err != nil {
+			return fmt.Errorf("--resume-only: story '%s' has no status file to resume from: %w", outputFilePath, err)
+		}
+	}
+	return nil
+}
+
+// initializeStoryState loads existing progress from the status file or initializes a new state
+// whose story text starts with header.
+func initializeStoryState(statusFilePath string, header string) (StoryProgressState, error) {
 	state := StoryProgressState{
 		FirstNewChapter: 1,
+		Usage:           &aiEndpoint.CostTracker{},
+		Planning:        &aiEndpoint.CostTracker{},
+		Summaries:       &aiEndpoint.CostTracker{},
+		Reviews:         &aiEndpoint.CostTracker{},
 	}
 
 	if _, err := os.Stat(statusFilePath); err == nil {
@@ -265,48 +952,334 @@ func initializeStoryState(statusFilePath string, abstractContent string) (StoryP
 			return state, fmt.Errorf("failed to read status file: %w", err)
 		}
 
-		state.AccumulatedInputTokens = statusData.AccumulatedInputTokens
-		state.AccumulatedOutputTokens = statusData.AccumulatedOutputTokens
-		state.AccumulatedCost = statusData.AccumulatedCost
+		state.Usage.AddUsage(statusData.AccumulatedInputTokens, statusData.AccumulatedOutputTokens, statusData.AccumulatedCost)
+		state.StartUsage = file.UsageTotals{InputTokens: statusData.AccumulatedInputTokens, OutputTokens: statusData.AccumulatedOutputTokens, Cost: statusData.AccumulatedCost}
+		addUsageTotals(state.Planning, statusData.PlanningUsage)
+		addUsageTotals(state.Summaries, statusData.SummaryUsage)
+		addUsageTotals(state.Reviews, statusData.ReviewUsage)
 		state.PreviousChapters = statusData.PreviousChapters
-		state.LastThoughtSignature = []byte(statusData.LastThoughtSignature)
+		state.LastThoughtSignature, err = file.DecodeThoughtSignature(statusData.LastThoughtSignature)
+		if err != nil {
+			log.Printf("Warning: Ignoring the thought signature in status file '%s': %v", statusFilePath, err)
+		}
 		state.ChaptersAlreadyWritten = statusData.ChaptersWritten
+		state.ChapterMetrics = statusData.ChapterMetrics
+		state.StorySummary = statusData.StorySummary
+		state.SummaryChapter = statusData.SummaryChapter
+		state.ChapterRecaps = statusData.ChapterRecaps
+		state.ChapterTitles = statusData.ChapterTitles
+		state.AppendPrompts = statusData.AppendPrompts
+		state.AppendTo = statusData.AppendTo
+		state.AppendOffset = statusData.AppendOffset
+		state.AppendLength = statusData.AppendLength
+		state.ChapterHeaderFormat = statusData.ChapterHeaderFormat
+		state.Separator = statusData.Separator
+		state.NoChapterTitles = statusData.NoChapterTitles
 		state.FirstNewChapter = state.ChaptersAlreadyWritten + 1
 
 		log.Printf("Resuming from Chapter %d.", state.FirstNewChapter)
 	} else {
 		log.Printf("No status file found at '%s'. Starting new story.", statusFilePath)
-		// Initialize header for new story
-		header := fmt.Sprintf("--- Full Story: %s ---\n\nStory Plan Abstract:\n%s\n\n----------------------------------------\n\n", time.Now().Format("2006-01-02 15:04:05"), abstractContent)
 		state.PreviousChapters = header
 	}
 
 	return state, nil
 }
 
-// saveStateToFiles saves the current state to the status YAML file and rewrites the full text output file.
-func saveStateToFiles(state *StoryProgressState, statusFilePath, outputFilePath string) error {
+// saveStateToFiles saves the current state to the status YAML file and rewrites the full text output file,
+// with fm as its front matter when not nil. When sync is true both files are flushed to disk before
+// returning (disabled by --no-sync).
+func saveStateToFiles(state *StoryProgressState, statusFilePath, outputFilePath string, sync bool, fm *frontMatter) error {
 	// Save Status File
+	inputTokens, outputTokens, cost := state.Usage.Summary()
 	statusData := file.StoryStatus{
 		PreviousChapters:        state.PreviousChapters,
-		LastThoughtSignature:    string(state.LastThoughtSignature),
-		AccumulatedInputTokens:  state.AccumulatedInputTokens,
-		AccumulatedOutputTokens: state.AccumulatedOutputTokens,
-		AccumulatedCost:         state.AccumulatedCost,
+		LastThoughtSignature:    file.EncodeThoughtSignature(state.LastThoughtSignature),
+		AccumulatedInputTokens:  inputTokens,
+		AccumulatedOutputTokens: outputTokens,
+		AccumulatedCost:         cost,
 		ChaptersWritten:         state.ChaptersAlreadyWritten,
+		ChapterMetrics:          state.ChapterMetrics,
+		StorySummary:            state.StorySummary,
+		SummaryChapter:          state.SummaryChapter,
+		ChapterRecaps:           state.ChapterRecaps,
+		ChapterTitles:           state.ChapterTitles,
+		AppendPrompts:           state.AppendPrompts,
+		PlanningUsage:           usageTotals(state.Planning),
+		SummaryUsage:            usageTotals(state.Summaries),
+		ReviewUsage:             usageTotals(state.Reviews),
+		ChapterHeaderFormat:     state.ChapterHeaderFormat,
+		Separator:               state.Separator,
+		NoChapterTitles:         state.NoChapterTitles,
 	}
-	if err := file.WriteStoryStatusFile(statusFilePath, statusData); err != nil {
+	if state.AppendTo != "" {
+		// Written before the status file, which must record the length of this save.
+		if err := appendStory(state, sync); err != nil {
+			log.Printf("Warning: %v", err)
+		}
+		statusData.AppendTo = state.AppendTo
+		statusData.AppendOffset = state.AppendOffset
+		statusData.AppendLength = state.AppendLength
+	}
+	if err := file.WriteStoryStatusFile(statusFilePath, statusData, sync); err != nil {
 		return fmt.Errorf("failed to save status file: %w", err)
 	}
 
-	// Rewrite Full Text File
-	if err := os.WriteFile(outputFilePath, []byte(state.PreviousChapters), 0644); err != nil {
+	// Rewrite Full Text File in the format chosen by its extension
+	rendered, err := renderStory(state.PreviousChapters, export.FormatFromPath(outputFilePath), fm, state.layout.exportLayout(state.ChapterTitles))
+	if err != nil {
+		return fmt.Errorf("failed to render story output file: %w", err)
+	}
+	if err := file.WriteFile(outputFilePath, rendered, file.FileMode(), sync); err != nil {
 		return fmt.Errorf("failed to write story output file: %w", err)
 	}
 
 	return nil
 }
 
+// renderStory converts the plain story text kept in the status file into the given
+// output format by passing its header and chapters through an export.Writer. When fm is
+// not nil it is written first and the abstract is left out of the header. layout sets the chapter
+// headings and header separator.
+func renderStory(storyText, format string, fm *frontMatter, layout export.Layout) ([]byte, error) {
+	var buf bytes.Buffer
+	w, err := export.NewWriterWithLayout(&buf, format, layout)
+	if err != nil {
+		return nil, err
+	}
+
+	header, chapters := parseStoryText(storyText)
+	if fm != nil {
+		block, err := fm.render()
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(block)
+		header = fm.withoutAbstract(header)
+	}
+	if err := w.WriteHeader(header); err != nil {
+		return nil, err
+	}
+	for _, c := range chapters {
+		if err := w.WriteChapter(c.Number, c.Body); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// contextOrBackground returns ctx, or context.Background() when ctx is nil.
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
+
+// newAPIInput returns a CallGeminiAPIInput for prompt carrying the settings shared by every call of the story command.
+func newAPIInput(cfg FullStoryConfig, prompt string) aiEndpoint.CallGeminiAPIInput {
+	return aiEndpoint.CallGeminiAPIInput{
+		Ctx:               contextOrBackground(cfg.ctx),
+		APIKey:            cfg.APIKey,
+		ModelName:         cfg.ModelName,
+		Prompt:            prompt,
+		ThinkingLevel:     cfg.ThinkingLevel,
+		Limiter:           cfg.Limiter,
+		SystemInstruction: cfg.StylePrompt,
+		Seed:              cfg.Seed,
+		MaxOutputTokens:   cfg.MaxOutputTokens,
+		Temperature:       cfg.Temperature,
+		TopP:              cfg.TopP,
+		ThinkingBudget:    cfg.ThinkingBudget,
+		Timeout:           cfg.Timeout,
+		Client:            cfg.client,
+		HTTPClient:        cfg.httpClient,
+		CacheDir:          cfg.CacheDir,
+	}
+}
+
+// historyWindow returns the recent chapter turns to send before a chapter prompt with --history-turns.
+// Without signatures, the turns are sent as plain text, for retries after a rejected signature.
+func historyWindow(state *StoryProgressState, withSignatures bool) []aiEndpoint.HistoryTurn {
+	if withSignatures || len(state.RecentTurns) == 0 {
+		return state.RecentTurns
+	}
+	turns := make([]aiEndpoint.HistoryTurn, len(state.RecentTurns))
+	for i, turn := range state.RecentTurns {
+		turns[i] = aiEndpoint.HistoryTurn{UserPrompt: turn.UserPrompt, ModelResponse: turn.ModelResponse}
+	}
+	return turns
+}
+
+// recordHistoryTurn keeps a finished chapter and its prompt as a conversation turn, dropping the
+// oldest turns beyond cfg.HistoryTurns. It does nothing when --history-turns is 0.
+func recordHistoryTurn(cfg FullStoryConfig, state *StoryProgressState, prompt, chapterText string, signature []byte) {
+	if cfg.HistoryTurns <= 0 {
+		return
+	}
+	state.RecentTurns = append(state.RecentTurns, aiEndpoint.HistoryTurn{
+		UserPrompt:       prompt,
+		ModelResponse:    strings.TrimSpace(chapterText),
+		ThoughtSignature: signature,
+	})
+	if excess := len(state.RecentTurns) - cfg.HistoryTurns; excess > 0 {
+		state.RecentTurns = state.RecentTurns[excess:]
+	}
+}
+
+// abstractTurnPrompt is the request the abstract answers when it is sent as the model's previous turn.
+const abstractTurnPrompt = "Write a concise, compelling story writing plan, including the settings, the name of main characters and a detail plan for all chapters."
+
+// abstractTurn returns the abstract, with the thought signature saved in the abstract file, as
+// the model's previous turn for the first chapter of a new story, so chapter 1 continues the
+// reasoning the plan was built with. It returns nil for later chapters, for resumed stories, when
+// the abstract file has no signature, or with --no-abstract-signature.
+func abstractTurn(cfg FullStoryConfig, state *StoryProgressState, chapterNum int) *aiEndpoint.HistoryTurn {
+	if chapterNum != 1 || len(state.LastThoughtSignature) > 0 || len(cfg.AbstractSignature) == 0 || cfg.NoAbstractSignature {
+		return nil
+	}
+	log.Printf("Sending the abstract's thought signature (%d bytes) with Chapter 1.", len(cfg.AbstractSignature))
+	return &aiEndpoint.HistoryTurn{
+		UserPrompt:       abstractTurnPrompt,
+		ModelResponse:    cfg.AbstractContent,
+		ThoughtSignature: cfg.AbstractSignature,
+	}
+}
+
+// targetWordsForChapter returns the word target for a chapter, using the chapter plan when it lists the chapter.
+func targetWordsForChapter(cfg FullStoryConfig, chapterNum int) int {
+	if words, ok := cfg.ChapterPlan[chapterNum]; ok {
+		return words
+	}
+	return cfg.WordsPerChapter
+}
+
+// chapterExpansionResult holds the outcome of expanding a chapter that fell short of its word target.
+type chapterExpansionResult struct {
+	Text             string
+	ThoughtSignature []byte
+	InputTokens      int
+	OutputTokens     int
+	Cost             float64
+	Rounds           int
+}
+
+// expandShortChapter asks Gemini to continue a chapter whose word count is below
+// cfg.MinWordRatio of targetWords, appending each continuation until the minimum is
+// reached or cfg.MaxExpansionRounds is exhausted. The original chapter prompt and
+// the text so far are sent as the previous turn so the thought signature is preserved.
+func expandShortChapter(cfg FullStoryConfig, chapterNum, targetWords int, chapterPrompt, chapterText string, signature []byte) chapterExpansionResult {
+	result := chapterExpansionResult{
+		Text:             strings.TrimSpace(chapterText),
+		ThoughtSignature: signature,
+	}
+	if cfg.MinWordRatio <= 0 {
+		return result
+	}
+	minWords := int(float64(targetWords) * cfg.MinWordRatio)
+
+	for round := 1; round <= cfg.MaxExpansionRounds; round++ {
+		wordCount := utils.CountWords(result.Text, cfg.Language)
+		if wordCount >= minWords {
+			break
+		}
+		log.Printf("Chapter %d has %d words, below the minimum of %d. Requesting expansion round %d/%d...", chapterNum, wordCount, minWords, round, cfg.MaxExpansionRounds)
+
+		prompt := fmt.Sprintf(`Chapter %d as written so far has only %d words, but it should be approximately %d words.
+Continue and expand this chapter from exactly where it ends so that the whole chapter reaches approximately %d words.
+Output ONLY the continuation text. Do not repeat what has already been written and do not add a chapter title.
+`, chapterNum, wordCount, targetWords, targetWords)
+
+		apiInput := newAPIInput(cfg, prompt)
+		apiInput.PreviousTurn = &aiEndpoint.HistoryTurn{
+			UserPrompt:       chapterPrompt,
+			ModelResponse:    result.Text,
+			ThoughtSignature: result.ThoughtSignature,
+		}
+		apiResponse := aiEndpoint.CallGeminiAPI(apiInput)
+		if apiResponse.Err != nil {
+			log.Printf("Warning: Expansion round %d for Chapter %d failed: %v. Keeping the chapter as is.", round, chapterNum, apiResponse.Err)
+			break
+		}
+
+		result.Text = result.Text + "\n\n" + strings.TrimSpace(apiResponse.GeneratedText)
+		result.ThoughtSignature = apiResponse.ThoughtSignature
+		result.InputTokens += apiResponse.InputTokens
+		result.OutputTokens += apiResponse.OutputTokens
+		result.Cost += apiResponse.Cost
+		result.Rounds = round
+	}
+	return result
+}
+
+// continueTruncatedChapter asks Gemini to pick up a chapter that was cut off by the output token
+// limit (finish reason MAX_TOKENS), appending each continuation directly to the text until a response
+// finishes normally or cfg.MaxContinuations is exhausted. Like expandShortChapter, the chapter prompt
+// and the text so far are sent as the previous turn so the thought signature is preserved.
+func continueTruncatedChapter(cfg FullStoryConfig, chapterNum int, chapterPrompt, chapterText string, signature []byte, finishReason string) chapterExpansionResult {
+	result := chapterExpansionResult{
+		Text:             chapterText,
+		ThoughtSignature: signature,
+	}
+
+	for round := 1; round <= cfg.MaxContinuations && finishReason == aiEndpoint.FinishReasonMaxTokens; round++ {
+		log.Printf("Chapter %d was cut off by the output token limit. Requesting continuation %d/%d...", chapterNum, round, cfg.MaxContinuations)
+
+		prompt := fmt.Sprintf(`Your text for Chapter %d was cut off by the output length limit.
+Continue from exactly where it stops, even if that is mid-sentence or mid-word, and finish the chapter.
+Output ONLY the continuation text. Do not repeat what has already been written and do not add a chapter title.
+`, chapterNum)
+
+		apiInput := newAPIInput(cfg, prompt)
+		apiInput.PreviousTurn = &aiEndpoint.HistoryTurn{
+			UserPrompt:       chapterPrompt,
+			ModelResponse:    result.Text,
+			ThoughtSignature: result.ThoughtSignature,
+		}
+		apiResponse := aiEndpoint.CallGeminiAPI(apiInput)
+		if apiResponse.Err != nil {
+			log.Printf("Warning: Continuation %d for Chapter %d failed: %v. Keeping the chapter as is.", round, chapterNum, apiResponse.Err)
+			break
+		}
+
+		result.Text += apiResponse.GeneratedText
+		result.ThoughtSignature = apiResponse.ThoughtSignature
+		result.InputTokens += apiResponse.InputTokens
+		result.OutputTokens += apiResponse.OutputTokens
+		result.Cost += apiResponse.Cost
+		result.Rounds = round
+		finishReason = apiResponse.FinishReason
+	}
+	if finishReason == aiEndpoint.FinishReasonMaxTokens {
+		if cfg.MaxOutputTokens > 0 {
+			// The cap was set on purpose, so end the chapter cleanly rather than mid-sentence.
+			trimmed := trimToLastSentence(result.Text)
+			log.Printf("Warning: Chapter %d is still cut off by --max-output-tokens %d after %d continuation(s); trimmed %d trailing characters to end at the last complete sentence.",
+				chapterNum, cfg.MaxOutputTokens, result.Rounds, len(result.Text)-len(trimmed))
+			result.Text = trimmed
+		} else {
+			log.Printf("Warning: Chapter %d is still cut off after %d continuation(s); it may end mid-sentence.", chapterNum, result.Rounds)
+		}
+	}
+	return result
+}
+
+// sentenceEndPattern matches sentence-ending punctuation, Western or CJK, with any closing quotes or brackets.
+var sentenceEndPattern = regexp.MustCompile(`[.!?。！？…]["'”’»」』)）]*`)
+
+// trimToLastSentence cuts text after its last complete sentence. Text without any sentence
+// ending is returned unchanged.
+func trimToLastSentence(text string) string {
+	matches := sentenceEndPattern.FindAllStringIndex(text, -1)
+	if len(matches) == 0 {
+		return text
+	}
+	return text[:matches[len(matches)-1][1]]
+}
+
 // generateStoryChapters loops through and generates each chapter, writing status and content to files.
 func generateStoryChapters(
 	cfg FullStoryConfig,
@@ -315,184 +1288,483 @@ func generateStoryChapters(
 	statusFilePath string,
 	outputFilePath string,
 ) error {
+	const maxChapterRetries = 3 // Number of retries for chapter generation
+
+	runStart := time.Now()
+	defer func() { state.RunDuration = time.Since(runStart) }()
+
+	// Chapters written by a single-shot call or an earlier run also go to the split directory.
+	if cfg.SplitDir != "" {
+		if _, err := backfillSplitChapters(cfg.SplitDir, state.PreviousChapters, !cfg.NoSync); err != nil {
+			return err
+		}
+	}
+	// Nothing is left when a single-shot call already wrote every chapter.
+	if state.FirstNewChapter > totalChapters {
+		return nil
+	}
+
 	log.Printf("Starting full story generation from Chapter %d to Chapter %d, aiming for %d words per chapter...",
 		state.FirstNewChapter, totalChapters, cfg.WordsPerChapter)
 
-	const maxChapterRetries = 3 // Number of retries for chapter generation
+	var progress *logging.Progress
+	if !cfg.NoProgress {
+		progress = logging.StartProgress()
+		defer progress.Done()
+		updateProgress(progress, state.ChaptersAlreadyWritten, totalChapters, state.Usage)
+	}
 
 	for i := state.FirstNewChapter - 1; i < totalChapters; i++ {
 		chapterNum := i + 1
+		if cfg.ctx != nil && cfg.ctx.Err() != nil {
+			return fmt.Errorf("story generation stopped before Chapter %d: %w", chapterNum, cfg.ctx.Err())
+		}
+		if cfg.interrupt.Requested() {
+			log.Printf("Stopping before Chapter %d on interrupt. %d chapters are saved in '%s' and '%s'; run the same command again to resume.",
+				chapterNum, state.ChaptersAlreadyWritten, outputFilePath, statusFilePath)
+			return fmt.Errorf("%w before Chapter %d; run the same command again to resume", ErrInterrupted, chapterNum)
+		}
+		// A status file whose chapter count lags behind its story text (e.g. after a hand edit) must
+		// not make a chapter appear twice, so a chapter already in the story is never written again.
+		if hasChapter(state.PreviousChapters, chapterNum) {
+			cfg.Logger.Warn("chapter_exists", fmt.Sprintf("Chapter %d is already in the story; skipping it instead of writing it twice.", chapterNum),
+				logging.Fields{"chapter": chapterNum})
+			state.ChaptersAlreadyWritten = max(state.ChaptersAlreadyWritten, chapterNum)
+			updateProgress(progress, chapterNum, totalChapters, state.Usage)
+			continue
+		}
+		targetWords := targetWordsForChapter(cfg, chapterNum)
+		chapterStart := time.Now()
 
-		log.Printf("Generating Chapter %d (out of %d)", chapterNum, totalChapters)
-
-		prompt := fmt.Sprintf(`Given the following complete story abstract (plan) and the chapters already written, please write Chapter %d of the story.
-Generate a short title for the charpter.
-The chapter should be approximately %d words. Focus on progressing the narrative as outlined in the abstract for this specific chapter.
-
---- Full Story Abstract (Plan) ---
-%s
---- End Full Story Abstract (Plan) ---
+		cfg.Logger.Info("chapter_start", fmt.Sprintf("Generating Chapter %d (out of %d), aiming for %d words", chapterNum, totalChapters, targetWords),
+			logging.Fields{"chapter": chapterNum, "total_chapters": totalChapters, "target_words": targetWords})
 
---- Previously Written Chapters (including abstract and previous chapters) ---
-%s
---- End Previously Written Chapters ---
+		var summaryUsage summaryUpdateResult
+		if cfg.ContextMode == ContextModeSummary {
+			summaryUsage = updateStorySummary(cfg, state)
+		}
+		if cfg.ChapterSummaries {
+			recapUsage := updateChapterRecaps(cfg, state)
+			summaryUsage.InputTokens += recapUsage.InputTokens
+			summaryUsage.OutputTokens += recapUsage.OutputTokens
+			summaryUsage.Cost += recapUsage.Cost
+		}
 
-Write Chapter %d now, ensuring it flows logically from previous chapters and adheres to the overall story plan.
-`,
-			chapterNum,
-			cfg.WordsPerChapter,
-			cfg.AbstractContent,
-			state.PreviousChapters,
-			chapterNum,
-		)
+		prompt, err := buildChapterPrompt(cfg, state, chapterNum, totalChapters, targetWords)
+		if err != nil {
+			return err
+		}
+		if prompt, err = fitPromptToContext(cfg, state, chapterNum, totalChapters, targetWords, prompt); err != nil {
+			return err
+		}
+		if cfg.PrintPrompt {
+			logChapterPrompt(cfg, chapterNum, prompt)
+		}
 
 		var chapterText string
 		var chapterSignature []byte
+		var chapterFinishReason string
 		var chapterInputTokens, chapterOutputTokens int
 		var chapterCost float64
 		var chapterGenerationErr error
+		var chapterAttempts int
+		// stopChapter ends the run without writing the chapter. Its failed attempts and the summary
+		// call were billed, so the run's totals include them.
+		stopChapter := func(err error) error {
+			state.Usage.AddUsage(chapterInputTokens+summaryUsage.InputTokens, chapterOutputTokens+summaryUsage.OutputTokens, chapterCost+summaryUsage.Cost)
+			return err
+		}
 
 		// Retry logic for CallGeminiAPI for chapter generation
 		for attempt := 0; attempt <= maxChapterRetries; attempt++ {
 			if attempt > 0 {
-				log.Printf("Retrying Chapter %d (attempt %d/%d) after previous failure: %v", chapterNum, attempt, maxChapterRetries, chapterGenerationErr)
-				time.Sleep(20 * time.Second) // Small delay before retrying
+				cfg.Logger.Warn("chapter_retry", fmt.Sprintf("Retrying Chapter %d (attempt %d/%d) after previous failure: %v", chapterNum, attempt, maxChapterRetries, chapterGenerationErr),
+					logging.Fields{"chapter": chapterNum, "attempt": attempt, "max_retries": maxChapterRetries, "error": chapterGenerationErr.Error()})
+				// Backs off further when rate limited. Cancellation or Ctrl+C ends the wait, and the
+				// chapter is left for the next run.
+				var stopErr error
+				select {
+				case <-contextOrBackground(cfg.ctx).Done():
+					stopErr = fmt.Errorf("story generation stopped while retrying Chapter %d: %w", chapterNum, cfg.ctx.Err())
+				case <-cfg.interrupt.Done():
+					log.Printf("Stopping before retrying Chapter %d on interrupt. %d chapters are saved in '%s' and '%s'; run the same command again to resume.",
+						chapterNum, state.ChaptersAlreadyWritten, outputFilePath, statusFilePath)
+					stopErr = fmt.Errorf("%w while retrying Chapter %d; run the same command again to resume", ErrInterrupted, chapterNum)
+				case <-time.After(aiEndpoint.RetryDelay(chapterGenerationErr, attempt)):
+				}
+				if stopErr != nil {
+					return stopChapter(stopErr)
+				}
 			}
 
-			apiInput := aiEndpoint.CallGeminiAPIInput{
-				Ctx:           context.Background(),
-				APIKey:        cfg.APIKey,
-				ModelName:     cfg.ModelName,
-				Prompt:        prompt,
-				ThinkingLevel: cfg.ThinkingLevel,
-				// PreviousTurn: nil, // Not using conversation history struct, using prompt context + thought signature
-				ThoughtSignature: state.LastThoughtSignature,
+			apiInput := newAPIInput(cfg, prompt)
+			// PreviousTurn is only used for the abstract turn of chapter 1: otherwise the prompt
+			// carries the context, plus the thought signature and any --history-turns window.
+			apiInput.ThoughtSignature = state.LastThoughtSignature
+			// A signature from another model or a stale plan may be rejected, so retries go without them.
+			apiInput.PreviousTurns = historyWindow(state, attempt == 0)
+			if attempt == 0 {
+				apiInput.PreviousTurn = abstractTurn(cfg, state, chapterNum)
 			}
 			apiResponse := aiEndpoint.CallGeminiAPI(apiInput)
+			chapterAttempts = attempt + 1
+			logChapterAttempt(cfg, chapterNum, chapterAttempts, cfg.ModelName, apiResponse)
 
 			chapterText = apiResponse.GeneratedText
 			chapterSignature = apiResponse.ThoughtSignature
-			chapterInputTokens = apiResponse.InputTokens
-			chapterOutputTokens = apiResponse.OutputTokens
-			chapterCost = apiResponse.Cost
+			chapterFinishReason = apiResponse.FinishReason
+			// Failed attempts are billed for the tokens they report, so every attempt is counted.
+			chapterInputTokens += apiResponse.InputTokens
+			chapterOutputTokens += apiResponse.OutputTokens
+			chapterCost += apiResponse.Cost
 			chapterGenerationErr = apiResponse.Err
 
 			if chapterGenerationErr == nil {
 				// Success, break out of retry loop
 				break
 			}
+			// A rejected API key or invalid setting fails every attempt and chapter the same way, so
+			// the run stops with the chapters written so far saved and the chapter left for the next run.
+			if aiEndpoint.IsConfigError(chapterGenerationErr) {
+				return stopChapter(fmt.Errorf("story generation stopped at Chapter %d: %w", chapterNum, chapterGenerationErr))
+			}
+			// The same prompt would be blocked again.
+			if errors.Is(chapterGenerationErr, aiEndpoint.ErrSafetyBlocked) {
+				break
+			}
+		}
+
+		// After the primary model exhausts its retries, try the fallback model once. The thought
+		// signature belongs to the primary model, so it is not sent.
+		chapterCfg := cfg
+		if chapterGenerationErr != nil && cfg.FallbackModel != "" && cfg.FallbackModel != cfg.ModelName {
+			// Cancellation or Ctrl+C during the last attempt stops the run instead of paying for the fallback.
+			if err := contextOrBackground(cfg.ctx).Err(); err != nil {
+				return stopChapter(fmt.Errorf("story generation stopped before the fallback model for Chapter %d: %w", chapterNum, err))
+			}
+			select {
+			case <-cfg.interrupt.Done():
+				log.Printf("Stopping before the fallback model for Chapter %d on interrupt. %d chapters are saved in '%s' and '%s'; run the same command again to resume.",
+					chapterNum, state.ChaptersAlreadyWritten, outputFilePath, statusFilePath)
+				return stopChapter(fmt.Errorf("%w before the fallback model for Chapter %d; run the same command again to resume", ErrInterrupted, chapterNum))
+			default:
+			}
+			cfg.Logger.Warn("chapter_fallback", fmt.Sprintf("Chapter %d failed on %s after %d attempts: %v. Retrying once with fallback model %s.", chapterNum, cfg.ModelName, chapterAttempts, chapterGenerationErr, cfg.FallbackModel),
+				logging.Fields{"chapter": chapterNum, "model": cfg.ModelName, "fallback_model": cfg.FallbackModel, "error": chapterGenerationErr.Error()})
+			chapterCfg.ModelName = cfg.FallbackModel
+			apiResponse := aiEndpoint.CallGeminiAPI(newAPIInput(chapterCfg, prompt))
+			chapterAttempts++
+			logChapterAttempt(cfg, chapterNum, chapterAttempts, chapterCfg.ModelName, apiResponse)
+			chapterText = apiResponse.GeneratedText
+			chapterSignature = apiResponse.ThoughtSignature
+			chapterFinishReason = apiResponse.FinishReason
+			chapterInputTokens += apiResponse.InputTokens
+			chapterOutputTokens += apiResponse.OutputTokens
+			chapterCost += apiResponse.Cost
+			chapterGenerationErr = apiResponse.Err
 		}
 
 		if chapterGenerationErr != nil {
-			log.Fatalf("Critical Error: Failed to generate Chapter %d after %d attempts: %v. Marking chapter with error message and proceeding.", chapterNum, maxChapterRetries+1, chapterGenerationErr)
+			// Do not exit here: the placeholder below is saved with the status file like any other chapter,
+			// so the story and its state stay consistent and the remaining chapters are still generated.
+			cfg.Logger.Error("chapter_failed", fmt.Sprintf("Failed to generate Chapter %d after %d attempts: %v. Marking chapter with error message and proceeding.", chapterNum, chapterAttempts, chapterGenerationErr),
+				logging.Fields{"chapter": chapterNum, "attempts": chapterAttempts, "error": chapterGenerationErr.Error()})
 			// If all retries fail, mark the chapter with an error message in the output.
 			chapterText = fmt.Sprintf("Error generating Chapter %d: %v\n\n[Generation Failed - Please review logs]", chapterNum, chapterGenerationErr)
+			if errors.Is(chapterGenerationErr, aiEndpoint.ErrSafetyBlocked) {
+				chapterText = fmt.Sprintf("Chapter %d was blocked by Gemini's safety filters: %v\n\n[Blocked by Safety Filters - Revise this chapter's plan in the abstract, or soften the style prompt, and regenerate it]", chapterNum, chapterGenerationErr)
+			}
 			chapterSignature = nil // Clear signature if generation failed
-			chapterInputTokens = 0
-			chapterOutputTokens = 0
-			chapterCost = 0
+			// The usage of the failed attempts is kept: it was billed.
+		}
+		// The summary call made for this chapter's context is billed to the chapter.
+		chapterInputTokens += summaryUsage.InputTokens
+		chapterOutputTokens += summaryUsage.OutputTokens
+		chapterCost += summaryUsage.Cost
+
+		expansionRounds, continuations := 0, 0
+		if chapterGenerationErr == nil {
+			continuation := continueTruncatedChapter(chapterCfg, chapterNum, prompt, chapterText, chapterSignature, chapterFinishReason)
+			chapterText = continuation.Text
+			chapterSignature = continuation.ThoughtSignature
+			chapterInputTokens += continuation.InputTokens
+			chapterOutputTokens += continuation.OutputTokens
+			chapterCost += continuation.Cost
+			continuations = continuation.Rounds
+
+			expansion := expandShortChapter(chapterCfg, chapterNum, targetWords, prompt, chapterText, chapterSignature)
+			chapterText = expansion.Text
+			chapterSignature = expansion.ThoughtSignature
+			chapterInputTokens += expansion.InputTokens
+			chapterOutputTokens += expansion.OutputTokens
+			chapterCost += expansion.Cost
+			expansionRounds = expansion.Rounds
+
+			previousSignature := state.LastThoughtSignature
+			if chapterCfg.ModelName != cfg.ModelName {
+				previousSignature = nil
+			}
+			validation := regenerateInvalidChapter(chapterCfg, chapterNum, targetWords, prompt, chapterText, chapterSignature, previousSignature)
+			chapterText = validation.Text
+			chapterSignature = validation.ThoughtSignature
+			chapterInputTokens += validation.InputTokens
+			chapterOutputTokens += validation.OutputTokens
+			chapterCost += validation.Cost
+			if validation.Regenerations > 0 {
+				cfg.Logger.Info("chapter_regenerated", fmt.Sprintf("Chapter %d was regenerated %d time(s) after failing validation, costing %s extra.", chapterNum, validation.Regenerations, aiEndpoint.FormatCost(validation.Cost)),
+					logging.Fields{"chapter": chapterNum, "regenerations": validation.Regenerations, "cost": validation.Cost})
+			}
+			if validation.Err != nil {
+				cfg.Logger.Warn("chapter_validation_failed", fmt.Sprintf("Chapter %d still fails validation after %d regeneration(s): %v. Keeping the last draft; consider editing it.", chapterNum, validation.Regenerations, validation.Err),
+					logging.Fields{"chapter": chapterNum, "regenerations": validation.Regenerations, "error": validation.Err.Error()})
+			}
+		}
+		if chapterCfg.ModelName != cfg.ModelName {
+			if chapterGenerationErr == nil {
+				cfg.Logger.Warn("chapter_fallback_used", fmt.Sprintf("Chapter %d was generated with fallback model %s; consider regenerating it with %s.", chapterNum, chapterCfg.ModelName, cfg.ModelName),
+					logging.Fields{"chapter": chapterNum, "model": chapterCfg.ModelName})
+			}
+			// The next chapter goes back to the primary model, which cannot use the fallback's signature.
+			chapterSignature = nil
 		}
 
 		chapterContentToWrite := strings.TrimSpace(chapterText) + "\n\n"
-		wordCount := len(strings.Fields(strings.ReplaceAll(chapterContentToWrite, "\n", " ")))
+		if chapterGenerationErr == nil && cfg.Language != "" {
+			if ok, script := checkChapterLanguage(chapterText, cfg.Language); !ok {
+				cfg.Logger.Warn("chapter_language", fmt.Sprintf("Chapter %d appears not to be in %s (mostly %s script); consider regenerating it.", chapterNum, cfg.Language, script),
+					logging.Fields{"chapter": chapterNum, "language": cfg.Language, "dominant_script": script})
+			}
+		}
+		wordCount := utils.CountWords(chapterContentToWrite, cfg.Language)
 		characterCount := utf8.RuneCountInString(chapterContentToWrite) // Count characters
 		chapterHeader := fmt.Sprintf("## Chapter %d\n\n", chapterNum)
 
 		// Update State
-		state.AccumulatedInputTokens += chapterInputTokens
-		state.AccumulatedOutputTokens += chapterOutputTokens
-		state.AccumulatedCost += chapterCost
+		state.Usage.AddUsage(chapterInputTokens, chapterOutputTokens, chapterCost)
+		state.Summaries.AddUsage(summaryUsage.InputTokens, summaryUsage.OutputTokens, summaryUsage.Cost)
 		state.PreviousChapters += chapterHeader + chapterContentToWrite
 		state.LastThoughtSignature = chapterSignature
 		state.ChaptersAlreadyWritten = chapterNum
-
-		log.Printf("Chapter %d details: Words %d, Characters %d, Input Tokens %d, Output Tokens %d, Cost: $%.6f. Accumulated: Input Tokens %d, Output Tokens %d, Cost: $%.6f",
-			chapterNum, wordCount, characterCount, chapterInputTokens, chapterOutputTokens, chapterCost, state.AccumulatedInputTokens, state.AccumulatedOutputTokens, state.AccumulatedCost)
+		if cfg.ImagePrompts {
+			// Billed to the chapter, like its context summary.
+			imageUsage := updateImagePrompts(cfg, state, outputFilePath)
+			state.Usage.AddUsage(imageUsage.InputTokens, imageUsage.OutputTokens, imageUsage.Cost)
+			chapterInputTokens += imageUsage.InputTokens
+			chapterOutputTokens += imageUsage.OutputTokens
+			chapterCost += imageUsage.Cost
+		}
+		if chapterGenerationErr == nil {
+			recordHistoryTurn(cfg, state, prompt, chapterText, chapterSignature)
+		}
+		if chapterGenerationErr == nil && !cfg.NoChapterTitles {
+			if title := extractChapterTitle(chapterText); title != "" {
+				if state.ChapterTitles == nil {
+					state.ChapterTitles = make(map[int]string)
+				}
+				state.ChapterTitles[chapterNum] = title
+			}
+		}
+		chapterSeconds := time.Since(chapterStart).Seconds()
+		state.ChapterMetrics = append(state.ChapterMetrics, file.ChapterMetrics{
+			Chapter:      chapterNum,
+			Words:        wordCount,
+			InputTokens:  chapterInputTokens,
+			OutputTokens: chapterOutputTokens,
+			Cost:         chapterCost,
+			Seconds:      chapterSeconds,
+			Model:        chapterCfg.ModelName,
+		})
+
+		accumulatedInput, accumulatedOutput, accumulatedCost := state.Usage.Summary()
+		cfg.Logger.Info("chapter_done", fmt.Sprintf("Chapter %d details: Words %d (target %d, expansion rounds %d, continuations %d), Characters %d, Input Tokens %d, Output Tokens %d, Cost: %s, Time: %.1fs. Accumulated: Input Tokens %d, Output Tokens %d, Cost: %s",
+			chapterNum, wordCount, targetWords, expansionRounds, continuations, characterCount, chapterInputTokens, chapterOutputTokens, aiEndpoint.FormatCost(chapterCost), chapterSeconds, accumulatedInput, accumulatedOutput, aiEndpoint.FormatCost(accumulatedCost)),
+			logging.Fields{
+				"chapter":                   chapterNum,
+				"words":                     wordCount,
+				"target_words":              targetWords,
+				"expansion_rounds":          expansionRounds,
+				"continuations":             continuations,
+				"characters":                characterCount,
+				"input_tokens":              chapterInputTokens,
+				"output_tokens":             chapterOutputTokens,
+				"cost":                      chapterCost,
+				"seconds":                   chapterSeconds,
+				"accumulated_input_tokens":  accumulatedInput,
+				"accumulated_output_tokens": accumulatedOutput,
+				"accumulated_cost":          accumulatedCost,
+			})
 
 		// Save Status and Rewrite Full Text File
-		if err := saveStateToFiles(state, statusFilePath, outputFilePath); err != nil {
+		if err := saveStateToFiles(state, statusFilePath, outputFilePath, !cfg.NoSync, newFrontMatter(cfg, state)); err != nil {
 			return err
 		}
+		if cfg.SplitDir != "" {
+			if err := writeSplitChapter(cfg.SplitDir, chapterNum, chapterContentToWrite, !cfg.NoSync); err != nil {
+				return err
+			}
+		}
 		log.Printf("Chapter %d generated, status saved, and story file updated.", chapterNum)
-
-		// Add a small delay to avoid hitting rate limits if generating many chapters quickly
-		time.Sleep(1 * time.Second)
+		updateProgress(progress, chapterNum, totalChapters, state.Usage)
 	}
 	return nil
 }
 
-// Execute is the main entry point for the 'story' subcommand.
-func Execute(args []string) error {
-	// 1. Parse and validate flags
-	cfg, err := parseAndValidateFlags(args)
-	if err != nil {
-		return err
+// logChapterAttempt logs the tokens and cost of one attempt at writing a chapter, numbered from 1,
+// in verbose mode. Failed attempts are included, since they are billed for the tokens they report.
+func logChapterAttempt(cfg FullStoryConfig, chapterNum, attempt int, model string, resp aiEndpoint.GeminiAPIResponse) {
+	if !logging.Enabled(logging.VerbosityVerbose) {
+		return
+	}
+	outcome := "succeeded"
+	errText := ""
+	if resp.Err != nil {
+		outcome = "failed"
+		errText = resp.Err.Error()
 	}
+	cfg.Logger.Info("chapter_attempt", fmt.Sprintf("Chapter %d attempt %d on %s %s: Input Tokens %d, Output Tokens %d, Cost: %s",
+		chapterNum, attempt, model, outcome, resp.InputTokens, resp.OutputTokens, aiEndpoint.FormatCost(resp.Cost)),
+		logging.Fields{"chapter": chapterNum, "attempt": attempt, "model": model, "input_tokens": resp.InputTokens, "output_tokens": resp.OutputTokens, "cost": resp.Cost, "error": errText})
+}
 
-	// Preserve original log output and flags to restore later
-	originalLogOutput := log.Writer()
-	originalLogFlags := log.Flags()
-	defer func() {
-		log.SetOutput(originalLogOutput) // Restore original log output when Execute exits
-		log.SetFlags(originalLogFlags)   // Restore original log flags
-	}()
+// updateProgress shows how many of the story's chapters are done and the cost accumulated so far.
+func updateProgress(progress *logging.Progress, chaptersDone, totalChapters int, usage *aiEndpoint.CostTracker) {
+	_, _, cost := usage.Summary()
+	percent := 0
+	if totalChapters > 0 {
+		percent = chaptersDone * 100 / totalChapters
+	}
+	progress.Update("Chapter %d/%d (%d%%) — %s spent", chaptersDone, totalChapters, percent, aiEndpoint.FormatCost(cost))
+}
 
-	// 2. Configure logging
-	logFile, err := setupLogging(cfg.AbstractFilePath)
-	if err != nil {
-		// setupLogging already logs a warning and ensures logging goes to stderr.
+// printChapterMetrics prints a per-chapter summary table followed by the generation time of this run.
+// Chapters generated by earlier runs are included when their metrics were loaded from the status file.
+func printChapterMetrics(metrics []file.ChapterMetrics, runDuration time.Duration) {
+	if len(metrics) == 0 || !logging.Enabled(logging.VerbosityNormal) {
+		return
 	}
-	if logFile != nil {
-		defer logFile.Close() // Ensure the log file is closed
+	fmt.Println("\nChapter summary:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "Chapter\tWords\tInput Tokens\tOutput Tokens\tCost\tSeconds\t")
+	for _, m := range metrics {
+		fmt.Fprintf(w, "%d\t%d\t%d\t%d\t%s\t%.1f\t\n", m.Chapter, m.Words, m.InputTokens, m.OutputTokens, aiEndpoint.FormatCost(m.Cost), m.Seconds)
 	}
+	w.Flush()
+	fmt.Printf("Total generation time this run: %s\n", runDuration.Round(time.Second))
+}
 
-	// 3. Load Gemini configuration
-	cfg.APIKey, cfg.ModelName, cfg.ThinkingLevel, err = loadGeminiAPIConfig(cfg.ConfigPath)
-	if err != nil {
-		return err
+// reportStoryCompletion logs the final output path and accumulated totals of a story run.
+func reportStoryCompletion(cfg FullStoryConfig, state *StoryProgressState, outputFilePath string) {
+	if cfg.FallbackModel != "" {
+		var fallbackChapters []string
+		for _, m := range state.ChapterMetrics {
+			if m.Model == cfg.FallbackModel && m.Model != cfg.ModelName {
+				fallbackChapters = append(fallbackChapters, strconv.Itoa(m.Chapter))
+			}
+		}
+		if len(fallbackChapters) > 0 {
+			cfg.Logger.Warn("fallback_chapters", fmt.Sprintf("Chapters generated with fallback model %s: %s", cfg.FallbackModel, strings.Join(fallbackChapters, ", ")),
+				logging.Fields{"fallback_model": cfg.FallbackModel, "chapters": fallbackChapters})
+		}
+	}
+	reportWordCounts(cfg, newWordCountSummary(cfg, state.ChapterMetrics))
+	reportCostBreakdown(cfg, state)
+	inputTokens, outputTokens, cost := state.Usage.Summary()
+	cfg.Logger.Info("story_done", fmt.Sprintf("Full story saved to: %s. Total accumulated tokens: Input %d, Output %d. Total accumulated cost: %s. Generation time this run: %.1fs", outputFilePath, inputTokens, outputTokens, aiEndpoint.FormatCost(cost), state.RunDuration.Seconds()),
+		logging.Fields{
+			"output_path":               outputFilePath,
+			"run_seconds":               state.RunDuration.Seconds(),
+			"accumulated_input_tokens":  inputTokens,
+			"accumulated_output_tokens": outputTokens,
+			"accumulated_cost":          cost,
+		})
+}
+
+// appendStoryLedger appends the usage of a story run to the --ledger file, if any. Runs that
+// stopped before the story file was set up, and --print-prompt-only, are not recorded; an
+// interrupted or failed run is, since it may have paid for chapters. A ledger that cannot be
+// written is only a warning.
+func appendStoryLedger(cfg FullStoryConfig, command string, result StoryResult) {
+	if cfg.LedgerPath == "" || cfg.PrintPromptOnly || result.OutputPath == "" {
+		return
+	}
+	entry := aiEndpoint.LedgerEntry{
+		Time:         time.Now(),
+		Command:      command,
+		Model:        result.Model,
+		InputTokens:  result.RunUsage.InputTokens,
+		OutputTokens: result.RunUsage.OutputTokens,
+		Cost:         result.RunUsage.Cost,
+		OutputPath:   result.OutputPath,
 	}
+	if err := aiEndpoint.AppendLedger(cfg.LedgerPath, entry); err != nil {
+		cfg.Logger.Warn("ledger_failed", fmt.Sprintf("Failed to append to the ledger: %v", err), logging.Fields{"path": cfg.LedgerPath, "error": err.Error()})
+	}
+}
 
-	// 4. Read abstract and determine total chapters
-	var totalChapters int
-	var initialInputTokens, initialOutputTokens int
-	var initialCost float64
-	cfg.AbstractContent, totalChapters, initialInputTokens, initialOutputTokens, initialCost, err = readAbstractAndDetermineTotalChapters(cfg)
-	if err != nil {
-		return err
+// printStoryResult prints the output path, per-chapter summary, and total cost of a story run for the CLI.
+func printStoryResult(result StoryResult) {
+	logging.Printf(logging.VerbosityQuiet, "Full story successfully generated and saved to: %s\n", result.OutputPath)
+	printChapterMetrics(result.Chapters, result.RunDuration)
+	if result.WordCounts.Chapters > 0 {
+		logging.Printf(logging.VerbosityNormal, "Word counts: %s\n", result.WordCounts)
+	}
+	if result.AbstractPath != "" {
+		logging.Printf(logging.VerbosityNormal, "Generated abstract saved to: %s\n", result.AbstractPath)
+	}
+	if result.ReviewPath != "" {
+		logging.Printf(logging.VerbosityNormal, "Story review saved to: %s\n", result.ReviewPath)
 	}
+	logging.Printf(logging.VerbosityNormal, "Cost breakdown: %s\n", result.Breakdown)
+	logging.Printf(logging.VerbosityQuiet, "Total accumulated cost for full story generation process: %s\n", aiEndpoint.FormatCost(result.Cost))
+}
 
-	// 5. Determine output paths
-	finalOutputPath := determineOutputFilePath(cfg.AbstractFilePath, cfg.OutputPath)
-	statusOutputPath := determineStatusFilePath(finalOutputPath)
+// Execute is the main entry point for the 'story' subcommand. A leading non-flag
+// argument selects a nested subcommand such as 'continue'.
+func Execute(args []string) error {
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		switch args[0] {
+		case "continue":
+			return executeContinue(args[1:])
+		case "bible":
+			return executeBible(args[1:])
+		case "merge":
+			return executeMerge(args[1:])
+		case "status":
+			return executeStatus(args[1:])
+		case "outline":
+			return executeOutline(args[1:])
+		default:
+			return cli.UsageError(fmt.Errorf("unknown story subcommand '%s' (available: continue, bible, merge, status, outline)", args[0]))
+		}
+	}
+	return executeGenerate(args)
+}
 
-	// 6. Initialize story state (resume logic based on status file)
-	state, err := initializeStoryState(statusOutputPath, cfg.AbstractContent)
+// executeGenerate is the flag-parsing wrapper around GenerateStory used by the 'story' command.
+func executeGenerate(args []string) error {
+	cfg, err := parseAndValidateFlags(args)
 	if err != nil {
-		return err
+		return cli.UsageError(err)
 	}
 
-	// Add initial token counts from chapter planning (only if starting fresh or if not already accounted for)
-	// Since we are resuming from status, if status exists, these counts might already be in there if we were careful.
-	// However, simple approach: add current run's setup cost to accumulator.
-	state.AccumulatedInputTokens += initialInputTokens
-	state.AccumulatedOutputTokens += initialOutputTokens
-	state.AccumulatedCost += initialCost
+	defer startStoryLogging(&cfg)()
+	interrupt, stopInterrupts := handleInterrupts()
+	defer stopInterrupts()
+	cfg.interrupt = interrupt
 
-	// 7. If starting fresh (no chapters written), save initial state and file content immediately
-	if state.ChaptersAlreadyWritten == 0 {
-		if err := saveStateToFiles(&state, statusOutputPath, finalOutputPath); err != nil {
-			return fmt.Errorf("failed to save initial story state: %w", err)
-		}
+	// GenerateStory restores the cost format it found when it returns, so the command sets it
+	// here too for the summary printed afterwards.
+	if err := aiEndpoint.SetCostFormat(cfg.CostFormat); err != nil {
+		return cli.UsageError(fmt.Errorf("invalid cost display flags: %w", err))
 	}
-
-	// 8. Generate story chapter by chapter
-	if err := generateStoryChapters(cfg, totalChapters, &state, statusOutputPath, finalOutputPath); err != nil {
+	result, err := GenerateStory(context.Background(), cfg)
+	appendStoryLedger(cfg, "story", result)
+	if err != nil {
 		return err
 	}
-
-	fmt.Printf("Full story successfully generated and saved to: %s\n", finalOutputPath)
-	log.Printf("Full story saved to: %s. Total accumulated tokens: Input %d, Output %d. Total accumulated cost: $%.6f", finalOutputPath, state.AccumulatedInputTokens, state.AccumulatedOutputTokens, state.AccumulatedCost)
-	fmt.Printf("Total accumulated cost for full story generation process: $%.6f\n", state.AccumulatedCost)
-
+	if cfg.PrintPromptOnly {
+		return nil
+	}
+	printStoryResult(result)
 	return nil
 }
@@ -0,0 +1,120 @@
+package story
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+	"github.com/zicongmei/ai-story/fullText1/pkg/utils"
+)
+
+// Values accepted by --context-mode.
+const (
+	// ContextModeFull sends the entire story written so far with every chapter prompt.
+	ContextModeFull = "full"
+	// ContextModeSummary sends a rolling summary of the story plus only the most recent chapters.
+	ContextModeSummary = "summary"
+)
+
+// summaryRecentChapters is how many of the latest chapters are sent in full in summary mode.
+const summaryRecentChapters = 2
+
+// summaryMaxWords caps the length of the rolling story summary.
+const summaryMaxWords = 600
+
+// validateContextMode checks the --context-mode value.
+func validateContextMode(mode string) error {
+	switch mode {
+	case ContextModeFull, ContextModeSummary:
+		return nil
+	default:
+		return fmt.Errorf("--context-mode must be '%s' or '%s', got '%s'", ContextModeFull, ContextModeSummary, mode)
+	}
+}
+
+// summaryUpdateResult is the outcome of updateStorySummary.
+type summaryUpdateResult struct {
+	InputTokens  int
+	OutputTokens int
+	Cost         float64
+}
+
+// updateStorySummary brings state.StorySummary up to date with every chapter written so far,
+// asking Gemini to fold the chapters newer than state.SummaryChapter into the existing summary.
+// On failure the previous summary is kept and a warning is logged, so the next chapter retries.
+func updateStorySummary(cfg FullStoryConfig, state *StoryProgressState) summaryUpdateResult {
+	var result summaryUpdateResult
+	if state.SummaryChapter >= state.ChaptersAlreadyWritten {
+		return result
+	}
+
+	_, chapters := parseStoryText(state.PreviousChapters)
+	var newChapters strings.Builder
+	lastChapter := state.SummaryChapter
+	for _, c := range chapters {
+		if c.Number > state.SummaryChapter {
+			fmt.Fprintf(&newChapters, "## Chapter %d\n\n%s\n\n", c.Number, c.Body)
+			lastChapter = c.Number
+		}
+	}
+	if lastChapter == state.SummaryChapter {
+		return result
+	}
+
+	previous := state.StorySummary
+	if previous == "" {
+		previous = "(none yet; this is the start of the story)"
+	}
+	prompt := fmt.Sprintf(`You are keeping a running summary of a story for a writer who cannot reread earlier chapters.
+Update the summary below so that it also covers the new chapters that follow it.
+Keep every plot event, character (with names, relationships, and current state), location, object, and unresolved thread that later chapters may depend on. Drop scene-level detail.
+Return only the updated summary, in no more than %d words.
+
+--- Current Summary ---
+%s
+--- End Current Summary ---
+
+--- New Chapters ---
+%s--- End New Chapters ---`, summaryMaxWords, previous, newChapters.String())
+	if cfg.Language != "" {
+		prompt += fmt.Sprintf("\n\nWrite the summary in %s.", cfg.Language)
+	}
+
+	apiInput := newAPIInput(cfg, prompt)
+	apiInput.SystemInstruction = "" // The style prompt is for story text, not for the summary.
+	apiResponse := aiEndpoint.CallGeminiAPI(apiInput)
+	result.InputTokens = apiResponse.InputTokens
+	result.OutputTokens = apiResponse.OutputTokens
+	result.Cost = apiResponse.Cost
+	if apiResponse.Err != nil || strings.TrimSpace(apiResponse.GeneratedText) == "" {
+		err := apiResponse.Err
+		if err == nil {
+			err = fmt.Errorf("empty summary returned")
+		}
+		cfg.Logger.Warn("summary_failed", fmt.Sprintf("Failed to update the story summary through Chapter %d: %v. Keeping the summary through Chapter %d.", lastChapter, err, state.SummaryChapter),
+			logging.Fields{"chapter": lastChapter, "summary_chapter": state.SummaryChapter, "error": err.Error()})
+		return result
+	}
+
+	state.StorySummary = strings.TrimSpace(apiResponse.GeneratedText)
+	state.SummaryChapter = lastChapter
+	cfg.Logger.Info("summary_updated", fmt.Sprintf("Story summary updated through Chapter %d (%d words). Input Tokens %d, Output Tokens %d, Cost: %s",
+		lastChapter, utils.CountWords(state.StorySummary, cfg.Language), result.InputTokens, result.OutputTokens, aiEndpoint.FormatCost(result.Cost)),
+		logging.Fields{"chapter": lastChapter, "words": utils.CountWords(state.StorySummary, cfg.Language), "input_tokens": result.InputTokens, "output_tokens": result.OutputTokens, "cost": result.Cost})
+	return result
+}
+
+// recentChaptersText returns the last n chapters of the story text in the standard
+// "## Chapter N" layout, without the header block.
+func recentChaptersText(storyText string, n int) string {
+	_, chapters := parseStoryText(storyText)
+	if len(chapters) > n {
+		chapters = chapters[len(chapters)-n:]
+	}
+	var b strings.Builder
+	for _, c := range chapters {
+		fmt.Fprintf(&b, "## Chapter %d\n\n%s\n\n", c.Number, c.Body)
+	}
+	return b.String()
+}
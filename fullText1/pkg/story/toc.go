@@ -0,0 +1,147 @@
+package story
+
+import (
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/export"
+	"github.com/zicongmei/ai-story/fullText1/pkg/utils"
+)
+
+// Values accepted by --toc.
+const (
+	tocNone   = ""       // No table of contents
+	tocFile   = "file"   // Write the table of contents to <output>.toc.md
+	tocInline = "inline" // Insert the table of contents after the story header
+)
+
+// tocFileSuffix replaces the story file's extension for the table of contents file.
+const tocFileSuffix = ".toc.md"
+
+// maxTitleLength is the longest first line, in characters, still taken to be a chapter title.
+const maxTitleLength = 120
+
+// titlePrefixPattern matches a leading "Chapter 3:", "Chapter 3 -", or "Title:" label in front of a title.
+var titlePrefixPattern = regexp.MustCompile(`(?i)^(chapter\s+\d+\s*[:.\-–—]?\s*|title\s*:\s*)`)
+
+// validateTOCMode checks the --toc value.
+func validateTOCMode(mode string) error {
+	switch mode {
+	case tocNone, tocFile, tocInline:
+		return nil
+	default:
+		return fmt.Errorf("--toc must be '%s' or '%s', got '%s'", tocFile, tocInline, mode)
+	}
+}
+
+// extractChapterTitle returns the title the model wrote at the top of a chapter: its first
+// non-empty line with Markdown heading and emphasis markers and any "Chapter N:" label removed.
+// It returns "" when the first line is too long to be a title.
+func extractChapterTitle(body string) string {
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		line = strings.TrimSpace(strings.TrimLeft(line, "#"))
+		line = strings.TrimSpace(strings.Trim(line, "*_"))
+		line = strings.TrimSpace(titlePrefixPattern.ReplaceAllString(line, ""))
+		line = strings.TrimSpace(strings.Trim(line, "*_\"“”"))
+		if line == "" || utf8.RuneCountInString(line) > maxTitleLength {
+			return ""
+		}
+		return line
+	}
+	return ""
+}
+
+// resolveChapterTitles decides whether chapters are written with titles. --no-chapter-titles turns
+// titles off for the remaining chapters and is saved in the status file, so a resumed story keeps
+// writing chapters without titles even when the flag is not given again.
+func resolveChapterTitles(cfg *FullStoryConfig, state *StoryProgressState) {
+	switch {
+	case cfg.NoChapterTitles:
+		if !state.NoChapterTitles && state.ChaptersAlreadyWritten > 0 {
+			log.Printf("Warning: --no-chapter-titles applies to the remaining chapters; the %d chapters already written keep their titles.", state.ChaptersAlreadyWritten)
+		}
+		state.NoChapterTitles = true
+	case state.NoChapterTitles:
+		cfg.NoChapterTitles = true
+		log.Printf("Writing chapters without titles, as saved in the status file.")
+	}
+}
+
+// tocEntry is one line of the table of contents.
+type tocEntry struct {
+	Number int
+	Title  string
+	Words  int
+}
+
+// buildTOC lists every chapter in the story with its title and word count. Titles recorded
+// during generation are used when present; otherwise they are read from the chapter text, unless
+// the story is written with --no-chapter-titles.
+func buildTOC(state *StoryProgressState, language string) []tocEntry {
+	_, chapters := parseStoryText(state.PreviousChapters)
+	entries := make([]tocEntry, 0, len(chapters))
+	for _, c := range chapters {
+		title := state.ChapterTitles[c.Number]
+		if title == "" && !state.NoChapterTitles {
+			title = extractChapterTitle(c.Body)
+		}
+		entries = append(entries, tocEntry{Number: c.Number, Title: title, Words: utils.CountWords(c.Body, language)})
+	}
+	return entries
+}
+
+// formatTOC renders the table of contents as Markdown, one "Chapter N — Title" line per chapter.
+func formatTOC(entries []tocEntry) string {
+	var b strings.Builder
+	b.WriteString("Table of Contents\n\n")
+	for _, e := range entries {
+		if e.Title != "" {
+			fmt.Fprintf(&b, "- Chapter %d — %s (%d words)\n", e.Number, e.Title, e.Words)
+		} else {
+			fmt.Fprintf(&b, "- Chapter %d (%d words)\n", e.Number, e.Words)
+		}
+	}
+	return b.String()
+}
+
+// writeTOC writes the table of contents selected by cfg.TOC once all chapters are done: to a
+// separate .toc.md file next to the story, or inserted after the header of the story file. The
+// inline table is added only to the rendered story file, never to the status file, so it is not
+// sent back to the model as context.
+func writeTOC(cfg FullStoryConfig, state *StoryProgressState, outputFilePath string) error {
+	if cfg.TOC == tocNone {
+		return nil
+	}
+	toc := formatTOC(buildTOC(state, cfg.Language))
+
+	if cfg.TOC == tocFile {
+		tocPath := sidecarFilePath(outputFilePath, tocFileSuffix)
+		if err := file.WriteFile(tocPath, []byte(toc), file.FileMode(), !cfg.NoSync); err != nil {
+			return fmt.Errorf("failed to write table of contents: %w", err)
+		}
+		log.Printf("Table of contents saved to: %s", tocPath)
+		return nil
+	}
+
+	storyText := state.PreviousChapters
+	if loc := chapterHeaderPattern.FindStringIndex(storyText); loc != nil {
+		storyText = storyText[:loc[0]] + toc + "\n" + storyText[loc[0]:]
+	}
+	rendered, err := renderStory(storyText, export.FormatFromPath(outputFilePath), newFrontMatter(cfg, state), state.layout.exportLayout(state.ChapterTitles))
+	if err != nil {
+		return fmt.Errorf("failed to render story with table of contents: %w", err)
+	}
+	if err := file.WriteFile(outputFilePath, rendered, file.FileMode(), !cfg.NoSync); err != nil {
+		return fmt.Errorf("failed to write story output file: %w", err)
+	}
+	log.Printf("Table of contents inserted into: %s", outputFilePath)
+	return nil
+}
@@ -0,0 +1,184 @@
+package story
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/aiEndpoint"
+)
+
+// ChapterValidator checks a generated chapter and returns an error describing what is wrong with
+// it, or nil to accept it. The error text is sent to Gemini as feedback when the chapter is
+// regenerated, so it should say what to fix, e.g. "must not contain the word \"TODO\"".
+type ChapterValidator func(chapterNum int, text string) error
+
+// addWordListFlag registers a repeatable flag taking comma-separated words, appended to *words.
+func addWordListFlag(cmd *flag.FlagSet, name, usage string, words *[]string) {
+	cmd.Func(name, usage, func(value string) error {
+		for _, word := range strings.Split(value, ",") {
+			if word = strings.TrimSpace(word); word != "" {
+				*words = append(*words, word)
+			}
+		}
+		return nil
+	})
+}
+
+// wordPattern matches word case-insensitively. Word boundaries are required at edges that are
+// ASCII letters or digits, so "TODO" does not match "TODOS" while terms in scripts without
+// spaces, such as Chinese names, still match inside a sentence.
+func wordPattern(word string) *regexp.Regexp {
+	isWordRune := func(r rune) bool {
+		return r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_')
+	}
+	pattern := regexp.QuoteMeta(word)
+	if first, _ := utf8.DecodeRuneInString(word); isWordRune(first) {
+		pattern = `\b` + pattern
+	}
+	if last, _ := utf8.DecodeLastRuneInString(word); isWordRune(last) {
+		pattern += `\b`
+	}
+	return regexp.MustCompile(`(?i)` + pattern)
+}
+
+// forbidWordsValidator rejects chapters containing any of words.
+func forbidWordsValidator(words []string) ChapterValidator {
+	patterns := make([]*regexp.Regexp, len(words))
+	for i, word := range words {
+		patterns[i] = wordPattern(word)
+	}
+	return func(chapterNum int, text string) error {
+		var found []string
+		for i, pattern := range patterns {
+			if pattern.MatchString(text) {
+				found = append(found, fmt.Sprintf("%q", words[i]))
+			}
+		}
+		if len(found) > 0 {
+			return fmt.Errorf("the chapter must not contain %s", strings.Join(found, ", "))
+		}
+		return nil
+	}
+}
+
+// requireWordsValidator rejects chapters that do not mention every one of words.
+func requireWordsValidator(words []string) ChapterValidator {
+	patterns := make([]*regexp.Regexp, len(words))
+	for i, word := range words {
+		patterns[i] = wordPattern(word)
+	}
+	return func(chapterNum int, text string) error {
+		var missing []string
+		for i, pattern := range patterns {
+			if !pattern.MatchString(text) {
+				missing = append(missing, fmt.Sprintf("%q", words[i]))
+			}
+		}
+		if len(missing) > 0 {
+			return fmt.Errorf("the chapter must mention %s", strings.Join(missing, ", "))
+		}
+		return nil
+	}
+}
+
+// chainValidators returns a validator that runs every non-nil validator and joins their errors
+// into one line, so a single regeneration can fix all problems at once. It returns nil when there are none.
+func chainValidators(validators ...ChapterValidator) ChapterValidator {
+	var active []ChapterValidator
+	for _, v := range validators {
+		if v != nil {
+			active = append(active, v)
+		}
+	}
+	switch len(active) {
+	case 0:
+		return nil
+	case 1:
+		return active[0]
+	}
+	return func(chapterNum int, text string) error {
+		var problems []string
+		for _, v := range active {
+			if err := v(chapterNum, text); err != nil {
+				problems = append(problems, err.Error())
+			}
+		}
+		if len(problems) == 0 {
+			return nil
+		}
+		return errors.New(strings.Join(problems, "; "))
+	}
+}
+
+// resolveChapterValidator combines cfg.ChapterValidator with the validators selected by
+// --forbid-words and --require-words into cfg.ChapterValidator.
+func resolveChapterValidator(cfg *FullStoryConfig) {
+	var forbid, require ChapterValidator
+	if len(cfg.ForbidWords) > 0 {
+		forbid = forbidWordsValidator(cfg.ForbidWords)
+	}
+	if len(cfg.RequireWords) > 0 {
+		require = requireWordsValidator(cfg.RequireWords)
+	}
+	cfg.ChapterValidator = chainValidators(cfg.ChapterValidator, forbid, require)
+}
+
+// chapterValidationResult holds the outcome of validating a chapter and regenerating it on failure.
+type chapterValidationResult struct {
+	Text             string
+	ThoughtSignature []byte
+	InputTokens      int
+	OutputTokens     int
+	Cost             float64
+	Regenerations    int
+	Err              error // The last validation error when the chapter still fails after every regeneration
+}
+
+// regenerateInvalidChapter runs cfg.ChapterValidator on a chapter and, while it fails, writes the
+// chapter again from scratch, up to cfg.MaxValidationRetries times. Each retry sends the chapter
+// prompt with the validation feedback appended and the previous chapter's signature, and the new
+// draft is continued and expanded like the first one. The usage of every retry is accumulated,
+// including drafts that are rejected again. If every retry fails, the last draft is kept.
+func regenerateInvalidChapter(cfg FullStoryConfig, chapterNum, targetWords int, chapterPrompt, chapterText string, chapterSignature, previousSignature []byte) chapterValidationResult {
+	result := chapterValidationResult{
+		Text:             chapterText,
+		ThoughtSignature: chapterSignature,
+	}
+	if cfg.ChapterValidator == nil {
+		return result
+	}
+
+	result.Err = cfg.ChapterValidator(chapterNum, result.Text)
+	for round := 1; result.Err != nil && round <= cfg.MaxValidationRetries; round++ {
+		log.Printf("Chapter %d failed validation: %v. Regenerating it (%d/%d)...", chapterNum, result.Err, round, cfg.MaxValidationRetries)
+
+		prompt := fmt.Sprintf("%s\n\nA previous draft of this chapter was rejected because %v. Write the whole chapter again and make sure this does not happen.", chapterPrompt, result.Err)
+		apiInput := newAPIInput(cfg, prompt)
+		apiInput.ThoughtSignature = previousSignature
+		apiResponse := aiEndpoint.CallGeminiAPI(apiInput)
+		result.InputTokens += apiResponse.InputTokens
+		result.OutputTokens += apiResponse.OutputTokens
+		result.Cost += apiResponse.Cost
+		if apiResponse.Err != nil {
+			log.Printf("Warning: Regenerating Chapter %d failed: %v. Keeping the previous draft.", chapterNum, apiResponse.Err)
+			break
+		}
+		result.Regenerations = round
+
+		continuation := continueTruncatedChapter(cfg, chapterNum, prompt, apiResponse.GeneratedText, apiResponse.ThoughtSignature, apiResponse.FinishReason)
+		expansion := expandShortChapter(cfg, chapterNum, targetWords, prompt, continuation.Text, continuation.ThoughtSignature)
+		result.Text = expansion.Text
+		result.ThoughtSignature = expansion.ThoughtSignature
+		result.InputTokens += continuation.InputTokens + expansion.InputTokens
+		result.OutputTokens += continuation.OutputTokens + expansion.OutputTokens
+		result.Cost += continuation.Cost + expansion.Cost
+		result.Err = cfg.ChapterValidator(chapterNum, result.Text)
+	}
+	return result
+}
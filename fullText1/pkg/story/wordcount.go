@@ -0,0 +1,83 @@
+package story
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/zicongmei/ai-story/fullText1/pkg/abstract/file"
+	"github.com/zicongmei/ai-story/fullText1/pkg/logging"
+)
+
+// WordCountSummary compares the chapters' actual word counts with their targets (--words-per-chapter,
+// or the --chapter-plan entry) and the +/- --word-tolerance band around them.
+type WordCountSummary struct {
+	Chapters     int     // Chapters with recorded word counts
+	AverageWords float64 // Average words per chapter
+	Tolerance    float64 // Allowed deviation from the target as a fraction, e.g. 0.2 for +/- 20%
+	Short        []int   // Chapters below the band, in order
+	Long         []int   // Chapters above the band, in order
+}
+
+// Outside returns how many chapters fell outside the tolerance band.
+func (s WordCountSummary) Outside() int {
+	return len(s.Short) + len(s.Long)
+}
+
+// String formats s on one line, e.g. for "Word counts: ...".
+func (s WordCountSummary) String() string {
+	text := fmt.Sprintf("average %.0f words over %d chapters; %d outside +/-%.0f%% of the target",
+		s.AverageWords, s.Chapters, s.Outside(), s.Tolerance*100)
+	if len(s.Short) > 0 {
+		text += fmt.Sprintf(" (short: %s)", chapterList(s.Short))
+	}
+	if len(s.Long) > 0 {
+		text += fmt.Sprintf(" (long: %s)", chapterList(s.Long))
+	}
+	return text
+}
+
+// chapterList joins chapter numbers with commas.
+func chapterList(chapters []int) string {
+	parts := make([]string, len(chapters))
+	for i, n := range chapters {
+		parts[i] = strconv.Itoa(n)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// newWordCountSummary measures the recorded chapter metrics against each chapter's target word count.
+func newWordCountSummary(cfg FullStoryConfig, metrics []file.ChapterMetrics) WordCountSummary {
+	s := WordCountSummary{Tolerance: cfg.WordTolerance}
+	totalWords := 0
+	for _, m := range metrics {
+		s.Chapters++
+		totalWords += m.Words
+		target := float64(targetWordsForChapter(cfg, m.Chapter))
+		switch {
+		case float64(m.Words) < target*(1-cfg.WordTolerance):
+			s.Short = append(s.Short, m.Chapter)
+		case float64(m.Words) > target*(1+cfg.WordTolerance):
+			s.Long = append(s.Long, m.Chapter)
+		}
+	}
+	if s.Chapters > 0 {
+		s.AverageWords = float64(totalWords) / float64(s.Chapters)
+	}
+	return s
+}
+
+// reportWordCounts logs how the chapters' word counts compare with their targets.
+func reportWordCounts(cfg FullStoryConfig, s WordCountSummary) {
+	if s.Chapters == 0 {
+		return
+	}
+	cfg.Logger.Info("word_counts", "Word counts: "+s.String(),
+		logging.Fields{
+			"chapters":       s.Chapters,
+			"average_words":  s.AverageWords,
+			"tolerance":      s.Tolerance,
+			"short_chapters": s.Short,
+			"long_chapters":  s.Long,
+		})
+}
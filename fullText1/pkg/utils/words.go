@@ -0,0 +1,64 @@
+// Package utils holds small text helpers shared by the abstract and story commands.
+package utils
+
+import (
+	"strings"
+	"unicode"
+)
+
+// spacelessLanguages lists, in lower case, the languages whose text does not separate words with
+// spaces. Both English names and common codes and native names are accepted.
+var spacelessLanguages = map[string]bool{
+	"chinese":   true,
+	"mandarin":  true,
+	"cantonese": true,
+	"japanese":  true,
+	"zh":        true,
+	"zh-cn":     true,
+	"zh-tw":     true,
+	"ja":        true,
+	"中文":        true,
+	"简体中文":      true,
+	"繁體中文":      true,
+	"日本語":       true,
+}
+
+// IsCJKLanguage reports whether language (as given to --language) is Chinese or Japanese,
+// whose text is measured in characters rather than space-separated words.
+func IsCJKLanguage(language string) bool {
+	return spacelessLanguages[strings.ToLower(strings.TrimSpace(language))]
+}
+
+// isCJKRune reports whether r is a Han, Hiragana, or Katakana character.
+func isCJKRune(r rune) bool {
+	return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana)
+}
+
+// CountWords counts the words in text for the given language. Space-delimited languages (and
+// an empty language) are counted by splitting on whitespace. For Chinese and Japanese, each
+// Han, Hiragana, or Katakana character counts as one word, the usual measure for those
+// languages, while runs of other letters or digits (such as embedded English words or numbers)
+// count as one word each and punctuation is not counted.
+func CountWords(text, language string) int {
+	if !IsCJKLanguage(language) {
+		return len(strings.Fields(text))
+	}
+
+	count := 0
+	inWord := false
+	for _, r := range text {
+		switch {
+		case isCJKRune(r):
+			count++
+			inWord = false
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if !inWord {
+				count++
+				inWord = true
+			}
+		default:
+			inWord = false
+		}
+	}
+	return count
+}